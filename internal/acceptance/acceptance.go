@@ -0,0 +1,270 @@
+// Package acceptance checks the services an app connects to against a
+// signed, versioned list of accepted service bridges.
+package acceptance
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Modes, from ACCEPTANCE_MODE
+const (
+	ModeOff    = "off"
+	ModeWarn   = "warn"   // Log services that aren't accepted (the default)
+	ModeStrict = "strict" // Refuse them
+)
+
+// DefaultPath is where the list lives, relative to the app root.
+const DefaultPath = "acceptance.json"
+
+// List is the acceptance list. Version increases with every signed
+// revision so an older list can't be swapped back in.
+type List struct {
+	Version   int       `json:"version"`
+	Issuer    string    `json:"issuer,omitempty"`
+	IssuedAt  time.Time `json:"issued_at"`
+	Services  []Rule    `json:"services"`
+	Signature string    `json:"signature,omitempty"` // Base64 ed25519 over Payload
+}
+
+// Rule accepts a kind of service on some hosts.
+type Rule struct {
+	Kind string `json:"kind"` // qast, astauth, oidc, rabbitmq, postgres, redis
+	// Hosts are exact names, "*.example.com" for subdomains or "*" for any
+	Hosts      []string `json:"hosts"`
+	RequireTLS bool     `json:"require_tls,omitempty"`
+	MinVersion string   `json:"min_version,omitempty"`
+}
+
+// Config is read from the environment.
+type Config struct {
+	Mode      string
+	Path      string
+	PublicKey ed25519.PublicKey
+}
+
+// FromEnv reads ACCEPTANCE_MODE, ACCEPTANCE_LIST and ACCEPTANCE_PUBLIC_KEY
+// (base64 ed25519 public key from `wodge compliance acceptance keygen`).
+func FromEnv() (Config, error) {
+	cfg := Config{Mode: os.Getenv("ACCEPTANCE_MODE"), Path: os.Getenv("ACCEPTANCE_LIST")}
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = ModeWarn
+	case ModeOff, ModeWarn, ModeStrict:
+	default:
+		return cfg, fmt.Errorf("unknown ACCEPTANCE_MODE %q (off, warn, strict)", cfg.Mode)
+	}
+	if cfg.Path == "" {
+		cfg.Path = DefaultPath
+	}
+	if raw := os.Getenv("ACCEPTANCE_PUBLIC_KEY"); raw != "" {
+		key, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return cfg, errors.New("ACCEPTANCE_PUBLIC_KEY is not a base64 ed25519 public key")
+		}
+		cfg.PublicKey = key
+	}
+	return cfg, nil
+}
+
+// Load reads the list at path and verifies its signature.
+func Load(path string, pub ed25519.PublicKey) (*List, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var l List
+	if err := json.Unmarshal(raw, &l); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := l.Verify(pub); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &l, nil
+}
+
+// Payload is what the signature covers: the list without its signature.
+func (l *List) Payload() []byte {
+	c := *l
+	c.Signature = ""
+	b, _ := json.Marshal(c)
+	return b
+}
+
+// Sign bumps the version and signs the list.
+func (l *List) Sign(priv ed25519.PrivateKey, now time.Time) {
+	l.Version++
+	l.IssuedAt = now.UTC()
+	l.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, l.Payload()))
+}
+
+// Verify checks the signature against pub.
+func (l *List) Verify(pub ed25519.PublicKey) error {
+	if pub == nil {
+		return errors.New("no public key to verify the acceptance list, set ACCEPTANCE_PUBLIC_KEY")
+	}
+	if l.Signature == "" {
+		return errors.New("acceptance list is not signed")
+	}
+	sig, err := base64.StdEncoding.DecodeString(l.Signature)
+	if err != nil || !ed25519.Verify(pub, l.Payload(), sig) {
+		return errors.New("acceptance list signature is invalid")
+	}
+	return nil
+}
+
+// CheckRollback refuses a list older than the newest one seen before,
+// recorded in stateFile, and records l's version otherwise.
+func CheckRollback(stateFile string, l *List) error {
+	if raw, err := os.ReadFile(stateFile); err == nil {
+		seen, _ := strconv.Atoi(strings.TrimSpace(string(raw)))
+		if l.Version < seen {
+			return fmt.Errorf("acceptance list version %d is older than version %d seen before", l.Version, seen)
+		}
+		if l.Version == seen {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(stateFile, []byte(strconv.Itoa(l.Version)+"\n"), 0o600)
+}
+
+// Violation is a service the list does not accept.
+type Violation struct {
+	Kind   string `json:"kind"`
+	Host   string `json:"host,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (v Violation) Error() string {
+	if v.Host == "" {
+		return v.Kind + ": " + v.Reason
+	}
+	return v.Kind + " (" + v.Host + "): " + v.Reason
+}
+
+// Check matches s against the rules for its kind. The version is left to
+// CheckVersion since most services only report it once connected.
+func (l *List) Check(s Service) *Violation {
+	rules := l.rules(s.Kind)
+	if len(rules) == 0 {
+		return &Violation{Kind: s.Kind, Host: s.Host, Reason: "service kind is not on the acceptance list"}
+	}
+	hostAccepted := false
+	for _, r := range rules {
+		if !matchHost(r.Hosts, s.Host) {
+			continue
+		}
+		hostAccepted = true
+		if !r.RequireTLS || s.TLS {
+			return nil
+		}
+	}
+	if !hostAccepted {
+		return &Violation{Kind: s.Kind, Host: s.Host, Reason: "host is not on the acceptance list"}
+	}
+	return &Violation{Kind: s.Kind, Host: s.Host, Reason: "the acceptance list requires TLS"}
+}
+
+// CheckVersion compares the version a service reported (or declared)
+// with the minimum for its host. An unknown version fails when a minimum
+// is set.
+func (l *List) CheckVersion(s Service, version string) *Violation {
+	min := l.MinVersion(s)
+	if min == "" {
+		return nil
+	}
+	if version == "" {
+		return &Violation{Kind: s.Kind, Host: s.Host, Reason: "version is unknown, the acceptance list requires " + min + " or newer"}
+	}
+	if CompareVersions(version, min) < 0 {
+		return &Violation{Kind: s.Kind, Host: s.Host, Reason: fmt.Sprintf("version %s is older than the accepted minimum %s", version, min)}
+	}
+	return nil
+}
+
+// MinVersion is the minimum version accepted for s, if any.
+func (l *List) MinVersion(s Service) string {
+	for _, r := range l.rules(s.Kind) {
+		if matchHost(r.Hosts, s.Host) {
+			return r.MinVersion
+		}
+	}
+	return ""
+}
+
+func (l *List) rules(kind string) []Rule {
+	var out []Rule
+	for _, r := range l.Services {
+		if r.Kind == kind {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func matchHost(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		switch {
+		case p == "*" || p == host:
+			return true
+		case strings.HasPrefix(p, "*.") && strings.HasSuffix(host, p[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// CompareVersions compares dotted numeric versions ("3.12.1", "v1.4",
+// "16.2 (Debian 16.2-1)"), ignoring anything after the numbers.
+func CompareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		end := 0
+		for end < len(p) && p[end] >= '0' && p[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			break
+		}
+		n, _ := strconv.Atoi(p[:end])
+		parts = append(parts, n)
+		if end < len(p) {
+			break
+		}
+	}
+	return parts
+}
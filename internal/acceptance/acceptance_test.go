@@ -0,0 +1,175 @@
+package acceptance
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func signedList(t *testing.T, priv ed25519.PrivateKey) *List {
+	t.Helper()
+	l := &List{Issuer: "security@example.com", Services: []Rule{
+		{Kind: "postgres", Hosts: []string{"db.internal"}, RequireTLS: true, MinVersion: "15"},
+	}}
+	l.Sign(priv, time.Now())
+	return l
+}
+
+func TestListVerify(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name    string
+		change  func(l *List)
+		key     ed25519.PublicKey
+		wantErr bool
+	}{
+		{"valid", func(*List) {}, pub, false},
+		{"other key", func(*List) {}, otherPub, true},
+		{"no key", func(*List) {}, nil, true},
+		{"unsigned", func(l *List) { l.Signature = "" }, pub, true},
+		{"signature not base64", func(l *List) { l.Signature = "not base64!" }, pub, true},
+		{"host added", func(l *List) { l.Services[0].Hosts = append(l.Services[0].Hosts, "*") }, pub, true},
+		{"TLS requirement dropped", func(l *List) { l.Services[0].RequireTLS = false }, pub, true},
+		{"version bumped", func(l *List) { l.Version++ }, pub, true},
+		{"signed by another key", func(l *List) { l.Sign(otherPriv, time.Now()) }, pub, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := signedList(t, priv)
+			tt.change(l)
+			if err := l.Verify(tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("Verify() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Load verifies the list as stored, so editing the file breaks it.
+func TestLoadVerifiesTheFile(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	path := filepath.Join(t.TempDir(), "acceptance.json")
+	write := func(l *List) {
+		raw, _ := json.Marshal(l)
+		if err := os.WriteFile(path, raw, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := signedList(t, priv)
+	write(l)
+	loaded, err := Load(path, pub)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if loaded.Version != 1 || loaded.Services[0].Hosts[0] != "db.internal" {
+		t.Errorf("Load() = %+v", loaded)
+	}
+
+	l.Services[0].Hosts = []string{"*"}
+	write(l)
+	if _, err := Load(path, pub); err == nil {
+		t.Error("Load() accepted an edited list")
+	}
+}
+
+func TestCheckRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		seen    string // State file content, none if empty
+		version int
+		wantErr bool
+		want    string // State file afterwards
+	}{
+		{"first list", "", 3, false, "3\n"},
+		{"same version", "3\n", 3, false, "3\n"},
+		{"newer version", "3\n", 4, false, "4\n"},
+		{"older version", "3\n", 2, true, "3\n"},
+		{"unreadable state", "garbage", 1, false, "1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := filepath.Join(t.TempDir(), "state", "acceptance.version")
+			if tt.seen != "" {
+				os.MkdirAll(filepath.Dir(state), 0o700)
+				if err := os.WriteFile(state, []byte(tt.seen), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := CheckRollback(state, &List{Version: tt.version})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckRollback() = %v, wantErr %v", err, tt.wantErr)
+			}
+			raw, _ := os.ReadFile(state)
+			if string(raw) != tt.want {
+				t.Errorf("state = %q, want %q", raw, tt.want)
+			}
+		})
+	}
+}
+
+func TestListCheck(t *testing.T) {
+	l := &List{Services: []Rule{
+		{Kind: "postgres", Hosts: []string{"db.internal"}, RequireTLS: true, MinVersion: "15.2"},
+		{Kind: "redis", Hosts: []string{"*.cache.example.com"}},
+		{Kind: "rabbitmq", Hosts: []string{"*"}},
+	}}
+	tests := []struct {
+		name    string
+		service Service
+		version string
+		want    string // Violation reason, "" when accepted
+	}{
+		{"accepted with TLS", Service{Kind: "postgres", Host: "db.internal", TLS: true}, "16.2 (Debian 16.2-1)", ""},
+		{"host case ignored", Service{Kind: "postgres", Host: "DB.internal", TLS: true}, "15.2", ""},
+		{"TLS required", Service{Kind: "postgres", Host: "db.internal"}, "16", "the acceptance list requires TLS"},
+		{"other host", Service{Kind: "postgres", Host: "db.evil", TLS: true}, "16", "host is not on the acceptance list"},
+		{"version too old", Service{Kind: "postgres", Host: "db.internal", TLS: true}, "15.1", "version 15.1 is older than the accepted minimum 15.2"},
+		{"version unknown", Service{Kind: "postgres", Host: "db.internal", TLS: true}, "", "version is unknown, the acceptance list requires 15.2 or newer"},
+		{"subdomain", Service{Kind: "redis", Host: "eu.cache.example.com"}, "", ""},
+		{"wildcard doesn't cover the domain itself", Service{Kind: "redis", Host: "cache.example.com"}, "", "host is not on the acceptance list"},
+		{"wildcard doesn't cover lookalikes", Service{Kind: "redis", Host: "evilcache.example.com"}, "", "host is not on the acceptance list"},
+		{"any host", Service{Kind: "rabbitmq", Host: "mq"}, "", ""},
+		{"kind not listed", Service{Kind: "qast", Host: "qast.example.com"}, "", "service kind is not on the acceptance list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := l.Check(tt.service)
+			if v == nil {
+				v = l.CheckVersion(tt.service, tt.version)
+			}
+			got := ""
+			if v != nil {
+				got = v.Reason
+			}
+			if got != tt.want {
+				t.Errorf("violation = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"3.12.1", "3.12.1", 0},
+		{"3.12", "3.12.0", 0},
+		{"v1.4", "1.4", 0},
+		{"3.9", "3.12", -1},
+		{"16.2 (Debian 16.2-1)", "16.1", 1},
+		{"7.2.4-alpine", "7.2.5", -1},
+		{"unknown", "1", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package acceptance
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Service is a service bridge the app is configured to use.
+type Service struct {
+	Kind    string `json:"kind"`
+	Setting string `json:"setting"` // The env variable it comes from
+	Host    string `json:"host"`
+	TLS     bool   `json:"tls"`
+	// Declared is the version from <KIND>_VERSION, for HTTP services that
+	// don't report one
+	Declared string `json:"declared_version,omitempty"`
+}
+
+// ReportsVersion is true for kinds whose version is read from the
+// connection; the others are checked against their declared version.
+func ReportsVersion(kind string) bool {
+	switch kind {
+	case "postgres", "redis", "rabbitmq":
+		return true
+	}
+	return false
+}
+
+// ServicesFromEnv lists the configured services.
+func ServicesFromEnv() []Service {
+	var out []Service
+	if v := os.Getenv("QAST_URL"); v != "" {
+		s := fromURL("qast", "QAST_URL", v)
+		s.Declared = os.Getenv("QAST_VERSION")
+		out = append(out, s)
+	}
+	switch os.Getenv("AUTH_PROVIDER") {
+	case "", "astauth":
+		if v := os.Getenv("ASTAUTH_URL"); v != "" {
+			s := fromURL("astauth", "ASTAUTH_URL", v)
+			s.Declared = os.Getenv("ASTAUTH_VERSION")
+			out = append(out, s)
+		}
+	case "oidc":
+		if v := os.Getenv("OIDC_ISSUER"); v != "" {
+			out = append(out, fromURL("oidc", "OIDC_ISSUER", v))
+		}
+	}
+	if v := os.Getenv("RABBITMQ_URL"); v != "" {
+		out = append(out, fromURL("rabbitmq", "RABBITMQ_URL", v))
+	}
+	if v := os.Getenv("POSTGRES_DSN"); v != "" {
+		out = append(out, fromPostgresDSN(v))
+	}
+	if v := os.Getenv("REDIS_ADDR"); v != "" {
+		// The Redis driver connects in plain text
+		host, _, err := net.SplitHostPort(v)
+		if err != nil {
+			host = v
+		}
+		out = append(out, Service{Kind: "redis", Setting: "REDIS_ADDR", Host: host})
+	}
+	return out
+}
+
+func fromURL(kind, setting, raw string) Service {
+	s := Service{Kind: kind, Setting: setting}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return s
+	}
+	s.Host = u.Hostname()
+	switch u.Scheme {
+	case "https", "amqps", "rediss":
+		s.TLS = true
+	}
+	return s
+}
+
+// fromPostgresDSN handles both URL and key=value DSNs. Only the sslmode
+// values that refuse plain connections count as TLS.
+func fromPostgresDSN(dsn string) Service {
+	s := Service{Kind: "postgres", Setting: "POSTGRES_DSN"}
+	var mode string
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return s
+		}
+		s.Host = u.Hostname()
+		mode = u.Query().Get("sslmode")
+	} else {
+		for _, kv := range strings.Fields(dsn) {
+			k, v, _ := strings.Cut(kv, "=")
+			switch k {
+			case "host":
+				s.Host = v
+			case "sslmode":
+				mode = v
+			}
+		}
+	}
+	if s.Host == "" {
+		s.Host = "localhost"
+	}
+	// lib/pq defaults to require
+	s.TLS = mode == "" || mode == "require" || mode == "verify-ca" || mode == "verify-full"
+	return s
+}
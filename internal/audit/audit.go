@@ -0,0 +1,346 @@
+// Package audit writes the tamper-evident audit trail kept as NIS2 evidence.
+//
+// Entries are JSON lines. Each one carries the SHA-256 of its own content
+// chained to the previous entry's hash, and optionally an HMAC of that hash,
+// so deleting, reordering or editing any entry breaks verification.
+//
+// The chain can't show that its newest entries were cut off: a log truncated
+// after any entry is still a valid chain. Detecting that needs the head (the
+// last seq and hash, as printed by wodge audit verify) kept somewhere the
+// log's writer can't change, to compare against later.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"wodge/internal/logging"
+	"wodge/internal/requestid"
+)
+
+// Outcomes
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// Actions recorded besides the security events, which are audited under
+// their own names (e.g. auth.login, auth.login_failed)
+const (
+	ActionDataAccess   = "data.access"
+	ActionAdmin        = "admin.action"
+	ActionConfigLoad   = "config.loaded"
+	ActionConfigChange = "config.changed"
+)
+
+// Entry is one audit record.
+type Entry struct {
+	Seq       uint64                 `json:"seq"`
+	Time      time.Time              `json:"time"`
+	Action    string                 `json:"action"`
+	Outcome   string                 `json:"outcome"`
+	ActorID   string                 `json:"actor_id,omitempty"`
+	Actor     string                 `json:"actor,omitempty"` // Username
+	IP        string                 `json:"ip,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Resource  string                 `json:"resource,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	PrevHash  string                 `json:"prev_hash"`
+	Hash      string                 `json:"hash"`
+	MAC       string                 `json:"mac,omitempty"`
+}
+
+// digest hashes everything except Hash and MAC, chained to PrevHash.
+func (e Entry) digest() string {
+	e.Hash, e.MAC = "", ""
+	body, _ := json.Marshal(e)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func mac(key []byte, hash string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(hash))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// Logger appends entries to rotating files named audit-<timestamp>.jsonl.
+type Logger struct {
+	dir     string
+	key     []byte
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	seq  uint64
+	prev string
+
+	syncMu sync.Mutex
+	synced uint64 // Last seq known to be on disk
+}
+
+// Open continues the chain found in dir, creating it if needed. key enables
+// HMAC signing; maxSize is the size at which files rotate.
+func Open(dir string, key []byte, maxSize int64) (*Logger, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	l := &Logger{dir: dir, key: key, maxSize: maxSize}
+
+	files, err := Files(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		last, err := lastEntry(files[len(files)-1])
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			l.seq, l.prev = last.Seq, last.Hash
+		}
+		if l.f, err = os.OpenFile(files[len(files)-1], os.O_APPEND|os.O_WRONLY, 0o600); err != nil {
+			return nil, err
+		}
+		if info, err := l.f.Stat(); err == nil {
+			l.size = info.Size()
+		}
+	}
+	return l, nil
+}
+
+// KeyFromEnv decodes the base64 HMAC key in the named variable, nil if unset.
+func KeyFromEnv(name string) ([]byte, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid base64: %w", name, err)
+	}
+	if len(key) < 32 {
+		return nil, fmt.Errorf("%s must be at least 32 bytes", name)
+	}
+	return key, nil
+}
+
+// Record appends e to the chain. The request ID is taken from ctx.
+func (l *Logger) Record(ctx context.Context, e Entry) error {
+	if e.RequestID == "" {
+		e.RequestID = requestid.FromContext(ctx)
+	}
+	if e.Outcome == "" {
+		e.Outcome = Success
+	}
+
+	l.mu.Lock()
+	if l.f == nil || (l.maxSize > 0 && l.size >= l.maxSize) {
+		if err := l.rotate(); err != nil {
+			l.mu.Unlock()
+			return err
+		}
+	}
+
+	e.Seq = l.seq + 1
+	e.Time = time.Now().UTC()
+	e.PrevHash = l.prev
+	e.Hash = e.digest()
+	if l.key != nil {
+		e.MAC = mac(l.key, e.Hash)
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		l.mu.Unlock()
+		return err
+	}
+	n, err := l.f.Write(append(line, '\n'))
+	l.size += int64(n)
+	if err != nil {
+		l.mu.Unlock()
+		return err
+	}
+	l.seq, l.prev = e.Seq, e.Hash
+	l.mu.Unlock()
+
+	// Audit entries must survive a crash right after the action
+	return l.sync(e.Seq)
+}
+
+// sync returns once entry seq is on disk. Records waiting at the same time
+// share one fsync (group commit): each sync covers every entry written
+// before it, so busy servers sync once per batch instead of once per entry.
+func (l *Logger) sync(seq uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	if l.synced >= seq {
+		return nil
+	}
+	l.mu.Lock()
+	f, written := l.f, l.seq
+	l.mu.Unlock()
+	// A file closed meanwhile was synced by rotate or Close
+	if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	l.synced = written
+	return nil
+}
+
+func (l *Logger) rotate() error {
+	if l.f != nil {
+		if err := l.f.Sync(); err != nil {
+			return err
+		}
+		l.f.Close()
+	}
+	name := filepath.Join(l.dir, fmt.Sprintf("audit-%s.jsonl", time.Now().UTC().Format("20060102T150405.000000000")))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	l.f, l.size = f, 0
+	return nil
+}
+
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	return l.f.Close()
+}
+
+// LastOf returns the most recent entry with one of the given actions, or nil.
+func (l *Logger) LastOf(actions ...string) (*Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	files, err := Files(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	// Newest file first, most callers find their entry there
+	for i := len(files) - 1; i >= 0; i-- {
+		var found *Entry
+		err := scanFile(files[i], func(e Entry) {
+			for _, a := range actions {
+				if e.Action == a {
+					found = &e
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found != nil {
+			return found, nil
+		}
+	}
+	return nil, nil
+}
+
+// Files lists the audit files in dir in chain order.
+func Files(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	sort.Strings(files)
+	return files, err
+}
+
+// Read returns the entries in dir recorded in [from, to), in chain order.
+// Zero times leave that end open.
+func Read(dir string, from, to time.Time) ([]Entry, error) {
+	files, err := Files(dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, path := range files {
+		err := scanFile(path, func(e Entry) {
+			if (from.IsZero() || !e.Time.Before(from)) && (to.IsZero() || e.Time.Before(to)) {
+				entries = append(entries, e)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func lastEntry(path string) (*Entry, error) {
+	var last *Entry
+	err := scanFile(path, func(e Entry) { last = &e })
+	return last, err
+}
+
+func scanFile(path string, fn func(e Entry)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("%s: unreadable entry: %w", filepath.Base(path), err)
+		}
+		fn(e)
+	}
+	return scanner.Err()
+}
+
+// The process-wide audit log; Record is a no-op until SetDefault is called.
+var (
+	defaultMu sync.RWMutex
+	std       *Logger
+)
+
+// SetDefault installs l as the process-wide audit log.
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	std = l
+}
+
+// Record appends e to the default audit log. Failures are logged, never
+// returned: an audit outage must be visible but must not fail the request.
+func Record(ctx context.Context, e Entry) {
+	defaultMu.RLock()
+	l := std
+	defaultMu.RUnlock()
+	if l == nil {
+		return
+	}
+	if err := l.Record(ctx, e); err != nil {
+		logging.For("audit").ErrorContext(ctx, "Failed to record entry", "action", e.Action, "error", err)
+	}
+}
+
+// Outcome derives success/failure from an action name like "auth.login_failed".
+func Outcome(action string) string {
+	for _, s := range []string{"_failed", "_blocked", "lockout", "_reuse"} {
+		if strings.HasSuffix(action, s) {
+			return Failure
+		}
+	}
+	return Success
+}
@@ -0,0 +1,44 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// Concurrent records share syncs but still form one unbroken chain, across
+// rotations too.
+func TestRecordConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, testKey, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const writers, each = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				if err := l.Record(context.Background(), Entry{Action: ActionDataAccess, Resource: "GET /api/items"}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Verify(dir, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Entries != writers*each || report.Files < 2 {
+		t.Errorf("Verify() = %d entries in %d files, problems %v; want %d entries over several files",
+			report.Entries, report.Files, report.Problems, writers*each)
+	}
+}
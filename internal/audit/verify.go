@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Problem is one verification failure.
+type Problem struct {
+	File string
+	Line int
+	Seq  uint64
+	Msg  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d (seq %d): %s", p.File, p.Line, p.Seq, p.Msg)
+}
+
+// Report is the result of verifying an audit directory.
+type Report struct {
+	Files    int
+	Entries  int
+	FirstSeq uint64
+	LastSeq  uint64
+	LastHash string // Head of the chain, to keep elsewhere and compare later
+	Signed   bool   // Whether MACs were checked
+	Problems []Problem
+}
+
+func (r Report) OK() bool { return len(r.Problems) == 0 }
+
+// Verify walks every file in dir in order and checks that sequence numbers
+// have no gaps, each hash matches its entry, each entry links to the previous
+// hash and, when key is given, each MAC is valid. Entries missing from the
+// end are not detected; compare LastSeq and LastHash with a recorded head.
+func Verify(dir string, key []byte) (Report, error) {
+	report := Report{Signed: key != nil}
+	files, err := Files(dir)
+	if err != nil {
+		return report, err
+	}
+	report.Files = len(files)
+
+	var prevSeq uint64
+	var prevHash string
+	started := false
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return report, err
+		}
+		name := filepath.Base(path)
+		problem := func(line int, seq uint64, format string, args ...interface{}) {
+			report.Problems = append(report.Problems, Problem{File: name, Line: line, Seq: seq, Msg: fmt.Sprintf(format, args...)})
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			var e Entry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				problem(line, 0, "unreadable entry: %v", err)
+				continue
+			}
+			report.Entries++
+
+			if !started {
+				report.FirstSeq = e.Seq
+				// The chain may start later than 1 if old files were archived,
+				// but then it can't be checked against its predecessor
+				if e.Seq != 1 || e.PrevHash != "" {
+					problem(line, e.Seq, "chain does not start at seq 1 (earlier entries missing)")
+				}
+				started = true
+			} else {
+				if e.Seq != prevSeq+1 {
+					if e.Seq > prevSeq+1 {
+						problem(line, e.Seq, "gap: entries %d-%d missing", prevSeq+1, e.Seq-1)
+					} else {
+						problem(line, e.Seq, "sequence out of order after %d", prevSeq)
+					}
+				}
+				if e.PrevHash != prevHash {
+					problem(line, e.Seq, "prev_hash does not match the preceding entry")
+				}
+			}
+			if e.digest() != e.Hash {
+				problem(line, e.Seq, "hash mismatch: entry was modified")
+			}
+			if key != nil {
+				if e.MAC == "" {
+					problem(line, e.Seq, "missing MAC")
+				} else if !hmac.Equal([]byte(e.MAC), []byte(mac(key, e.Hash))) {
+					problem(line, e.Seq, "MAC mismatch: entry or hash was forged")
+				}
+			}
+			prevSeq, prevHash = e.Seq, e.Hash
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return report, err
+		}
+	}
+	report.LastSeq, report.LastHash = prevSeq, prevHash
+	return report, nil
+}
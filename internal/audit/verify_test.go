@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// writeLog records n entries and returns the path of the single log file.
+func writeLog(t *testing.T, dir string, n int) string {
+	t.Helper()
+	l, err := Open(dir, testKey, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := l.Record(context.Background(), Entry{Action: ActionDataAccess, Actor: "alice", Resource: "GET /api/items"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+	files, err := Files(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Files() = %v, %v", files, err)
+	}
+	return files[0]
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(lines []string) []string
+		key     []byte
+		wantMsg string // Substring of a reported problem, empty for a clean chain
+	}{
+		{"untouched", func(l []string) []string { return l }, testKey, ""},
+		{"modified entry", func(l []string) []string {
+			l[1] = strings.Replace(l[1], `"actor":"alice"`, `"actor":"mallory"`, 1)
+			return l
+		}, testKey, "hash mismatch"},
+		{"deleted entry", func(l []string) []string {
+			return append(l[:1], l[2:]...)
+		}, testKey, "gap"},
+		{"reordered entries", func(l []string) []string {
+			l[1], l[2] = l[2], l[1]
+			return l
+		}, testKey, "out of order"},
+		{"deleted first entry", func(l []string) []string { return l[1:] }, testKey, "does not start at seq 1"},
+		{"checked with another key", func(l []string) []string { return l }, []byte("another key, also thirty-two byt"), "MAC mismatch"},
+		{"unsigned check of a signed log", func(l []string) []string { return l }, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeLog(t, dir, 4)
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tt.tamper(strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n"))
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := Verify(dir, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantMsg == "" {
+				if !report.OK() {
+					t.Errorf("Verify() problems = %v, want none", report.Problems)
+				}
+				return
+			}
+			for _, p := range report.Problems {
+				if strings.Contains(p.Msg, tt.wantMsg) {
+					return
+				}
+			}
+			t.Errorf("Verify() problems = %v, want %q", report.Problems, tt.wantMsg)
+		})
+	}
+}
+
+// Truncating the tail leaves a valid chain; only a recorded head shows it.
+func TestVerifyReportsHead(t *testing.T) {
+	dir := t.TempDir()
+	path := writeLog(t, dir, 3)
+	full, err := Verify(dir, testKey)
+	if err != nil || !full.OK() {
+		t.Fatalf("Verify() = %v, %v", full, err)
+	}
+
+	raw, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(string(raw), "\n")
+	if err := os.WriteFile(path, []byte(strings.Join(lines[:2], "")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cut, err := Verify(dir, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cut.OK() {
+		t.Errorf("truncated log problems = %v; the chain itself can't show truncation", cut.Problems)
+	}
+	if cut.LastSeq != 2 || cut.LastHash == full.LastHash {
+		t.Errorf("truncated head = seq %d %s, want seq 2 and a hash other than the recorded %s", cut.LastSeq, cut.LastHash, full.LastHash)
+	}
+}
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"wodge/internal/acceptance"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	acceptanceKeyFile string
+	acceptanceIssuer  string
+)
+
+var acceptanceCmd = &cobra.Command{
+	Use:   "acceptance",
+	Short: "Manage the signed service acceptance list",
+	Long: `The service acceptance list names the service bridges an app may use.
+The server checks QAST_URL, ASTAUTH_URL, OIDC_ISSUER, RABBITMQ_URL,
+POSTGRES_DSN and REDIS_ADDR against it at startup, and 'wodge compliance
+scan' checks them at build time.
+
+The list lives in acceptance.json (ACCEPTANCE_LIST) and is verified with
+ACCEPTANCE_PUBLIC_KEY. ACCEPTANCE_MODE=warn (the default) logs services that
+are not accepted, strict refuses to start, off skips the check.
+
+  {
+    "issuer": "platform team",
+    "services": [
+      {"kind": "qast", "hosts": ["qast.example.com"], "require_tls": true, "min_version": "1.4"},
+      {"kind": "postgres", "hosts": ["*.db.example.com"], "require_tls": true, "min_version": "15"},
+      {"kind": "redis", "hosts": ["127.0.0.1", "localhost"]}
+    ]
+  }
+
+Postgres, Redis and RabbitMQ report their version when connected. For QAST
+and AstAuth set QAST_VERSION and ASTAUTH_VERSION to the deployed version.`,
+}
+
+var acceptanceKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Create a signing key pair",
+	Args:  cobra.NoArgs,
+	Run:   runAcceptanceKeygen,
+}
+
+var acceptanceSignCmd = &cobra.Command{
+	Use:   "sign [list]",
+	Short: "Sign the acceptance list, bumping its version",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runAcceptanceSign,
+}
+
+func init() {
+	acceptanceKeygenCmd.Flags().StringVar(&acceptanceKeyFile, "key", "acceptance.key", "Where to write the private key")
+	acceptanceSignCmd.Flags().StringVar(&acceptanceKeyFile, "key", "acceptance.key", "Private key from keygen")
+	acceptanceSignCmd.Flags().StringVar(&acceptanceIssuer, "issuer", "", "Set the issuer recorded in the list")
+	acceptanceCmd.AddCommand(acceptanceKeygenCmd, acceptanceSignCmd)
+	complianceCmd.AddCommand(acceptanceCmd)
+}
+
+func runAcceptanceKeygen(cmd *cobra.Command, args []string) {
+	if _, err := os.Stat(acceptanceKeyFile); err == nil {
+		fmt.Printf("Error: %s already exists\n", acceptanceKeyFile)
+		os.Exit(1)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	key := base64.StdEncoding.EncodeToString(priv) + "\n"
+	if err := os.WriteFile(acceptanceKeyFile, []byte(key), 0o600); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Private key written to %s, keep it out of the repository\n", acceptanceKeyFile)
+	fmt.Println("\nAdd the public key to the app's .env:")
+	fmt.Printf("  ACCEPTANCE_PUBLIC_KEY=%s\n", base64.StdEncoding.EncodeToString(pub))
+}
+
+func runAcceptanceSign(cmd *cobra.Command, args []string) {
+	path := acceptance.DefaultPath
+	if len(args) > 0 {
+		path = args[0]
+	}
+	raw, err := os.ReadFile(acceptanceKeyFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	priv, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(priv) != ed25519.PrivateKeySize {
+		fmt.Printf("Error: %s is not an ed25519 private key\n", acceptanceKeyFile)
+		os.Exit(1)
+	}
+
+	raw, err = os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	var list acceptance.List
+	if err := json.Unmarshal(raw, &list); err != nil {
+		fmt.Printf("Error: %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	if acceptanceIssuer != "" {
+		list.Issuer = acceptanceIssuer
+	}
+	list.Sign(ed25519.PrivateKey(priv), time.Now())
+
+	out, _ := json.MarshalIndent(list, "", "  ")
+	if err := os.WriteFile(path, append(out, '\n'), 0o644); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Signed %s, version %d\n", path, list.Version)
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 	"unicode"
+	"wodge/internal/ingest"
 
 	"github.com/spf13/cobra"
 )
@@ -21,7 +22,9 @@ var addAPICmd = &cobra.Command{
 	Long: `Adds a new API. 
 If 'crud [name]' is specified, it creates a simple CRUD skeleton.
 If name is 'health', it adds a health check client.
-If name is 'postgres', 'redis', or 'rabbitmq', it adds a client library for that service.`,
+If name is 'postgres', 'redis', or 'rabbitmq', it adds a client library for that service.
+If name is 'auth', it adds the auth client; 'auth oidc' (or just 'oidc') configures
+an external OpenID Connect provider instead of AstAuth.`,
 	Args: cobra.RangeArgs(1, 2),
 	Run:  runAddAPI,
 }
@@ -67,13 +70,21 @@ func runAddAPI(cmd *cobra.Command, args []string) {
 	case "qast":
 		addQastClient(appRoot)
 	case "auth", "astauth":
-		addAuthClient(appRoot)
+		provider := "astauth"
+		if len(args) > 1 {
+			provider = args[1]
+		}
+		addAuthClient(appRoot, provider)
+	case "oidc":
+		addAuthClient(appRoot, "oidc")
 	case "health":
 		addHealthRoute(appRoot)
 	default:
 		// Default behavior: Create a generic API route
 		addGenericAPIRoute(appRoot, apiName)
+		return
 	}
+	printClassification(apiName)
 }
 
 func addGenericAPIRoute(appRoot, apiName string) {
@@ -242,12 +253,52 @@ export const rabbitmq = {
 	fmt.Println("  docker run --name rabbitmq -p 5672:5672 -d rabbitmq")
 }
 
-func addAuthClient(appRoot string) {
-	fmt.Println("Adding AstAuth Client...")
+func addAuthClient(appRoot, provider string) {
+	if provider != "astauth" && provider != "oidc" {
+		fmt.Printf("Error: unknown auth provider %q (expected astauth or oidc)\n", provider)
+		os.Exit(1)
+	}
+	fmt.Printf("Adding Auth Client (%s)...\n", provider)
 	files := map[string]string{
-		"src/api/auth.ts": `import { apiPost } from '@/lib/wodge';
+		"src/api/auth.ts": `import { apiGet, apiPost, apiDelete, API_BASE } from '@/lib/wodge';
+
+const b64url = (buf: ArrayBuffer): string =>
+  btoa(String.fromCharCode(...new Uint8Array(buf))).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+
+const fromB64url = (s: string): Uint8Array =>
+  Uint8Array.from(atob(s.replace(/-/g, '+').replace(/_/g, '/')), c => c.charCodeAt(0));
+
+// Asks a security key to answer the webauthn options of login() or mfaChallenge()
+const securityKeyAnswer = async (options: { challenge: string; rp_id: string; allow_credentials: string[] }) => {
+  const cred = await navigator.credentials.get({
+      publicKey: {
+          challenge: fromB64url(options.challenge),
+          rpId: options.rp_id,
+          allowCredentials: options.allow_credentials.map(id => ({ type: 'public-key' as const, id: fromB64url(id) })),
+          userVerification: 'preferred',
+      },
+  }) as PublicKeyCredential;
+  const res = cred.response as AuthenticatorAssertionResponse;
+  return {
+      method: 'webauthn',
+      credential_id: cred.id,
+      client_data_json: b64url(res.clientDataJSON),
+      authenticator_data: b64url(res.authenticatorData),
+      signature: b64url(res.signature),
+  };
+};
 
 export const auth = {
+  // Which flows the server offers: { provider, password_login, register, redirect_login }
+  async config(): Promise<any> {
+    return apiGet('/auth/config');
+  },
+
+  // Redirect login through an external identity provider (OIDC, code flow + PKCE)
+  loginWithProvider(): void {
+    window.location.href = API_BASE + '/auth/oidc/login';
+  },
+
   async login(username: string, password: string): Promise<any> {
     return apiPost('/auth/login', { username, password });
   },
@@ -263,44 +314,174 @@ export const auth = {
     });
   },
   
-  async verify(token: string): Promise<any> {
-      // Fetch user profile to verify token validity
-      const { API_BASE } = await import('@/lib/wodge');
-      const res = await fetch(API_BASE + '/users/me', {
-        method: 'GET',
-        headers: { 
-            'Content-Type': 'application/json',
-            'Authorization': 'Bearer ' + token
-        }
-      });
-      if (!res.ok) throw new Error("Verification failed");
-      const user = await res.json();
-      return { user }; // Wrap in object to match expected structure if needed, or return user directly depending on usage
+  // Verifies the current session. Works for both the HttpOnly cookie session
+  // and a bearer token (sent by apiGet when one is stored).
+  async verify(): Promise<any> {
+      const res = await apiGet('/users/me');
+      return { user: res.user ?? res };
+  },
+
+  async refreshToken(refreshToken?: string): Promise<any> {
+      // In cookie mode the refresh token is sent as an HttpOnly cookie
+      return apiPost('/auth/refresh', refreshToken ? { refresh_token: refreshToken } : {});
+  },
+  async logout(accessToken?: string, refreshToken?: string): Promise<any> {
+      return apiPost('/auth/logout', { access_token: accessToken || '', refresh_token: refreshToken || '' });
+  },
+
+  // Second login step when login() answered { mfa_required: true, mfa_token, methods }
+  async mfaVerify(mfaToken: string, method: 'totp' | 'recovery', code: string): Promise<any> {
+      return apiPost('/auth/mfa/verify', { mfa_token: mfaToken, method, code });
   },
 
-  async refreshToken(refreshToken: string): Promise<any> {
-      return apiPost('/auth/refresh', { refresh_token: refreshToken });
+  // Second login step with a security key, using the webauthn options from login()
+  async mfaVerifyWebAuthn(mfaToken: string, options: { challenge: string; rp_id: string; allow_credentials: string[] }): Promise<any> {
+      return apiPost('/auth/mfa/verify', { mfa_token: mfaToken, ...await securityKeyAnswer(options) });
   },
-  async logout(accessToken: string, refreshToken: string): Promise<any> {
-      return apiPost('/auth/logout', { access_token: accessToken, refresh_token: refreshToken });
+
+  // Asks a signed-in user for their second factor again, as disableMfa and enrollTotp need.
+  // Returns { mfa_token, methods, webauthn }, like the login challenge.
+  async mfaChallenge(): Promise<any> {
+      return apiPost('/auth/mfa/challenge', {});
+  },
+  // The second factor for mfaChallenge(): a TOTP or recovery code, or the
+  // security key's answer to the challenge
+  async mfaProof(challenge: any, code?: string): Promise<any> {
+      if (code) return { code };
+      return { mfa_token: challenge.mfa_token, ...await securityKeyAnswer(challenge.webauthn) };
+  },
+
+  async mfaStatus(): Promise<any> {
+      return apiGet('/auth/mfa');
+  },
+
+  // Returns { secret, provisioning_uri, recovery_codes }. Render provisioning_uri as a QR code.
+  // Users with a security key pass mfaProof(await mfaChallenge()) first.
+  async enrollTotp(proof: Record<string, string> = {}): Promise<any> {
+      return apiPost('/auth/mfa/totp/enroll', proof);
+  },
+  async confirmTotp(code: string): Promise<any> {
+      return apiPost('/auth/mfa/totp/confirm', { code });
+  },
+  // proof is a code, or mfaProof(await mfaChallenge()) for a security key
+  async disableMfa(proof: string | Record<string, string>): Promise<any> {
+      return apiDelete('/auth/mfa', typeof proof === 'string' ? { code: proof } : proof);
+  },
+
+  async registerSecurityKey(name: string = 'Security key'): Promise<any> {
+      const opts = await apiPost('/auth/mfa/webauthn/register/begin', {});
+      const cred = await navigator.credentials.create({
+          publicKey: {
+              challenge: fromB64url(opts.challenge),
+              rp: opts.rp,
+              user: { ...opts.user, id: fromB64url(opts.user.id) },
+              pubKeyCredParams: opts.pub_key_cred_params,
+              authenticatorSelection: { userVerification: 'preferred' },
+          },
+      }) as PublicKeyCredential;
+      const res = cred.response as AuthenticatorAttestationResponse;
+      return apiPost('/auth/mfa/webauthn/register/finish', {
+          registration_token: opts.registration_token,
+          id: cred.id,
+          client_data_json: b64url(res.clientDataJSON),
+          authenticator_data: b64url(res.getAuthenticatorData()),
+          public_key: b64url(res.getPublicKey()!),
+          public_key_algorithm: res.getPublicKeyAlgorithm(),
+          name,
+      });
   }
 };
 `,
 	}
 	writeFiles(appRoot, files)
 
-	// Default AstAuth URL (running locally alongside wodge)
-	updateEnvFile(appRoot, "ASTAUTH_URL", "http://localhost:8080")
+	if provider == "oidc" {
+		updateEnvFile(appRoot, "AUTH_PROVIDER", "oidc")
+		updateEnvFile(appRoot, "OIDC_ISSUER", "https://idp.example.com/realms/wodge")
+		updateEnvFile(appRoot, "OIDC_CLIENT_ID", "wodge")
+		updateEnvFile(appRoot, "OIDC_CLIENT_SECRET", "")
+		updateEnvFile(appRoot, "OIDC_REDIRECT_URL", "http://localhost:8080/api/auth/oidc/callback")
+		updateEnvFile(appRoot, "OIDC_POST_LOGIN_REDIRECT", "http://localhost:5173/")
+	} else {
+		// Default AstAuth URL (running locally alongside wodge)
+		updateEnvFile(appRoot, "AUTH_PROVIDER", "astauth")
+		updateEnvFile(appRoot, "ASTAUTH_URL", "http://localhost:8080")
+	}
+	// Keep tokens out of JS: HttpOnly cookie sessions with CSRF protection
+	updateEnvFile(appRoot, "AUTH_SESSION_MODE", "cookie")
+	// Privileged roles must enroll a second factor (TOTP or security key)
+	updateEnvFile(appRoot, "MFA_REQUIRED_ROLES", "admin")
 
 	fmt.Println("Auth client added to src/api/auth.ts")
-	fmt.Println("Added ASTAUTH_URL to .env")
-	fmt.Println("Make sure AstAuth service is running on specified URL!")
+	if provider == "oidc" {
+		fmt.Println("Added AUTH_PROVIDER and OIDC_* settings to .env")
+		fmt.Println("Register the redirect URL with your identity provider and set OIDC_ISSUER/OIDC_CLIENT_ID.")
+	} else {
+		fmt.Println("Added AUTH_PROVIDER, ASTAUTH_URL, AUTH_SESSION_MODE and MFA_REQUIRED_ROLES to .env")
+		fmt.Println("Make sure AstAuth service is running on specified URL!")
+	}
 }
 
 func addQastClient(appRoot string) {
 	fmt.Println("Adding QAST Client...")
 	files := map[string]string{
-		"src/api/qast.ts": `import { apiPost, API_BASE } from '@/lib/wodge';
+		"src/api/qast.ts": withTypes(`import { apiGet, apiPost, apiDelete, apiHeaders, API_BASE } from '@/lib/wodge';
+
+// @wodge:types
+
+type ChatEvent = { type: string; data: any };
+
+// Reads an SSE body and calls onEvent for each event. cursor.lastEventId
+// follows the events read, so a dropped stream can be resumed from there.
+async function readEvents(body: ReadableStream<Uint8Array>, onEvent: (event: ChatEvent) => void, cursor: { lastEventId: string }): Promise<void> {
+  const reader = body.getReader();
+  const decoder = new TextDecoder();
+  let buffer = '';
+
+  while (true) {
+    const { value, done } = await reader.read();
+    if (done) break;
+
+    const chunk = decoder.decode(value, { stream: true });
+    buffer += chunk;
+
+    // Parse SSE events - split by double newline
+    const messages = buffer.split('\n\n');
+    buffer = messages.pop() || ''; // Keep incomplete part
+
+    for (const msg of messages) {
+      if (!msg.trim()) continue;
+
+      const lines = msg.split('\n');
+      let eventType = 'message';
+      const dataLines: string[] = [];
+
+      for (const line of lines) {
+        if (line.startsWith('event:')) {
+          eventType = line.substring(6).trim();
+        } else if (line.startsWith('id:')) {
+          cursor.lastEventId = line.substring(3).trim();
+        } else if (line.startsWith('data: ')) {
+          dataLines.push(line.substring(6));
+        } else if (line.startsWith('data:')) {
+          dataLines.push(line.substring(5));
+        }
+      }
+      const data = dataLines.join('\n');
+
+      if (data || eventType === 'done') {
+         let parsedData = data;
+         try {
+             parsedData = JSON.parse(data);
+         } catch {
+             // keep raw string
+         }
+
+         onEvent({ type: eventType, data: parsedData });
+      }
+    }
+  }
+}
 
 export const qast = {
   // RAG Search via Composer
@@ -309,76 +490,120 @@ export const qast = {
   },
 
   // Secure PII Chat with Streaming (SSE)
-  // Calls onEvent with { type: 'status' | 'token_map' | 'chunk' | 'done', data: any }
-  async chatStream(text: string, onEvent: (event: { type: string; data: any }) => void, userId: string = "default-user"): Promise<void> {
-    const headers: Record<string, string> = { 'Content-Type': 'application/json' };
-    const token = localStorage.getItem('access_token');
-        headers['Authorization'] = ` + "`" + `Bearer ${token}` + "`" + `;
-    }
-
+  // Calls onEvent with QAST's events ({ type: 'status' | 'chunk' | ..., data: any })
+  // and wodge's own: 'meta' first, 'usage' and 'done' last, 'error' on failures or limits.
+  // Wodge tokenizes PII before QAST sees it; pass meta's pii_session back on the
+  // next message and to rehydrate() to keep tokens stable across the conversation.
+  // The answer keeps running on the server if the connection drops: the stream is
+  // resumed from the last event received, and cancelChat(meta.message_id) stops it.
+  async chatStream(text: string, onEvent: (event: ChatEvent) => void, userId: string = "default-user", piiSession?: string): Promise<void> {
     const response = await fetch(API_BASE + '/qast/chat', {
       method: 'POST',
-      headers,
-      body: JSON.stringify({ text, user_id: userId }),
+      headers: apiHeaders(),
+      credentials: 'include',
+      body: JSON.stringify({ text, user_id: userId, pii_session: piiSession }),
     });
 
     if (!response.ok) {
-      throw new Error("Start stream failed: " + response.statusText);
+      const err = await response.json().catch(() => ({}));
+      throw new Error("Start stream failed: " + (err.detail || response.statusText));
     }
-    
-    if (!response.body) return;
-
-    const reader = response.body.getReader();
-    const decoder = new TextDecoder();
-    let buffer = '';
-
-    while (true) {
-      const { value, done } = await reader.read();
-      if (done) break;
-      
-      const chunk = decoder.decode(value, { stream: true });
-      buffer += chunk;
-      
-      // Parse SSE events - split by double newline
-      const messages = buffer.split('\n\n');
-      buffer = messages.pop() || ''; // Keep incomplete part
-      
-      for (const msg of messages) {
-        if (!msg.trim()) continue;
-        
-        const lines = msg.split('\n');
-        let eventType = 'message';
-        let data = '';
-
-        for (const line of lines) {
-          if (line.startsWith('event:')) {
-            eventType = line.substring(6).trim();
-          } else if (line.startsWith('data: ')) {
-            data = line.substring(6);
-          } else if (line.startsWith('data:')) {
-            data = line.substring(5);
-          }
-        }
-        
-        if (data || eventType === 'done') {
-           let parsedData = data;
-           try {
-               parsedData = JSON.parse(data);
-           } catch { 
-               // keep raw string
-           }
-           
-           onEvent({ type: eventType, data: parsedData });
-        }
+
+    let messageId = '';
+    let finished = false;
+    const cursor = { lastEventId: '' };
+    const track = (event: ChatEvent) => {
+      if (event.type === 'meta') messageId = event.data?.message_id || '';
+      if (event.type === 'done') finished = true;
+      onEvent(event);
+    };
+
+    let body = response.body;
+    for (let attempt = 0; ; attempt++) {
+      try {
+        if (body) await readEvents(body, track, cursor);
+      } catch (e) {
+        console.warn("Chat stream dropped, resuming", e);
       }
+      if (finished || !messageId) return;
+      if (attempt >= 5) throw new Error("The answer stream was lost");
+
+      await new Promise(resolve => setTimeout(resolve, Math.min(1000 * 2 ** attempt, 8000)));
+      const resumed = await fetch(API_BASE + '/qast/chat/' + encodeURIComponent(messageId) + '/events', {
+        headers: { ...apiHeaders(), 'Last-Event-ID': cursor.lastEventId },
+        credentials: 'include',
+      }).catch(() => null);
+      if (resumed && (resumed.status === 404 || resumed.status === 401)) {
+        throw new Error("The answer can no longer be resumed");
+      }
+      body = resumed && resumed.ok ? resumed.body : null;
     }
   },
 
+  // Stops a running answer. What was answered so far is kept, marked truncated.
+  async cancelChat(messageId: string): Promise<{ message_id: string; status: string }> {
+    return apiPost('/qast/chat/' + encodeURIComponent(messageId) + '/cancel', {});
+  },
+
   async ingest(text: string, userId: string = "default-user"): Promise<{ status: string; result: any }> {
     return apiPost('/qast/ingest', { text, user_id: userId });
+  },
+
+  // Uploads a PDF, DOCX, Markdown, HTML or text file. Wodge extracts and
+  // chunks it, then ingests the chunks in the background: poll ingestJob()
+  // for per-chunk progress.
+  async uploadDocument(file: File, userId: string = "default-user"): Promise<{ document: Document; job: Job }> {
+    const form = new FormData();
+    form.append('file', file);
+    form.append('user_id', userId);
+    const headers = apiHeaders();
+    delete headers['Content-Type']; // The browser sets the multipart boundary
+    const res = await fetch(API_BASE + '/qast/ingest', {
+      method: 'POST',
+      headers,
+      credentials: 'include',
+      body: form,
+    });
+    const body = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      throw new Error("Upload failed: " + (body.detail || res.statusText));
+    }
+    return body;
+  },
+
+  async ingestJob(jobId: string): Promise<Job> {
+    return apiGet('/qast/ingest/jobs/' + encodeURIComponent(jobId));
+  },
+
+  async documents(): Promise<Document[]> {
+    const res = await apiGet<{ documents: Document[] }>('/qast/documents');
+    return res.documents;
+  },
+
+  // Ingests a stored document again, or only the chunks that failed last time
+  async reingest(documentId: string, failedOnly: boolean = false): Promise<Job> {
+    return apiPost('/qast/documents/' + encodeURIComponent(documentId) + '/reingest', { failed_only: failedOnly });
+  },
+
+  // Removes the document from wodge's manifest. What QAST learned from it
+  // stays in its knowledge graph.
+  async deleteDocument(documentId: string): Promise<{ status: string; retained?: string }> {
+    return apiDelete('/qast/documents/' + encodeURIComponent(documentId));
+  },
+
+  // Puts the real values back into tokenized text. The token map never
+  // leaves the server; only the session's owner can rehydrate.
+  async rehydrate(text: string, piiSession: string): Promise<string> {
+    const res = await apiPost<{ text: string }>('/pii/rehydrate', { text, pii_session: piiSession });
+    return res.text;
+  },
+
+  // Drops the session's token map before it expires, e.g. on "clear chat"
+  async forgetPII(piiSession: string): Promise<void> {
+    await apiDelete('/pii/sessions/' + encodeURIComponent(piiSession));
   }
 };
-`,
+`, ingest.Document{}, ingest.Job{}),
 	}
 	writeFiles(appRoot, files)
 
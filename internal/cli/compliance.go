@@ -0,0 +1,242 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"wodge/internal/compliance"
+	"wodge/internal/incident"
+	"wodge/internal/monitor"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportIncident string
+	reportFrom     string
+	reportTo       string
+	reportFormat   string
+	reportOutput   string
+
+	scanJSON   bool
+	scanStrict bool
+)
+
+var complianceCmd = &cobra.Command{
+	Use:   "compliance",
+	Short: "Compliance reporting for the current Wodge app",
+}
+
+var incidentReportCmd = &cobra.Command{
+	Use:   "incident-report",
+	Short: "Draft a NIS2 Article 23 incident report",
+	Long: `Draft a NIS2 Article 23 incident report from the app's stored monitor
+events, audit log and detected incidents.
+
+The report follows the three notification stages (24h early warning, 72h
+incident notification, one-month final report) and covers affected services,
+timeline, request volume, error rates, affected users and the suspected cause.
+Fields only a person can assess are marked for completion.
+
+Reads the stores directly, so it works while the app is down. Run it from the
+app directory.`,
+	Example: `  wodge compliance incident-report --incident INC-0003
+  wodge compliance incident-report --from 6h --format html -o report.html`,
+	Args: cobra.NoArgs,
+	Run:  runIncidentReport,
+}
+
+var complianceScanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Check the app's components against their classification",
+	Long: `Walk the app for classified components and service clients (see
+'wodge add ui' and 'wodge add api') and the backend APIs they call, then
+check the settings in .env for the controls they require: auth on routes,
+MFA, redaction, audit signing and monitoring.
+
+Every component and service declares its data sensitivity, whether it
+handles PII, its logging requirements and the controls it needs. Missing
+controls are listed as recommendations, most important first.`,
+	Example: `  wodge compliance scan
+  wodge compliance scan --json
+  wodge compliance scan --strict   # exit 1 on recommendations, for CI`,
+	Args: cobra.NoArgs,
+	Run:  runComplianceScan,
+}
+
+func init() {
+	complianceScanCmd.Flags().BoolVar(&scanJSON, "json", false, "Print the result as JSON")
+	complianceScanCmd.Flags().BoolVar(&scanStrict, "strict", false, "Exit with status 1 when there are recommendations")
+	complianceCmd.AddCommand(complianceScanCmd)
+
+	f := incidentReportCmd.Flags()
+	f.StringVar(&reportIncident, "incident", "", "Report on this incident (sets the default window)")
+	f.StringVar(&reportFrom, "from", "", "Window start, RFC 3339 time or duration back from now (default 24h before --to)")
+	f.StringVar(&reportTo, "to", "", "Window end, RFC 3339 time or duration back from now (default now)")
+	f.StringVar(&reportFormat, "format", compliance.FormatMarkdown, "Output format: markdown, json or html (print to PDF)")
+	f.StringVarP(&reportOutput, "output", "o", "", "Write to this file instead of stdout")
+	complianceCmd.AddCommand(incidentReportCmd)
+	rootCmd.AddCommand(complianceCmd)
+}
+
+func runIncidentReport(cmd *cobra.Command, args []string) {
+	appRoot, err := findAppRoot()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	loadEnv(appRoot)
+	if reportOutput != "" {
+		reportOutput, _ = filepath.Abs(reportOutput)
+	}
+	// Store paths in .env are relative to the app
+	if err := os.Chdir(appRoot); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	from, err := compliance.ParseTime(reportFrom, now)
+	if err != nil {
+		fmt.Printf("Error: --from: %v\n", err)
+		os.Exit(1)
+	}
+	to, err := compliance.ParseTime(reportTo, now)
+	if err != nil {
+		fmt.Printf("Error: --to: %v\n", err)
+		os.Exit(1)
+	}
+
+	src := compliance.Sources{}
+	store, err := monitor.StoreFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: monitor events unavailable: %v\n", err)
+	} else if store != nil {
+		defer store.Close()
+		src.Events = store
+	}
+	auditDir := os.Getenv("AUDIT_DIR")
+	if auditDir == "" {
+		auditDir = filepath.Join(".wodge", "audit")
+	}
+	if _, err := os.Stat(auditDir); err == nil {
+		src.AuditDir = auditDir
+	}
+	if os.Getenv("INCIDENT_DETECTION") != "off" {
+		path := os.Getenv("INCIDENT_STORE_PATH")
+		if path == "" {
+			path = filepath.Join(".wodge", "incidents.json")
+		}
+		if src.Incidents, err = incident.OpenStore(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: incidents unavailable: %v\n", err)
+		}
+	}
+
+	entity := compliance.EntityFromEnv()
+	if entity.Name == "" {
+		entity.Name = filepath.Base(appRoot)
+	}
+	report, err := compliance.BuildIncidentReport(src, compliance.Options{
+		IncidentID: reportIncident,
+		From:       from,
+		To:         to,
+		Entity:     entity,
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	out, err := report.Render(reportFormat)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if reportOutput == "" {
+		os.Stdout.Write(out)
+		return
+	}
+	if err := os.WriteFile(reportOutput, out, 0o600); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Report written to %s\n", reportOutput)
+}
+
+func runComplianceScan(cmd *cobra.Command, args []string) {
+	appRoot, err := findAppRoot()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	loadEnv(appRoot)
+	// REDACT_CONFIG and friends are relative to the app
+	if err := os.Chdir(appRoot); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	res, err := compliance.Scan(appRoot)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if scanJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(res)
+	} else {
+		printScan(res)
+	}
+	if scanStrict && len(res.Findings) > 0 {
+		os.Exit(1)
+	}
+}
+
+func printScan(res *compliance.ScanResult) {
+	fmt.Printf("Compliance scan of %s\n\n", res.App)
+	if len(res.Detected) == 0 {
+		fmt.Println("No classified components or services found.")
+	} else {
+		fmt.Println("Classified components and services:")
+		fmt.Printf("  %-15s %-10s %-13s %-4s %-9s %s\n", "NAME", "KIND", "SENSITIVITY", "PII", "LOGGING", "CONTROLS")
+		for _, d := range res.Detected {
+			pii := "no"
+			if d.PII {
+				pii = "yes"
+			}
+			controls := strings.Join(d.Controls, ", ")
+			if controls == "" {
+				controls = "-"
+			}
+			fmt.Printf("  %-15s %-10s %-13s %-4s %-9s %s\n", d.Name, d.Kind, d.Sensitivity, pii, d.Logging, controls)
+		}
+	}
+
+	if len(res.Controls) > 0 {
+		fmt.Println("\nControls:")
+		for _, c := range res.Controls {
+			mark := "✓"
+			if !c.OK {
+				mark = "✗"
+			}
+			fmt.Printf("  %s %-11s %s\n", mark, c.Control, c.Detail)
+		}
+	}
+
+	if len(res.Findings) == 0 {
+		fmt.Println("\n✓ No recommendations")
+		return
+	}
+	fmt.Printf("\nRecommendations (%d):\n", len(res.Findings))
+	for _, f := range res.Findings {
+		fmt.Printf("  [%s] %s\n", strings.ToUpper(f.Priority), f.Message)
+		if len(f.Components) > 0 {
+			fmt.Printf("         Required by: %s\n", strings.Join(f.Components, ", "))
+		}
+		fmt.Printf("         Fix: %s\n", f.Fix)
+	}
+}
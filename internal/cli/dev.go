@@ -173,6 +173,11 @@ func startBackend(appPath string, port int) *os.Process {
 	// Force PORT env var for the server to pick up
 	os.Setenv("PORT", fmt.Sprintf("%d", port))
 
+	// The dev server is a development environment unless .env says otherwise
+	if os.Getenv("WODGE_ENV") == "" {
+		os.Setenv("WODGE_ENV", "development")
+	}
+
 	go func() {
 		server.Start(port)
 	}()
@@ -0,0 +1,189 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+	"wodge/internal/incident"
+	"wodge/internal/registry"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	incidentsApp   string
+	incidentsState string
+	incidentsNote  string
+)
+
+var incidentsCmd = &cobra.Command{
+	Use:   "incidents",
+	Short: "List and handle security incidents detected by a running app",
+	Long: `List and handle the security incidents a running Wodge backend detected
+in its monitor stream (failed login bursts, 5xx spikes, ID enumeration,
+dead-letter growth, refresh token reuse, ...).
+
+Incidents move from open to acknowledged to resolved.`,
+	Run: runIncidentsList,
+}
+
+var incidentsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List incidents",
+	Args:  cobra.NoArgs,
+	Run:   runIncidentsList,
+}
+
+var incidentsAckCmd = &cobra.Command{
+	Use:   "ack <id>",
+	Short: "Acknowledge an open incident",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runIncidentTransition(args[0], "ack")
+	},
+}
+
+var incidentsResolveCmd = &cobra.Command{
+	Use:   "resolve <id>",
+	Short: "Resolve an incident",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runIncidentTransition(args[0], "resolve")
+	},
+}
+
+func init() {
+	incidentsCmd.PersistentFlags().StringVar(&incidentsApp, "app", "", "App to talk to (defaults to the only running app)")
+	incidentsCmd.Flags().StringVar(&incidentsState, "state", "open,acknowledged", "Comma-separated states to list, empty for all")
+	incidentsListCmd.Flags().StringVar(&incidentsState, "state", "open,acknowledged", "Comma-separated states to list, empty for all")
+	incidentsAckCmd.Flags().StringVar(&incidentsNote, "note", "", "Note to record with the incident")
+	incidentsResolveCmd.Flags().StringVar(&incidentsNote, "note", "", "Note to record with the incident")
+	incidentsCmd.AddCommand(incidentsListCmd, incidentsAckCmd, incidentsResolveCmd)
+	rootCmd.AddCommand(incidentsCmd)
+}
+
+func runIncidentsList(cmd *cobra.Command, args []string) {
+	q := url.Values{}
+	if incidentsState != "" {
+		q.Set("state", incidentsState)
+	}
+	var out struct {
+		Incidents []incident.Incident `json:"incidents"`
+	}
+	if err := incidentsRequest("GET", "?"+q.Encode(), nil, &out); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(out.Incidents) == 0 {
+		fmt.Println("No incidents.")
+		return
+	}
+
+	fmt.Printf("%-10s %-9s %-13s %-20s %-6s %-17s %s\n", "ID", "SEVERITY", "STATE", "RULE", "COUNT", "LAST SEEN", "TITLE")
+	for _, inc := range out.Incidents {
+		title := inc.Title
+		if inc.Key != "" {
+			title += " (" + inc.Key + ")"
+		}
+		fmt.Printf("%-10s %-9s %-13s %-20s %-6d %-17s %s\n",
+			inc.ID, strings.ToUpper(inc.Severity), inc.State, inc.Rule, inc.Count,
+			inc.LastSeen.Local().Format("2006-01-02 15:04"), title)
+	}
+}
+
+func runIncidentTransition(id, action string) {
+	body := map[string]string{"note": incidentsNote}
+	if u, err := user.Current(); err == nil {
+		body["by"] = u.Username
+	}
+	var inc incident.Incident
+	if err := incidentsRequest("POST", "/"+url.PathEscape(id)+"/"+action, body, &inc); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ %s is now %s\n", inc.ID, inc.State)
+}
+
+// incidentsRequest calls the incidents API of the selected app and decodes
+// the JSON response into out.
+func incidentsRequest(method, path string, body interface{}, out interface{}) error {
+	app, err := runningApp(incidentsApp)
+	if err != nil {
+		return err
+	}
+	port := app.Port
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:%d/wodge/incidents%s", port, path), &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Operator-Token", operatorToken(app))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("app not reachable on port %d: %w", port, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var problem struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&problem)
+		if problem.Detail != "" {
+			return fmt.Errorf("%s", problem.Detail)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// runningApp finds the named app in the registry, or the only registered
+// app when name is empty.
+func runningApp(name string) (registry.WodgeApp, error) {
+	reg, err := registry.Load()
+	if err != nil {
+		return registry.WodgeApp{}, fmt.Errorf("loading registry: %w", err)
+	}
+	if name != "" {
+		app, ok := reg.Apps[name]
+		if !ok {
+			return registry.WodgeApp{}, fmt.Errorf("app '%s' not found", name)
+		}
+		return app, nil
+	}
+	switch len(reg.Apps) {
+	case 0:
+		return registry.WodgeApp{}, fmt.Errorf("no running Wodge apps found")
+	case 1:
+		for _, app := range reg.Apps {
+			return app, nil
+		}
+	}
+	return registry.WodgeApp{}, fmt.Errorf("multiple apps registered, choose one with --app")
+}
+
+// operatorToken is what the CLI authenticates to an app's operations API
+// with: WODGE_OPERATOR_TOKEN, or the token the app wrote on startup.
+func operatorToken(app registry.WodgeApp) string {
+	if token := os.Getenv("WODGE_OPERATOR_TOKEN"); token != "" {
+		return token
+	}
+	token, _ := os.ReadFile(filepath.Join(app.Path, ".wodge", "operator-token"))
+	return strings.TrimSpace(string(token))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -119,6 +120,7 @@ Available subcommands:
 
 		// Configure global eventChan for valid app
 		currentPort = targetApp.Port
+		currentToken = operatorToken(targetApp)
 
 		p := tea.NewProgram(initialModel(targetApp.Name))
 		if _, err := p.Run(); err != nil {
@@ -152,12 +154,18 @@ func listApps(reg *registry.Registry) {
 }
 
 func init() {
+	monitorCmd.Flags().StringVar(&monitorSince, "since", "15m", "Backfill stored events from this far back (duration or RFC 3339 time, empty for live only)")
 	rootCmd.AddCommand(monitorCmd)
 }
 
 // Global to pass to event listener (not elegant but works for this structure)
 var currentPort int = 8080
 
+// currentToken authenticates the event stream to the app's operations API
+var currentToken string
+
+var monitorSince string
+
 // -- Bubble Tea Model --
 
 type model struct {
@@ -174,6 +182,7 @@ func initialModel(appName string) model {
 	columns := []table.Column{
 		{Title: "Time", Width: 10},
 		{Title: "Type", Width: 10},
+		{Title: "Request", Width: 8},
 		{Title: "Details", Width: 60},
 	}
 
@@ -251,6 +260,10 @@ func (m *model) updateTable() {
 			} else {
 				payloadStr = fmt.Sprintf("%v", e.Payload)
 			}
+		} else if e.Type == monitor.TypePostgres || e.Type == monitor.TypeRedis || e.Type == monitor.TypeRabbitMQ {
+			payloadStr = formatDriverEvent(e.Payload)
+		} else if e.Type == monitor.TypeIncident {
+			payloadStr = formatIncidentEvent(e.Payload)
 		} else {
 			payloadStr = fmt.Sprintf("%v", e.Payload)
 		}
@@ -260,15 +273,56 @@ func (m *model) updateTable() {
 			payloadStr = payloadStr[:57] + "..."
 		}
 
+		// Short prefix is enough to grep for the full ID in the server logs
+		requestID := e.RequestID
+		if len(requestID) > 8 {
+			requestID = requestID[:8]
+		}
+
 		rows = append(rows, table.Row{
 			e.Timestamp.Format("15:04:05"),
 			string(e.Type),
+			requestID,
 			payloadStr,
 		})
 	}
 	m.table.SetRows(rows)
 }
 
+// formatIncidentEvent renders an incident.Incident payload, e.g.
+// | HIGH | open | INC-0003 x2 | Repeated failed logins from one IP
+func formatIncidentEvent(payload interface{}) string {
+	data, ok := payload.(map[string]interface{})
+	if !ok {
+		return fmt.Sprintf("%v", payload)
+	}
+	return fmt.Sprintf("| %s | %v | %v x%v | %v",
+		strings.ToUpper(fmt.Sprint(data["severity"])), data["state"], data["id"], data["count"], data["title"])
+}
+
+// formatDriverEvent renders a monitor.DriverEvent payload. The statement
+// goes last since long ones get truncated, e.g.
+// | QUERY | 1.2ms | 1 rows | SELECT * FROM users WHERE id = $1
+func formatDriverEvent(payload interface{}) string {
+	data, ok := payload.(map[string]interface{})
+	if !ok {
+		return fmt.Sprintf("%v", payload)
+	}
+	out := fmt.Sprintf("| %v | %vms |", data["operation"], data["duration_ms"])
+	if rows, ok := data["rows"]; ok {
+		out += fmt.Sprintf(" %v rows |", rows)
+	} else if bytes, ok := data["bytes"]; ok {
+		out += fmt.Sprintf(" %vB |", bytes)
+	}
+	if errStr, ok := data["error"]; ok {
+		out += fmt.Sprintf(" ERR %v |", errStr)
+	}
+	if stmt, ok := data["statement"]; ok {
+		return out + fmt.Sprintf(" %v", stmt)
+	}
+	return out + fmt.Sprintf(" %v", data["target"])
+}
+
 func (m model) View() string {
 	if m.err != nil {
 		return fmt.Sprintf("Error: %v\n", m.err)
@@ -298,30 +352,51 @@ func listenForEvents() tea.Msg {
 
 var eventChan chan monitor.Event
 
+// startEventStream follows the app's event stream. The first connection
+// backfills --since worth of stored events; reconnects resume after the last
+// event received via Last-Event-ID, so nothing is lost while disconnected.
 func startEventStream() {
-	url := fmt.Sprintf("http://localhost:%d/wodge/monitor/events", currentPort)
-	resp, err := http.Get(url)
-	if err != nil {
-		// Send error maybe? for now retry
-		time.Sleep(1 * time.Second)
-		startEventStream()
-		return
-	}
-	defer resp.Body.Close()
-
-	reader := bufio.NewReader(resp.Body)
+	var lastID uint64
 	for {
-		line, err := reader.ReadString('\n')
+		url := fmt.Sprintf("http://localhost:%d/wodge/monitor/events", currentPort)
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set("X-Operator-Token", currentToken)
+		if lastID > 0 {
+			req.Header.Set("Last-Event-ID", strconv.FormatUint(lastID, 10))
+		} else if monitorSince != "" {
+			q := req.URL.Query()
+			q.Set("since", monitorSince)
+			req.URL.RawQuery = q.Encode()
+		}
+
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
-			break
+			time.Sleep(1 * time.Second)
+			continue
 		}
-		if strings.HasPrefix(line, "data:") {
-			jsonStr := strings.TrimPrefix(line, "data:")
-			var evt monitor.Event
-			if err := json.Unmarshal([]byte(jsonStr), &evt); err == nil {
-				eventChan <- evt
+
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			if strings.HasPrefix(line, "data:") {
+				jsonStr := strings.TrimPrefix(line, "data:")
+				var evt monitor.Event
+				if err := json.Unmarshal([]byte(jsonStr), &evt); err == nil {
+					if evt.ID > lastID {
+						lastID = evt.ID
+					}
+					eventChan <- evt
+				}
 			}
 		}
+		resp.Body.Close()
+		time.Sleep(1 * time.Second)
 	}
 }
 
@@ -5,6 +5,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"wodge/internal/generator"
+	"wodge/internal/services"
 	"wodge/internal/templates"
 
 	"github.com/spf13/cobra"
@@ -44,8 +46,9 @@ func addUIComponent(cmd *cobra.Command, args []string) {
 	case "sidebar":
 		addComponentFile(appRoot, "sidebar", "src/components/ui/Sidebar.tsx", templates.ComponentSidebar)
 		// Sidebar needs API clients
-		addComponentFile(appRoot, "users-api", "src/api/users.ts", templates.ComponentUsersAPI)
-		addComponentFile(appRoot, "history-api", "src/api/history.ts", templates.ComponentHistoryAPI)
+		addComponentFile(appRoot, "users-api", "src/api/users.ts", withTypes(templates.ComponentUsersAPI, services.UserSummary{}))
+		addComponentFile(appRoot, "history-api", "src/api/history.ts", withTypes(templates.ComponentHistoryAPI,
+			services.Session{}, services.Message{}, services.SessionDetail{}, services.SharedSession{}))
 	case "secure-chat", "llm-chat":
 		addSecureChatComponent(appRoot)
 	case "llmwrapper":
@@ -57,6 +60,28 @@ func addUIComponent(cmd *cobra.Command, args []string) {
 		fmt.Println("Available components: button, card, input, navbar, qast-test, secure-chat (llm-chat), login, llmwrapper")
 		os.Exit(1)
 	}
+	printClassification(componentName)
+}
+
+// printClassification tells the developer what a freshly added template
+// handles and which controls it needs. `wodge compliance scan` checks them.
+func printClassification(name string) {
+	c, ok := templates.Classify(name)
+	if !ok || c.Sensitivity == templates.Public {
+		return
+	}
+	fmt.Printf("\nClassification: %s", c.Sensitivity)
+	if c.PII {
+		fmt.Print(", handles personal data")
+	}
+	fmt.Printf(", logging: %s\n", c.Logging)
+	if len(c.Controls) > 0 {
+		fmt.Printf("Required controls: %s\n", strings.Join(c.Controls, ", "))
+	}
+	if c.Note != "" {
+		fmt.Println(c.Note)
+	}
+	fmt.Println("Run `wodge compliance scan` to check them.")
 }
 
 func addLoginPage(appRoot string) {
@@ -115,10 +140,17 @@ func addLoginPage(appRoot string) {
 	}
 
 	fmt.Println("\nIMPORTANT NEXT STEPS:")
-	fmt.Println("1. Ensure your backend AstAuth server is running.")
+	fmt.Println("1. Ensure your auth provider (AstAuth or your OIDC identity provider) is reachable.")
 	fmt.Println("2. Run `wodge run dev` - your routes (except login) should now be protected automatically!")
 }
 
+// withTypes fills an API client template with the TypeScript for the Go
+// models it returns, so the client and server types can't drift apart.
+func withTypes(content string, models ...interface{}) string {
+	types := "// Generated by wodge from its Go models.\n" + generator.TypeScript(models...)
+	return strings.Replace(content, templates.TypesMarker, types, 1)
+}
+
 func addComponentFile(appRoot, component, path, content string) {
 	fullPath := filepath.Join(appRoot, path)
 	dir := filepath.Dir(fullPath)
@@ -143,11 +175,11 @@ func addComponentFile(appRoot, component, path, content string) {
 
 func addSecureChatComponent(appRoot string) {
 	fmt.Println("Adding SecureChat Component and Dependencies...")
-	// Dependencies: Card, Input, Button, TokenManager
+	// Dependencies: Card, Input, Button. PII tokens are rehydrated by the
+	// server, so the TokenManager is no longer needed.
 	addComponentFile(appRoot, "card", "src/components/ui/Card.tsx", templates.ComponentCard)
 	addComponentFile(appRoot, "input", "src/components/ui/Input.tsx", templates.ComponentInput)
 	addComponentFile(appRoot, "button", "src/components/ui/Button.tsx", templates.ComponentButton)
-	addComponentFile(appRoot, "token-manager", "src/utils/TokenManager.ts", templates.ComponentTokenManager)
 
 	// Add the Chat component
 	addComponentFile(appRoot, "secure-chat", "src/components/ui/SecureChat.tsx", templates.ComponentSecureChat)
@@ -0,0 +1,267 @@
+package compliance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Report formats
+const (
+	FormatMarkdown = "markdown"
+	FormatJSON     = "json"
+	FormatHTML     = "html"
+)
+
+// Render writes the report in the given format. The HTML is self-contained
+// and styled for printing to PDF.
+func (r *IncidentReport) Render(format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	case FormatMarkdown, "md", "":
+		err = markdownTemplate.Execute(&buf, r)
+	case FormatHTML:
+		err = htmlTemplate.Execute(&buf, r)
+	default:
+		return nil, fmt.Errorf("unknown report format %q (markdown, json, html)", format)
+	}
+	return buf.Bytes(), err
+}
+
+// ContentType is the MIME type of a format.
+func ContentType(format string) string {
+	switch format {
+	case FormatJSON:
+		return "application/json"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	}
+	return "text/markdown; charset=utf-8"
+}
+
+var funcs = map[string]interface{}{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.UTC().Format("2006-01-02 15:04 UTC")
+	},
+	"pct":   func(f float64) string { return fmt.Sprintf("%.2f%%", f*100) },
+	"upper": strings.ToUpper,
+	"yesno": func(b bool) string {
+		if b {
+			return "Yes"
+		}
+		return "No"
+	},
+	// Keep table cells on one line
+	"cell": func(s string) string { return strings.NewReplacer("|", "\\|", "\n", " ").Replace(s) },
+}
+
+var markdownTemplate = texttemplate.Must(texttemplate.New("markdown").Funcs(funcs).Parse(`# NIS2 incident report (draft)
+
+| | |
+|---|---|
+| Entity | {{if .Entity.Name}}{{.Entity.Name}}{{else}}-{{end}} |
+| Contact | {{if .Entity.Contact}}{{.Entity.Contact}}{{else}}-{{end}} |
+{{- with .Incident}}
+| Incident | {{.ID}}: {{cell .Title}} ({{.State}}) |
+{{- end}}
+| Reporting window | {{time .From}} to {{time .To}} |
+| Generated | {{time .GeneratedAt}} |
+| Evidence | {{range $i, $s := .Sources}}{{if $i}}, {{end}}{{$s}}{{else}}none{{end}} |
+{{if .Gaps}}
+> **Evidence gaps:**
+{{- range .Gaps}}
+> - {{.}}
+{{- end}}
+{{end}}
+## 1. Early warning (within 24 hours, Art. 23(4)(a))
+
+- **Detected:** {{time .EarlyWarning.DetectedAt}}
+- **Due by:** {{time .EarlyWarning.Deadline}}
+- **Suspected unlawful or malicious act:** {{yesno .EarlyWarning.SuspectedMalicious}}
+{{- range .EarlyWarning.Indicators}}
+  - {{.}}
+{{- end}}
+- **Possible cross-border impact:** {{.EarlyWarning.CrossBorderImpact}}
+
+## 2. Incident notification (within 72 hours, Art. 23(4)(b))
+
+- **Due by:** {{time .Notification.Deadline}}
+- **Initial severity assessment:** {{upper .Notification.Severity}}
+{{with .Notification.Impact}}
+### Impact
+
+| Metric | Value |
+|---|---|
+| Requests | {{.Requests}} |
+| Server errors (5xx) | {{.ServerErrors}} |
+| Client errors (4xx) | {{.ClientErrors}} |
+| Server error rate | {{pct .ErrorRate}} |
+| Active users | {{.ActiveUsers}} |
+| Affected users | {{.AffectedUsers}} |
+
+### Affected services
+{{if .AffectedServices}}
+| Service | Calls | Errors | Error rate | Affected |
+|---|---|---|---|---|
+{{- range .AffectedServices}}
+| {{.Name}} | {{.Requests}} | {{.Errors}} | {{pct .ErrorRate}} | {{yesno .Affected}} |
+{{- end}}
+{{else}}
+No service traffic recorded in the window.
+{{end}}
+{{- if .SecurityEvents}}
+### Security events
+
+| Action | Count |
+|---|---|
+{{- range $action, $n := .SecurityEvents}}
+| {{$action}} | {{$n}} |
+{{- end}}
+{{end}}
+{{- end}}
+### Suspected cause
+
+{{.Notification.SuspectedCause.Summary}}
+{{range .Notification.SuspectedCause.Indicators}}
+- {{.}}
+{{- end}}
+
+### Indicators of compromise
+{{if .Notification.IOCs}}
+| Kind | Value | Reason |
+|---|---|---|
+{{- range .Notification.IOCs}}
+| {{.Kind}} | {{cell .Value}} | {{cell .Reason}} |
+{{- end}}
+{{else}}
+None identified.
+{{end}}
+## 3. Timeline
+{{if .Timeline}}
+| Time | Source | Event |
+|---|---|---|
+{{- range .Timeline}}
+| {{time .Time}} | {{.Source}} | {{cell .Summary}} |
+{{- end}}
+{{- if .TimelineTruncated}}
+
+_{{.TimelineTruncated}} more entries omitted, see the audit log._
+{{- end}}
+{{else}}
+No notable events in the window.
+{{end}}
+## 4. Final report (within one month, Art. 23(4)(d))
+
+- **Due by:** {{time .FinalReport.Deadline}}
+- **Detailed description, severity and impact:** {{.FinalReport.Description}}
+- **Type of threat or root cause:** {{.FinalReport.RootCause}}
+- **Applied and ongoing mitigation measures:** {{.FinalReport.Mitigation}}
+- **Cross-border impact:** {{.FinalReport.CrossBorderImpact}}
+`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>NIS2 incident report{{with .Incident}} {{.ID}}{{end}}</title>
+<style>
+@page { size: A4; margin: 18mm 16mm; }
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; font-size: 10.5pt; color: #111; line-height: 1.4; max-width: 180mm; margin: 0 auto; }
+h1 { font-size: 18pt; margin-bottom: 4pt; }
+h2 { font-size: 13pt; border-bottom: 1px solid #999; padding-bottom: 2pt; margin-top: 18pt; page-break-after: avoid; }
+h3 { font-size: 11pt; margin-top: 12pt; page-break-after: avoid; }
+table { border-collapse: collapse; width: 100%; margin: 6pt 0; page-break-inside: auto; }
+tr { page-break-inside: avoid; }
+th, td { border: 1px solid #bbb; padding: 3pt 5pt; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.draft { color: #a00; font-weight: bold; }
+.todo { color: #a60; font-style: italic; }
+.gaps { border-left: 3px solid #a60; padding-left: 8pt; }
+</style>
+</head>
+<body>
+<h1>NIS2 incident report</h1>
+<p class="draft">Draft, generated {{time .GeneratedAt}}. Review before submitting.</p>
+<table>
+<tr><th>Entity</th><td>{{if .Entity.Name}}{{.Entity.Name}}{{else}}-{{end}}</td></tr>
+<tr><th>Contact</th><td>{{if .Entity.Contact}}{{.Entity.Contact}}{{else}}-{{end}}</td></tr>
+{{with .Incident}}<tr><th>Incident</th><td>{{.ID}}: {{.Title}} ({{.State}})</td></tr>{{end}}
+<tr><th>Reporting window</th><td>{{time .From}} to {{time .To}}</td></tr>
+<tr><th>Evidence</th><td>{{range $i, $s := .Sources}}{{if $i}}, {{end}}{{$s}}{{else}}none{{end}}</td></tr>
+</table>
+{{if .Gaps}}<div class="gaps"><strong>Evidence gaps:</strong><ul>{{range .Gaps}}<li>{{.}}</li>{{end}}</ul></div>{{end}}
+
+<h2>1. Early warning (within 24 hours, Art. 23(4)(a))</h2>
+<table>
+<tr><th>Detected</th><td>{{time .EarlyWarning.DetectedAt}}</td></tr>
+<tr><th>Due by</th><td>{{time .EarlyWarning.Deadline}}</td></tr>
+<tr><th>Suspected unlawful or malicious act</th><td>{{yesno .EarlyWarning.SuspectedMalicious}}{{if .EarlyWarning.Indicators}}<ul>{{range .EarlyWarning.Indicators}}<li>{{.}}</li>{{end}}</ul>{{end}}</td></tr>
+<tr><th>Possible cross-border impact</th><td class="todo">{{.EarlyWarning.CrossBorderImpact}}</td></tr>
+</table>
+
+<h2>2. Incident notification (within 72 hours, Art. 23(4)(b))</h2>
+<table>
+<tr><th>Due by</th><td>{{time .Notification.Deadline}}</td></tr>
+<tr><th>Initial severity assessment</th><td>{{upper .Notification.Severity}}</td></tr>
+</table>
+{{with .Notification.Impact}}
+<h3>Impact</h3>
+<table>
+<tr><th>Requests</th><td>{{.Requests}}</td></tr>
+<tr><th>Server errors (5xx)</th><td>{{.ServerErrors}}</td></tr>
+<tr><th>Client errors (4xx)</th><td>{{.ClientErrors}}</td></tr>
+<tr><th>Server error rate</th><td>{{pct .ErrorRate}}</td></tr>
+<tr><th>Active users</th><td>{{.ActiveUsers}}</td></tr>
+<tr><th>Affected users</th><td>{{.AffectedUsers}}</td></tr>
+</table>
+<h3>Affected services</h3>
+{{if .AffectedServices}}<table>
+<tr><th>Service</th><th>Calls</th><th>Errors</th><th>Error rate</th><th>Affected</th></tr>
+{{range .AffectedServices}}<tr><td>{{.Name}}</td><td>{{.Requests}}</td><td>{{.Errors}}</td><td>{{pct .ErrorRate}}</td><td>{{yesno .Affected}}</td></tr>
+{{end}}</table>{{else}}<p>No service traffic recorded in the window.</p>{{end}}
+{{if .SecurityEvents}}<h3>Security events</h3>
+<table>
+<tr><th>Action</th><th>Count</th></tr>
+{{range $action, $n := .SecurityEvents}}<tr><td>{{$action}}</td><td>{{$n}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+<h3>Suspected cause</h3>
+<p>{{.Notification.SuspectedCause.Summary}}</p>
+{{if .Notification.SuspectedCause.Indicators}}<ul>{{range .Notification.SuspectedCause.Indicators}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<h3>Indicators of compromise</h3>
+{{if .Notification.IOCs}}<table>
+<tr><th>Kind</th><th>Value</th><th>Reason</th></tr>
+{{range .Notification.IOCs}}<tr><td>{{.Kind}}</td><td>{{.Value}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>{{else}}<p>None identified.</p>{{end}}
+
+<h2>3. Timeline</h2>
+{{if .Timeline}}<table>
+<tr><th>Time</th><th>Source</th><th>Event</th></tr>
+{{range .Timeline}}<tr><td>{{time .Time}}</td><td>{{.Source}}</td><td>{{.Summary}}</td></tr>
+{{end}}</table>
+{{if .TimelineTruncated}}<p><em>{{.TimelineTruncated}} more entries omitted, see the audit log.</em></p>{{end}}
+{{else}}<p>No notable events in the window.</p>{{end}}
+
+<h2>4. Final report (within one month, Art. 23(4)(d))</h2>
+<table>
+<tr><th>Due by</th><td>{{time .FinalReport.Deadline}}</td></tr>
+<tr><th>Detailed description, severity and impact</th><td class="todo">{{.FinalReport.Description}}</td></tr>
+<tr><th>Type of threat or root cause</th><td class="todo">{{.FinalReport.RootCause}}</td></tr>
+<tr><th>Applied and ongoing mitigation measures</th><td class="todo">{{.FinalReport.Mitigation}}</td></tr>
+<tr><th>Cross-border impact</th><td class="todo">{{.FinalReport.CrossBorderImpact}}</td></tr>
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,555 @@
+// Package compliance assembles the evidence wodge collects (monitor events,
+// the audit log and detected incidents) into regulatory reports, and scans
+// apps for classified components that lack the controls they require.
+package compliance
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"wodge/internal/audit"
+	"wodge/internal/incident"
+	"wodge/internal/monitor"
+	"wodge/internal/security"
+	"wodge/internal/services"
+)
+
+// NIS2 Article 23 reporting deadlines, counted from detection
+const (
+	EarlyWarningDeadline = 24 * time.Hour
+	NotificationDeadline = 72 * time.Hour
+	FinalReportDeadline  = 30 * 24 * time.Hour
+)
+
+// ToComplete marks the fields only the incident owner can fill in.
+const ToComplete = "To be completed by the incident owner"
+
+// maxTimeline caps the timeline; the full record stays in the audit log
+const maxTimeline = 300
+
+// Sources are where a report's evidence comes from. Any of them may be
+// missing, the report then says so.
+type Sources struct {
+	Events    monitor.Store
+	AuditDir  string
+	Incidents *incident.Store
+}
+
+// Options select what to report on. With an incident the window defaults
+// to an hour either side of its first and last match.
+type Options struct {
+	IncidentID string
+	From, To   time.Time
+	Entity     Entity
+}
+
+// Entity identifies the reporting organisation.
+type Entity struct {
+	Name    string `json:"name"`
+	Contact string `json:"contact,omitempty"`
+}
+
+// IncidentReport is a NIS2 Article 23 report draft. Its sections follow the
+// three notification stages: early warning, incident notification and final
+// report.
+type IncidentReport struct {
+	GeneratedAt time.Time           `json:"generated_at"`
+	Entity      Entity              `json:"entity"`
+	From        time.Time           `json:"from"`
+	To          time.Time           `json:"to"`
+	Incident    *incident.Incident  `json:"incident,omitempty"`
+	Incidents   []incident.Incident `json:"incidents"` // All incidents seen in the window
+	Sources     []string            `json:"sources"`
+	Gaps        []string            `json:"gaps,omitempty"` // Missing evidence
+
+	EarlyWarning EarlyWarning    `json:"early_warning"`
+	Notification Notification    `json:"notification"`
+	FinalReport  FinalReport     `json:"final_report"`
+	Timeline     []TimelineEntry `json:"timeline"`
+	// TimelineTruncated counts the entries left out beyond the cap
+	TimelineTruncated int `json:"timeline_truncated,omitempty"`
+}
+
+// EarlyWarning is due within 24 hours (Art. 23(4)(a)).
+type EarlyWarning struct {
+	DetectedAt         time.Time `json:"detected_at"`
+	Deadline           time.Time `json:"deadline"`
+	SuspectedMalicious bool      `json:"suspected_malicious"`
+	Indicators         []string  `json:"indicators,omitempty"`
+	CrossBorderImpact  string    `json:"cross_border_impact"`
+}
+
+// Notification is due within 72 hours (Art. 23(4)(b)).
+type Notification struct {
+	Deadline       time.Time `json:"deadline"`
+	Severity       string    `json:"severity"`
+	Impact         Impact    `json:"impact"`
+	SuspectedCause Cause     `json:"suspected_cause"`
+	// Indicators of compromise
+	IOCs []IOC `json:"indicators_of_compromise,omitempty"`
+}
+
+// FinalReport is due within one month (Art. 23(4)(d)).
+type FinalReport struct {
+	Deadline          time.Time `json:"deadline"`
+	Description       string    `json:"description"`
+	RootCause         string    `json:"root_cause"`
+	Mitigation        string    `json:"mitigation"`
+	CrossBorderImpact string    `json:"cross_border_impact"`
+}
+
+// Impact summarises service degradation and who was affected.
+type Impact struct {
+	Requests         int             `json:"requests"`
+	ServerErrors     int             `json:"server_errors"`
+	ClientErrors     int             `json:"client_errors"`
+	ErrorRate        float64         `json:"error_rate"` // Server errors per request
+	ActiveUsers      int             `json:"active_users"`
+	AffectedUsers    int             `json:"affected_users"`
+	AffectedServices []ServiceImpact `json:"affected_services"`
+	SecurityEvents   map[string]int  `json:"security_events,omitempty"` // By action
+}
+
+// ServiceImpact is the traffic of one API area or driver.
+type ServiceImpact struct {
+	Name      string  `json:"name"`
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Affected  bool    `json:"affected"`
+}
+
+// Cause is the machine-drafted starting point for the cause analysis.
+type Cause struct {
+	Summary    string   `json:"summary"`
+	Indicators []string `json:"indicators,omitempty"`
+}
+
+// IOC is an indicator of compromise, e.g. an IP behind failed logins.
+type IOC struct {
+	Kind   string `json:"kind"` // ip, token, user, queue
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+// TimelineEntry is one notable moment in the window.
+type TimelineEntry struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"` // incident, audit, monitor
+	Summary string    `json:"summary"`
+}
+
+// BuildIncidentReport assembles a report draft from src.
+func BuildIncidentReport(src Sources, opts Options) (*IncidentReport, error) {
+	r := &IncidentReport{GeneratedAt: time.Now().UTC(), Entity: opts.Entity, From: opts.From, To: opts.To}
+
+	if opts.IncidentID != "" {
+		if src.Incidents == nil {
+			return nil, services.Unavailable("Incident detection is not enabled")
+		}
+		inc, err := src.Incidents.Get(opts.IncidentID)
+		if err != nil {
+			return nil, err
+		}
+		r.Incident = &inc
+		if r.From.IsZero() {
+			r.From = inc.FirstSeen.Add(-time.Hour)
+		}
+		if r.To.IsZero() {
+			r.To = inc.LastSeen.Add(time.Hour)
+			if inc.ResolvedAt != nil && inc.ResolvedAt.After(r.To) {
+				r.To = *inc.ResolvedAt
+			}
+		}
+	}
+	if r.To.IsZero() {
+		r.To = r.GeneratedAt
+	}
+	if r.From.IsZero() {
+		r.From = r.To.Add(-24 * time.Hour)
+	}
+	if !r.From.Before(r.To) {
+		return nil, services.Validation("The report window must start before it ends")
+	}
+
+	a := newAnalysis()
+	if src.Incidents != nil {
+		r.Sources = append(r.Sources, "incidents")
+		for _, inc := range src.Incidents.List() {
+			if inc.LastSeen.Before(r.From) || !inc.FirstSeen.Before(r.To) {
+				continue
+			}
+			r.Incidents = append(r.Incidents, inc)
+			a.incident(inc)
+		}
+	} else {
+		r.Gaps = append(r.Gaps, "Incident detection is not enabled")
+	}
+	if src.Events != nil {
+		events, err := monitor.QueryAll(src.Events, monitor.Filter{Since: r.From, Until: r.To})
+		if err != nil {
+			return nil, fmt.Errorf("reading monitor events: %w", err)
+		}
+		r.Sources = append(r.Sources, "monitor")
+		for _, e := range events {
+			a.event(e)
+		}
+	} else {
+		r.Gaps = append(r.Gaps, "Monitor events are not persisted (MONITOR_STORE=off), request volume and error rates are unknown")
+	}
+	if src.AuditDir != "" {
+		entries, err := audit.Read(src.AuditDir, r.From, r.To)
+		if err != nil {
+			return nil, fmt.Errorf("reading audit log: %w", err)
+		}
+		r.Sources = append(r.Sources, "audit")
+		for _, e := range entries {
+			a.audit(e)
+		}
+	} else {
+		r.Gaps = append(r.Gaps, "No audit log available")
+	}
+	if r.Incidents == nil {
+		r.Incidents = []incident.Incident{}
+	}
+	a.fill(r)
+	return r, nil
+}
+
+// analysis accumulates the evidence of one window.
+type analysis struct {
+	impact        Impact
+	services      map[string]*ServiceImpact
+	activeUsers   map[string]bool
+	affectedUsers map[string]bool
+	failedIPs     map[string]int
+	errorMinutes  map[time.Time]map[string]int // Minute -> route -> 5xx count
+	severity      string
+	malicious     []string
+	iocs          []IOC
+	timeline      []TimelineEntry
+	firstSeen     time.Time
+}
+
+func newAnalysis() *analysis {
+	return &analysis{
+		services:      make(map[string]*ServiceImpact),
+		activeUsers:   make(map[string]bool),
+		affectedUsers: make(map[string]bool),
+		failedIPs:     make(map[string]int),
+		errorMinutes:  make(map[time.Time]map[string]int),
+	}
+}
+
+var severityRank = map[string]int{
+	incident.SeverityLow: 1, incident.SeverityMedium: 2, incident.SeverityHigh: 3, incident.SeverityCritical: 4,
+}
+
+// Rules that point at a deliberate attack rather than a fault
+var maliciousRules = map[string]bool{
+	"failed_logins": true, "id_enumeration": true, "refresh_token_reuse": true,
+}
+
+func (a *analysis) seen(t time.Time) {
+	if a.firstSeen.IsZero() || t.Before(a.firstSeen) {
+		a.firstSeen = t
+	}
+}
+
+func (a *analysis) incident(inc incident.Incident) {
+	a.seen(inc.FirstSeen)
+	if severityRank[inc.Severity] > severityRank[a.severity] {
+		a.severity = inc.Severity
+	}
+	if maliciousRules[inc.Rule] {
+		a.malicious = append(a.malicious, fmt.Sprintf("%s: %s", inc.ID, inc.Title))
+	}
+	if inc.Key != "" {
+		a.iocs = append(a.iocs, IOC{Kind: iocKind(inc.Key), Value: inc.Key, Reason: fmt.Sprintf("%s (%s)", inc.Title, inc.ID)})
+	}
+	a.add(inc.FirstSeen, "incident", fmt.Sprintf("%s opened: %s [%s]", inc.ID, inc.Title, inc.Severity))
+	if inc.AcknowledgedAt != nil {
+		a.add(*inc.AcknowledgedAt, "incident", fmt.Sprintf("%s acknowledged by %s", inc.ID, inc.AcknowledgedBy))
+	}
+	if inc.ResolvedAt != nil {
+		a.add(*inc.ResolvedAt, "incident", fmt.Sprintf("%s resolved by %s", inc.ID, inc.ResolvedBy))
+	}
+}
+
+func (a *analysis) event(e monitor.Event) {
+	p, _ := e.Payload.(map[string]interface{})
+	str := func(k string) string { s, _ := p[k].(string); return s }
+	switch e.Type {
+	case monitor.TypeRequest:
+		status := 0
+		if f, ok := p["status"].(float64); ok {
+			status = int(f)
+		}
+		svc := a.service(serviceOf(str("path")))
+		svc.Requests++
+		a.impact.Requests++
+		user := str("user_id")
+		if user != "" {
+			a.activeUsers[user] = true
+		}
+		switch {
+		case status >= 500:
+			svc.Errors++
+			a.impact.ServerErrors++
+			if user != "" {
+				a.affectedUsers[user] = true
+			}
+			minute := e.Timestamp.UTC().Truncate(time.Minute)
+			if a.errorMinutes[minute] == nil {
+				a.errorMinutes[minute] = make(map[string]int)
+			}
+			route := str("route")
+			if route == "" {
+				route = str("path")
+			}
+			a.errorMinutes[minute][str("method")+" "+route]++
+		case status >= 400:
+			a.impact.ClientErrors++
+		}
+	case monitor.TypePostgres, monitor.TypeRedis, monitor.TypeRabbitMQ:
+		svc := a.service(strings.ToLower(string(e.Type)))
+		svc.Requests++
+		if str("error") != "" {
+			svc.Errors++
+		}
+	}
+}
+
+func (a *analysis) audit(e audit.Entry) {
+	if strings.HasPrefix(e.Action, "auth.") {
+		if a.impact.SecurityEvents == nil {
+			a.impact.SecurityEvents = make(map[string]int)
+		}
+		a.impact.SecurityEvents[e.Action]++
+	}
+	if e.Outcome == audit.Failure && strings.HasPrefix(e.Action, "auth.") {
+		if e.IP != "" {
+			a.failedIPs[e.IP]++
+		}
+		if e.ActorID != "" {
+			a.affectedUsers[e.ActorID] = true
+		}
+	}
+	if routineAudit(e) {
+		return
+	}
+	summary := e.Action
+	if e.Actor != "" {
+		summary += " by " + e.Actor
+	}
+	if e.Resource != "" {
+		summary += " on " + e.Resource
+	}
+	if e.IP != "" {
+		summary += " from " + e.IP
+	}
+	if e.Outcome == audit.Failure {
+		summary += " (failed)"
+	}
+	a.add(e.Time, "audit", summary)
+}
+
+// routineAudit leaves everyday entries out of the timeline. They still
+// count towards the impact figures.
+func routineAudit(e audit.Entry) bool {
+	switch e.Action {
+	case audit.ActionDataAccess:
+		return true
+	case security.ActionLogin, security.ActionLogout, security.ActionTokenRefreshed, security.ActionRegister, security.ActionMFAEnrolled:
+		return e.Outcome != audit.Failure
+	}
+	// Incidents come from the incident store with more detail
+	return strings.HasPrefix(e.Action, "incident.")
+}
+
+func (a *analysis) service(name string) *ServiceImpact {
+	s := a.services[name]
+	if s == nil {
+		s = &ServiceImpact{Name: name}
+		a.services[name] = s
+	}
+	return s
+}
+
+func (a *analysis) add(t time.Time, source, summary string) {
+	a.timeline = append(a.timeline, TimelineEntry{Time: t.UTC(), Source: source, Summary: summary})
+}
+
+// fill derives the report sections from the accumulated evidence.
+func (a *analysis) fill(r *IncidentReport) {
+	// Server error bursts, one timeline entry per minute
+	for minute, routes := range a.errorMinutes {
+		total, top, topN := 0, "", 0
+		for route, n := range routes {
+			total += n
+			if n > topN || (n == topN && route < top) {
+				top, topN = route, n
+			}
+		}
+		a.seen(minute)
+		a.add(minute, "monitor", fmt.Sprintf("%d server error(s), most on %s", total, top))
+	}
+
+	impact := a.impact
+	if impact.Requests > 0 {
+		impact.ErrorRate = round(float64(impact.ServerErrors) / float64(impact.Requests))
+	}
+	impact.ActiveUsers = len(a.activeUsers)
+	impact.AffectedUsers = len(a.affectedUsers)
+	impact.AffectedServices = []ServiceImpact{}
+	for _, s := range a.services {
+		if s.Requests > 0 {
+			s.ErrorRate = round(float64(s.Errors) / float64(s.Requests))
+		}
+		s.Affected = s.Errors > 0
+		impact.AffectedServices = append(impact.AffectedServices, *s)
+	}
+	sort.Slice(impact.AffectedServices, func(i, j int) bool {
+		si, sj := impact.AffectedServices[i], impact.AffectedServices[j]
+		if si.Errors != sj.Errors {
+			return si.Errors > sj.Errors
+		}
+		return si.Name < sj.Name
+	})
+
+	for ip, n := range a.failedIPs {
+		if n >= 5 {
+			a.iocs = append(a.iocs, IOC{Kind: "ip", Value: ip, Reason: fmt.Sprintf("%d failed authentication attempts", n)})
+		}
+	}
+	sort.Slice(a.iocs, func(i, j int) bool {
+		if a.iocs[i].Kind != a.iocs[j].Kind {
+			return a.iocs[i].Kind < a.iocs[j].Kind
+		}
+		return a.iocs[i].Value < a.iocs[j].Value
+	})
+
+	severity := a.severity
+	if severity == "" {
+		switch {
+		case impact.ErrorRate >= 0.2:
+			severity = incident.SeverityHigh
+		case impact.ErrorRate >= 0.05:
+			severity = incident.SeverityMedium
+		default:
+			severity = incident.SeverityLow
+		}
+	}
+
+	detected := a.firstSeen
+	if r.Incident != nil {
+		detected = r.Incident.FirstSeen
+	}
+	if detected.IsZero() {
+		detected = r.From
+	}
+	detected = detected.UTC()
+
+	r.EarlyWarning = EarlyWarning{
+		DetectedAt:         detected,
+		Deadline:           detected.Add(EarlyWarningDeadline),
+		SuspectedMalicious: len(a.malicious) > 0,
+		Indicators:         a.malicious,
+		CrossBorderImpact:  ToComplete,
+	}
+	r.Notification = Notification{
+		Deadline:       detected.Add(NotificationDeadline),
+		Severity:       severity,
+		Impact:         impact,
+		SuspectedCause: a.cause(r, impact),
+		IOCs:           a.iocs,
+	}
+	r.FinalReport = FinalReport{
+		Deadline:          detected.Add(FinalReportDeadline),
+		Description:       ToComplete,
+		RootCause:         ToComplete,
+		Mitigation:        ToComplete,
+		CrossBorderImpact: ToComplete,
+	}
+
+	sort.SliceStable(a.timeline, func(i, j int) bool { return a.timeline[i].Time.Before(a.timeline[j].Time) })
+	if len(a.timeline) > maxTimeline {
+		r.TimelineTruncated = len(a.timeline) - maxTimeline
+		a.timeline = a.timeline[:maxTimeline]
+	}
+	r.Timeline = a.timeline
+	if r.Timeline == nil {
+		r.Timeline = []TimelineEntry{}
+	}
+}
+
+// cause drafts the suspected cause from the strongest signal available.
+func (a *analysis) cause(r *IncidentReport, impact Impact) Cause {
+	var c Cause
+	c.Indicators = append(c.Indicators, a.malicious...)
+	for _, s := range impact.AffectedServices {
+		if s.Affected {
+			c.Indicators = append(c.Indicators, fmt.Sprintf("%s: %d of %d calls failed", s.Name, s.Errors, s.Requests))
+		}
+	}
+	switch {
+	case r.Incident != nil && maliciousRules[r.Incident.Rule]:
+		c.Summary = "Suspected malicious activity: " + r.Incident.Title
+	case r.Incident != nil:
+		c.Summary = "Detected by rule " + r.Incident.Rule + ": " + r.Incident.Title
+	case len(a.malicious) > 0:
+		c.Summary = "Suspected malicious activity, see indicators"
+	case impact.ServerErrors > 0:
+		c.Summary = "Service degradation with server errors, see affected services"
+	default:
+		c.Summary = "No cause indicated by the collected evidence"
+	}
+	c.Summary += ". " + ToComplete + "."
+	return c
+}
+
+// serviceOf maps a request path to its API area, e.g. /api/qast/chat -> qast.
+func serviceOf(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "api" {
+		return parts[1]
+	}
+	if len(parts) > 0 && parts[0] != "" {
+		return parts[0]
+	}
+	return "other"
+}
+
+func iocKind(key string) string {
+	if net.ParseIP(key) != nil {
+		return "ip"
+	}
+	return "key"
+}
+
+func round(f float64) float64 {
+	return float64(int(f*10000+0.5)) / 10000
+}
+
+// EntityFromEnv reads NIS2_ENTITY_NAME and NIS2_CONTACT.
+func EntityFromEnv() Entity {
+	return Entity{Name: os.Getenv("NIS2_ENTITY_NAME"), Contact: os.Getenv("NIS2_CONTACT")}
+}
+
+// ParseTime accepts an RFC 3339 time or a duration back from now, e.g. "6h".
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration like 6h", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,443 @@
+package compliance
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"wodge/internal/acceptance"
+	"wodge/internal/audit"
+	"wodge/internal/generator"
+	"wodge/internal/redact"
+	"wodge/internal/templates"
+)
+
+// ControlAcceptance is the service acceptance list check, which applies to
+// the app's service configuration rather than to a component.
+const ControlAcceptance = "acceptance"
+
+// Recommendation priorities
+const (
+	PriorityHigh   = "high"
+	PriorityMedium = "medium"
+	PriorityLow    = "low"
+)
+
+// ScanResult is what `wodge compliance scan` found in an app.
+type ScanResult struct {
+	App       string      `json:"app"`
+	ScannedAt time.Time   `json:"scanned_at"`
+	Detected  []Detection `json:"detected"`
+	// API clients in src/api that no classification covers
+	Unclassified []string         `json:"unclassified,omitempty"`
+	Controls     []ControlStatus  `json:"controls"`
+	Findings     []Recommendation `json:"recommendations"`
+}
+
+// Detection is a classified component or service the app uses, with the
+// files that show it.
+type Detection struct {
+	templates.Classification
+	Evidence []string `json:"evidence"`
+}
+
+// ControlStatus says whether a control some detected component requires is
+// in place.
+type ControlStatus struct {
+	Control    string   `json:"control"`
+	OK         bool     `json:"ok"`
+	Detail     string   `json:"detail"`
+	RequiredBy []string `json:"required_by"`
+}
+
+// Recommendation is a missing control, most important first.
+type Recommendation struct {
+	Priority   string   `json:"priority"`
+	Control    string   `json:"control"`
+	Message    string   `json:"message"`
+	Fix        string   `json:"fix"`
+	Components []string `json:"components,omitempty"`
+}
+
+// sourceFile is a frontend source file, path relative to the app root
+type sourceFile struct {
+	path    string
+	content string
+}
+
+// Scan walks the app's sources for classified components and the backend
+// APIs they call, and checks the settings in the environment (load the
+// app's .env first) against the controls they require.
+func Scan(appRoot string) (*ScanResult, error) {
+	files, err := readSources(appRoot)
+	if err != nil {
+		return nil, err
+	}
+	res := &ScanResult{App: filepath.Base(appRoot), ScannedAt: time.Now().UTC()}
+
+	classified := map[string]bool{}
+	for _, c := range templates.Classifications {
+		var evidence []string
+		for _, f := range c.Files {
+			classified[f] = true
+			if _, err := os.Stat(filepath.Join(appRoot, f)); err == nil {
+				evidence = append(evidence, f)
+			}
+		}
+		for _, f := range files {
+			if containsString(evidence, f.path) {
+				continue
+			}
+			for _, api := range c.APIs {
+				if callsAPI(f.content, api) {
+					evidence = append(evidence, f.path)
+					break
+				}
+			}
+		}
+		if len(evidence) > 0 {
+			res.Detected = append(res.Detected, Detection{Classification: c, Evidence: evidence})
+		}
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.path, "src/api/") && !classified[f.path] {
+			res.Unclassified = append(res.Unclassified, f.path)
+		}
+	}
+
+	res.checkControls()
+	res.checkPublicRoutes(files)
+	res.checkAcceptance()
+	if len(res.Unclassified) > 0 {
+		res.Findings = append(res.Findings, Recommendation{
+			Priority: PriorityLow,
+			Message:  "API clients without a classification: " + strings.Join(res.Unclassified, ", "),
+			Fix:      "Review what data they handle and protect them like the closest classified service",
+		})
+	}
+	sort.SliceStable(res.Findings, func(i, j int) bool {
+		return priorityRank(res.Findings[i].Priority) < priorityRank(res.Findings[j].Priority)
+	})
+	return res, nil
+}
+
+// control is one check in checkControls. check returns whether the control
+// is in place, a description of the current setting, and when missing the
+// recommendation message and fix.
+type control struct {
+	name  string
+	check func() (ok bool, detail, message, fix string)
+}
+
+var controls = []control{
+	{templates.ControlAuth, checkAuth},
+	{templates.ControlMFA, checkMFA},
+	{templates.ControlRedaction, checkRedaction},
+	{templates.ControlAudit, checkAudit},
+	{templates.ControlMonitoring, checkMonitoring},
+}
+
+func (r *ScanResult) checkControls() {
+	for _, ctl := range controls {
+		var requiredBy []string
+		highest := templates.Public
+		for _, d := range r.Detected {
+			if d.Requires(ctl.name) {
+				requiredBy = append(requiredBy, d.Name)
+				if d.Sensitivity > highest {
+					highest = d.Sensitivity
+				}
+			}
+		}
+		if len(requiredBy) == 0 {
+			continue
+		}
+		ok, detail, message, fix := ctl.check()
+		r.Controls = append(r.Controls, ControlStatus{Control: ctl.name, OK: ok, Detail: detail, RequiredBy: requiredBy})
+		if !ok {
+			r.Findings = append(r.Findings, Recommendation{
+				Priority:   priorityFor(highest),
+				Control:    ctl.name,
+				Message:    message,
+				Fix:        fix,
+				Components: requiredBy,
+			})
+		}
+	}
+}
+
+// checkPublicRoutes flags pages that stay reachable without signing in but
+// render components that require auth.
+func (r *ScanResult) checkPublicRoutes(files []sourceFile) {
+	needsAuth := map[string]string{} // Import path -> component
+	for _, d := range r.Detected {
+		if d.Name == "login" || !d.Requires(templates.ControlAuth) {
+			continue
+		}
+		for _, f := range d.Files {
+			imp := "@/" + strings.TrimSuffix(strings.TrimPrefix(f, "src/"), filepath.Ext(f))
+			needsAuth[imp] = d.Name
+		}
+	}
+
+	protected := r.detected("login")
+	for _, f := range files {
+		if !strings.HasPrefix(f.path, "src/routes/") || !strings.HasSuffix(f.path, ".route.tsx") {
+			continue
+		}
+		base := strings.TrimSuffix(filepath.Base(f.path), ".route.tsx")
+		if protected && !generator.IsPublicRoute(base) {
+			continue
+		}
+		var uses []string
+		for imp, name := range needsAuth {
+			if strings.Contains(f.content, "'"+imp+"'") || strings.Contains(f.content, `"`+imp+`"`) {
+				uses = append(uses, name)
+			}
+		}
+		if len(uses) == 0 {
+			continue
+		}
+		sort.Strings(uses)
+		rec := Recommendation{
+			Priority:   PriorityHigh,
+			Control:    templates.ControlAuth,
+			Components: uses,
+		}
+		if protected {
+			rec.Message = f.path + " is a public page but renders " + strings.Join(uses, ", ")
+			rec.Fix = "Rename it so it does not end in .public, or move the component to a protected page"
+		} else {
+			rec.Message = f.path + " renders " + strings.Join(uses, ", ") + " but pages are not behind ProtectedRoute"
+			rec.Fix = "wodge add ui login"
+		}
+		r.Findings = append(r.Findings, rec)
+	}
+}
+
+// checkAcceptance holds the configured service URLs against the signed
+// acceptance list. Versions the services only report once connected are
+// left to the server's startup check.
+func (r *ScanResult) checkAcceptance() {
+	cfg, err := acceptance.FromEnv()
+	services := acceptance.ServicesFromEnv()
+	if cfg.Mode == acceptance.ModeOff || len(services) == 0 {
+		return
+	}
+	priority, consequence := PriorityMedium, "wodge warns about it at startup"
+	if cfg.Mode == acceptance.ModeStrict {
+		priority, consequence = PriorityHigh, "the server refuses to start"
+	}
+	var settings []string
+	for _, s := range services {
+		settings = append(settings, s.Setting)
+	}
+
+	var list *acceptance.List
+	if err == nil {
+		list, err = acceptance.Load(cfg.Path, cfg.PublicKey)
+	}
+	if err != nil {
+		r.Controls = append(r.Controls, ControlStatus{Control: ControlAcceptance, Detail: err.Error(), RequiredBy: settings})
+		r.Findings = append(r.Findings, Recommendation{
+			Priority:   priority,
+			Control:    ControlAcceptance,
+			Message:    "The service acceptance list can't be used (" + err.Error() + "), so " + consequence,
+			Fix:        "Sign " + cfg.Path + " with `wodge compliance acceptance sign` and set ACCEPTANCE_PUBLIC_KEY",
+			Components: settings,
+		})
+		return
+	}
+
+	rejected := 0
+	for _, s := range services {
+		v := list.Check(s)
+		if v == nil && !acceptance.ReportsVersion(s.Kind) {
+			v = list.CheckVersion(s, s.Declared)
+		}
+		if v == nil {
+			continue
+		}
+		rejected++
+		r.Findings = append(r.Findings, Recommendation{
+			Priority:   priority,
+			Control:    ControlAcceptance,
+			Message:    s.Setting + ": " + v.Error() + ", " + consequence,
+			Fix:        "Point " + s.Setting + " at an accepted service, or add it to the acceptance list and re-sign it",
+			Components: []string{s.Setting},
+		})
+	}
+	r.Controls = append(r.Controls, ControlStatus{
+		Control:    ControlAcceptance,
+		OK:         rejected == 0,
+		Detail:     fmt.Sprintf("list version %d, %d of %d services accepted", list.Version, len(services)-rejected, len(services)),
+		RequiredBy: settings,
+	})
+}
+
+func (r *ScanResult) detected(name string) bool {
+	for _, d := range r.Detected {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func checkAuth() (bool, string, string, string) {
+	switch provider := os.Getenv("AUTH_PROVIDER"); provider {
+	case "oidc":
+		if os.Getenv("OIDC_ISSUER") == "" {
+			return false, "AUTH_PROVIDER=oidc without OIDC_ISSUER",
+				"No identity provider is configured, so requests to the backend are anonymous",
+				"Set OIDC_ISSUER, OIDC_CLIENT_ID and OIDC_REDIRECT_URL in .env"
+		}
+		return true, "OIDC (" + os.Getenv("OIDC_ISSUER") + ")", "", ""
+	case "", "astauth":
+		if os.Getenv("ASTAUTH_URL") == "" {
+			return false, "ASTAUTH_URL is not set",
+				"No auth provider is configured, so requests to the backend are anonymous",
+				"wodge add api auth, then set ASTAUTH_URL (or AUTH_PROVIDER=oidc)"
+		}
+		return true, "AstAuth (" + os.Getenv("ASTAUTH_URL") + ")", "", ""
+	default:
+		return false, "unknown AUTH_PROVIDER " + provider,
+			"AUTH_PROVIDER is not recognised, the server starts without auth",
+			"Set AUTH_PROVIDER to astauth or oidc"
+	}
+}
+
+func checkMFA() (bool, string, string, string) {
+	if os.Getenv("AUTH_PROVIDER") == "oidc" {
+		// Second factors are the identity provider's business
+		return true, "enforced by the identity provider", "", ""
+	}
+	if roles := os.Getenv("MFA_REQUIRED_ROLES"); roles != "" {
+		return true, "required for " + roles, "", ""
+	}
+	return false, "MFA_REQUIRED_ROLES is not set",
+		"No role has to use a second factor",
+		"Set MFA_REQUIRED_ROLES=admin (comma-separated roles) in .env"
+}
+
+func checkRedaction() (bool, string, string, string) {
+	if _, err := redact.FromEnv(); err != nil {
+		return false, "invalid: " + err.Error(),
+			"The redaction config does not load, the server falls back to the defaults",
+			"Fix REDACT_CONFIG or REDACT_MAX_BODY_BYTES"
+	}
+	if os.Getenv("REDACT_HASH_KEY") == "" {
+		return false, "REDACT_HASH_KEY is not set",
+			"Redacted values get new pseudonyms on every restart, so events about the same person can't be correlated",
+			"Set REDACT_HASH_KEY to a random secret in .env"
+	}
+	detail := "default rules"
+	if path := os.Getenv("REDACT_CONFIG"); path != "" {
+		detail = path
+	}
+	return true, detail + ", stable pseudonyms", "", ""
+}
+
+func checkAudit() (bool, string, string, string) {
+	key, err := audit.KeyFromEnv("AUDIT_HMAC_KEY")
+	if err != nil {
+		return false, "invalid: " + err.Error(),
+			"The audit key does not load, so audit entries are not signed",
+			"Set AUDIT_HMAC_KEY to 32+ random bytes, base64 encoded"
+	}
+	if key == nil {
+		return false, "unsigned",
+			"Audit entries are hash-chained but not signed, so the chain can be rewritten by anyone with file access",
+			"Set AUDIT_HMAC_KEY (32+ random bytes, base64) in .env"
+	}
+	return true, "signed", "", ""
+}
+
+func checkMonitoring() (bool, string, string, string) {
+	if os.Getenv("MONITOR_STORE") == "off" {
+		return false, "MONITOR_STORE=off",
+			"Monitor events are not stored, so incident reports have no evidence",
+			"Remove MONITOR_STORE=off (or use MONITOR_STORE=postgres)"
+	}
+	if os.Getenv("INCIDENT_DETECTION") == "off" {
+		return false, "INCIDENT_DETECTION=off",
+			"Incidents are not detected, so NIS2 reporting deadlines can pass unnoticed",
+			"Remove INCIDENT_DETECTION=off"
+	}
+	store := os.Getenv("MONITOR_STORE")
+	if store == "" {
+		store = "file"
+	}
+	return true, store + " store, incident detection on", "", ""
+}
+
+// readSources reads the app's TypeScript sources, skipping generated code.
+func readSources(appRoot string) ([]sourceFile, error) {
+	var files []sourceFile
+	err := filepath.WalkDir(filepath.Join(appRoot, "src"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "node_modules" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := filepath.Ext(path)
+		if (ext != ".ts" && ext != ".tsx") || strings.Contains(d.Name(), ".generated.") {
+			return nil
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(appRoot, path)
+		files = append(files, sourceFile{path: filepath.ToSlash(rel), content: string(raw)})
+		return nil
+	})
+	return files, err
+}
+
+// callsAPI reports whether source refers to a backend route starting with
+// prefix in a string literal.
+func callsAPI(source, prefix string) bool {
+	for _, quote := range []string{"'", `"`, "`"} {
+		if strings.Contains(source, quote+prefix) || strings.Contains(source, quote+"/api"+prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func priorityFor(s templates.Sensitivity) string {
+	switch {
+	case s >= templates.Restricted:
+		return PriorityHigh
+	case s >= templates.Confidential:
+		return PriorityMedium
+	}
+	return PriorityLow
+}
+
+func priorityRank(p string) int {
+	switch p {
+	case PriorityHigh:
+		return 0
+	case PriorityMedium:
+		return 1
+	}
+	return 2
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package compliance
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// app writes a fixture app with the given sources and returns its root.
+func app(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "fixture")
+	for path, content := range files {
+		path = filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// env sets the settings Scan reads, unset ones to empty.
+func env(t *testing.T, set map[string]string) {
+	t.Helper()
+	for _, name := range []string{
+		"AUTH_PROVIDER", "ASTAUTH_URL", "OIDC_ISSUER", "MFA_REQUIRED_ROLES",
+		"REDACT_CONFIG", "REDACT_MAX_BODY_BYTES", "REDACT_HASH_KEY", "AUDIT_HMAC_KEY",
+		"MONITOR_STORE", "INCIDENT_DETECTION", "ACCEPTANCE_MODE",
+		"QAST_URL", "RABBITMQ_URL", "POSTGRES_DSN", "REDIS_ADDR",
+	} {
+		t.Setenv(name, set[name])
+	}
+}
+
+func TestScanRecommendations(t *testing.T) {
+	sources := map[string]string{
+		"src/api/history.ts":                "export const list = () => fetch('/api/history/')",
+		"src/api/billing.ts":                "export const invoices = () => fetch('/api/billing/')",
+		"src/routes/history.route.tsx":      "import { list } from '@/api/history'",
+		"src/routes/home.route.tsx":         "export default function Home() {}",
+		"src/routes.generated.tsx":          "import '@/api/history'",
+		"src/node_modules/x/index.ts":       "fetch('/api/postgres/')",
+		"src/components/ui/Button.tsx":      "export function Button() {}",
+		"src/routes/about.public.route.tsx": "export default function About() {}",
+	}
+	login := map[string]string{
+		"src/routes/login.route.tsx":        "export default function Login() {}",
+		"src/context/AuthProvider.tsx":      "export function AuthProvider() {}",
+		"src/components/ProtectedRoute.tsx": "export function ProtectedRoute() {}",
+	}
+	configured := map[string]string{
+		"ASTAUTH_URL":        "http://astauth:8080",
+		"MFA_REQUIRED_ROLES": "admin",
+		"REDACT_HASH_KEY":    "hash-key",
+		"AUDIT_HMAC_KEY":     base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))),
+	}
+	with := func(base map[string]string, extra ...map[string]string) map[string]string {
+		out := map[string]string{}
+		for _, m := range append([]map[string]string{base}, extra...) {
+			for k, v := range m {
+				out[k] = v
+			}
+		}
+		return out
+	}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		env   map[string]string
+		want  []string // "priority control" per recommendation, in order
+		route string   // Page a route recommendation names
+	}{
+		{
+			name:  "nothing configured",
+			files: sources,
+			want:  []string{"high auth", "medium auth", "medium redaction", "medium audit", "low "},
+			route: "src/routes/history.route.tsx renders history-api but pages are not behind ProtectedRoute",
+		},
+		{
+			name:  "controls configured without login",
+			files: sources,
+			env:   configured,
+			want:  []string{"high auth", "low "},
+			route: "src/routes/history.route.tsx",
+		},
+		{
+			name:  "behind login",
+			files: with(sources, login),
+			env:   configured,
+			want:  []string{"low "},
+		},
+		{
+			name:  "behind login without MFA",
+			files: with(sources, login),
+			env:   with(configured, map[string]string{"MFA_REQUIRED_ROLES": ""}),
+			want:  []string{"high mfa", "low "},
+		},
+		{
+			name: "public page renders user data",
+			files: with(sources, login, map[string]string{
+				"src/routes/about.public.route.tsx": "import { list } from \"@/api/history\"",
+			}),
+			env:   configured,
+			want:  []string{"high auth", "low "},
+			route: "src/routes/about.public.route.tsx is a public page but renders history-api",
+		},
+		{
+			name:  "no monitoring",
+			files: with(sources, login),
+			env:   with(configured, map[string]string{"MONITOR_STORE": "off"}),
+			want:  []string{"high monitoring", "low "},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env(t, with(map[string]string{"ACCEPTANCE_MODE": "off"}, tt.env))
+			res, err := Scan(app(t, tt.files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			route := ""
+			for _, f := range res.Findings {
+				got = append(got, f.Priority+" "+f.Control)
+				if f.Control == "auth" && f.Priority == PriorityHigh {
+					route = f.Message
+				}
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("recommendations = %q, want %q", got, tt.want)
+			}
+			if !strings.HasPrefix(route, tt.route) || tt.route == "" && route != "" {
+				t.Errorf("route recommendation = %q, want %q", route, tt.route)
+			}
+			if strings.Join(res.Unclassified, ",") != "src/api/billing.ts" {
+				t.Errorf("unclassified = %q, want only src/api/billing.ts", res.Unclassified)
+			}
+		})
+	}
+}
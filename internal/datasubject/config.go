@@ -0,0 +1,101 @@
+package datasubject
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"time"
+	"wodge/internal/services"
+)
+
+// DefaultConfigPath is where the app declares its own stores, relative to
+// the app root.
+const DefaultConfigPath = "datasubjects.json"
+
+// Config declares where the app keeps user-linked data beyond what wodge
+// finds on its own (QAST, monitor events, MFA):
+//
+//	{
+//	  "postgres": [
+//	    {"table": "orders", "column": "customer_id", "time_column": "created_at", "retention": "8760h"},
+//	    {"table": "newsletter", "column": "email", "match": "email"}
+//	  ],
+//	  "redis": ["cart:{user_id}", "session:{user_id}:*"]
+//	}
+type Config struct {
+	Postgres []TableConfig `json:"postgres"`
+	Redis    []string      `json:"redis"`
+}
+
+type TableConfig struct {
+	Table      string `json:"table"`
+	Column     string `json:"column"`
+	Match      string `json:"match,omitempty"` // user_id (default), username or email
+	TimeColumn string `json:"time_column,omitempty"`
+	Retention  string `json:"retention,omitempty"` // Go duration, needs time_column
+}
+
+var identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// ConfigFromEnv loads DATA_SUBJECT_CONFIG, or datasubjects.json when it
+// exists. No file means no app-specific stores.
+func ConfigFromEnv() (Config, error) {
+	var cfg Config
+	path := os.Getenv("DATA_SUBJECT_CONFIG")
+	explicit := path != ""
+	if !explicit {
+		path = DefaultConfigPath
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, t := range cfg.Postgres {
+		if _, err := t.retention(); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, ident := range []string{t.Table, t.Column, t.TimeColumn} {
+			if ident != "" && !identRe.MatchString(ident) {
+				return cfg, fmt.Errorf("%s: invalid identifier %q", path, ident)
+			}
+		}
+		if t.Table == "" || t.Column == "" {
+			return cfg, fmt.Errorf("%s: postgres entries need a table and a column", path)
+		}
+		switch t.Match {
+		case "", "user_id", "username", "email":
+		default:
+			return cfg, fmt.Errorf("%s: %s: match must be user_id, username or email", path, t.Table)
+		}
+	}
+	return cfg, nil
+}
+
+func (t TableConfig) retention() (time.Duration, error) {
+	if t.Retention == "" {
+		return 0, nil
+	}
+	if t.TimeColumn == "" {
+		return 0, fmt.Errorf("%s: retention needs a time_column", t.Table)
+	}
+	d, err := time.ParseDuration(t.Retention)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s: invalid retention %q", t.Table, t.Retention)
+	}
+	return d, nil
+}
+
+// SQLTable builds the store for the entry.
+func (t TableConfig) SQLTable(db services.DatabaseService) *SQLTable {
+	d, _ := t.retention()
+	return &SQLTable{DB: db, Table: t.Table, Column: t.Column, Match: t.Match, TimeColumn: t.TimeColumn, RetentionPeriod: d}
+}
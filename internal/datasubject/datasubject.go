@@ -0,0 +1,437 @@
+// Package datasubject handles data-subject requests: exporting and erasing
+// everything an app stores about one person across its services, and
+// purging records past their retention period.
+package datasubject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"wodge/internal/audit"
+	"wodge/internal/logging"
+	"wodge/internal/services"
+)
+
+// Operations
+const (
+	OpExport = "export"
+	OpErase  = "erase"
+)
+
+// Job and store states
+const (
+	StatePending = "pending"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+	StateSkipped = "skipped" // The store can't match the subject
+)
+
+// ErrUnmatched is returned by stores keyed by an identifier the subject was
+// given without. The job marks the store skipped and the erase unverified
+// rather than counting nothing.
+var ErrUnmatched = errors.New("the identifier this store matches on is unknown")
+
+// unmatched names the missing identifier.
+func unmatched(field string) error {
+	return fmt.Errorf("%w: %s", ErrUnmatched, field)
+}
+
+// maxJobs finished jobs are kept for the API, oldest dropped first
+const maxJobs = 100
+
+// Subject identifies the person a request is about. Stores match on
+// whichever identifiers they key their data by.
+type Subject struct {
+	UserID   string `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+// Store is one place the app keeps user-linked data.
+type Store interface {
+	Name() string
+	// Export returns the records stored about s.
+	Export(ctx context.Context, s Subject) ([]interface{}, error)
+	// Erase deletes them and returns how many were removed.
+	Erase(ctx context.Context, s Subject) (int, error)
+	// Count is how many records about s remain. An erase is verified by
+	// counting again afterwards.
+	Count(ctx context.Context, s Subject) (int, error)
+}
+
+// Resolver fills in identifiers a subject was given without, e.g. the user
+// ID for a request by email. It leaves fields it can't resolve empty.
+type Resolver func(ctx context.Context, s Subject) (Subject, error)
+
+// Purger is implemented by stores with a retention period.
+type Purger interface {
+	Retention() time.Duration
+	// Purge deletes records older than before.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+// Retained is user-linked data a request deliberately leaves in place.
+type Retained struct {
+	Store  string `json:"store"`
+	Reason string `json:"reason"`
+}
+
+// Job is one export or erase request. Stores are processed one after the
+// other, so Stores doubles as the progress report.
+type Job struct {
+	ID         string        `json:"id"`
+	Operation  string        `json:"operation"`
+	Subject    Subject       `json:"subject"`
+	Actor      string        `json:"actor,omitempty"`
+	State      string        `json:"state"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	Done       int           `json:"done"`
+	Total      int           `json:"total"`
+	Stores     []StoreResult `json:"stores"`
+	Retained   []Retained    `json:"retained,omitempty"`
+	// Verified is true when every store was erased and counts zero records
+	// left; a skipped store leaves it false
+	Verified bool `json:"verified,omitempty"`
+}
+
+// StoreResult is the outcome of a job for one store.
+type StoreResult struct {
+	Store   string `json:"store"`
+	State   string `json:"state"`
+	Records int    `json:"records"`
+	// Remaining is the count after an erase, which should be zero
+	Remaining *int          `json:"remaining,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Data      []interface{} `json:"data,omitempty"` // Export only
+}
+
+// StoreInfo describes a registered store.
+type StoreInfo struct {
+	Name      string `json:"name"`
+	Retention string `json:"retention,omitempty"`
+}
+
+// PurgeResult is what one retention run removed from a store.
+type PurgeResult struct {
+	Store   string    `json:"store"`
+	Before  time.Time `json:"before"`
+	Removed int       `json:"removed"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Registry holds the app's stores and runs requests against all of them.
+type Registry struct {
+	mu       sync.Mutex
+	stores   []Store
+	resolve  Resolver
+	retained []Retained
+	jobs     map[string]*Job
+	order    []string
+	nextID   int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{jobs: map[string]*Job{}}
+}
+
+// Register adds a store. Names must be unique, since jobs and the audit
+// trail report stores by name; a second store with a taken name is refused.
+func (r *Registry) Register(s Store) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, st := range r.stores {
+		if st.Name() == s.Name() {
+			return fmt.Errorf("datasubject: a store named %q is already registered", s.Name())
+		}
+	}
+	r.stores = append(r.stores, s)
+	return nil
+}
+
+// SetResolver sets how jobs complete their subject before running the stores.
+func (r *Registry) SetResolver(fn Resolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolve = fn
+}
+
+// Retain records user-linked data that requests leave in place, so the
+// report can say why.
+func (r *Registry) Retain(store, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retained = append(r.retained, Retained{Store: store, Reason: reason})
+}
+
+// Stores lists the registered stores.
+func (r *Registry) Stores() []StoreInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]StoreInfo, 0, len(r.stores))
+	for _, s := range r.stores {
+		info := StoreInfo{Name: s.Name()}
+		if p, ok := s.(Purger); ok && p.Retention() > 0 {
+			info.Retention = p.Retention().String()
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// Start runs op for s in the background and returns the job to poll.
+func (r *Registry) Start(ctx context.Context, op string, s Subject, actor string) (Job, error) {
+	if op != OpExport && op != OpErase {
+		return Job{}, services.Validation(fmt.Sprintf("Unknown operation %q", op))
+	}
+	if s == (Subject{}) {
+		return Job{}, services.Validation("Identify the data subject by user_id, username or email")
+	}
+
+	r.mu.Lock()
+	r.nextID++
+	job := &Job{
+		ID:        fmt.Sprintf("DSR-%04d", r.nextID),
+		Operation: op,
+		Subject:   s,
+		Actor:     actor,
+		State:     StateRunning,
+		StartedAt: time.Now().UTC(),
+		Total:     len(r.stores),
+		Retained:  append([]Retained(nil), r.retained...),
+	}
+	stores := append([]Store(nil), r.stores...)
+	resolve := r.resolve
+	for _, st := range stores {
+		job.Stores = append(job.Stores, StoreResult{Store: st.Name(), State: StatePending})
+	}
+	r.jobs[job.ID] = job
+	r.order = append(r.order, job.ID)
+	r.trim()
+	snapshot := job.copy()
+	r.mu.Unlock()
+
+	// The job outlives the request that started it
+	go r.run(context.WithoutCancel(ctx), job, stores, resolve)
+	return snapshot, nil
+}
+
+// Job returns a snapshot of a job.
+func (r *Registry) Job(id string) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, services.NotFound("Data-subject request not found")
+	}
+	return job.copy(), nil
+}
+
+// Jobs lists the jobs, newest first, without exported data.
+func (r *Registry) Jobs() []Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Job, 0, len(r.order))
+	for i := len(r.order) - 1; i >= 0; i-- {
+		job := r.jobs[r.order[i]].copy()
+		for j := range job.Stores {
+			job.Stores[j].Data = nil
+		}
+		out = append(out, job)
+	}
+	return out
+}
+
+func (r *Registry) run(ctx context.Context, job *Job, stores []Store, resolve Resolver) {
+	log := logging.For("datasubject")
+	log.InfoContext(ctx, "Data-subject request started", "id", job.ID, "operation", job.Operation, "stores", len(stores))
+
+	subject := job.Subject
+	if resolve != nil && (subject.UserID == "" || subject.Username == "" || subject.Email == "") {
+		resolved, err := resolve(ctx, subject)
+		if err != nil {
+			log.WarnContext(ctx, "Resolving the data subject failed, stores keyed by the missing identifiers are skipped", "id", job.ID, "error", err)
+		} else {
+			subject = resolved
+			r.update(func() { job.Subject = subject })
+		}
+	}
+
+	failed, skipped := false, false
+	for i, st := range stores {
+		r.update(func() { job.Stores[i].State = StateRunning })
+		res := StoreResult{Store: st.Name(), State: StateDone}
+		var err error
+		switch job.Operation {
+		case OpExport:
+			res.Data, err = st.Export(ctx, subject)
+			res.Records = len(res.Data)
+		case OpErase:
+			res.Records, err = st.Erase(ctx, subject)
+			if err == nil {
+				var left int
+				left, err = st.Count(ctx, subject)
+				res.Remaining = &left
+			}
+		}
+		switch {
+		case errors.Is(err, ErrUnmatched):
+			res = StoreResult{Store: st.Name(), State: StateSkipped, Error: err.Error()}
+			skipped = true
+			log.WarnContext(ctx, "Data-subject request skipped a store", "id", job.ID, "store", st.Name(), "reason", err)
+		case err != nil:
+			res.State, res.Error = StateFailed, err.Error()
+			failed = true
+			log.ErrorContext(ctx, "Data-subject request failed for a store", "id", job.ID, "store", st.Name(), "error", err)
+		}
+		r.update(func() {
+			job.Stores[i] = res
+			job.Done++
+		})
+		recordStore(ctx, job, res)
+	}
+
+	r.update(func() {
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+		job.State = StateDone
+		if failed {
+			job.State = StateFailed
+		}
+		if job.Operation == OpErase {
+			job.Verified = !failed && !skipped
+			for _, res := range job.Stores {
+				if res.Remaining == nil || *res.Remaining > 0 {
+					job.Verified = false
+				}
+			}
+		}
+	})
+
+	// The audit entry holds counts only, never the exported data
+	counts := map[string]interface{}{}
+	var skippedStores []string
+	for _, res := range job.Stores {
+		counts[res.Store] = res.Records
+		if res.State == StateSkipped {
+			skippedStores = append(skippedStores, res.Store)
+		}
+	}
+	entry := audit.Entry{
+		Action:   "datasubject." + job.Operation,
+		Actor:    job.Actor,
+		Resource: job.ID,
+		Details:  map[string]interface{}{"user_id": subject.UserID, "records": counts, "verified": job.Verified},
+	}
+	if skippedStores != nil {
+		entry.Details["skipped"] = skippedStores
+	}
+	if failed {
+		entry.Outcome = audit.Failure
+	}
+	audit.Record(ctx, entry)
+	log.InfoContext(ctx, "Data-subject request finished", "id", job.ID, "state", job.State, "verified", job.Verified)
+}
+
+// recordStore audits a job's outcome for one store as soon as it is known,
+// so the trail shows how far a job got even if the process dies mid-way.
+func recordStore(ctx context.Context, job *Job, res StoreResult) {
+	entry := audit.Entry{
+		Action:   "datasubject." + job.Operation + ".store",
+		Actor:    job.Actor,
+		Resource: job.ID,
+		Details:  map[string]interface{}{"store": res.Store, "state": res.State, "records": res.Records},
+	}
+	if res.Remaining != nil {
+		entry.Details["remaining"] = *res.Remaining
+	}
+	if res.Error != "" {
+		entry.Details["error"] = res.Error
+	}
+	if res.State == StateFailed {
+		entry.Outcome = audit.Failure
+	}
+	audit.Record(ctx, entry)
+}
+
+// Purge deletes records past their retention period from every store that
+// has one.
+func (r *Registry) Purge(ctx context.Context, now time.Time) []PurgeResult {
+	r.mu.Lock()
+	stores := append([]Store(nil), r.stores...)
+	r.mu.Unlock()
+
+	var results []PurgeResult
+	for _, st := range stores {
+		p, ok := st.(Purger)
+		if !ok || p.Retention() <= 0 {
+			continue
+		}
+		res := PurgeResult{Store: st.Name(), Before: now.Add(-p.Retention()).UTC()}
+		n, err := p.Purge(ctx, res.Before)
+		res.Removed = n
+		if err != nil {
+			res.Error = err.Error()
+		}
+		if n > 0 {
+			audit.Record(ctx, audit.Entry{
+				Action:   "datasubject.purge",
+				Resource: res.Store,
+				Details:  map[string]interface{}{"removed": n, "before": res.Before},
+			})
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Store < results[j].Store })
+	return results
+}
+
+// RunRetention purges every interval until ctx is done.
+func (r *Registry) RunRetention(ctx context.Context, interval time.Duration) {
+	log := logging.For("datasubject")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, res := range r.Purge(ctx, time.Now()) {
+			switch {
+			case res.Error != "":
+				log.Error("Retention purge failed", "store", res.Store, "error", res.Error)
+			case res.Removed > 0:
+				log.Info("Retention purge", "store", res.Store, "removed", res.Removed, "before", res.Before)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Registry) update(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn()
+}
+
+// trim drops the oldest finished jobs beyond maxJobs. Callers hold r.mu.
+func (r *Registry) trim() {
+	for len(r.order) > maxJobs {
+		id := r.order[0]
+		if r.jobs[id].State == StateRunning {
+			return
+		}
+		delete(r.jobs, id)
+		r.order = r.order[1:]
+	}
+}
+
+func (j *Job) copy() Job {
+	c := *j
+	c.Stores = append([]StoreResult(nil), j.Stores...)
+	return c
+}
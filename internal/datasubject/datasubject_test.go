@@ -0,0 +1,217 @@
+package datasubject
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+	"wodge/internal/audit"
+	"wodge/internal/pii"
+	"wodge/internal/replay"
+	"wodge/internal/security"
+	"wodge/internal/sse"
+)
+
+// wait polls a job until it finishes.
+func wait(t *testing.T, r *Registry, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := r.Job(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State != StateRunning {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func TestEraseSkipsStoresThatCantMatchTheSubject(t *testing.T) {
+	byEmail := func(_ context.Context, s Subject) (Subject, error) {
+		if s.Email == "alice@example.com" {
+			s.UserID = "u-alice"
+		}
+		return s, nil
+	}
+	tests := []struct {
+		name     string
+		resolve  Resolver
+		subject  Subject
+		state    string
+		verified bool
+	}{
+		{"by user ID", nil, Subject{UserID: "u-alice"}, StateDone, true},
+		{"by email without a resolver", nil, Subject{Email: "alice@example.com"}, StateSkipped, false},
+		{"by email, resolved", byEmail, Subject{Email: "alice@example.com"}, StateDone, true},
+		{"by email, unresolved", byEmail, Subject{Email: "bob@example.com"}, StateSkipped, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := security.NewFileMFAStore(filepath.Join(t.TempDir(), "mfa.json"), nil)
+			if err := store.Put(&security.MFAEnrollment{UserID: "u-alice", TOTPConfirmed: true}); err != nil {
+				t.Fatal(err)
+			}
+			r := NewRegistry()
+			r.Register(&MFAEnrollments{Store: store})
+			r.SetResolver(tt.resolve)
+
+			started, err := r.Start(context.Background(), OpErase, tt.subject, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			job := wait(t, r, started.ID)
+			if got := job.Stores[0].State; got != tt.state {
+				t.Errorf("store state = %q, want %q (%s)", got, tt.state, job.Stores[0].Error)
+			}
+			if job.Verified != tt.verified {
+				t.Errorf("verified = %v, want %v", job.Verified, tt.verified)
+			}
+			left, _ := store.Get("u-alice")
+			if erased := left == nil; erased != tt.verified {
+				t.Errorf("enrollment erased = %v, want %v", erased, tt.verified)
+			}
+		})
+	}
+}
+
+func TestEraseDeletesPIITokenMaps(t *testing.T) {
+	sealer, _ := security.NewSealer(make([]byte, 32))
+	tok, err := pii.New(nil, pii.NewMemoryStore(), sealer, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, owner := range []string{"u-alice", "u-alice", "u-bob"} {
+		if _, _, err := tok.Tokenize(ctx, owner, pii.NewSession(), "call alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewRegistry()
+	r.Register(&PIITokenMaps{Tokenizer: tok})
+
+	started, err := r.Start(ctx, OpErase, Subject{UserID: "u-alice"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := wait(t, r, started.ID)
+	if res := job.Stores[0]; res.Records != 2 || !job.Verified {
+		t.Errorf("erase = %+v, verified %v; want 2 maps erased and verified", res, job.Verified)
+	}
+	if n, _ := tok.Count(ctx, "u-bob"); n != 1 {
+		t.Errorf("bob's maps = %d, want 1", n)
+	}
+}
+
+// Finished chats are erased; running ones are asked to cancel and keep the
+// erase unverified until they end.
+func TestEraseChatStreams(t *testing.T) {
+	ctx := context.Background()
+	buf := replay.NewMemoryBuffer()
+	for _, st := range []replay.Stream{
+		{ID: "done", Owner: "u-alice", Status: "completed"},
+		{ID: "running", Owner: "u-alice", Status: replay.Running},
+		{ID: "bob", Owner: "u-bob", Status: "completed"},
+	} {
+		if err := buf.Save(ctx, &st, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := buf.Append(ctx, st.ID, []sse.Event{{ID: "1", Data: "answer"}}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewRegistry()
+	r.Register(&ChatStreams{Buffer: buf, TTL: time.Hour})
+
+	started, err := r.Start(ctx, OpExport, Subject{UserID: "u-alice"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := wait(t, r, started.ID); job.Stores[0].Records != 2 {
+		t.Errorf("exported %d streams, want 2", job.Stores[0].Records)
+	}
+
+	started, err = r.Start(ctx, OpErase, Subject{UserID: "u-alice"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := wait(t, r, started.ID)
+	res := job.Stores[0]
+	if res.Records != 1 || res.Remaining == nil || *res.Remaining != 1 || job.Verified {
+		t.Errorf("erase = %+v, verified %v; want 1 erased, 1 running left, unverified", res, job.Verified)
+	}
+	if st, err := buf.Load(ctx, "running"); err != nil || !st.Cancel {
+		t.Errorf("running chat = %+v, %v; want a cancel request", st, err)
+	}
+	if _, err := buf.Load(ctx, "bob"); err != nil {
+		t.Errorf("bob's chat was erased: %v", err)
+	}
+}
+
+func TestRegisterRefusesDuplicateNames(t *testing.T) {
+	r := NewRegistry()
+	store := security.NewFileMFAStore(filepath.Join(t.TempDir(), "mfa.json"), nil)
+	if err := r.Register(&MFAEnrollments{Store: store}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(&MFAEnrollments{Store: store}); err == nil {
+		t.Error("a second store named mfa was registered")
+	}
+	if n := len(r.Stores()); n != 1 {
+		t.Errorf("%d stores registered, want 1", n)
+	}
+}
+
+// Each store's outcome is audited as it finishes, then the job's.
+func TestJobsAuditEachStore(t *testing.T) {
+	dir := t.TempDir()
+	logger, err := audit.Open(dir, nil, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.SetDefault(logger)
+	t.Cleanup(func() {
+		audit.SetDefault(nil)
+		logger.Close()
+	})
+
+	store := security.NewFileMFAStore(filepath.Join(t.TempDir(), "mfa.json"), nil)
+	if err := store.Put(&security.MFAEnrollment{UserID: "u-alice", TOTPConfirmed: true}); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	r.Register(&MFAEnrollments{Store: store})
+	r.Register(&ChatStreams{Buffer: replay.NewMemoryBuffer(), TTL: time.Hour})
+	started, err := r.Start(context.Background(), OpErase, Subject{UserID: "u-alice"}, "dpo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait(t, r, started.ID)
+
+	var entries []audit.Entry
+	for deadline := time.Now().Add(5 * time.Second); len(entries) < 3 && time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if entries, err = audit.Read(dir, time.Time{}, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []struct{ action, store, state string }{
+		{"datasubject.erase.store", "mfa", StateDone},
+		{"datasubject.erase.store", "chat_buffer", StateDone},
+		{"datasubject.erase", "", ""},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("%d audit entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.Action != w.action || e.Resource != started.ID || e.Actor != "dpo" {
+			t.Errorf("entry %d = %s %s by %s, want %s %s by dpo", i, e.Action, e.Resource, e.Actor, w.action, started.ID)
+		}
+		if w.store != "" && (e.Details["store"] != w.store || e.Details["state"] != w.state) {
+			t.Errorf("entry %d details = %v, want store %s %s", i, e.Details, w.store, w.state)
+		}
+	}
+}
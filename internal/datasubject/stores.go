@@ -0,0 +1,547 @@
+package datasubject
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"wodge/internal/ingest"
+	"wodge/internal/monitor"
+	"wodge/internal/pii"
+	"wodge/internal/replay"
+	"wodge/internal/security"
+	"wodge/internal/services"
+
+	"github.com/lib/pq"
+)
+
+// SQLTable is a Postgres table with a column identifying the subject.
+type SQLTable struct {
+	DB     services.DatabaseService
+	Table  string
+	Column string
+	// Match is the subject field Column holds: user_id (default), username or email
+	Match string
+	// TimeColumn and RetentionPeriod enable retention purges
+	TimeColumn      string
+	RetentionPeriod time.Duration
+}
+
+func (t *SQLTable) Name() string { return "postgres:" + t.Table }
+
+func (t *SQLTable) Export(ctx context.Context, s Subject) ([]interface{}, error) {
+	value, err := t.value(s)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := t.DB.Query(ctx, "SELECT * FROM "+quoteIdent(t.Table)+" WHERE "+pq.QuoteIdentifier(t.Column)+" = $1", value)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(rows))
+	for i, row := range rows {
+		out[i] = row
+	}
+	return out, nil
+}
+
+func (t *SQLTable) Erase(ctx context.Context, s Subject) (int, error) {
+	value, err := t.value(s)
+	if err != nil {
+		return 0, err
+	}
+	n, err := t.DB.Execute(ctx, "DELETE FROM "+quoteIdent(t.Table)+" WHERE "+pq.QuoteIdentifier(t.Column)+" = $1", value)
+	return int(n), err
+}
+
+func (t *SQLTable) Count(ctx context.Context, s Subject) (int, error) {
+	value, err := t.value(s)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := t.DB.Query(ctx, "SELECT COUNT(*) AS n FROM "+quoteIdent(t.Table)+" WHERE "+pq.QuoteIdentifier(t.Column)+" = $1", value)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	n, _ := rows[0]["n"].(int64)
+	return int(n), nil
+}
+
+func (t *SQLTable) Retention() time.Duration {
+	if t.TimeColumn == "" {
+		return 0
+	}
+	return t.RetentionPeriod
+}
+
+func (t *SQLTable) Purge(ctx context.Context, before time.Time) (int, error) {
+	n, err := t.DB.Execute(ctx, "DELETE FROM "+quoteIdent(t.Table)+" WHERE "+pq.QuoteIdentifier(t.TimeColumn)+" < $1", before)
+	return int(n), err
+}
+
+func (t *SQLTable) value(s Subject) (string, error) {
+	return s.require(t.Match)
+}
+
+// quoteIdent quotes a possibly schema-qualified name like public.orders.
+func quoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = pq.QuoteIdentifier(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// RedisKeys are key patterns holding the subject's data, with {user_id},
+// {username} or {email} standing in for the subject, e.g. "cart:{user_id}"
+// or "session:{user_id}:*". Expiry is left to the keys' own TTLs. A pattern
+// whose placeholder the subject lacks skips the store as a whole.
+type RedisKeys struct {
+	Cache    services.CacheService
+	Patterns []string
+}
+
+func (k *RedisKeys) Name() string { return "redis" }
+
+func (k *RedisKeys) Export(ctx context.Context, s Subject) ([]interface{}, error) {
+	keys, err := k.keys(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for _, key := range keys {
+		val, err := k.Cache.Get(ctx, key)
+		if errors.Is(err, services.ErrNotFound) {
+			continue // Expired meanwhile
+		}
+		record := map[string]interface{}{"key": key, "value": val}
+		if err != nil {
+			// Hashes, lists and sets can't be read with GET
+			record = map[string]interface{}{"key": key, "error": err.Error()}
+		}
+		out = append(out, record)
+	}
+	return out, nil
+}
+
+func (k *RedisKeys) Erase(ctx context.Context, s Subject) (int, error) {
+	keys, err := k.keys(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range keys {
+		if err := k.Cache.Delete(ctx, key); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+func (k *RedisKeys) Count(ctx context.Context, s Subject) (int, error) {
+	keys, err := k.keys(ctx, s)
+	return len(keys), err
+}
+
+func (k *RedisKeys) keys(ctx context.Context, s Subject) ([]string, error) {
+	seen := map[string]bool{}
+	var keys []string
+	var patterns []string
+	for _, p := range k.Patterns {
+		pattern, err := s.expand(p, escapeGlob)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	for _, pattern := range patterns {
+		found, err := k.Cache.Keys(ctx, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range found {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// QastSessions are the subject's chat sessions in QAST.
+type QastSessions struct {
+	Qast services.QastService
+}
+
+func (q *QastSessions) Name() string { return "qast:sessions" }
+
+func (q *QastSessions) Export(ctx context.Context, s Subject) ([]interface{}, error) {
+	ids, err := q.sessionIDs(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for _, id := range ids {
+		sess, err := q.Qast.GetSession(ctx, id)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, sess)
+	}
+	return out, nil
+}
+
+func (q *QastSessions) Erase(ctx context.Context, s Subject) (int, error) {
+	ids, err := q.sessionIDs(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := q.Qast.DeleteSession(ctx, id); err != nil && !errors.Is(err, services.ErrNotFound) {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+func (q *QastSessions) Count(ctx context.Context, s Subject) (int, error) {
+	ids, err := q.sessionIDs(ctx, s)
+	return len(ids), err
+}
+
+func (q *QastSessions) sessionIDs(ctx context.Context, s Subject) ([]string, error) {
+	if s.UserID == "" {
+		return nil, unmatched("user_id")
+	}
+	sessions, err := q.Qast.GetSessions(ctx, s.UserID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(sessions))
+	for i, sess := range sessions {
+		ids[i] = sess.ID
+	}
+	return ids, nil
+}
+
+// QastContext is the personal context QAST keeps under the subject's user
+// ID. QAST has no delete for it, erasing empties it.
+type QastContext struct {
+	Qast services.QastService
+}
+
+func (q *QastContext) Name() string { return "qast:context" }
+
+func (q *QastContext) Export(ctx context.Context, s Subject) ([]interface{}, error) {
+	data, err := q.get(ctx, s)
+	if data == nil || err != nil {
+		return nil, err
+	}
+	return []interface{}{data}, nil
+}
+
+func (q *QastContext) Erase(ctx context.Context, s Subject) (int, error) {
+	n, err := q.Count(ctx, s)
+	if n == 0 || err != nil {
+		return 0, err
+	}
+	return 1, q.Qast.UpdateContext(ctx, s.UserID, "")
+}
+
+func (q *QastContext) Count(ctx context.Context, s Subject) (int, error) {
+	data, err := q.get(ctx, s)
+	if data == nil || err != nil {
+		return 0, err
+	}
+	if data.Content == "" {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func (q *QastContext) get(ctx context.Context, s Subject) (*services.ContextDocument, error) {
+	if s.UserID == "" {
+		return nil, unmatched("user_id")
+	}
+	data, err := q.Qast.GetContext(ctx, s.UserID)
+	if errors.Is(err, services.ErrNotFound) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// MonitorEvents are stored monitor events carrying the subject's user ID.
+// Retention is MONITOR_RETENTION's job.
+type MonitorEvents struct {
+	Store monitor.Store
+}
+
+func (m *MonitorEvents) Name() string { return "monitor" }
+
+func (m *MonitorEvents) Export(ctx context.Context, s Subject) ([]interface{}, error) {
+	if s.UserID == "" {
+		return nil, unmatched("user_id")
+	}
+	events, err := monitor.QueryAll(m.Store, monitor.Filter{UserID: s.UserID})
+	out := make([]interface{}, len(events))
+	for i, e := range events {
+		out[i] = e
+	}
+	return out, err
+}
+
+func (m *MonitorEvents) Erase(ctx context.Context, s Subject) (int, error) {
+	if s.UserID == "" {
+		return 0, unmatched("user_id")
+	}
+	return m.Store.Delete(monitor.Filter{UserID: s.UserID})
+}
+
+func (m *MonitorEvents) Count(ctx context.Context, s Subject) (int, error) {
+	events, err := m.Export(ctx, s)
+	return len(events), err
+}
+
+// MFAEnrollments are the subject's second factors. Exports leave out the
+// secrets.
+type MFAEnrollments struct {
+	Store security.MFAStore
+}
+
+func (m *MFAEnrollments) Name() string { return "mfa" }
+
+func (m *MFAEnrollments) Export(ctx context.Context, s Subject) ([]interface{}, error) {
+	e, err := m.get(s)
+	if e == nil || err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, c := range e.WebAuthn {
+		keys = append(keys, c.Name)
+	}
+	return []interface{}{map[string]interface{}{
+		"methods":        e.Methods(),
+		"recovery_codes": len(e.RecoveryCodes),
+		"security_keys":  keys,
+		"updated_at":     e.UpdatedAt,
+	}}, nil
+}
+
+func (m *MFAEnrollments) Erase(ctx context.Context, s Subject) (int, error) {
+	e, err := m.get(s)
+	if e == nil || err != nil {
+		return 0, err
+	}
+	return 1, m.Store.Delete(s.UserID)
+}
+
+func (m *MFAEnrollments) Count(ctx context.Context, s Subject) (int, error) {
+	e, err := m.get(s)
+	if e == nil || err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (m *MFAEnrollments) get(s Subject) (*security.MFAEnrollment, error) {
+	if s.UserID == "" {
+		return nil, unmatched("user_id")
+	}
+	return m.Store.Get(s.UserID)
+}
+
+// PIITokenMaps are the sealed maps behind the tokens in the subject's chats,
+// which hold the real values: names, emails, card numbers.
+type PIITokenMaps struct {
+	Tokenizer *pii.Tokenizer
+}
+
+func (p *PIITokenMaps) Name() string { return "pii" }
+
+func (p *PIITokenMaps) Export(ctx context.Context, s Subject) ([]interface{}, error) {
+	if s.UserID == "" {
+		return nil, unmatched("user_id")
+	}
+	maps, err := p.Tokenizer.Maps(ctx, s.UserID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, 0, len(maps))
+	for session, tokens := range maps {
+		out = append(out, map[string]interface{}{"pii_session": session, "tokens": tokens})
+	}
+	return out, nil
+}
+
+func (p *PIITokenMaps) Erase(ctx context.Context, s Subject) (int, error) {
+	if s.UserID == "" {
+		return 0, unmatched("user_id")
+	}
+	return p.Tokenizer.ForgetAll(ctx, s.UserID)
+}
+
+func (p *PIITokenMaps) Count(ctx context.Context, s Subject) (int, error) {
+	if s.UserID == "" {
+		return 0, unmatched("user_id")
+	}
+	return p.Tokenizer.Count(ctx, s.UserID)
+}
+
+// ChatStreams are the subject's buffered chat answers, kept for clients
+// that resume until the buffer TTL runs out.
+type ChatStreams struct {
+	Buffer replay.Buffer
+	// TTL keeps a running chat's state while it is asked to cancel
+	TTL time.Duration
+}
+
+func (c *ChatStreams) Name() string { return "chat_buffer" }
+
+func (c *ChatStreams) Export(ctx context.Context, s Subject) ([]interface{}, error) {
+	streams, err := c.streams(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, 0, len(streams))
+	for _, st := range streams {
+		events, err := c.Buffer.Since(ctx, st.ID, 0)
+		if errors.Is(err, services.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		data := make([]string, 0, len(events))
+		for _, ev := range events {
+			data = append(data, ev.Data)
+		}
+		out = append(out, map[string]interface{}{
+			"message_id": st.ID,
+			"status":     st.Status,
+			"started_at": st.StartedAt,
+			"events":     data,
+		})
+	}
+	return out, nil
+}
+
+// Erase drops the finished streams. Running ones would be saved again when
+// they end, so they are asked to cancel instead and left for the count to
+// report; erasing again once they stopped removes them.
+func (c *ChatStreams) Erase(ctx context.Context, s Subject) (int, error) {
+	streams, err := c.streams(ctx, s)
+	n := 0
+	for _, st := range streams {
+		if !st.Done() {
+			st.Cancel = true
+			if err := c.Buffer.Save(ctx, &st, c.TTL); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err := c.Buffer.Delete(ctx, st.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, err
+}
+
+func (c *ChatStreams) Count(ctx context.Context, s Subject) (int, error) {
+	streams, err := c.streams(ctx, s)
+	return len(streams), err
+}
+
+func (c *ChatStreams) streams(ctx context.Context, s Subject) ([]replay.Stream, error) {
+	if s.UserID == "" {
+		return nil, unmatched("user_id")
+	}
+	return c.Buffer.Streams(ctx, s.UserID)
+}
+
+// IngestedDocuments are the files the subject uploaded for QAST: the
+// manifest entries and their extracted text.
+type IngestedDocuments struct {
+	Ingester *ingest.Ingester
+}
+
+func (d *IngestedDocuments) Name() string { return "ingest" }
+
+func (d *IngestedDocuments) Export(ctx context.Context, s Subject) ([]interface{}, error) {
+	docs, err := d.documents(s)
+	out := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		out[i] = doc
+	}
+	return out, err
+}
+
+func (d *IngestedDocuments) Erase(ctx context.Context, s Subject) (int, error) {
+	docs, err := d.documents(s)
+	n := 0
+	for _, doc := range docs {
+		if _, err = d.Ingester.Delete(s.UserID, doc.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, err
+}
+
+func (d *IngestedDocuments) Count(ctx context.Context, s Subject) (int, error) {
+	docs, err := d.documents(s)
+	return len(docs), err
+}
+
+func (d *IngestedDocuments) documents(s Subject) ([]ingest.Document, error) {
+	if s.UserID == "" {
+		return nil, unmatched("user_id")
+	}
+	return d.Ingester.Documents(s.UserID)
+}
+
+// field returns the identifier a store matches on.
+func (s Subject) field(name string) string {
+	switch name {
+	case "username":
+		return s.Username
+	case "email":
+		return s.Email
+	}
+	return s.UserID
+}
+
+// require returns the identifier a store matches on, ErrUnmatched if the
+// subject lacks it.
+func (s Subject) require(name string) (string, error) {
+	v := s.field(name)
+	if v == "" {
+		if name == "" {
+			name = "user_id"
+		}
+		return "", unmatched(name)
+	}
+	return v, nil
+}
+
+// expand fills the {user_id}, {username} and {email} placeholders in
+// pattern. It fails when a placeholder's value is unknown.
+func (s Subject) expand(pattern string, escape func(string) string) (string, error) {
+	for _, name := range []string{"user_id", "username", "email"} {
+		ph := "{" + name + "}"
+		if !strings.Contains(pattern, ph) {
+			continue
+		}
+		v, err := s.require(name)
+		if err != nil {
+			return "", err
+		}
+		pattern = strings.ReplaceAll(pattern, ph, escape(v))
+	}
+	return pattern, nil
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// escapeGlob keeps subject values from widening a Redis pattern.
+func escapeGlob(s string) string { return globEscaper.Replace(s) }
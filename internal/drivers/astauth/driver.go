@@ -9,46 +9,59 @@ import (
 	"io"
 	"net/http"
 	"time"
+	"wodge/internal/requestid"
+	"wodge/internal/services"
 )
 
-type AstAuthService interface {
-	Login(ctx context.Context, username, password string) (*AuthResponse, error)
-	Register(ctx context.Context, email, username, password, confirmPassword, firstName, lastName string) error
-	VerifyToken(ctx context.Context, accessToken string) (*User, error)
-	RefreshToken(ctx context.Context, refreshToken string) (*AuthResponse, error)
-	Logout(ctx context.Context, accessToken, refreshToken string) error
-}
+// Ensure AstAuthDriver implements services.AuthService
+var _ services.AuthService = (*AstAuthDriver)(nil)
 
 type AstAuthDriver struct {
 	BaseURL string
 	Client  *http.Client
+
+	jwt     *JWTVerifier
+	revoked *RevocationCache
 }
 
 func NewAstAuthDriver(baseURL string) *AstAuthDriver {
 	return &AstAuthDriver{
 		BaseURL: baseURL,
 		Client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: &requestid.Transport{},
 		},
+		revoked: NewRevocationCache(time.Hour),
 	}
 }
 
-type AuthResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	User         User   `json:"user"`
+// JWTConfig configures offline verification of AstAuth access tokens.
+type JWTConfig struct {
+	JWKSURL  string // Defaults to BaseURL + "/.well-known/jwks.json"
+	Issuer   string // Expected "iss" and "aud"; the server only enables
+	Audience string // local verification with both set
+	Leeway   time.Duration
 }
 
-type User struct {
-	ID        string `json:"id"`
-	Username  string `json:"username,omitempty"`
-	Email     string `json:"email"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Role      string `json:"role"`
+// EnableLocalVerification makes VerifyToken validate JWTs against the cached
+// JWKS instead of calling AstAuth on every request. Remote verification is
+// still used for opaque tokens and whenever the JWKS can't be fetched.
+func (d *AstAuthDriver) EnableLocalVerification(cfg JWTConfig) {
+	if cfg.JWKSURL == "" {
+		cfg.JWKSURL = d.BaseURL + "/.well-known/jwks.json"
+	}
+	if cfg.Leeway == 0 {
+		cfg.Leeway = 30 * time.Second
+	}
+	d.jwt = &JWTVerifier{
+		Keys:     NewKeySet(cfg.JWKSURL, d.Client),
+		Issuer:   cfg.Issuer,
+		Audience: cfg.Audience,
+		Leeway:   cfg.Leeway,
+	}
 }
 
-func (d *AstAuthDriver) Login(ctx context.Context, username, password string) (*AuthResponse, error) {
+func (d *AstAuthDriver) Login(ctx context.Context, username, password string) (*services.AuthResponse, error) {
 	reqBody := map[string]string{
 		"username": username,
 		"password": password,
@@ -63,16 +76,19 @@ func (d *AstAuthDriver) Login(ctx context.Context, username, password string) (*
 
 	resp, err := d.Client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, services.Upstream("astauth", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("login failed (status %d): %s", resp.StatusCode, string(body))
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusBadRequest {
+			return nil, fmt.Errorf("login failed (status %d): %s: %w", resp.StatusCode, string(body), services.ErrInvalidCredentials)
+		}
+		return nil, services.UpstreamStatus("astauth", "login", resp.StatusCode, string(body))
 	}
 
-	var authResp AuthResponse
+	var authResp services.AuthResponse
 	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
 		return nil, err
 	}
@@ -82,7 +98,7 @@ func (d *AstAuthDriver) Login(ctx context.Context, username, password string) (*
 
 // RefreshToken refreshes the access token using a valid refresh token.
 // Corresponds to POST /api/v1/auth/refresh-token
-func (d *AstAuthDriver) RefreshToken(ctx context.Context, refreshToken string) (*AuthResponse, error) {
+func (d *AstAuthDriver) RefreshToken(ctx context.Context, refreshToken string) (*services.AuthResponse, error) {
 	reqBody := map[string]string{
 		"refresh_token": refreshToken,
 	}
@@ -96,30 +112,50 @@ func (d *AstAuthDriver) RefreshToken(ctx context.Context, refreshToken string) (
 
 	r, err := d.Client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, services.Upstream("astauth", err)
 	}
 	defer r.Body.Close()
 
 	if r.StatusCode != http.StatusOK {
-		var errResp map[string]string
-		json.NewDecoder(r.Body).Decode(&errResp)
-		msg := errResp["error"]
-		if msg == "" {
-			msg = "refresh failed"
+		if r.StatusCode == http.StatusBadRequest || r.StatusCode == http.StatusUnauthorized || r.StatusCode == http.StatusForbidden {
+			body, _ := io.ReadAll(r.Body)
+			return nil, &services.Error{
+				Kind:    services.ErrUnauthorized,
+				Message: "Session expired, please log in again",
+				Err:     fmt.Errorf("refresh rejected (status %d): %s", r.StatusCode, string(body)),
+			}
 		}
-		return nil, errors.New(msg)
+		return nil, statusError("refresh", r)
 	}
 
-	var resp AuthResponse
+	var resp services.AuthResponse
 	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
 
-// VerifyToken verifies the validity of an access token by fetching the user profile.
+// VerifyToken verifies an access token, locally when a JWKS is configured and
+// otherwise by fetching the user profile from AstAuth.
+func (d *AstAuthDriver) VerifyToken(ctx context.Context, accessToken string) (*services.User, error) {
+	if d.revoked.Revoked(accessToken) {
+		return nil, services.Unauthorized("Token revoked")
+	}
+	if d.jwt != nil {
+		user, err := d.jwt.Verify(ctx, accessToken)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, ErrNotJWT) && !errors.Is(err, ErrKeysUnavailable) {
+			return nil, &services.Error{Kind: services.ErrUnauthorized, Message: "Invalid token", Err: err}
+		}
+	}
+	return d.verifyRemote(ctx, accessToken)
+}
+
+// verifyRemote fetches the user profile to verify the token.
 // Corresponds to GET /api/v1/users/me
-func (d *AstAuthDriver) VerifyToken(ctx context.Context, accessToken string) (*User, error) {
+func (d *AstAuthDriver) verifyRemote(ctx context.Context, accessToken string) (*services.User, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", d.BaseURL+"/api/v1/users/me", nil)
 	if err != nil {
 		return nil, err
@@ -128,15 +164,18 @@ func (d *AstAuthDriver) VerifyToken(ctx context.Context, accessToken string) (*U
 
 	r, err := d.Client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, services.Upstream("astauth", err)
 	}
 	defer r.Body.Close()
 
+	if r.StatusCode == http.StatusUnauthorized || r.StatusCode == http.StatusForbidden {
+		return nil, services.Unauthorized("Invalid token")
+	}
 	if r.StatusCode != http.StatusOK {
-		return nil, errors.New("invalid token")
+		return nil, statusError("verify token", r)
 	}
 
-	var user User
+	var user services.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		return nil, err
 	}
@@ -162,19 +201,21 @@ func (d *AstAuthDriver) Register(ctx context.Context, email, username, password,
 
 	resp, err := d.Client.Do(req)
 	if err != nil {
-		return err
+		return services.Upstream("astauth", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("register failed (status %d): %s", resp.StatusCode, string(body))
+		return statusError("register", resp)
 	}
 
 	return nil
 }
 
 func (d *AstAuthDriver) Logout(ctx context.Context, accessToken, refreshToken string) error {
+	// Revoke locally first so offline verification rejects the token immediately
+	d.revoked.Revoke(accessToken)
+
 	reqBody := map[string]string{
 		"access_token":  accessToken,
 		"refresh_token": refreshToken,
@@ -189,14 +230,33 @@ func (d *AstAuthDriver) Logout(ctx context.Context, accessToken, refreshToken st
 
 	resp, err := d.Client.Do(req)
 	if err != nil {
-		return err
+		return services.Upstream("astauth", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("logout failed (status %d): %s", resp.StatusCode, string(body))
+		return statusError("logout", resp)
 	}
 
 	return nil
 }
+
+// statusError turns a non-success AstAuth response into a typed error. For
+// client errors AstAuth's own message (e.g. "username already taken") is
+// meant for users, so it becomes the client-facing message.
+func statusError(op string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	e := services.UpstreamStatus("astauth", op, resp.StatusCode, string(body))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		var msg struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &msg) == nil {
+			if m := firstNonEmpty(msg.Error, msg.Message); m != "" {
+				e.Message = m
+			}
+		}
+	}
+	return e
+}
@@ -0,0 +1,234 @@
+package astauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrKeysUnavailable is returned when the JWKS can't be fetched, so callers
+// can fall back to remote verification instead of rejecting the token.
+var ErrKeysUnavailable = errors.New("jwks unavailable")
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// KeySet fetches and caches a JSON Web Key Set. Unknown key IDs trigger a
+// refetch (rate limited), which is how key rotation is picked up.
+type KeySet struct {
+	URL    string
+	Client *http.Client
+
+	// TTL is used when the response carries no Cache-Control max-age
+	TTL time.Duration
+	// MinRefresh bounds how often an unknown kid, a stale set or a failing
+	// endpoint may cause a refetch
+	MinRefresh time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	expiresAt   time.Time
+	attemptedAt time.Time // Last fetch, successful or not
+	lastErr     error     // Why the last fetch failed, nil if it didn't
+}
+
+func NewKeySet(url string, client *http.Client) *KeySet {
+	return &KeySet{
+		URL:        url,
+		Client:     client,
+		TTL:        10 * time.Minute,
+		MinRefresh: 30 * time.Second,
+		keys:       make(map[string]crypto.PublicKey),
+	}
+}
+
+// Key returns the public key for kid, refreshing the set when it is stale or
+// the kid is unknown.
+func (ks *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ks.mu.RLock()
+	key, ok := ks.lookup(kid)
+	fresh := time.Now().Before(ks.expiresAt)
+	canRefresh := ks.attemptedAt.IsZero() || time.Since(ks.attemptedAt) >= ks.MinRefresh
+	lastErr := ks.lastErr
+	ks.mu.RUnlock()
+
+	if ok && (fresh || !canRefresh) {
+		return key, nil
+	}
+	if !canRefresh {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	err := ks.refresh(ctx)
+	ks.mu.Lock()
+	ks.attemptedAt, ks.lastErr = time.Now(), err
+	ks.mu.Unlock()
+	if err != nil {
+		if ok {
+			// Serve the stale key rather than failing while the JWKS endpoint is down
+			return key, nil
+		}
+		return nil, err
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup must be called with the lock held. An empty kid matches a single-key set.
+func (ks *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+func (ks *KeySet) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", ks.URL, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+	resp, err := ks.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: status %d", ErrKeysUnavailable, resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue // Skip keys we can't use rather than failing the whole set
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%w: no usable signing keys", ErrKeysUnavailable)
+	}
+
+	ttl := ks.TTL
+	if maxAge, ok := cacheMaxAge(resp.Header.Get("Cache-Control")); ok {
+		ttl = maxAge
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.expiresAt = time.Now().Add(ttl)
+	ks.mu.Unlock()
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ec point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func cacheMaxAge(header string) (time.Duration, bool) {
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "max-age=") {
+			if secs, err := strconv.Atoi(strings.TrimPrefix(part, "max-age=")); err == nil && secs > 0 {
+				return time.Duration(secs) * time.Second, true
+			}
+		}
+	}
+	return 0, false
+}
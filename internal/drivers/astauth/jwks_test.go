@@ -0,0 +1,90 @@
+package astauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwksServer serves the key as kid "k1", or fails while down is set.
+func jwksServer(t *testing.T, key *ecdsa.PublicKey, down *atomic.Bool) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"keys":[{"kty":"EC","kid":"k1","crv":"P-256","x":%q,"y":%q}]}`,
+			base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &fetches
+}
+
+func TestKeySetRateLimitsFailedFetches(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var down atomic.Bool
+	down.Store(true)
+	srv, fetches := jwksServer(t, &priv.PublicKey, &down)
+	ks := NewKeySet(srv.URL, srv.Client())
+	ks.MinRefresh = time.Hour
+
+	for i := 0; i < 3; i++ {
+		if _, err := ks.Key(context.Background(), "k1"); !errors.Is(err, ErrKeysUnavailable) {
+			t.Fatalf("Key() error = %v, want ErrKeysUnavailable", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("failing endpoint fetched %d times, want 1", n)
+	}
+
+	down.Store(false)
+	ks.attemptedAt = time.Now().Add(-2 * time.Hour)
+	if _, err := ks.Key(context.Background(), "k1"); err != nil {
+		t.Fatalf("Key() after recovery: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := ks.Key(context.Background(), "rotated"); err == nil || errors.Is(err, ErrKeysUnavailable) {
+			t.Fatalf("Key(unknown kid) error = %v, want unknown signing key", err)
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("fetched %d times, want 2: an unknown kid right after a fetch doesn't refetch", n)
+	}
+}
+
+// A stale key keeps working while the endpoint is down, without a fetch per request.
+func TestKeySetServesStaleKeyWhileDown(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var down atomic.Bool
+	srv, fetches := jwksServer(t, &priv.PublicKey, &down)
+	ks := NewKeySet(srv.URL, srv.Client())
+	ks.TTL = time.Nanosecond
+	ks.MinRefresh = time.Hour
+
+	if _, err := ks.Key(context.Background(), "k1"); err != nil {
+		t.Fatal(err)
+	}
+	down.Store(true)
+	ks.attemptedAt = time.Now().Add(-2 * time.Hour)
+	for i := 0; i < 3; i++ {
+		if _, err := ks.Key(context.Background(), "k1"); err != nil {
+			t.Fatalf("Key() with a stale set: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("fetched %d times, want 2", n)
+	}
+}
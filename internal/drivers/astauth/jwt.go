@@ -0,0 +1,240 @@
+package astauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+	"wodge/internal/services"
+)
+
+// ErrNotJWT is returned for opaque tokens that can only be verified remotely.
+var ErrNotJWT = errors.New("token is not a JWT")
+
+// JWTVerifier validates AstAuth access tokens offline against the JWKS.
+type JWTVerifier struct {
+	Keys     *KeySet
+	Issuer   string        // Expected "iss", skipped if empty
+	Audience string        // Expected "aud", skipped if empty
+	Leeway   time.Duration // Allowed clock skew for exp/nbf/iat
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Claims is the decoded JWT payload
+type Claims map[string]interface{}
+
+// Verify checks signature, expiry, audience and issuer and maps the claims onto a User.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*services.User, error) {
+	claims, err := v.VerifyClaims(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return claims.User(), nil
+}
+
+// VerifyClaims is Verify for callers that need claims beyond the User, e.g. an OIDC nonce.
+func (v *JWTVerifier) VerifyClaims(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrNotJWT
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrNotJWT
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrNotJWT
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	key, err := v.Keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+	if err := v.validateClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) validateClaims(claims Claims, now time.Time) error {
+	exp, ok := claims.time("exp")
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(exp.Add(v.Leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(v.Leeway).Before(nbf) {
+		return errors.New("token not yet valid")
+	}
+	if iat, ok := claims.time("iat"); ok && now.Add(v.Leeway).Before(iat) {
+		return errors.New("token issued in the future")
+	}
+	if v.Issuer != "" && claims.string("iss") != v.Issuer {
+		return errors.New("unexpected token issuer")
+	}
+	if v.Audience != "" && !claims.hasAudience(v.Audience) {
+		return errors.New("unexpected token audience")
+	}
+	return nil
+}
+
+// User maps standard OIDC claims, falling back to AstAuth's own names.
+func (c Claims) User() *services.User {
+	u := &services.User{
+		ID:        c.string("sub"),
+		Email:     c.string("email"),
+		Username:  firstNonEmpty(c.string("preferred_username"), c.string("username")),
+		FirstName: firstNonEmpty(c.string("given_name"), c.string("first_name")),
+		LastName:  firstNonEmpty(c.string("family_name"), c.string("last_name")),
+		Role:      c.string("role"),
+	}
+	if u.ID == "" {
+		u.ID = firstNonEmpty(c.string("user_id"), c.string("id"))
+	}
+	if u.Role == "" {
+		if roles, ok := c["roles"].([]interface{}); ok && len(roles) > 0 {
+			u.Role, _ = roles[0].(string)
+		}
+	}
+	return u
+}
+
+func (c Claims) string(key string) string {
+	s, _ := c[key].(string)
+	return s
+}
+
+func (c Claims) time(key string) (time.Time, bool) {
+	switch v := c[key].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return time.Unix(n, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (c Claims) hasAudience(aud string) bool {
+	switch v := c["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unverifiedExpiry reads "exp" without checking the signature. Only used to
+// size revocation cache entries, never to trust a token.
+func unverifiedExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return time.Time{}, false
+	}
+	return claims.time("exp")
+}
+
+func decodeSegment(seg string, out interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var h hash.Hash
+	var hashID crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		h, hashID = sha256.New(), crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		h, hashID = sha512.New384(), crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		h, hashID = sha512.New(), crypto.SHA512
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(pub, signed, sig) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	default:
+		// Rejects "none" and symmetric algorithms, which must never be accepted with a JWKS
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(pub, hashID, digest, sig) != nil {
+			return errors.New("invalid token signature")
+		}
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPSS(pub, hashID, digest, sig, nil) != nil {
+			return errors.New("invalid token signature")
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("invalid token signature")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package astauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signES256 builds a token with the given header and claims signed by priv.
+func signES256(t *testing.T, priv *ecdsa.PrivateKey, header, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := segment(header) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTVerifierRejectsBadTokens(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var down atomic.Bool
+	srv, _ := jwksServer(t, &priv.PublicKey, &down)
+	v := &JWTVerifier{
+		Keys:     NewKeySet(srv.URL, srv.Client()),
+		Issuer:   "https://auth.example.com",
+		Audience: "app",
+		Leeway:   30 * time.Second,
+	}
+
+	now := time.Now().Unix()
+	es256 := map[string]interface{}{"alg": "ES256", "kid": "k1"}
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "u1", "iss": v.Issuer, "aud": "app", "exp": now + 60}
+		for k, val := range changes {
+			if val == nil {
+				delete(c, k)
+			} else {
+				c[k] = val
+			}
+		}
+		return c
+	}
+	withAlg := func(alg string) map[string]interface{} {
+		return map[string]interface{}{"alg": alg, "kid": "k1"}
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", signES256(t, priv, es256, claims(nil)), false},
+		{"audience in a list", signES256(t, priv, es256, claims(map[string]interface{}{"aud": []string{"other", "app"}})), false},
+		{"expired within leeway", signES256(t, priv, es256, claims(map[string]interface{}{"exp": now - 10})), false},
+		{"alg none", signES256(t, priv, withAlg("none"), claims(nil)), true},
+		{"alg HS256", signES256(t, priv, withAlg("HS256"), claims(nil)), true},
+		{"alg not matching the key's signature", signES256(t, priv, withAlg("ES384"), claims(nil)), true},
+		{"alg RS256 with an EC key", signES256(t, priv, withAlg("RS256"), claims(nil)), true},
+		{"signed by another key", signES256(t, other, es256, claims(nil)), true},
+		{"expired", signES256(t, priv, es256, claims(map[string]interface{}{"exp": now - 120})), true},
+		{"no expiry", signES256(t, priv, es256, claims(map[string]interface{}{"exp": nil})), true},
+		{"not yet valid", signES256(t, priv, es256, claims(map[string]interface{}{"nbf": now + 120})), true},
+		{"issued in the future", signES256(t, priv, es256, claims(map[string]interface{}{"iat": now + 120})), true},
+		{"other audience", signES256(t, priv, es256, claims(map[string]interface{}{"aud": "other"})), true},
+		{"no audience", signES256(t, priv, es256, claims(map[string]interface{}{"aud": nil})), true},
+		{"other issuer", signES256(t, priv, es256, claims(map[string]interface{}{"iss": "https://evil.example"})), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.VerifyClaims(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierRejectsTamperedPayload(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var down atomic.Bool
+	srv, _ := jwksServer(t, &priv.PublicKey, &down)
+	v := &JWTVerifier{Keys: NewKeySet(srv.URL, srv.Client())}
+
+	token := signES256(t, priv, map[string]interface{}{"alg": "ES256", "kid": "k1"},
+		map[string]interface{}{"sub": "u1", "role": "user", "exp": time.Now().Unix() + 60})
+	admin, _ := json.Marshal(map[string]interface{}{"sub": "u1", "role": "admin", "exp": time.Now().Unix() + 60})
+	parts := strings.Split(token, ".")
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(admin) + "." + parts[2]
+
+	if _, err := v.VerifyClaims(context.Background(), token); err != nil {
+		t.Fatalf("VerifyClaims(original) = %v", err)
+	}
+	if _, err := v.VerifyClaims(context.Background(), forged); err == nil {
+		t.Error("VerifyClaims accepted a token with a modified payload")
+	}
+}
@@ -0,0 +1,60 @@
+package astauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// RevocationCache remembers logged-out access tokens until they would have
+// expired anyway, so locally verified tokens stop working right after logout.
+// It is per process; a multi-instance deployment still relies on short token lifetimes.
+type RevocationCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	maxTTL  time.Duration
+}
+
+func NewRevocationCache(maxTTL time.Duration) *RevocationCache {
+	return &RevocationCache{entries: make(map[string]time.Time), maxTTL: maxTTL}
+}
+
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *RevocationCache) Revoke(token string) {
+	if token == "" {
+		return
+	}
+	now := time.Now()
+	until := now.Add(r.maxTTL)
+	if exp, ok := unverifiedExpiry(token); ok && exp.Before(until) {
+		until = exp
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, exp := range r.entries {
+		if now.After(exp) {
+			delete(r.entries, k)
+		}
+	}
+	r.entries[tokenKey(token)] = until
+}
+
+func (r *RevocationCache) Revoked(token string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	until, ok := r.entries[tokenKey(token)]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(r.entries, tokenKey(token))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,419 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"wodge/internal/drivers/astauth"
+	"wodge/internal/requestid"
+	"wodge/internal/services"
+)
+
+// Ensure OIDCDriver implements services.RedirectAuthService
+var _ services.RedirectAuthService = (*OIDCDriver)(nil)
+
+// Config configures an OpenID Connect provider (Keycloak, Entra ID, Auth0, ...)
+type Config struct {
+	Issuer       string // Discovery is read from Issuer + "/.well-known/openid-configuration"
+	ClientID     string
+	ClientSecret string // Empty for public clients, PKCE is always used
+	RedirectURL  string // e.g. http://localhost:8080/api/auth/oidc/callback
+	Scopes       []string
+	Audience     string // Expected "aud" of access tokens, defaults to ClientID
+	RoleClaim    string // Claim holding the user's role(s), defaults to "role"/"roles"
+
+	// PasswordGrant enables username/password login through the resource owner
+	// password grant. Most providers disable it; leave off unless required.
+	PasswordGrant bool
+}
+
+// discovery is the subset of the provider metadata wodge uses
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	RevocationEndpoint    string `json:"revocation_endpoint"`
+}
+
+type OIDCDriver struct {
+	Config Config
+	Client *http.Client
+
+	mu        sync.Mutex
+	meta      *discovery
+	idTokens  *astauth.JWTVerifier
+	access    *astauth.JWTVerifier
+	revoked   *astauth.RevocationCache
+	lastTried time.Time
+	fetching  chan struct{} // Closed when the discovery fetch in flight ends
+}
+
+func NewOIDCDriver(cfg Config) *OIDCDriver {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	// Without an audience any token the provider signed, for whichever
+	// client, would pass as an access token to this app
+	if cfg.Audience == "" {
+		cfg.Audience = cfg.ClientID
+	}
+	return &OIDCDriver{
+		Config: cfg,
+		Client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &requestid.Transport{},
+		},
+		revoked: astauth.NewRevocationCache(time.Hour),
+	}
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// metadata fetches the discovery document once. Failed fetches are retried
+// at most every 10 seconds so an unreachable IdP doesn't stall every request.
+// The fetch runs without the lock; concurrent callers wait for it.
+func (d *OIDCDriver) metadata(ctx context.Context) (*discovery, error) {
+	d.mu.Lock()
+	if d.meta != nil {
+		defer d.mu.Unlock()
+		return d.meta, nil
+	}
+	if wait := d.fetching; wait != nil {
+		d.mu.Unlock()
+		select {
+		case <-wait:
+			return d.metadata(ctx)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if time.Since(d.lastTried) < 10*time.Second {
+		d.mu.Unlock()
+		return nil, services.Unavailable("Identity provider unavailable")
+	}
+	d.lastTried = time.Now()
+	done := make(chan struct{})
+	d.fetching = done
+	d.mu.Unlock()
+
+	meta, err := d.discover(ctx)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer close(done)
+	d.fetching = nil
+	if err != nil {
+		return nil, err
+	}
+	keys := astauth.NewKeySet(meta.JWKSURI, d.Client)
+	d.idTokens = &astauth.JWTVerifier{Keys: keys, Issuer: meta.Issuer, Audience: d.Config.ClientID, Leeway: 30 * time.Second}
+	d.access = &astauth.JWTVerifier{Keys: keys, Issuer: meta.Issuer, Audience: d.Config.Audience, Leeway: 30 * time.Second}
+	d.meta = meta
+	return d.meta, nil
+}
+
+// discover reads and checks the provider's discovery document.
+func (d *OIDCDriver) discover(ctx context.Context) (*discovery, error) {
+	issuer := strings.TrimSuffix(d.Config.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, "GET", issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, services.Upstream("oidc", fmt.Errorf("discovery: %w", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, services.UpstreamStatus("oidc", "discovery", resp.StatusCode, "")
+	}
+
+	var meta discovery
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, services.Upstream("oidc", fmt.Errorf("discovery: %w", err))
+	}
+	if meta.Issuer != issuer && meta.Issuer != d.Config.Issuer {
+		return nil, services.Upstream("oidc", fmt.Errorf("discovery issuer mismatch: %s", meta.Issuer))
+	}
+	return &meta, nil
+}
+
+// AuthCodeURL builds the authorization request for the code flow with PKCE (S256).
+func (d *OIDCDriver) AuthCodeURL(state, codeChallenge, nonce string) string {
+	meta, err := d.metadata(context.Background())
+	if err != nil {
+		return ""
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {d.Config.ClientID},
+		"redirect_uri":          {d.Config.RedirectURL},
+		"scope":                 {strings.Join(d.Config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange redeems an authorization code and checks the ID token nonce.
+func (d *OIDCDriver) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*services.AuthResponse, error) {
+	tokens, err := d.token(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {d.Config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, services.Upstream("oidc", errors.New("provider returned no id_token"))
+	}
+	return d.session(ctx, tokens, nonce)
+}
+
+// Login uses the password grant when enabled; otherwise users sign in through AuthCodeURL.
+func (d *OIDCDriver) Login(ctx context.Context, username, password string) (*services.AuthResponse, error) {
+	if !d.Config.PasswordGrant {
+		return nil, services.ErrNotSupported
+	}
+	tokens, err := d.token(ctx, url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+		"scope":      {strings.Join(d.Config.Scopes, " ")},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.session(ctx, tokens, "")
+}
+
+// Register is not available, accounts are managed by the identity provider.
+func (d *OIDCDriver) Register(ctx context.Context, email, username, password, confirmPassword, firstName, lastName string) error {
+	return services.ErrNotSupported
+}
+
+func (d *OIDCDriver) RefreshToken(ctx context.Context, refreshToken string) (*services.AuthResponse, error) {
+	tokens, err := d.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Providers without refresh token rotation keep the old one valid
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = refreshToken
+	}
+	return d.session(ctx, tokens, "")
+}
+
+// VerifyToken validates JWT access tokens against the provider's JWKS and
+// falls back to the userinfo endpoint for opaque tokens.
+func (d *OIDCDriver) VerifyToken(ctx context.Context, accessToken string) (*services.User, error) {
+	if d.revoked.Revoked(accessToken) {
+		return nil, services.Unauthorized("Token revoked")
+	}
+	if _, err := d.metadata(ctx); err != nil {
+		return nil, err
+	}
+	claims, err := d.access.VerifyClaims(ctx, accessToken)
+	if err == nil {
+		return d.userFromClaims(claims), nil
+	}
+	if !errors.Is(err, astauth.ErrNotJWT) && !errors.Is(err, astauth.ErrKeysUnavailable) {
+		return nil, &services.Error{Kind: services.ErrUnauthorized, Message: "Invalid token", Err: err}
+	}
+	claims, err = d.userinfo(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return d.userFromClaims(claims), nil
+}
+
+// Logout revokes the tokens at the provider when it supports RFC 7009.
+func (d *OIDCDriver) Logout(ctx context.Context, accessToken, refreshToken string) error {
+	d.revoked.Revoke(accessToken)
+
+	meta, err := d.metadata(ctx)
+	if err != nil {
+		return err
+	}
+	if meta.RevocationEndpoint == "" {
+		return nil
+	}
+	for hint, token := range map[string]string{"refresh_token": refreshToken, "access_token": accessToken} {
+		if token == "" {
+			continue
+		}
+		form := url.Values{"token": {token}, "token_type_hint": {hint}}
+		resp, err := d.postForm(ctx, meta.RevocationEndpoint, form)
+		if err != nil {
+			return services.Upstream("oidc", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return services.UpstreamStatus("oidc", "revoke token", resp.StatusCode, "")
+		}
+	}
+	return nil
+}
+
+// session maps a token response onto an AuthResponse, preferring the ID
+// token's claims and filling gaps from userinfo.
+func (d *OIDCDriver) session(ctx context.Context, tokens *tokenResponse, nonce string) (*services.AuthResponse, error) {
+	var user *services.User
+	if tokens.IDToken != "" {
+		claims, err := d.idTokens.VerifyClaims(ctx, tokens.IDToken)
+		if err != nil {
+			return nil, services.Upstream("oidc", fmt.Errorf("invalid id_token: %w", err))
+		}
+		if nonce != "" {
+			if got, _ := claims["nonce"].(string); got != nonce {
+				return nil, services.Upstream("oidc", errors.New("id_token nonce mismatch"))
+			}
+		}
+		user = d.userFromClaims(claims)
+	}
+	if user == nil || user.Email == "" {
+		claims, err := d.userinfo(ctx, tokens.AccessToken)
+		if err != nil && user == nil {
+			return nil, err
+		}
+		if err == nil {
+			info := d.userFromClaims(claims)
+			if user != nil && info.ID != user.ID {
+				return nil, services.Upstream("oidc", errors.New("userinfo subject mismatch"))
+			}
+			user = info
+		}
+	}
+	return &services.AuthResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		User:         *user,
+	}, nil
+}
+
+func (d *OIDCDriver) userFromClaims(claims astauth.Claims) *services.User {
+	user := claims.User()
+	if d.Config.RoleClaim == "" {
+		return user
+	}
+	switch v := claims[d.Config.RoleClaim].(type) {
+	case string:
+		user.Role = v
+	case []interface{}:
+		if len(v) > 0 {
+			user.Role, _ = v[0].(string)
+		}
+	}
+	return user
+}
+
+func (d *OIDCDriver) userinfo(ctx context.Context, accessToken string) (astauth.Claims, error) {
+	meta, err := d.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if meta.UserinfoEndpoint == "" {
+		return nil, services.Unauthorized("Invalid token")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", meta.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, services.Upstream("oidc", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, services.Unauthorized("Invalid token")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, services.UpstreamStatus("oidc", "userinfo", resp.StatusCode, "")
+	}
+
+	var claims astauth.Claims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, services.Upstream("oidc", err)
+	}
+	return claims, nil
+}
+
+func (d *OIDCDriver) token(ctx context.Context, form url.Values) (*tokenResponse, error) {
+	meta, err := d.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.postForm(ctx, meta.TokenEndpoint, form)
+	if err != nil {
+		return nil, services.Upstream("oidc", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	var tokens tokenResponse
+	_ = json.Unmarshal(body, &tokens)
+	if resp.StatusCode != http.StatusOK {
+		if tokens.Error == "invalid_grant" && form.Get("grant_type") == "password" {
+			return nil, fmt.Errorf("login failed: %s: %w", tokens.Description, services.ErrInvalidCredentials)
+		}
+		if tokens.Error == "invalid_grant" {
+			// Expired or reused refresh token, or an authorization code already redeemed
+			return nil, &services.Error{
+				Kind:    services.ErrUnauthorized,
+				Message: "Session expired, please log in again",
+				Err:     fmt.Errorf("token request rejected: %s", tokens.Description),
+			}
+		}
+		return nil, services.UpstreamStatus("oidc", "token request", resp.StatusCode, string(body))
+	}
+	if tokens.AccessToken == "" {
+		return nil, services.Upstream("oidc", errors.New("provider returned no access_token"))
+	}
+	return &tokens, nil
+}
+
+// postForm sends a client-authenticated form request (client_secret_basic,
+// or just client_id for public clients).
+func (d *OIDCDriver) postForm(ctx context.Context, endpoint string, form url.Values) (*http.Response, error) {
+	if d.Config.ClientSecret == "" {
+		form.Set("client_id", d.Config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if d.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(d.Config.ClientID), url.QueryEscape(d.Config.ClientSecret))
+	}
+	return d.Client.Do(req)
+}
@@ -0,0 +1,68 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAudienceDefaultsToClientID(t *testing.T) {
+	if got := NewOIDCDriver(Config{ClientID: "app"}).Config.Audience; got != "app" {
+		t.Errorf("Audience = %q, want the client ID", got)
+	}
+	if got := NewOIDCDriver(Config{ClientID: "app", Audience: "api"}).Config.Audience; got != "api" {
+		t.Errorf("Audience = %q, want the configured one", got)
+	}
+}
+
+// Concurrent callers share one discovery fetch, and a caller whose context
+// ends stops waiting for it.
+func TestMetadataSharesOneFetch(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		fmt.Fprintf(w, `{"issuer":%q,"jwks_uri":%q}`, srv.URL, srv.URL+"/jwks")
+	}))
+	defer srv.Close()
+	d := NewOIDCDriver(Config{Issuer: srv.URL, ClientID: "app"})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := d.metadata(context.Background())
+			errs <- err
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := d.metadata(ctx); err != context.DeadlineExceeded {
+		t.Errorf("metadata() with an expiring context = %v, want DeadlineExceeded", err)
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("metadata() = %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("discovery fetched %d times, want 1", n)
+	}
+}
@@ -3,10 +3,10 @@ package postgres
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	"errors"
 	"wodge/internal/services"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresDriver struct {
@@ -29,11 +29,11 @@ var _ services.DatabaseService = (*PostgresDriver)(nil)
 
 func (p *PostgresDriver) Query(ctx context.Context, query string, args ...interface{}) ([]map[string]interface{}, error) {
 	if p == nil || p.db == nil {
-		return nil, fmt.Errorf("database connection is nil")
+		return nil, services.Unavailable("Database not connected")
 	}
 	rows, err := p.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, queryError(err)
 	}
 	defer rows.Close()
 
@@ -77,11 +77,47 @@ func (p *PostgresDriver) Query(ctx context.Context, query string, args ...interf
 
 func (p *PostgresDriver) Execute(ctx context.Context, query string, args ...interface{}) (int64, error) {
 	if p == nil || p.db == nil {
-		return 0, fmt.Errorf("database connection is nil")
+		return 0, services.Unavailable("Database not connected")
 	}
 	result, err := p.db.ExecContext(ctx, query, args...)
 	if err != nil {
-		return 0, err
+		return 0, queryError(err)
 	}
 	return result.RowsAffected()
 }
+
+// queryError types the Postgres errors caused by the caller's statement.
+// Anything else (connection loss, server faults) stays internal.
+func queryError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch {
+	case pqErr.Code == "23505": // unique_violation
+		return &services.Error{Kind: services.ErrConflict, Message: "Record already exists", Err: err}
+	case pqErr.Code.Class() == "23": // other integrity constraints
+		msg := "Constraint violation"
+		if pqErr.Constraint != "" {
+			msg += ": " + pqErr.Constraint
+		}
+		return &services.Error{Kind: services.ErrConflict, Message: msg, Err: err}
+	case pqErr.Code.Class() == "22", pqErr.Code.Class() == "42": // data exception, syntax/access rule
+		// The server's message can quote values and schema names, so the
+		// client only gets the SQLSTATE and the message stays in the log
+		return &services.Error{
+			Kind:    services.ErrValidation,
+			Message: "Invalid query",
+			Details: map[string]interface{}{"sqlstate": string(pqErr.Code)},
+			Err:     err,
+		}
+	}
+	return err
+}
+
+// ServerVersion reports the server's version, e.g. "16.2 (Debian 16.2-1)".
+func (p *PostgresDriver) ServerVersion(ctx context.Context) (string, error) {
+	var v string
+	err := p.db.QueryRowContext(ctx, "SHOW server_version").Scan(&v)
+	return v, err
+}
@@ -0,0 +1,49 @@
+package postgres
+
+import (
+	"errors"
+	"testing"
+	"wodge/internal/services"
+
+	"github.com/lib/pq"
+)
+
+func TestQueryError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		kind    error
+		message string
+	}{
+		{"unique violation", &pq.Error{Code: "23505", Message: "duplicate key value (email)=(alice@example.com)"}, services.ErrConflict, "Record already exists"},
+		{"foreign key", &pq.Error{Code: "23503", Constraint: "orders_user_fk"}, services.ErrConflict, "Constraint violation: orders_user_fk"},
+		{"syntax error", &pq.Error{Code: "42601", Message: `syntax error at or near "secret_table"`}, services.ErrValidation, "Invalid query"},
+		{"bad input", &pq.Error{Code: "22P02", Message: `invalid input syntax for type integer: "alice@example.com"`}, services.ErrValidation, "Invalid query"},
+		{"server fault", &pq.Error{Code: "XX000", Message: "internal error"}, nil, ""},
+		{"not from Postgres", errors.New("connection reset"), nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := queryError(tt.err)
+			var typed *services.Error
+			if !errors.As(err, &typed) {
+				if tt.kind != nil {
+					t.Fatalf("queryError() = %v, want a %v", err, tt.kind)
+				}
+				return
+			}
+			if tt.kind == nil {
+				t.Fatalf("queryError() = %v, want it untyped", err)
+			}
+			if !errors.Is(err, tt.kind) || typed.Message != tt.message {
+				t.Errorf("queryError() = %v %q, want %v %q", typed.Kind, typed.Message, tt.kind, tt.message)
+			}
+			if pqErr := tt.err.(*pq.Error); typed.Kind == services.ErrValidation && typed.Details["sqlstate"] != string(pqErr.Code) {
+				t.Errorf("Details = %v, want the SQLSTATE %s", typed.Details, pqErr.Code)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Error("queryError() lost the Postgres error")
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package qast
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+	"wodge/internal/logging"
+	"wodge/internal/services"
+)
+
+// maxResponse caps buffered QAST responses
+const maxResponse = 8 << 20
+
+// call describes one QAST request.
+type call struct {
+	op         string // For errors and logs, e.g. "get session"
+	method     string
+	path       string
+	body       interface{} // Sent as JSON when set
+	timeout    time.Duration
+	idempotent bool   // Safe to send again after a failure
+	token      string // Overrides the API key
+}
+
+// do sends c and returns the buffered response. The whole exchange is bound
+// by c.timeout. Idempotent calls are retried with jittered backoff on
+// transport errors and 502, 503 and 504. Every attempt goes through the
+// circuit breaker.
+func (q *QastDriver) do(ctx context.Context, c call) (int, []byte, error) {
+	if q == nil || q.httpClient == nil {
+		return 0, nil, services.Unavailable("QAST not configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	attempts := 1
+	if c.idempotent {
+		attempts += q.retries
+	}
+	var status int
+	var body []byte
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			logging.For("qast").DebugContext(ctx, "Retrying request", "op", c.op, "attempt", attempt+1, "error", err)
+			if !sleep(ctx, backoff(attempt)) {
+				break
+			}
+		}
+		status, body, err = q.attempt(ctx, c)
+		if err == nil && !retryable(status) {
+			return status, body, nil
+		}
+		if errors.Is(err, errBreakerOpen) {
+			break
+		}
+	}
+	if err != nil {
+		if errors.Is(err, errBreakerOpen) {
+			return 0, nil, q.breaker.unavailable()
+		}
+		return 0, nil, services.Upstream("qast", fmt.Errorf("%s: %w", c.op, err))
+	}
+	return status, body, nil
+}
+
+// attempt sends c once.
+func (q *QastDriver) attempt(ctx context.Context, c call) (int, []byte, error) {
+	resp, err := q.send(ctx, c)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		q.breaker.done(ctx, false)
+		return 0, nil, err
+	}
+	q.breaker.done(ctx, resp.StatusCode < 500)
+	return resp.StatusCode, body, nil
+}
+
+// send issues the request behind the breaker. On success the caller reads
+// the body and reports the outcome with q.breaker.done.
+func (q *QastDriver) send(ctx context.Context, c call) (*http.Response, error) {
+	if err := q.breaker.allow(); err != nil {
+		return nil, err
+	}
+	var reader io.Reader
+	if c.body != nil {
+		raw, err := json.Marshal(c.body)
+		if err != nil {
+			q.breaker.release()
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, c.method, q.baseURL+c.path, reader)
+	if err != nil {
+		q.breaker.release()
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := c.token; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if q.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+q.apiKey)
+	}
+	resp, err := q.httpClient.Do(req)
+	if err != nil {
+		q.breaker.done(ctx, false)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func retryable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// backoff is full jitter over an exponential step: up to 200ms, 400ms,
+// 800ms, ... capped at 3s.
+func backoff(attempt int) time.Duration {
+	step := 200 * time.Millisecond << (attempt - 1)
+	if step <= 0 || step > 3*time.Second {
+		step = 3 * time.Second
+	}
+	return rand.N(step) + time.Millisecond
+}
+
+// sleep waits for d unless ctx ends first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+var errBreakerOpen = errors.New("circuit breaker open")
+
+// breaker fails calls fast after threshold consecutive failures. Once the
+// cooldown has passed a single trial call is let through: success closes
+// the breaker, failure opens it for another cooldown.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if time.Since(b.openedAt) < b.cooldown || b.trial {
+		return errBreakerOpen
+	}
+	b.trial = true
+	return nil
+}
+
+// done records the outcome of an allowed call. Calls abandoned by their
+// caller say nothing about QAST and are not counted.
+func (b *breaker) done(ctx context.Context, ok bool) {
+	if !ok && errors.Is(context.Cause(ctx), context.Canceled) {
+		b.release()
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if ok {
+		if b.failures >= b.threshold {
+			logging.For("qast").Info("Circuit breaker closed, QAST is responding again")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			logging.For("qast").Warn("Circuit breaker opened, failing QAST calls fast", "failures", b.failures, "cooldown", b.cooldown)
+		}
+		b.openedAt = time.Now()
+	}
+}
+
+// release frees the trial slot of a call that never reached QAST.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// retryIn is how long the breaker stays open, zero when calls go through.
+func (b *breaker) retryIn() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return 0
+	}
+	return max(b.cooldown-time.Since(b.openedAt), 0)
+}
+
+func (b *breaker) unavailable() error {
+	wait := b.retryIn()
+	err := services.Unavailable("QAST is unavailable, try again shortly")
+	err.Details = map[string]interface{}{"retry_after": int(wait.Round(time.Second) / time.Second)}
+	err.Err = fmt.Errorf("%w, retrying in %s", errBreakerOpen, wait.Round(time.Second))
+	return err
+}
@@ -0,0 +1,149 @@
+package qast
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"wodge/internal/services"
+)
+
+// scripted serves the statuses in turn, repeating the last, and counts the
+// requests it got.
+func scripted(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var n atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(n.Add(1)) - 1
+		w.WriteHeader(statuses[min(i, len(statuses)-1)])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &n
+}
+
+func newDriver(t *testing.T, url string, cfg Config) *QastDriver {
+	t.Helper()
+	cfg.APIKey = "key"
+	q, err := NewQastDriver(url, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		idempotent bool
+		retries    int
+		wantStatus int
+		wantCalls  int32
+	}{
+		{"success", []int{200}, true, 0, 200, 1},
+		{"retried 503 then success", []int{503, 502, 200}, true, 0, 200, 3},
+		{"retries exhausted", []int{503}, true, 0, 503, 3},
+		{"retries configured off", []int{503, 200}, true, -1, 503, 1},
+		{"not idempotent", []int{503, 200}, false, 0, 503, 1},
+		{"500 is not retried", []int{500, 200}, true, 0, 500, 1},
+		{"client errors are not retried", []int{404, 200}, true, 0, 404, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := scripted(t, tt.statuses...)
+			q := newDriver(t, srv.URL, Config{Retries: tt.retries, BreakerThreshold: 100})
+			status, _, err := q.do(context.Background(), call{op: "test", method: http.MethodGet, path: "/", timeout: 5 * time.Second, idempotent: tt.idempotent})
+			if err != nil || status != tt.wantStatus {
+				t.Fatalf("do() = %d, %v, want %d", status, err, tt.wantStatus)
+			}
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("QAST got %d requests, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDoTransportErrorsAreUpstream(t *testing.T) {
+	srv, _ := scripted(t, 200)
+	srv.Close()
+	q := newDriver(t, srv.URL, Config{Retries: -1})
+	_, _, err := q.do(context.Background(), call{op: "test", method: http.MethodGet, path: "/", timeout: time.Second})
+	if !errors.Is(err, services.ErrUpstream) {
+		t.Errorf("do() = %v, want an upstream error", err)
+	}
+}
+
+// The breaker opens after threshold failures, fails calls fast while open,
+// lets one trial through after the cooldown and closes when it succeeds.
+func TestBreakerStates(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+	srv, calls := scripted(t, 500, 500, 500, 200)
+	q := newDriver(t, srv.URL, Config{Retries: -1, BreakerThreshold: 2, BreakerCooldown: cooldown})
+	get := func() error {
+		_, _, err := q.do(context.Background(), call{op: "test", method: http.MethodGet, path: "/", timeout: time.Second})
+		return err
+	}
+
+	steps := []struct {
+		name      string
+		wait      time.Duration
+		wantErr   error // nil when the call reaches QAST
+		wantCalls int32
+		ready     bool
+	}{
+		{"first failure", 0, nil, 1, true},
+		{"second failure opens", 0, nil, 2, false},
+		{"open fails fast", 0, services.ErrUnavailable, 2, false},
+		{"failed trial reopens", cooldown, nil, 3, false},
+		{"reopened fails fast", 0, services.ErrUnavailable, 3, false},
+		{"successful trial closes", cooldown, nil, 4, true},
+		{"closed", 0, nil, 5, true},
+	}
+	for _, s := range steps {
+		time.Sleep(s.wait)
+		err := get()
+		if s.wantErr != nil {
+			if !errors.Is(err, s.wantErr) {
+				t.Fatalf("%s: do() = %v, want %v", s.name, err, s.wantErr)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: do() = %v", s.name, err)
+		}
+		if n := calls.Load(); n != s.wantCalls {
+			t.Fatalf("%s: QAST got %d requests, want %d", s.name, n, s.wantCalls)
+		}
+		if ready := q.Ready() == nil; ready != s.ready {
+			t.Fatalf("%s: ready = %v, want %v", s.name, ready, s.ready)
+		}
+	}
+}
+
+func TestBreakerAllowsOneTrial(t *testing.T) {
+	b := &breaker{threshold: 1, cooldown: time.Millisecond}
+	b.done(context.Background(), false)
+	time.Sleep(2 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() after the cooldown = %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, errBreakerOpen) {
+		t.Errorf("second allow() during the trial = %v, want the breaker open", err)
+	}
+	b.release()
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() after a released trial = %v", err)
+	}
+}
+
+// Calls their caller gave up on say nothing about QAST.
+func TestBreakerIgnoresCancelledCalls(t *testing.T) {
+	b := &breaker{threshold: 1, cooldown: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.done(ctx, false)
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() after a cancelled call = %v", err)
+	}
+}
@@ -4,18 +4,44 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
 	"time"
+	"wodge/internal/logging"
+	"wodge/internal/requestid"
 	"wodge/internal/services"
 )
 
+// DevToken is the key QAST accepts from local development setups. It is
+// only sent when Config.DevMode is set and no API key is configured.
+const DevToken = "dev-token-bypass"
+
+// Config tunes the QAST client. Zero values take the defaults.
+type Config struct {
+	APIKey string
+	// DevMode allows falling back to DevToken when APIKey is empty
+	DevMode bool
+
+	Timeout       time.Duration // History, users and context calls, default 10s
+	AskTimeout    time.Duration // Default 60s
+	IngestTimeout time.Duration // Default 2m
+	ChatTimeout   time.Duration // Until the chat stream starts, default 30s
+
+	Retries          int           // Extra attempts for idempotent calls, default 2, -1 for none
+	BreakerThreshold int           // Consecutive failures that open the breaker, default 5
+	BreakerCooldown  time.Duration // How long the breaker stays open, default 30s
+}
+
 type QastDriver struct {
 	baseURL    string
 	apiKey     string
 	httpClient *http.Client
+	cfg        Config
+	retries    int
+	breaker    *breaker
 }
 
 type composerRequest struct {
@@ -30,75 +56,91 @@ type composerResponse struct {
 	Error   string   `json:"error,omitempty"`
 }
 
-func NewQastDriver(baseURL string, apiKey string) *QastDriver {
-	if apiKey == "" {
-		apiKey = "dev-token-bypass"
+// NewQastDriver creates the client. Without an API key it refuses to start
+// unless cfg.DevMode is set.
+func NewQastDriver(baseURL string, cfg Config) (*QastDriver, error) {
+	if cfg.APIKey == "" {
+		if !cfg.DevMode {
+			return nil, errors.New("QAST_API_KEY is required outside development, the dev token is only accepted locally")
+		}
+		cfg.APIKey = DevToken
+	}
+	cfg.Timeout = orDefault(cfg.Timeout, 10*time.Second)
+	cfg.AskTimeout = orDefault(cfg.AskTimeout, time.Minute)
+	cfg.IngestTimeout = orDefault(cfg.IngestTimeout, 2*time.Minute)
+	cfg.ChatTimeout = orDefault(cfg.ChatTimeout, 30*time.Second)
+	cfg.BreakerCooldown = orDefault(cfg.BreakerCooldown, 30*time.Second)
+	if cfg.BreakerThreshold <= 0 {
+		cfg.BreakerThreshold = 5
+	}
+	retries := cfg.Retries
+	switch {
+	case retries == 0:
+		retries = 2
+	case retries < 0:
+		retries = 0
 	}
+
 	transport := &http.Transport{
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 100,
 		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
 	}
 
 	return &QastDriver{
 		baseURL:    baseURL,
-		apiKey:     apiKey,
-		httpClient: &http.Client{Transport: transport},
+		apiKey:     cfg.APIKey,
+		httpClient: &http.Client{Transport: &requestid.Transport{Base: transport}},
+		cfg:        cfg,
+		retries:    retries,
+		breaker:    &breaker{threshold: cfg.BreakerThreshold, cooldown: cfg.BreakerCooldown},
+	}, nil
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
 	}
+	return d
 }
 
 // Ensure QastDriver implements services.QastService
 var _ services.QastService = (*QastDriver)(nil)
 
-func (q *QastDriver) Ask(ctx context.Context, query, userId, expertise string) (string, []string, error) {
-	if q == nil || q.httpClient == nil {
-		return "", nil, fmt.Errorf("qast driver is nil")
+// Ready fails while the circuit breaker is open.
+func (q *QastDriver) Ready() error {
+	if q == nil || q.breaker == nil {
+		return services.Unavailable("QAST not configured")
 	}
-
-	reqBody := composerRequest{
-		Query:          query,
-		UserID:         userId,
-		ExpertiseLevel: expertise,
-	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	url := fmt.Sprintf("%s/api/v1/composer/ask", q.baseURL)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
+	if q.breaker.retryIn() > 0 {
+		return q.breaker.unavailable()
 	}
+	return nil
+}
 
-	resp, err := q.httpClient.Do(req)
+func (q *QastDriver) Ask(ctx context.Context, query, userId, expertise string) (string, []string, error) {
+	status, body, err := q.do(ctx, call{
+		op:      "ask",
+		method:  http.MethodPost,
+		path:    "/api/v1/composer/ask",
+		body:    composerRequest{Query: query, UserID: userId, ExpertiseLevel: expertise},
+		timeout: q.cfg.AskTimeout,
+	})
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to call qast api: %w", err)
+		return "", nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		var errResp map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil {
-			if errMsg, ok := errResp["error"].(string); ok {
-				return "", nil, fmt.Errorf("qast api error (%d): %s", resp.StatusCode, errMsg)
-			}
-		}
-		return "", nil, fmt.Errorf("qast api returned status: %d", resp.StatusCode)
+	if status != http.StatusOK {
+		return "", nil, statusError("ask", status, body)
 	}
 
 	var respBody composerResponse
-	if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
-		return "", nil, fmt.Errorf("failed to decode response: %w", err)
+	if err := json.Unmarshal(body, &respBody); err != nil {
+		return "", nil, malformed("ask", err)
 	}
 
 	if respBody.Error != "" {
-		return "", nil, fmt.Errorf("qast api error: %s", respBody.Error)
+		return "", nil, services.Upstream("qast", fmt.Errorf("ask: %s", respBody.Error))
 	}
 
 	return respBody.Answer, respBody.Context, nil
@@ -116,54 +158,31 @@ type ingestResponse struct {
 }
 
 func (q *QastDriver) IngestGraph(ctx context.Context, text, userId string) (interface{}, error) {
-	if q == nil || q.httpClient == nil {
-		return nil, fmt.Errorf("qast driver is nil")
-	}
-
-	reqBody := ingestRequest{
-		Text:         text,
-		UserID:       userId,
-		TemplateName: "extract_knowledge_graph", // Hardcoded for now
-	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	url := fmt.Sprintf("%s/api/v1/privacy/extract", q.baseURL)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
-	}
-
-	resp, err := q.httpClient.Do(req)
+	status, body, err := q.do(ctx, call{
+		op:     "ingest",
+		method: http.MethodPost,
+		path:   "/api/v1/privacy/extract",
+		body: ingestRequest{
+			Text:         text,
+			UserID:       userId,
+			TemplateName: "extract_knowledge_graph", // Hardcoded for now
+		},
+		timeout: q.cfg.IngestTimeout,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to call qast api: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		var errResp map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil {
-			if errMsg, ok := errResp["error"].(string); ok {
-				return nil, fmt.Errorf("qast api error (%d): %s", resp.StatusCode, errMsg)
-			}
-		}
-		return nil, fmt.Errorf("qast api returned status: %d", resp.StatusCode)
+	if status != http.StatusOK {
+		return nil, statusError("ingest", status, body)
 	}
 
 	var respBody ingestResponse
-	if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if err := json.Unmarshal(body, &respBody); err != nil {
+		return nil, malformed("ingest", err)
 	}
 
 	if respBody.Error != "" {
-		return nil, fmt.Errorf("qast api error: %s", respBody.Error)
+		return nil, services.Upstream("qast", fmt.Errorf("ingest: %s", respBody.Error))
 	}
 
 	return respBody.Result, nil
@@ -181,330 +200,349 @@ type secureChatRequest struct {
 	TargetMessageID string `json:"target_message_id,omitempty"`
 }
 
-// SecureChat now returns a ReadCloser for the SSE stream
+// SecureChat returns the SSE stream. ChatTimeout bounds the wait for the
+// stream to start, not the stream itself; closing the stream ends the call.
 func (q *QastDriver) SecureChat(ctx context.Context, text, userId, sessionId, targetMessageID, token string) (io.ReadCloser, error) {
 	if q == nil || q.httpClient == nil {
-		return nil, fmt.Errorf("qast driver is nil")
-	}
-
-	reqBody := secureChatRequest{
-		Text:            text,
-		UserID:          userId,
-		SessionID:       sessionId,
-		TargetMessageID: targetMessageID,
-	}
-
-	jsonBody, err := json.Marshal(reqBody)
+		return nil, services.Unavailable("QAST not configured")
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(q.cfg.ChatTimeout, func() { cancel(errChatStart) })
+	c := call{
+		op:     "chat",
+		method: http.MethodPost,
+		path:   "/api/v1/pipeline/chat",
+		body: secureChatRequest{
+			Text:            text,
+			UserID:          userId,
+			SessionID:       sessionId,
+			TargetMessageID: targetMessageID,
+		},
+		token: token,
+	}
+
+	logging.For("qast").DebugContext(ctx, "Sending request", "op", c.op, "path", c.path)
+	resp, err := q.send(ctx, c)
+	started := timer.Stop()
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	url := fmt.Sprintf("%s/api/v1/pipeline/chat", q.baseURL)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if token != "" {
-		req.Header.Set("Authorization", "Bearer "+token)
-	} else if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
-	}
-
-	// Important: we return the body to be streamed
-	log.Printf("[QastDriver] Sending request to %s", url)
-	resp, err := q.httpClient.Do(req)
-	if err != nil {
-		log.Printf("[QastDriver] httpClient.Do failed: %v", err)
-		return nil, fmt.Errorf("failed to call qast api: %w", err)
+		cancel(nil)
+		if errors.Is(err, errBreakerOpen) {
+			return nil, q.breaker.unavailable()
+		}
+		if !started {
+			err = fmt.Errorf("%w within %s", errChatStart, q.cfg.ChatTimeout)
+		}
+		logging.For("qast").ErrorContext(ctx, "Request failed", "op", c.op, "error", err)
+		return nil, services.Upstream("qast", fmt.Errorf("chat: %w", err))
 	}
+	q.breaker.done(ctx, resp.StatusCode < 500)
 
 	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return nil, fmt.Errorf("qast api returned status: %d", resp.StatusCode)
+		defer cancel(nil)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, statusError("chat", resp.StatusCode, body)
 	}
 
-	return resp.Body, nil
+	return &stream{ReadCloser: resp.Body, cancel: cancel}, nil
 }
 
-// -- History Methods --
+// errChatStart cancels a chat that didn't start within ChatTimeout. It
+// counts against the breaker, unlike a caller going away.
+var errChatStart = fmt.Errorf("chat stream did not start: %w", context.DeadlineExceeded)
 
-func (q *QastDriver) CreateSession(ctx context.Context, userID, title string) (interface{}, error) {
-	url := fmt.Sprintf("%s/api/v1/history/sessions", q.baseURL)
-	reqBody := map[string]string{"user_id": userID, "title": title}
-	jsonBody, _ := json.Marshal(reqBody)
+// stream releases the request context when the chat stream is closed.
+type stream struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
-	}
+func (s *stream) Close() error {
+	defer s.cancel(nil)
+	return s.ReadCloser.Close()
+}
+
+// -- History Methods --
 
-	resp, err := q.httpClient.Do(req)
+func (q *QastDriver) CreateSession(ctx context.Context, userID, title string) (*services.Session, error) {
+	status, body, err := q.do(ctx, call{
+		op:      "create session",
+		method:  http.MethodPost,
+		path:    "/api/v1/history/sessions",
+		body:    map[string]string{"user_id": userID, "title": title},
+		timeout: q.cfg.Timeout,
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("failed to create session: %d", resp.StatusCode)
+	if status != http.StatusCreated {
+		return nil, statusError("create session", status, body)
 	}
 
-	var result interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	var sess services.Session
+	if err := decode("create session", body, &sess); err != nil {
 		return nil, err
 	}
-	return result, nil
+	return &sess, nil
 }
 
-func (q *QastDriver) GetSessions(ctx context.Context, userID string) (interface{}, error) {
-	url := fmt.Sprintf("%s/api/v1/history/sessions?user_id=%s", q.baseURL, userID)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+func (q *QastDriver) GetSessions(ctx context.Context, userID string) ([]services.Session, error) {
+	status, body, err := q.do(ctx, call{
+		op:         "get sessions",
+		method:     http.MethodGet,
+		path:       "/api/v1/history/sessions?user_id=" + url.QueryEscape(userID),
+		timeout:    q.cfg.Timeout,
+		idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
-	}
-
-	resp, err := q.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get sessions: %d", resp.StatusCode)
-	}
-
-	var result interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	if status != http.StatusOK {
+		return nil, statusError("get sessions", status, body)
 	}
-	return result, nil
+	return decodeList[services.Session]("get sessions", body, "sessions")
 }
 
-func (q *QastDriver) GetSession(ctx context.Context, sessionID string) (interface{}, error) {
-	url := fmt.Sprintf("%s/api/v1/history/sessions/%s", q.baseURL, sessionID)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+func (q *QastDriver) GetSession(ctx context.Context, sessionID string) (*services.SessionDetail, error) {
+	status, body, err := q.do(ctx, call{
+		op:         "get session",
+		method:     http.MethodGet,
+		path:       "/api/v1/history/sessions/" + url.PathEscape(sessionID),
+		timeout:    q.cfg.Timeout,
+		idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
+	if status != http.StatusOK {
+		return nil, statusError("get session", status, body)
 	}
 
-	resp, err := q.httpClient.Do(req)
-	if err != nil {
+	var detail services.SessionDetail
+	if err := decode("get session", body, &detail); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get session: %d", resp.StatusCode)
+	if detail.Session.ID != sessionID {
+		return nil, malformed("get session", fmt.Errorf("asked for session %s, got %s", sessionID, detail.Session.ID))
 	}
-
-	var result interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	if detail.Messages == nil {
+		detail.Messages = []services.Message{}
 	}
-	return result, nil
+	return &detail, nil
 }
 
 func (q *QastDriver) DeleteSession(ctx context.Context, sessionID string) error {
-	url := fmt.Sprintf("%s/api/v1/history/sessions/%s", q.baseURL, sessionID)
-	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	status, body, err := q.do(ctx, call{
+		op:         "delete session",
+		method:     http.MethodDelete,
+		path:       "/api/v1/history/sessions/" + url.PathEscape(sessionID),
+		timeout:    q.cfg.Timeout,
+		idempotent: true,
+	})
 	if err != nil {
 		return err
 	}
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
-	}
-
-	resp, err := q.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to delete session: %d", resp.StatusCode)
+	if status != http.StatusOK {
+		return statusError("delete session", status, body)
 	}
 	return nil
 }
 
-func (q *QastDriver) ShareSession(ctx context.Context, sessionID, targetUsername string) (interface{}, error) {
-	url := fmt.Sprintf("%s/api/v1/history/sessions/%s/share", q.baseURL, sessionID)
-	reqBody := map[string]string{"target_username": targetUsername}
-	jsonBody, _ := json.Marshal(reqBody)
-
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
+func (q *QastDriver) ShareSession(ctx context.Context, sessionID, targetUsername string) (*services.SharedSession, error) {
+	status, body, err := q.do(ctx, call{
+		op:      "share session",
+		method:  http.MethodPost,
+		path:    "/api/v1/history/sessions/" + url.PathEscape(sessionID) + "/share",
+		body:    map[string]string{"target_username": targetUsername},
+		timeout: q.cfg.Timeout,
+	})
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
+	if status != http.StatusOK {
+		return nil, statusError("share session", status, body)
 	}
 
-	resp, err := q.httpClient.Do(req)
-	if err != nil {
+	var shared services.SharedSession
+	if err := decode("share session", body, &shared); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to share session: %d", resp.StatusCode)
+	if shared.SessionID == "" {
+		shared.SessionID = sessionID
 	}
-
-	var result interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	if shared.SharedWith == "" {
+		shared.SharedWith = targetUsername
 	}
-	return result, nil
+	return &shared, nil
 }
 
-func (q *QastDriver) SearchUsers(ctx context.Context, query string) (interface{}, error) {
-	url := fmt.Sprintf("%s/api/v1/users/search?q=%s", q.baseURL, query)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+func (q *QastDriver) SearchUsers(ctx context.Context, query string) ([]services.UserSummary, error) {
+	status, body, err := q.do(ctx, call{
+		op:         "search users",
+		method:     http.MethodGet,
+		path:       "/api/v1/users/search?q=" + url.QueryEscape(query),
+		timeout:    q.cfg.Timeout,
+		idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
-	}
-
-	resp, err := q.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to search users: %d", resp.StatusCode)
-	}
-
-	var result interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	if status != http.StatusOK {
+		return nil, statusError("search users", status, body)
 	}
-	return result, nil
+	return decodeList[services.UserSummary]("search users", body, "users")
 }
 
 func (q *QastDriver) SyncUser(ctx context.Context, id, email, username, firstName, lastName string) error {
-	url := fmt.Sprintf("%s/api/v1/users", q.baseURL)
-	reqBody := map[string]string{
-		"id":         id,
-		"email":      email,
-		"username":   username,
-		"first_name": firstName,
-		"last_name":  lastName,
-	}
-	jsonBody, _ := json.Marshal(reqBody)
-
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
-	}
-
-	resp, err := q.httpClient.Do(req)
+	status, body, err := q.do(ctx, call{
+		op:     "sync user",
+		method: http.MethodPost,
+		path:   "/api/v1/users",
+		body: map[string]string{
+			"id":         id,
+			"email":      email,
+			"username":   username,
+			"first_name": firstName,
+			"last_name":  lastName,
+		},
+		timeout: q.cfg.Timeout,
+		// Repeating a sync at worst answers 409, which counts as done
+		idempotent: true,
+	})
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+	if status != http.StatusCreated && status != http.StatusOK && status != http.StatusConflict {
 		// Ignore conflict/existing
-		return fmt.Errorf("failed to sync user: %d", resp.StatusCode)
+		return statusError("sync user", status, body)
 	}
 	return nil
 }
 
 func (q *QastDriver) UpdateContext(ctx context.Context, id, content string) error {
-	url := fmt.Sprintf("%s/api/v1/context/%s", q.baseURL, id)
-	reqBody := map[string]string{"content": content}
-	jsonBody, _ := json.Marshal(reqBody)
-
-	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
-	}
-
-	resp, err := q.httpClient.Do(req)
+	status, body, err := q.do(ctx, call{
+		op:         "update context",
+		method:     http.MethodPut,
+		path:       "/api/v1/context/" + url.PathEscape(id),
+		body:       map[string]string{"content": content},
+		timeout:    q.cfg.Timeout,
+		idempotent: true,
+	})
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to update context: %d", resp.StatusCode)
+	if status != http.StatusOK {
+		return statusError("update context", status, body)
 	}
 	return nil
 }
 
-func (q *QastDriver) GetContext(ctx context.Context, id string) (interface{}, error) {
-	url := fmt.Sprintf("%s/api/v1/context/%s", q.baseURL, id)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+func (q *QastDriver) GetContext(ctx context.Context, id string) (*services.ContextDocument, error) {
+	status, body, err := q.do(ctx, call{
+		op:         "get context",
+		method:     http.MethodGet,
+		path:       "/api/v1/context/" + url.PathEscape(id),
+		timeout:    q.cfg.Timeout,
+		idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
+	if status != http.StatusOK {
+		return nil, statusError("get context", status, body)
 	}
 
-	resp, err := q.httpClient.Do(req)
-	if err != nil {
+	var doc services.ContextDocument
+	if err := decode("get context", body, &doc); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get context: %d", resp.StatusCode)
+	if doc.UserID == "" {
+		doc.UserID = id
 	}
-
-	var result interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
-	}
-	return result, nil
+	return &doc, nil
 }
 
 func (q *QastDriver) UpdateMessage(ctx context.Context, sessionID, messageID, content string, metadata map[string]interface{}) error {
-	url := fmt.Sprintf("%s/api/v1/history/sessions/%s/messages/%s", q.baseURL, sessionID, messageID)
-	reqBody := map[string]interface{}{
-		"content":  content,
-		"metadata": metadata,
-	}
-	jsonBody, _ := json.Marshal(reqBody)
-
-	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer(jsonBody))
+	status, body, err := q.do(ctx, call{
+		op:     "update message",
+		method: http.MethodPut,
+		path:   "/api/v1/history/sessions/" + url.PathEscape(sessionID) + "/messages/" + url.PathEscape(messageID),
+		body: map[string]interface{}{
+			"content":  content,
+			"metadata": metadata,
+		},
+		timeout:    q.cfg.Timeout,
+		idempotent: true,
+	})
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if q.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+q.apiKey)
+	if status != http.StatusOK {
+		return statusError("update message", status, body)
 	}
+	return nil
+}
 
-	resp, err := q.httpClient.Do(req)
-	if err != nil {
-		return err
+// statusError turns a non-success QAST response into a typed error. The body
+// is kept in the cause for logs and never shown to clients.
+func statusError(op string, status int, body []byte) error {
+	if len(body) > 4096 {
+		body = body[:4096]
 	}
-	defer resp.Body.Close()
+	return services.UpstreamStatus("qast", op, status, string(body))
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to update message: %d", resp.StatusCode)
+// validator is implemented by the QAST models in services.
+type validator interface {
+	Validate() error
+}
+
+// decode reads a QAST response into v, rejecting payloads that don't decode
+// or don't validate.
+func decode(op string, body []byte, v validator) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return malformed(op, err)
+	}
+	if err := v.Validate(); err != nil {
+		return malformed(op, err)
 	}
 	return nil
 }
+
+// decodeList reads a list QAST sends either bare or wrapped as
+// {"<key>": [...]} or {"data": [...]}. A missing list is empty, never null.
+func decodeList[T validator](op string, body []byte, key string) ([]T, error) {
+	raw := bytes.TrimSpace(body)
+	if len(raw) > 0 && raw[0] == '{' {
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &envelope); err != nil {
+			return nil, malformed(op, err)
+		}
+		list, ok := envelope[key]
+		if !ok {
+			list, ok = envelope["data"]
+		}
+		if !ok {
+			return nil, malformed(op, fmt.Errorf("object without a %q list", key))
+		}
+		raw = list
+	}
+	items := []T{}
+	if string(raw) != "null" {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, malformed(op, err)
+		}
+	}
+	for _, item := range items {
+		if err := item.Validate(); err != nil {
+			return nil, malformed(op, err)
+		}
+	}
+	return items, nil
+}
+
+func malformed(op string, err error) error {
+	return services.Upstream("qast", fmt.Errorf("%s: malformed response: %w", op, err))
+}
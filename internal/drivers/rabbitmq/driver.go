@@ -3,7 +3,8 @@ package rabbitmq
 import (
 	"context"
 	"fmt"
-	"log"
+	"wodge/internal/logging"
+	"wodge/internal/requestid"
 	"wodge/internal/services"
 
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -59,11 +60,20 @@ func (r *RabbitMQDriver) Publish(ctx context.Context, topic string, message []by
 		amqp.Publishing{
 			ContentType: "text/plain",
 			Body:        message,
+			Headers:     headersFromContext(ctx),
 		},
 	)
 }
 
 func (r *RabbitMQDriver) Subscribe(ctx context.Context, topic string, handler func(message []byte) error) error {
+	return r.SubscribeContext(ctx, topic, func(_ context.Context, message []byte) error {
+		return handler(message)
+	})
+}
+
+// SubscribeContext is Subscribe with a per-message context carrying the
+// request ID of the publishing request.
+func (r *RabbitMQDriver) SubscribeContext(ctx context.Context, topic string, handler func(ctx context.Context, message []byte) error) error {
 	if r == nil || r.channel == nil {
 		return fmt.Errorf("rabbitmq channel is nil")
 	}
@@ -96,11 +106,35 @@ func (r *RabbitMQDriver) Subscribe(ctx context.Context, topic string, handler fu
 	// Note: This simple implementation doesn't handle graceful shutdown of the consumer properly via context yet
 	go func() {
 		for d := range msgs {
-			if err := handler(d.Body); err != nil {
-				log.Printf("Error processing message from %s: %v", topic, err)
+			msgCtx := context.Background()
+			if id, ok := d.Headers[requestIDHeader].(string); ok {
+				msgCtx = requestid.NewContext(msgCtx, id)
+			}
+			if err := handler(msgCtx, d.Body); err != nil {
+				logging.For("rabbitmq").ErrorContext(msgCtx, "Processing message failed", "topic", topic, "error", err)
 			}
 		}
 	}()
 
 	return nil
 }
+
+// requestIDHeader carries the publishing request's ID with each message
+const requestIDHeader = "x-request-id"
+
+func headersFromContext(ctx context.Context) amqp.Table {
+	id := requestid.FromContext(ctx)
+	if id == "" {
+		return nil
+	}
+	return amqp.Table{requestIDHeader: id}
+}
+
+// ServerVersion reports the version the broker announced when connecting.
+func (r *RabbitMQDriver) ServerVersion(ctx context.Context) (string, error) {
+	if r == nil || r.conn == nil {
+		return "", fmt.Errorf("rabbitmq connection is nil")
+	}
+	v, _ := r.conn.Properties["version"].(string)
+	return v, nil
+}
@@ -2,6 +2,8 @@ package redis
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"time"
 	"wodge/internal/services"
 
@@ -30,7 +32,11 @@ func NewRedisDriver(addr string, password string, db int) (*RedisDriver, error)
 var _ services.CacheService = (*RedisDriver)(nil)
 
 func (r *RedisDriver) Get(ctx context.Context, key string) (string, error) {
-	return r.client.Get(ctx, key).Result()
+	val, err := r.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", services.NotFound("Key not found")
+	}
+	return val, err
 }
 
 func (r *RedisDriver) Set(ctx context.Context, key string, value string, ttlSeconds int) error {
@@ -40,3 +46,27 @@ func (r *RedisDriver) Set(ctx context.Context, key string, value string, ttlSeco
 func (r *RedisDriver) Delete(ctx context.Context, key string) error {
 	return r.client.Del(ctx, key).Err()
 }
+
+// Keys walks the keyspace with SCAN, so it doesn't block the server like KEYS.
+func (r *RedisDriver) Keys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	iter := r.client.Scan(ctx, 0, pattern, 500).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// ServerVersion reports redis_version from INFO server.
+func (r *RedisDriver) ServerVersion(ctx context.Context) (string, error) {
+	info, err := r.client.Info(ctx, "server").Result()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "redis_version:"); ok {
+			return v, nil
+		}
+	}
+	return "", errors.New("redis_version missing from INFO")
+}
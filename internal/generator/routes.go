@@ -8,6 +8,12 @@ import (
 	"unicode"
 )
 
+// IsPublicRoute reports whether the page route stays reachable without
+// signing in: login, register and names ending in .public.
+func IsPublicRoute(baseName string) bool {
+	return baseName == "login" || baseName == "register" || strings.HasSuffix(baseName, ".public")
+}
+
 // GenerateRoutes scans the routes directory and updates routes.generated.tsx
 func GenerateRoutes(srcDir string) error {
 	routesDir := filepath.Join(srcDir, "routes")
@@ -58,13 +64,7 @@ func GenerateRoutes(srcDir string) error {
 
 			// Wrap with ProtectedRoute if available and not explicitly public (like login)
 			if hasProtection {
-				// Define public routes
-				isPublic := false
-				if baseName == "login" || baseName == "register" || strings.HasSuffix(baseName, ".public") {
-					isPublic = true
-				}
-
-				if !isPublic {
+				if !IsPublicRoute(baseName) {
 					element = fmt.Sprintf("<ProtectedRoute><%s /></ProtectedRoute>", componentName)
 				}
 			}
@@ -0,0 +1,116 @@
+package generator
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// TypeScript renders TypeScript interfaces for the given Go structs, using
+// their JSON field names. Structs they reference are rendered first. A `ts`
+// struct tag overrides a field's type, e.g. ts:"'user' | 'assistant'".
+func TypeScript(values ...interface{}) string {
+	g := &tsGen{seen: map[reflect.Type]bool{}}
+	for _, v := range values {
+		g.emit(reflect.TypeOf(v))
+	}
+	return strings.Join(g.out, "\n")
+}
+
+type tsGen struct {
+	seen map[reflect.Type]bool
+	out  []string
+}
+
+func (g *tsGen) emit(t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || g.seen[t] || isScalar(t) {
+		return
+	}
+	g.seen[t] = true
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "export interface %s {\n", t.Name())
+	g.fields(&b, t)
+	b.WriteString("}\n")
+	g.out = append(g.out, b.String())
+}
+
+func (g *tsGen) fields(b *strings.Builder, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.fields(b, f.Type) // Embedded fields are flattened like encoding/json does
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		optional := f.Type.Kind() == reflect.Pointer || strings.Contains(opts, "omitempty")
+		typ := f.Tag.Get("ts")
+		if typ == "" {
+			typ = g.typeOf(f.Type)
+		}
+		if optional {
+			name += "?"
+		}
+		fmt.Fprintf(b, "    %s: %s;\n", name, typ)
+	}
+}
+
+func (g *tsGen) typeOf(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if isScalar(t) {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		elem := g.typeOf(t.Elem())
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return "Record<string, " + g.typeOf(t.Elem()) + ">"
+	case reflect.Struct:
+		g.emit(t)
+		return t.Name()
+	}
+	return "unknown"
+}
+
+// isScalar reports whether t marshals itself, like time.Time, which the
+// generated types treat as a string.
+func isScalar(t reflect.Type) bool {
+	if t.Kind() == reflect.Interface {
+		return false
+	}
+	pt := reflect.PointerTo(t)
+	return t.Implements(jsonMarshaler) || t.Implements(textMarshaler) || pt.Implements(jsonMarshaler) || pt.Implements(textMarshaler)
+}
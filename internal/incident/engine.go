@@ -0,0 +1,222 @@
+package incident
+
+import (
+	"context"
+	"time"
+	"wodge/internal/audit"
+	"wodge/internal/logging"
+	"wodge/internal/monitor"
+	"wodge/internal/requestid"
+)
+
+// Engine evaluates the rules against every monitor event and raises
+// INCIDENT events for matches.
+type Engine struct {
+	rules []*Rule
+	store *Store
+	bus   *monitor.Broadcaster
+
+	// Only touched by the Run goroutine
+	windows   map[windowKey]*window
+	lastSweep time.Time
+}
+
+type windowKey struct {
+	rule  string
+	group string
+}
+
+// window holds the recent matches of a threshold or distinct rule
+type window struct {
+	hits     []time.Time
+	distinct map[string]time.Time
+}
+
+// NewEngine compiles rules. Incidents are kept in store and published on bus.
+func NewEngine(rules []Rule, store *Store, bus *monitor.Broadcaster) (*Engine, error) {
+	e := &Engine{store: store, bus: bus, windows: make(map[windowKey]*window)}
+	for i := range rules {
+		r := rules[i]
+		if err := r.compile(); err != nil {
+			return nil, err
+		}
+		e.rules = append(e.rules, &r)
+	}
+	return e, nil
+}
+
+// Rules returns the compiled rules.
+func (e *Engine) Rules() []Rule {
+	out := make([]Rule, len(e.rules))
+	for i, r := range e.rules {
+		out[i] = *r
+	}
+	return out
+}
+
+// Store returns the incident store.
+func (e *Engine) Store() *Store {
+	return e.store
+}
+
+// engineBuffer is the engine's room for events during bursts; a full buffer
+// drops events, which Run reports.
+const engineBuffer = 10000
+
+// Run consumes the bus until ctx is done.
+func (e *Engine) Run(ctx context.Context) {
+	events := e.bus.SubscribeSize(engineBuffer)
+	defer e.bus.Unsubscribe(events)
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Type != monitor.TypeIncident {
+				e.Observe(ev)
+			}
+		case <-ticker.C:
+			if n := e.bus.Dropped(events); n > 0 {
+				logging.For("incident").Warn("Incident engine fell behind, events were not evaluated", "dropped", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Observe evaluates one event. It is not safe for concurrent use.
+func (e *Engine) Observe(me monitor.Event) {
+	ev := newEvent(me)
+	now := me.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	for _, r := range e.rules {
+		if !r.match(ev) {
+			continue
+		}
+		group := ""
+		if r.GroupBy != "" {
+			if group = ev.Field(r.GroupBy); group == "" {
+				continue // Can't attribute the event
+			}
+		}
+		if r.Kind == KindPattern || e.count(r, group, ev, now) {
+			e.raise(r, group, me.RequestID, now)
+		}
+	}
+	if now.Sub(e.lastSweep) > time.Minute {
+		e.sweep(now)
+	}
+}
+
+// count adds the event to the rule's window and reports whether the rule's
+// threshold is reached, starting a new window if so.
+func (e *Engine) count(r *Rule, group string, ev Event, now time.Time) bool {
+	key := windowKey{r.Name, group}
+	w := e.windows[key]
+	if w == nil {
+		w = &window{distinct: make(map[string]time.Time)}
+		e.windows[key] = w
+	}
+	w.prune(now.Add(-r.window))
+
+	var n int
+	if r.Kind == KindDistinct {
+		w.distinct[ev.Field(r.Distinct)] = now
+		n = len(w.distinct)
+	} else {
+		w.hits = append(w.hits, now)
+		n = len(w.hits)
+	}
+	if n < r.Count {
+		return false
+	}
+	delete(e.windows, key)
+	return true
+}
+
+func (w *window) prune(cutoff time.Time) {
+	i := 0
+	for i < len(w.hits) && w.hits[i].Before(cutoff) {
+		i++
+	}
+	w.hits = w.hits[i:]
+	for v, at := range w.distinct {
+		if at.Before(cutoff) {
+			delete(w.distinct, v)
+		}
+	}
+}
+
+// sweep drops windows that went quiet so one-off groups (e.g. IPs) don't
+// accumulate.
+func (e *Engine) sweep(now time.Time) {
+	e.lastSweep = now
+	for _, r := range e.rules {
+		for key, w := range e.windows {
+			if key.rule != r.Name {
+				continue
+			}
+			w.prune(now.Add(-r.window))
+			if len(w.hits) == 0 && len(w.distinct) == 0 {
+				delete(e.windows, key)
+			}
+		}
+	}
+}
+
+func (e *Engine) raise(r *Rule, group, reqID string, at time.Time) {
+	ctx := context.Background()
+	if reqID != "" {
+		ctx = requestid.NewContext(ctx, reqID)
+	}
+	log := logging.For("incident")
+	inc, opened, err := e.store.record(r, group, reqID, at.UTC())
+	if err != nil {
+		log.ErrorContext(ctx, "Saving incident failed", "rule", r.Name, "error", err)
+	}
+	if inc.ID == "" {
+		return
+	}
+	e.bus.PublishContext(ctx, monitor.TypeIncident, inc)
+	if !opened {
+		return
+	}
+	log.WarnContext(ctx, "Incident opened", "id", inc.ID, "rule", inc.Rule, "severity", inc.Severity, "key", inc.Key)
+	audit.Record(ctx, audit.Entry{
+		Action:   ActionOpened,
+		Resource: inc.ID,
+		Details:  map[string]interface{}{"rule": inc.Rule, "severity": inc.Severity, "key": inc.Key},
+	})
+}
+
+// Acknowledge marks an open incident as being handled by actor.
+func (e *Engine) Acknowledge(ctx context.Context, id, actor, note string) (Incident, error) {
+	return e.transition(ctx, id, StateAcknowledged, ActionAcknowledged, actor, note)
+}
+
+// Resolve closes an incident; later matches open a new one.
+func (e *Engine) Resolve(ctx context.Context, id, actor, note string) (Incident, error) {
+	return e.transition(ctx, id, StateResolved, ActionResolved, actor, note)
+}
+
+func (e *Engine) transition(ctx context.Context, id, state, action, actor, note string) (Incident, error) {
+	inc, err := e.store.transition(id, state, actor, note)
+	if err != nil {
+		return inc, err
+	}
+	e.bus.PublishContext(ctx, monitor.TypeIncident, inc)
+	logging.For("incident").InfoContext(ctx, "Incident "+state, "id", inc.ID, "by", actor)
+	audit.Record(ctx, audit.Entry{
+		Action:   action,
+		Actor:    actor,
+		Resource: inc.ID,
+		Details:  map[string]interface{}{"rule": inc.Rule, "note": note},
+	})
+	return inc, nil
+}
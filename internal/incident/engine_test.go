@@ -0,0 +1,144 @@
+package incident
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"wodge/internal/monitor"
+)
+
+// at is an event offset from the start of a test sequence.
+type at struct {
+	offset  time.Duration
+	typ     monitor.EventType
+	payload map[string]interface{}
+}
+
+func login(offset time.Duration, ip string) at {
+	return at{offset, monitor.TypeSecurity, map[string]interface{}{"action": "auth.login_failed", "ip": ip}}
+}
+
+func request(offset time.Duration, path string) at {
+	return at{offset, monitor.TypeRequest, map[string]interface{}{"route": "/api/items/:id", "path": path, "token_id": "t1"}}
+}
+
+// observe runs events through an engine with rule and returns the
+// incidents as key:times fired, e.g. "1.2.3.4:2".
+func observe(t *testing.T, rule Rule, events []at) []string {
+	t.Helper()
+	store, err := OpenStore(filepath.Join(t.TempDir(), "incidents.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine([]Rule{rule}, store, &monitor.Broadcaster{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, ev := range events {
+		e.Observe(monitor.Event{Type: ev.typ, Timestamp: start.Add(ev.offset), Payload: ev.payload})
+	}
+	var fired []string
+	for _, inc := range store.List() {
+		fired = append(fired, fmt.Sprintf("%s:%d", inc.Key, inc.Count))
+	}
+	sort.Strings(fired)
+	return fired
+}
+
+func TestRuleWindowsAndThresholds(t *testing.T) {
+	failedLogins := Rule{
+		Name: "failed_logins", Kind: KindThreshold, Type: monitor.TypeSecurity,
+		Where: map[string]string{"action": `^auth\.login_failed$`}, GroupBy: "ip", Count: 3, Window: "1m",
+	}
+	enumeration := Rule{
+		Name: "id_enumeration", Kind: KindDistinct, Type: monitor.TypeRequest,
+		Where: map[string]string{"route": `:id`}, GroupBy: "token_id", Distinct: "path", Count: 3, Window: "1m",
+	}
+	reuse := Rule{
+		Name: "reuse", Kind: KindPattern, Type: monitor.TypeSecurity,
+		Where: map[string]string{"action": `^auth\.refresh_token_reuse$`}, GroupBy: "user_id",
+	}
+	s := time.Second
+
+	tests := []struct {
+		name   string
+		rule   Rule
+		events []at
+		want   []string // key:times fired
+	}{
+		{"threshold within the window", failedLogins,
+			[]at{login(0, "a"), login(10*s, "a"), login(20*s, "a")}, []string{"a:1"}},
+		{"threshold one short", failedLogins,
+			[]at{login(0, "a"), login(10*s, "a")}, nil},
+		{"threshold spread past the window", failedLogins,
+			[]at{login(0, "a"), login(40*s, "a"), login(80*s, "a")}, nil},
+		{"hit exactly a window ago still counts", failedLogins,
+			[]at{login(0, "a"), login(30*s, "a"), login(60*s, "a")}, []string{"a:1"}},
+		{"window restarts after firing", failedLogins,
+			[]at{login(0, "a"), login(1*s, "a"), login(2*s, "a"), login(3*s, "a"), login(4*s, "a"), login(5*s, "a"), login(6*s, "a")}, []string{"a:2"}},
+		{"groups count separately", failedLogins,
+			[]at{login(0, "a"), login(1*s, "b"), login(2*s, "a"), login(3*s, "b"), login(4*s, "a")}, []string{"a:1"}},
+		{"events without the group field are ignored", failedLogins,
+			[]at{login(0, ""), login(1*s, ""), login(2*s, "")}, nil},
+		{"other event types are ignored", failedLogins,
+			[]at{{0, monitor.TypeRequest, map[string]interface{}{"action": "auth.login_failed", "ip": "a"}}, login(1*s, "a"), login(2*s, "a")}, nil},
+		{"where must match", failedLogins,
+			[]at{{0, monitor.TypeSecurity, map[string]interface{}{"action": "auth.login", "ip": "a"}}, login(1*s, "a"), login(2*s, "a")}, nil},
+		{"distinct values reach the count", enumeration,
+			[]at{request(0, "/api/items/1"), request(1*s, "/api/items/2"), request(2*s, "/api/items/3")}, []string{"t1:1"}},
+		{"repeated values count once", enumeration,
+			[]at{request(0, "/api/items/1"), request(1*s, "/api/items/1"), request(2*s, "/api/items/2"), request(3*s, "/api/items/2")}, nil},
+		{"distinct values expire", enumeration,
+			[]at{request(0, "/api/items/1"), request(30*s, "/api/items/2"), request(70*s, "/api/items/3")}, nil},
+		{"a value seen again stays in the window", enumeration,
+			[]at{request(0, "/api/items/1"), request(30*s, "/api/items/2"), request(50*s, "/api/items/1"), request(70*s, "/api/items/3")}, []string{"t1:1"}},
+		{"patterns fire on every match", reuse,
+			[]at{
+				{0, monitor.TypeSecurity, map[string]interface{}{"action": "auth.refresh_token_reuse", "user_id": "u1"}},
+				{time.Hour, monitor.TypeSecurity, map[string]interface{}{"action": "auth.refresh_token_reuse", "user_id": "u1"}},
+			}, []string{"u1:2"}},
+		{"numeric fields match as text", Rule{Name: "server_errors", Kind: KindThreshold, Type: monitor.TypeRequest, Where: map[string]string{"status": `^5\d\d$`}, Count: 2, Window: "1m"},
+			[]at{{0, monitor.TypeRequest, map[string]interface{}{"status": float64(502)}}, {s, monitor.TypeRequest, map[string]interface{}{"status": float64(404)}}, {2 * s, monitor.TypeRequest, map[string]interface{}{"status": float64(500)}}},
+			[]string{":1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := observe(t, tt.rule, tt.events)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("fired %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleCompileRejectsBadRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"no name", Rule{Kind: KindPattern}},
+		{"unknown kind", Rule{Name: "r", Kind: "sometimes"}},
+		{"unknown severity", Rule{Name: "r", Kind: KindPattern, Severity: "urgent"}},
+		{"threshold without a count", Rule{Name: "r", Kind: KindThreshold, Window: "1m"}},
+		{"threshold without a window", Rule{Name: "r", Kind: KindThreshold, Count: 3}},
+		{"negative window", Rule{Name: "r", Kind: KindThreshold, Count: 3, Window: "-1m"}},
+		{"distinct without a field", Rule{Name: "r", Kind: KindDistinct, Count: 3, Window: "1m"}},
+		{"invalid pattern", Rule{Name: "r", Kind: KindPattern, Where: map[string]string{"path": "("}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.compile(); err == nil {
+				t.Error("compile() accepted the rule")
+			}
+		})
+	}
+	for _, r := range DefaultRules() {
+		if err := r.compile(); err != nil {
+			t.Errorf("default rule %s: %v", r.Name, err)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Package incident detects security incidents in the monitor stream and
+// tracks them until they are resolved, as the basis for NIS2 early warnings.
+package incident
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"wodge/internal/services"
+)
+
+// Severities, in increasing order
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// States. An incident is open until someone acknowledges it and stays
+// active until resolved; new matches update the active incident.
+const (
+	StateOpen         = "open"
+	StateAcknowledged = "acknowledged"
+	StateResolved     = "resolved"
+)
+
+// Audit actions for incident state changes
+const (
+	ActionOpened       = "incident.opened"
+	ActionAcknowledged = "incident.acknowledged"
+	ActionResolved     = "incident.resolved"
+)
+
+// maxRequestIDs caps the sample of triggering request IDs kept per incident
+const maxRequestIDs = 20
+
+// Incident is one detected incident. It is also the payload of INCIDENT
+// monitor events.
+type Incident struct {
+	ID             string     `json:"id"`
+	Rule           string     `json:"rule"`
+	Title          string     `json:"title"`
+	Severity       string     `json:"severity"`
+	State          string     `json:"state"`
+	Key            string     `json:"key,omitempty"` // The group_by value, e.g. the IP
+	Count          int        `json:"count"`         // How often the rule fired
+	FirstSeen      time.Time  `json:"first_seen"`
+	LastSeen       time.Time  `json:"last_seen"`
+	RequestIDs     []string   `json:"request_ids,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy     string     `json:"resolved_by,omitempty"`
+	Note           string     `json:"note,omitempty"`
+}
+
+// Active reports whether new matches are added to this incident.
+func (i *Incident) Active() bool {
+	return i.State != StateResolved
+}
+
+// Store keeps incidents in a single JSON file.
+type Store struct {
+	path string
+
+	mu        sync.Mutex
+	incidents []*Incident
+	next      int
+}
+
+// OpenStore loads the incidents in path, creating the file on first save.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path, next: 1}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.incidents); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, inc := range s.incidents {
+		var n int
+		if _, err := fmt.Sscanf(inc.ID, "INC-%d", &n); err == nil && n >= s.next {
+			s.next = n + 1
+		}
+	}
+	return s, nil
+}
+
+// List returns copies of the incidents in the given states (all if none),
+// most recently seen first.
+func (s *Store) List(states ...string) []Incident {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Incident
+	for _, inc := range s.incidents {
+		if len(states) > 0 && !contains(states, inc.State) {
+			continue
+		}
+		out = append(out, *inc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastSeen.After(out[j].LastSeen) })
+	return out
+}
+
+// Get returns a copy of the incident with the given ID.
+func (s *Store) Get(id string) (Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inc := s.find(id)
+	if inc == nil {
+		return Incident{}, services.NotFound("Incident not found")
+	}
+	return *inc, nil
+}
+
+// record adds a match for rule and key: it updates the active incident or
+// opens a new one. opened tells which of the two happened.
+func (s *Store) record(r *Rule, key, requestID string, at time.Time) (inc Incident, opened bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var active *Incident
+	for _, i := range s.incidents {
+		if i.Rule == r.Name && i.Key == key && i.Active() {
+			active = i
+		}
+	}
+	if active == nil {
+		active = &Incident{
+			ID:        fmt.Sprintf("INC-%04d", s.next),
+			Rule:      r.Name,
+			Title:     r.Title,
+			Severity:  r.Severity,
+			State:     StateOpen,
+			Key:       key,
+			FirstSeen: at,
+		}
+		s.next++
+		s.incidents = append(s.incidents, active)
+		opened = true
+	}
+	active.Count++
+	active.LastSeen = at
+	if requestID != "" && len(active.RequestIDs) < maxRequestIDs {
+		active.RequestIDs = append(active.RequestIDs, requestID)
+	}
+	return *active, opened, s.save()
+}
+
+// transition moves an incident to state, recording who did it.
+func (s *Store) transition(id, state, by, note string) (Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inc := s.find(id)
+	if inc == nil {
+		return Incident{}, services.NotFound("Incident not found")
+	}
+	now := time.Now().UTC()
+	switch {
+	case inc.State == StateResolved:
+		return Incident{}, services.Conflict("Incident is already resolved")
+	case state == StateAcknowledged && inc.State == StateAcknowledged:
+		return Incident{}, services.Conflict("Incident is already acknowledged")
+	case state == StateAcknowledged:
+		inc.AcknowledgedAt, inc.AcknowledgedBy = &now, by
+	case state == StateResolved:
+		inc.ResolvedAt, inc.ResolvedBy = &now, by
+	default:
+		return Incident{}, services.Validation("Unknown incident state")
+	}
+	inc.State = state
+	if note != "" {
+		inc.Note = note
+	}
+	return *inc, s.save()
+}
+
+func (s *Store) find(id string) *Incident {
+	for _, inc := range s.incidents {
+		if inc.ID == id {
+			return inc
+		}
+	}
+	return nil
+}
+
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.incidents, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
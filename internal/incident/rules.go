@@ -0,0 +1,194 @@
+package incident
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"wodge/internal/monitor"
+)
+
+// Rule kinds
+const (
+	// KindPattern raises an incident for every matching event
+	KindPattern = "pattern"
+	// KindThreshold raises one when Count events match within Window
+	KindThreshold = "threshold"
+	// KindDistinct raises one when matching events carry Count different
+	// values of the Distinct field within Window
+	KindDistinct = "distinct"
+)
+
+// Rule is a detection rule. Fields are dot-separated paths into the event
+// payload, e.g. "status" or "action".
+type Rule struct {
+	Name     string            `json:"name"`
+	Title    string            `json:"title"`
+	Kind     string            `json:"kind"`
+	Severity string            `json:"severity"`
+	Type     monitor.EventType `json:"type,omitempty"`  // Event type to consider, any if empty
+	Where    map[string]string `json:"where,omitempty"` // Field -> regexp, all must match
+	GroupBy  string            `json:"group_by,omitempty"`
+	Distinct string            `json:"distinct,omitempty"`
+	Count    int               `json:"count,omitempty"`
+	Window   string            `json:"window,omitempty"` // Duration, e.g. "5m"
+
+	where  map[string]*regexp.Regexp
+	window time.Duration
+}
+
+// DefaultRules covers brute force, error spikes, ID enumeration, dead
+// letters and refresh token theft.
+func DefaultRules() []Rule {
+	return []Rule{
+		{
+			Name: "failed_logins", Title: "Repeated failed logins from one IP",
+			Kind: KindThreshold, Severity: SeverityHigh,
+			Type: monitor.TypeSecurity, Where: map[string]string{"action": `^auth\.login_failed$`},
+			GroupBy: "ip", Count: 10, Window: "5m",
+		},
+		{
+			Name: "server_errors", Title: "Spike of server errors",
+			Kind: KindThreshold, Severity: SeverityHigh,
+			Type: monitor.TypeRequest, Where: map[string]string{"status": `^5\d\d$`},
+			Count: 20, Window: "1m",
+		},
+		{
+			Name: "id_enumeration", Title: "One token accessing many records by ID",
+			Kind: KindDistinct, Severity: SeverityHigh,
+			Type: monitor.TypeRequest, Where: map[string]string{"route": `:id`, "token_id": `.`},
+			GroupBy: "token_id", Distinct: "path", Count: 25, Window: "5m",
+		},
+		{
+			Name: "dead_letters", Title: "Dead-letter queue growing",
+			Kind: KindThreshold, Severity: SeverityMedium,
+			Type: monitor.TypeRabbitMQ, Where: map[string]string{"operation": `^PUBLISH$`, "target": `(?i)dead|dlq|dlx`},
+			GroupBy: "target", Count: 10, Window: "10m",
+		},
+		{
+			Name: "refresh_token_reuse", Title: "Refresh token reused (possible token theft)",
+			Kind: KindPattern, Severity: SeverityCritical,
+			Type: monitor.TypeSecurity, Where: map[string]string{"action": `^auth\.refresh_token_reuse$`},
+			GroupBy: "user_id",
+		},
+		{
+			Name: "account_lockout", Title: "Account locked out",
+			Kind: KindPattern, Severity: SeverityMedium,
+			Type: monitor.TypeSecurity, Where: map[string]string{"action": `^auth\.lockout$`},
+			GroupBy: "username",
+		},
+	}
+}
+
+// RulesFromEnv loads INCIDENT_RULES, a JSON file with a list of rules that
+// replaces the defaults.
+func RulesFromEnv() ([]Rule, error) {
+	path := os.Getenv("INCIDENT_RULES")
+	if path == "" {
+		return DefaultRules(), nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule without a name")
+	}
+	if r.Title == "" {
+		r.Title = r.Name
+	}
+	switch r.Severity {
+	case SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical:
+	case "":
+		r.Severity = SeverityMedium
+	default:
+		return fmt.Errorf("rule %s: unknown severity %q", r.Name, r.Severity)
+	}
+	switch r.Kind {
+	case KindPattern:
+	case KindThreshold, KindDistinct:
+		if r.Count < 1 {
+			return fmt.Errorf("rule %s: count must be at least 1", r.Name)
+		}
+		d, err := time.ParseDuration(r.Window)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("rule %s: invalid window %q", r.Name, r.Window)
+		}
+		r.window = d
+		if r.Kind == KindDistinct && r.Distinct == "" {
+			return fmt.Errorf("rule %s: distinct rules need a distinct field", r.Name)
+		}
+	default:
+		return fmt.Errorf("rule %s: unknown kind %q", r.Name, r.Kind)
+	}
+	r.where = make(map[string]*regexp.Regexp, len(r.Where))
+	for field, pattern := range r.Where {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("rule %s: %s: %w", r.Name, field, err)
+		}
+		r.where[field] = re
+	}
+	return nil
+}
+
+func (r *Rule) match(e Event) bool {
+	if r.Type != "" && e.Type != r.Type {
+		return false
+	}
+	for field, re := range r.where {
+		if !re.MatchString(e.Field(field)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Event is a monitor event with its payload in generic JSON form.
+type Event struct {
+	monitor.Event
+	payload map[string]interface{}
+}
+
+func newEvent(e monitor.Event) Event {
+	ev := Event{Event: e}
+	switch p := e.Payload.(type) {
+	case map[string]interface{}:
+		ev.payload = p
+	default:
+		// Unredacted structs, e.g. when no filter is installed on the bus
+		if raw, err := json.Marshal(p); err == nil {
+			_ = json.Unmarshal(raw, &ev.payload)
+		}
+	}
+	return ev
+}
+
+// Field returns the payload value at path as a string, "" if missing.
+func (e Event) Field(path string) string {
+	var v interface{} = e.payload
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[key]
+	}
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	}
+	return fmt.Sprint(v)
+}
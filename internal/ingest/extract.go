@@ -0,0 +1,464 @@
+package ingest
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// maxInflated caps how much a compressed part of a file may expand to, so a
+// small upload cannot exhaust memory.
+const maxInflated = 64 << 20
+
+var errTooLarge = unreadable("The file expands to more text than can be ingested")
+
+// inflate reads r up to maxInflated bytes.
+func inflate(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxInflated+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxInflated {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
+
+// -- Plain text and Markdown --
+
+func extractText(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return "", unreadable("Text files must be UTF-8")
+	}
+	return string(data), nil
+}
+
+// -- HTML --
+
+// skipped elements hold no readable text
+var skipped = map[string]bool{"script": true, "style": true, "noscript": true, "template": true, "svg": true, "head": true}
+
+// blocks start on a new line
+var blocks = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "section": true, "article": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "pre": true,
+	"blockquote": true, "table": true, "ul": true, "ol": true, "dt": true, "dd": true, "hr": true,
+}
+
+func extractHTML(data []byte) (string, error) {
+	if !utf8.Valid(data) {
+		return "", unreadable("HTML files must be UTF-8")
+	}
+	var b strings.Builder
+	z := html.NewTokenizer(bytes.NewReader(data))
+	skip := 0
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return "", unreadable("The HTML could not be parsed")
+			}
+			return b.String(), nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if skipped[tag] && tt == html.StartTagToken {
+				skip++
+			}
+			if blocks[tag] {
+				b.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if skipped[tag] && skip > 0 {
+				skip--
+			}
+			if blocks[tag] {
+				b.WriteString("\n")
+			}
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(strings.Join(strings.Fields(string(z.Text())), " "))
+				b.WriteString(" ")
+			}
+		}
+	}
+}
+
+// -- DOCX --
+
+// extractDOCX reads the paragraphs of word/document.xml. Headers, footers
+// and comments are left out.
+func extractDOCX(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", unreadable("The DOCX file is not a valid archive")
+	}
+	var doc *zip.File
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			doc = f
+			break
+		}
+	}
+	if doc == nil {
+		return "", unreadable("The archive is not a Word document")
+	}
+	rc, err := doc.Open()
+	if err != nil {
+		return "", unreadable("The DOCX file is damaged")
+	}
+	defer rc.Close()
+	raw, err := inflate(rc)
+	if err != nil {
+		if errors.Is(err, errTooLarge) {
+			return "", err
+		}
+		return "", unreadable("The DOCX file is damaged")
+	}
+
+	var b strings.Builder
+	dec := xml.NewDecoder(bytes.NewReader(raw))
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return b.String(), nil
+		}
+		if err != nil {
+			return "", unreadable("The DOCX file is damaged")
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				b.WriteString("\t")
+			case "br", "cr":
+				b.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				b.WriteString("\n\n")
+			case "tc":
+				b.WriteString("\t")
+			}
+		case xml.CharData:
+			if inText {
+				b.Write(t)
+			}
+		}
+	}
+}
+
+// -- PDF --
+
+// streamStart finds the streams of a PDF with the dictionary before them.
+var streamStart = regexp.MustCompile(`(?s)<<((?:[^<>]|<[^<>]*>|<<(?:[^<>]|<[^<>]*>)*>>)*)>>\s*stream\r?\n`)
+
+// nonText are stream dictionaries that never hold page text
+var nonText = regexp.MustCompile(`/(?:Image|ObjStm|XRef|Metadata|Length1|Length2|Length3|FontFile|Type1C|CIDFontType0C|OpenType|ICCBased|XML)\b|/Type\s*/XObject`)
+
+// extractPDF pulls the text shown by the page content streams. Only plain
+// and FlateDecode streams are read; text drawn as images (scans) or with
+// fonts lacking a standard encoding does not come out, and a file with no
+// readable text is refused.
+func extractPDF(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return "", unreadable("The file is not a PDF")
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", unreadable("Encrypted PDFs cannot be ingested, remove the password first")
+	}
+	var b strings.Builder
+	total := 0
+	for _, m := range streamStart.FindAllSubmatchIndex(data, -1) {
+		dict := data[m[2]:m[3]]
+		body := data[m[1]:]
+		end := bytes.Index(body, []byte("endstream"))
+		if end < 0 {
+			continue
+		}
+		body = body[:end]
+		if nonText.Match(dict) {
+			continue
+		}
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			if bytes.Contains(dict, []byte("/DecodeParms")) {
+				continue // Predictors are only used for images and xref streams
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				continue
+			}
+			inflated, err := inflate(zr)
+			if errors.Is(err, errTooLarge) {
+				return "", err
+			}
+			if err != nil && len(inflated) == 0 {
+				continue // Damaged or truncated streams give what they have
+			}
+			body = inflated
+		case bytes.Contains(dict, []byte("/Filter")):
+			continue
+		}
+		if total += len(body); total > maxInflated {
+			return "", errTooLarge
+		}
+		pdfText(&b, body)
+	}
+	text := b.String()
+	if !readable(text) {
+		return "", unreadable("No readable text found in the PDF, scanned documents need OCR first")
+	}
+	return text, nil
+}
+
+// readable is false for text that is mostly not letters, digits or spaces,
+// which is what fonts with custom encodings come out as.
+func readable(text string) bool {
+	good, all := 0, 0
+	for _, r := range text {
+		all++
+		if r == ' ' || r == '\n' || r == '\t' || (r >= '0' && r <= '9') || strings.ContainsRune(".,;:!?'\"()-", r) ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r >= 0xC0 && r != utf8.RuneError {
+			good++
+		}
+	}
+	return all > 0 && good*10 >= all*8
+}
+
+// pdfText interprets the text operators of a content stream: strings shown
+// by Tj, TJ, ' and ", with line breaks for T*, ET and vertical Td and TD
+// moves, and a space for horizontal moves and wide gaps in TJ arrays.
+func pdfText(b *strings.Builder, content []byte) {
+	var operands []string // Strings since the last operator
+	var numbers []float64
+	inArray, gap := false, false
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := pdfLiteral(content[i:])
+			if inArray && gap && len(operands) > 0 {
+				s = " " + s
+			}
+			operands = append(operands, s)
+			gap = false
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			i += 2 // Dictionaries of marked content
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			operands = append(operands, pdfHex(content[i+1:i+end]))
+			i += end + 1
+		case c == '[':
+			inArray, gap = true, false
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isDelimiter(c) || isSpace(c):
+			i++
+		default:
+			j := i
+			for j < len(content) && !isDelimiter(content[j]) && !isSpace(content[j]) {
+				j++
+			}
+			word := string(content[i:j])
+			i = j
+			if inArray {
+				// Kerning of more than a fifth of an em is a word gap
+				if n, ok := pdfNumber(word); ok && n < -200 {
+					gap = true
+				}
+				continue
+			}
+			if n, ok := pdfNumber(word); ok {
+				numbers = append(numbers, n)
+				continue
+			}
+			switch word {
+			case "Tj", "TJ":
+				b.WriteString(strings.Join(operands, ""))
+			case "'", `"`:
+				b.WriteString("\n")
+				b.WriteString(strings.Join(operands, ""))
+			case "Td", "TD":
+				// A move along the line separates words, not lines
+				if len(numbers) >= 2 && numbers[len(numbers)-1] == 0 {
+					b.WriteString(" ")
+				} else {
+					b.WriteString("\n")
+				}
+			case "T*", "ET":
+				b.WriteString("\n")
+			}
+			operands, numbers = operands[:0], numbers[:0]
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func pdfNumber(word string) (float64, bool) {
+	var n float64
+	var frac float64
+	neg, digits := false, 0
+	for i, c := range word {
+		switch {
+		case c == '-' && i == 0:
+			neg = true
+		case c == '+' && i == 0:
+		case c == '.' && frac == 0:
+			frac = 1
+		case c >= '0' && c <= '9':
+			digits++
+			if frac > 0 {
+				frac /= 10
+				n += float64(c-'0') * frac
+			} else {
+				n = n*10 + float64(c-'0')
+			}
+		default:
+			return 0, false
+		}
+	}
+	if neg {
+		n = -n
+	}
+	return n, digits > 0
+}
+
+// pdfLiteral decodes the (string) at the start of s and returns it with the
+// number of bytes read.
+func pdfLiteral(s []byte) (string, int) {
+	var out []byte
+	depth := 0
+	i := 0
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '(':
+			if depth++; depth == 1 {
+				continue
+			}
+		case ')':
+			if depth--; depth == 0 {
+				return pdfString(out), i + 1
+			}
+		case '\\':
+			if i+1 >= len(s) {
+				continue
+			}
+			i++
+			switch e := s[i]; e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				if e == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+					i++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for k := 0; k < 2 && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '7'; k++ {
+						i++
+						v = v*8 + int(s[i]-'0')
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return pdfString(out), i
+}
+
+func pdfHex(s []byte) string {
+	var out []byte
+	var hi byte
+	half := false
+	for _, c := range s {
+		var v byte
+		switch {
+		case c >= '0' && c <= '9':
+			v = c - '0'
+		case c >= 'a' && c <= 'f':
+			v = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			v = c - 'A' + 10
+		default:
+			continue
+		}
+		if half {
+			out = append(out, hi<<4|v)
+		} else {
+			hi = v
+		}
+		half = !half
+	}
+	if half {
+		out = append(out, hi<<4)
+	}
+	return pdfString(out)
+}
+
+// pdfString decodes UTF-16 strings marked with a byte order mark and reads
+// the rest as Latin-1, which the standard encodings agree with for letters.
+func pdfString(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff {
+		units := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			units = append(units, binary.BigEndian.Uint16(b[i:]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
@@ -0,0 +1,189 @@
+// Package ingest turns uploaded documents into text for QAST's knowledge
+// graph: it checks the file type, extracts the text locally, splits it into
+// overlapping chunks and ingests them one by one as a tracked job, keeping a
+// manifest of what was ingested.
+package ingest
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"wodge/internal/services"
+)
+
+// Formats
+const (
+	PDF      = "pdf"
+	DOCX     = "docx"
+	Markdown = "markdown"
+	HTML     = "html"
+	Text     = "text"
+)
+
+// extensions maps file extensions to formats.
+var extensions = map[string]string{
+	".pdf":      PDF,
+	".docx":     DOCX,
+	".md":       Markdown,
+	".markdown": Markdown,
+	".html":     HTML,
+	".htm":      HTML,
+	".txt":      Text,
+	".text":     Text,
+}
+
+// sniffed is what http.DetectContentType may report for each format. Text
+// formats accept any text type, since e.g. Markdown may open with a tag.
+var sniffed = map[string][]string{
+	PDF:      {"application/pdf"},
+	DOCX:     {"application/zip"},
+	Markdown: {"text/"},
+	HTML:     {"text/html", "text/plain", "text/xml"},
+	Text:     {"text/"},
+}
+
+// Detect returns the format of a file from its name, after checking that its
+// first bytes agree. A mismatch is refused rather than guessed around, so a
+// renamed binary never reaches the extractors.
+func Detect(name string, head []byte) (string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	format, ok := extensions[ext]
+	if !ok {
+		return "", services.Validation(
+			"Unsupported file type, upload PDF, DOCX, Markdown, HTML or plain text",
+			services.FieldError{Field: "file", Code: "type", Message: fmt.Sprintf("%q files are not supported", ext)})
+	}
+	sniff := http.DetectContentType(head)
+	for _, prefix := range sniffed[format] {
+		if strings.HasPrefix(sniff, prefix) {
+			return format, nil
+		}
+	}
+	return "", services.Validation(
+		fmt.Sprintf("The file's content does not match its %s extension", ext),
+		services.FieldError{Field: "file", Code: "type", Message: "Content looks like " + sniff})
+}
+
+// Extract returns the text of a file in the given format.
+func Extract(format string, data []byte) (string, error) {
+	var text string
+	var err error
+	switch format {
+	case PDF:
+		text, err = extractPDF(data)
+	case DOCX:
+		text, err = extractDOCX(data)
+	case HTML:
+		text, err = extractHTML(data)
+	case Markdown, Text:
+		text, err = extractText(data)
+	default:
+		return "", fmt.Errorf("ingest: unknown format %q", format)
+	}
+	if err != nil {
+		return "", err
+	}
+	text = normalize(text)
+	if text == "" {
+		return "", unreadable("No text found in the file")
+	}
+	return text, nil
+}
+
+// unreadable reports a file that passed detection but cannot be extracted.
+func unreadable(msg string) error {
+	return services.Validation(msg, services.FieldError{Field: "file", Code: "unreadable", Message: msg})
+}
+
+// normalize unifies line endings, drops control characters, trims trailing
+// spaces and collapses runs of blank lines.
+func normalize(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) && r != unicode.ReplacementChar {
+			return r
+		}
+		return -1
+	}, s)
+	lines := strings.Split(s, "\n")
+	out := lines[:0]
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line == "" {
+			if blank++; blank > 1 {
+				continue
+			}
+		} else {
+			blank = 0
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// Chunk splits text into pieces of at most size characters, each repeating
+// the last overlap characters of the one before so facts spanning a cut are
+// seen whole at least once. Cuts fall on a paragraph, sentence or word
+// boundary in the second half of a piece when there is one.
+func Chunk(text string, size, overlap int) []string {
+	if size <= 0 {
+		return nil
+	}
+	overlap = min(max(overlap, 0), size/2)
+	runes := []rune(text)
+	var chunks []string
+	for start := 0; start < len(runes); {
+		end := min(start+size, len(runes))
+		if end < len(runes) {
+			end = boundary(runes, start+size/2, end)
+		}
+		if piece := strings.TrimSpace(string(runes[start:end])); piece != "" {
+			chunks = append(chunks, piece)
+		}
+		if end == len(runes) {
+			break
+		}
+		next := end - overlap
+		// Start the overlap on a word, not inside one
+		for i := next; i < end; i++ {
+			if unicode.IsSpace(runes[i]) {
+				next = i + 1
+				break
+			}
+		}
+		start = max(next, start+1)
+	}
+	return chunks
+}
+
+// boundary returns the best cut in runes[from:to]: after the last paragraph
+// break, else the last sentence end, else the last space, else at to.
+func boundary(runes []rune, from, to int) int {
+	sentence, space := -1, -1
+	for i := to - 1; i >= from; i-- {
+		r := runes[i]
+		if !unicode.IsSpace(r) {
+			continue
+		}
+		if r == '\n' && i > 0 && runes[i-1] == '\n' {
+			return i + 1
+		}
+		if space < 0 {
+			space = i + 1
+		}
+		if sentence < 0 && i > 0 && strings.ContainsRune(".!?:;\n", runes[i-1]) {
+			sentence = i + 1
+		}
+	}
+	switch {
+	case sentence > 0:
+		return sentence
+	case space > 0:
+		return space
+	}
+	return to
+}
@@ -0,0 +1,137 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"wodge/internal/services"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		head string
+		want string // Format, "" when refused
+	}{
+		{"PDF", "report.pdf", "%PDF-1.7\n", PDF},
+		{"upper-case extension", "REPORT.PDF", "%PDF-1.4\n", PDF},
+		{"DOCX", "notes.docx", "PK\x03\x04\x14\x00\x06\x00", DOCX},
+		{"Markdown", "README.md", "# Title\n\nSome text.", Markdown},
+		{"Markdown opening with a comment", "page.md", "<!-- draft -->\n# Title", Markdown},
+		{"HTML", "index.html", "<!DOCTYPE html><html><body>hi</body></html>", HTML},
+		{"XHTML", "page.htm", "<?xml version=\"1.0\"?><html></html>", HTML},
+		{"plain text", "notes.txt", "Just some words.", Text},
+		{"executable renamed to PDF", "invoice.pdf", "MZ\x90\x00\x03\x00\x00\x00", ""},
+		{"text renamed to DOCX", "notes.docx", "Just some words.", ""},
+		{"PDF renamed to text", "notes.txt", "%PDF-1.7\n", ""},
+		{"binary as text", "data.txt", "\x00\x01\x02\x03", ""},
+		{"unsupported extension", "tool.exe", "MZ\x90\x00", ""},
+		{"no extension", "README", "Just some words.", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Detect(tt.file, []byte(tt.head))
+			if got != tt.want {
+				t.Errorf("Detect(%q) = %q, %v, want %q", tt.file, got, err, tt.want)
+			}
+			if tt.want == "" && !errors.Is(err, services.ErrValidation) {
+				t.Errorf("Detect(%q) error = %v, want a validation error", tt.file, err)
+			}
+		})
+	}
+}
+
+// words returns n distinct words, with a sentence end after every seventh
+// and a paragraph break after every twentieth.
+func words(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "w%d", i)
+		switch {
+		case i%20 == 0:
+			b.WriteString(".\n\n")
+		case i%7 == 0:
+			b.WriteString(". ")
+		default:
+			b.WriteString(" ")
+		}
+	}
+	return b.String()
+}
+
+// letters returns n letters without spaces or repeats short enough to
+// look like an overlap.
+func letters(n int) string {
+	b := make([]byte, n)
+	x := uint32(1)
+	for i := range b {
+		x = x*1664525 + 1013904223
+		b[i] = 'a' + byte(x>>24)%26
+	}
+	return string(b)
+}
+
+// shared returns how much of the end of prev next opens with.
+func shared(prev, next string) int {
+	for n := min(len(prev), len(next)); n > 0; n-- {
+		if strings.HasSuffix(prev, next[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+func TestChunk(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		size, overlap int
+		wantChunks    int // 0 for more than one
+	}{
+		{"shorter than a chunk", "One line of text.", 100, 20, 1},
+		{"words", words(300), 200, 50, 0},
+		{"no overlap", words(300), 200, 0, 0},
+		{"overlap capped at half", words(300), 100, 90, 0},
+		{"no boundaries", letters(250), 100, 20, 0},
+		{"empty", "", 100, 20, -1},
+		{"no size", words(30), 0, 20, -1},
+	}
+	squash := func(s string) string { return strings.Join(strings.Fields(s), "") }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := Chunk(tt.text, tt.size, tt.overlap)
+			switch {
+			case tt.wantChunks < 0:
+				if len(chunks) != 0 {
+					t.Fatalf("Chunk() = %d chunks, want none", len(chunks))
+				}
+				return
+			case tt.wantChunks > 0 && len(chunks) != tt.wantChunks, tt.wantChunks == 0 && len(chunks) < 2:
+				t.Fatalf("Chunk() = %d chunks", len(chunks))
+			}
+			overlap := min(tt.overlap, tt.size/2)
+			rebuilt := chunks[0]
+			for i, c := range chunks {
+				if n := len([]rune(c)); n > tt.size {
+					t.Errorf("chunk %d has %d characters, over %d", i, n, tt.size)
+				}
+				if i == 0 {
+					continue
+				}
+				n := shared(chunks[i-1], c)
+				if n > overlap || overlap > 0 && n == 0 {
+					t.Errorf("chunk %d repeats %d characters of the one before, want 1 to %d", i, n, overlap)
+				}
+				if n > 0 && overlap > 0 && strings.Contains(c, " ") && !strings.HasPrefix(c, "w") {
+					t.Errorf("chunk %d starts inside a word: %q", i, c[:min(len(c), 10)])
+				}
+				rebuilt += c[n:]
+			}
+			if squash(rebuilt) != squash(tt.text) {
+				t.Errorf("chunks without their overlap do not rebuild the text:\n%q", rebuilt)
+			}
+		})
+	}
+}
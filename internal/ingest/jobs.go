@@ -0,0 +1,458 @@
+package ingest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"wodge/internal/audit"
+	"wodge/internal/logging"
+	"wodge/internal/services"
+)
+
+// maxJobs finished jobs are kept for the API, oldest dropped first
+const maxJobs = 100
+
+// Config limits uploads and shapes the chunks. Zero values mean the defaults.
+type Config struct {
+	MaxBytes     int64 // Per file, default 20 MiB
+	ChunkSize    int   // Characters per chunk, default 4000
+	ChunkOverlap int   // Characters repeated between chunks, default 400
+	MaxChunks    int   // Per document, default 500
+	Workers      int   // Documents ingested at once, default 2
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = 20 << 20
+	}
+	if c.ChunkSize <= 0 {
+		c.ChunkSize = 4000
+	}
+	if c.ChunkOverlap <= 0 {
+		c.ChunkOverlap = 400
+	}
+	if c.MaxChunks <= 0 {
+		c.MaxChunks = 500
+	}
+	if c.Workers <= 0 {
+		c.Workers = 2
+	}
+	return c
+}
+
+// Upload is a file to ingest.
+type Upload struct {
+	Name   string
+	Data   []byte
+	Owner  string // User ID, empty without auth
+	UserID string // QAST user the graph is built for
+	Actor  string
+}
+
+// Job is one ingestion of a document. Chunks are sent one after the other,
+// so Chunks doubles as the progress report.
+type Job struct {
+	ID         string        `json:"id"`
+	Document   string        `json:"document"`
+	Name       string        `json:"name"`
+	Owner      string        `json:"owner,omitempty"`
+	Actor      string        `json:"actor,omitempty"`
+	State      string        `json:"state" ts:"'pending' | 'running' | 'done' | 'failed'"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	Done       int           `json:"done"`
+	Failed     int           `json:"failed"`
+	Total      int           `json:"total"`
+	Chunks     []ChunkResult `json:"chunks,omitempty"`
+}
+
+// ChunkResult is the outcome of a job for one chunk.
+type ChunkResult struct {
+	Index int    `json:"index"`
+	Chars int    `json:"chars"`
+	State string `json:"state" ts:"'pending' | 'running' | 'done' | 'failed' | 'skipped'"`
+	Error string `json:"error,omitempty"`
+}
+
+// Ingester runs ingestion jobs against QAST and records them in the
+// manifest.
+type Ingester struct {
+	store *Store
+	qast  services.QastService
+	cfg   Config
+	slots chan struct{}
+
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string
+	// running maps document IDs to their unfinished job
+	running map[string]string
+}
+
+// New builds an Ingester. Documents the manifest shows as still ingesting
+// were cut short by a restart and are marked failed.
+func New(store *Store, qast services.QastService, cfg Config) (*Ingester, error) {
+	docs, err := store.Documents()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range docs {
+		if d.State != StatePending && d.State != StateRunning {
+			continue
+		}
+		err := store.Update(d.ID, func(d *Document) {
+			d.State, d.Error = StateFailed, "Interrupted by a restart, re-ingest the document"
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	cfg = cfg.withDefaults()
+	return &Ingester{
+		store:   store,
+		qast:    qast,
+		cfg:     cfg,
+		slots:   make(chan struct{}, cfg.Workers),
+		jobs:    map[string]*Job{},
+		running: map[string]string{},
+	}, nil
+}
+
+// MaxBytes is the largest file accepted.
+func (in *Ingester) MaxBytes() int64 {
+	return in.cfg.MaxBytes
+}
+
+// Add extracts and chunks an upload, records it in the manifest and starts
+// ingesting it. The same file uploaded again by the same owner for the same
+// QAST user is refused; re-ingest the existing document instead.
+func (in *Ingester) Add(ctx context.Context, u Upload) (Document, Job, error) {
+	if int64(len(u.Data)) > in.cfg.MaxBytes {
+		return Document{}, Job{}, services.Validation(
+			fmt.Sprintf("Files can be at most %d MiB", in.cfg.MaxBytes>>20),
+			services.FieldError{Field: "file", Code: "size"})
+	}
+	format, err := Detect(u.Name, u.Data[:min(len(u.Data), 512)])
+	if err != nil {
+		return Document{}, Job{}, err
+	}
+	sum := sha256.Sum256(u.Data)
+	digest := hex.EncodeToString(sum[:])
+	docs, err := in.store.Documents()
+	if err != nil {
+		return Document{}, Job{}, err
+	}
+	for _, d := range docs {
+		if d.Owner == u.Owner && d.UserID == u.UserID && d.SHA256 == digest {
+			return Document{}, Job{}, services.Conflict(fmt.Sprintf("This file was already uploaded as %s (%s), re-ingest it instead", d.ID, d.Name))
+		}
+	}
+
+	text, err := Extract(format, u.Data)
+	if err != nil {
+		return Document{}, Job{}, err
+	}
+	chunks := Chunk(text, in.cfg.ChunkSize, in.cfg.ChunkOverlap)
+	if len(chunks) > in.cfg.MaxChunks {
+		return Document{}, Job{}, services.Validation(
+			fmt.Sprintf("The document is too long: %d chunks, at most %d are ingested per document", len(chunks), in.cfg.MaxChunks),
+			services.FieldError{Field: "file", Code: "size"})
+	}
+
+	doc := Document{
+		ID:         newID(),
+		Name:       u.Name,
+		Format:     format,
+		Size:       int64(len(u.Data)),
+		SHA256:     digest,
+		Owner:      u.Owner,
+		UserID:     u.UserID,
+		Chars:      len([]rune(text)),
+		Chunks:     len(chunks),
+		State:      StatePending,
+		UploadedAt: time.Now().UTC(),
+	}
+	if err := in.store.SaveText(doc.ID, text); err != nil {
+		return Document{}, Job{}, err
+	}
+	if err := in.store.Put(doc); err != nil {
+		return Document{}, Job{}, err
+	}
+	job, err := in.start(ctx, doc, chunks, nil, u.Actor)
+	if err != nil {
+		return Document{}, Job{}, err
+	}
+	doc.Job = job.ID
+	return doc, job, nil
+}
+
+// Reingest sends a document's stored text to QAST again: every chunk, or
+// with failedOnly just those the latest ingestion missed.
+func (in *Ingester) Reingest(ctx context.Context, owner, id, actor string, failedOnly bool) (Job, error) {
+	doc, err := in.Document(owner, id)
+	if err != nil {
+		return Job{}, err
+	}
+	text, err := in.store.Text(id)
+	if err != nil {
+		return Job{}, err
+	}
+	chunks := Chunk(text, in.cfg.ChunkSize, in.cfg.ChunkOverlap)
+	var only []int
+	if failedOnly {
+		if len(doc.Failed) == 0 {
+			return Job{}, services.Validation("The document has no failed chunks to re-ingest")
+		}
+		if len(chunks) != doc.Chunks {
+			return Job{}, services.Validation("The chunk settings changed since the last ingestion, re-ingest the whole document")
+		}
+		only = doc.Failed
+	}
+	return in.start(ctx, doc, chunks, only, actor)
+}
+
+// Delete removes a document and its text from the manifest. What QAST built
+// from it stays in the knowledge graph, since QAST offers no way to remove it.
+func (in *Ingester) Delete(owner, id string) (Document, error) {
+	doc, err := in.Document(owner, id)
+	if err != nil {
+		return Document{}, err
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if _, ok := in.running[id]; ok {
+		return Document{}, services.Conflict("The document is still being ingested, wait for it to finish")
+	}
+	return doc, in.store.Delete(id)
+}
+
+// Documents lists the owner's documents, newest first.
+func (in *Ingester) Documents(owner string) ([]Document, error) {
+	docs, err := in.store.Documents()
+	if err != nil {
+		return nil, err
+	}
+	out := docs[:0]
+	for _, d := range docs {
+		if d.Owner == owner {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// Document returns one of the owner's documents. Other owners' documents
+// are reported as not found, so IDs cannot be probed.
+func (in *Ingester) Document(owner, id string) (Document, error) {
+	doc, err := in.store.Document(id)
+	if err != nil {
+		return Document{}, err
+	}
+	if doc.Owner != owner {
+		return Document{}, services.NotFound("Document not found")
+	}
+	return doc, nil
+}
+
+// Job returns a snapshot of one of the owner's jobs.
+func (in *Ingester) Job(owner, id string) (Job, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	job, ok := in.jobs[id]
+	if !ok || job.Owner != owner {
+		return Job{}, services.NotFound("Ingestion job not found")
+	}
+	return job.copy(), nil
+}
+
+// Jobs lists the owner's jobs, newest first, without per-chunk results.
+func (in *Ingester) Jobs(owner string) []Job {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	out := []Job{}
+	for i := len(in.order) - 1; i >= 0; i-- {
+		job := in.jobs[in.order[i]]
+		if job.Owner != owner {
+			continue
+		}
+		c := *job
+		c.Chunks = nil
+		out = append(out, c)
+	}
+	return out
+}
+
+// start queues a job for the chunks of doc, or only those at the indexes
+// in only.
+func (in *Ingester) start(ctx context.Context, doc Document, chunks []string, only []int, actor string) (Job, error) {
+	in.mu.Lock()
+	if _, ok := in.running[doc.ID]; ok {
+		in.mu.Unlock()
+		return Job{}, services.Conflict("The document is already being ingested")
+	}
+	job := &Job{
+		ID:        newID(),
+		Document:  doc.ID,
+		Name:      doc.Name,
+		Owner:     doc.Owner,
+		Actor:     actor,
+		State:     StatePending,
+		StartedAt: time.Now().UTC(),
+	}
+	selected := map[int]bool{}
+	for _, i := range only {
+		selected[i] = true
+	}
+	for i, chunk := range chunks {
+		if len(only) > 0 && !selected[i] {
+			continue
+		}
+		job.Chunks = append(job.Chunks, ChunkResult{Index: i, Chars: len([]rune(chunk)), State: StatePending})
+	}
+	job.Total = len(job.Chunks)
+	in.jobs[job.ID] = job
+	in.order = append(in.order, job.ID)
+	in.running[doc.ID] = job.ID
+	in.trim()
+	snapshot := job.copy()
+	in.mu.Unlock()
+
+	err := in.store.Update(doc.ID, func(d *Document) {
+		d.State, d.Job, d.Error, d.Chunks = StatePending, job.ID, "", len(chunks)
+	})
+	if err != nil {
+		in.update(func() {
+			delete(in.running, doc.ID)
+			job.State = StateFailed
+		})
+		return Job{}, err
+	}
+
+	// The job outlives the request that started it
+	go in.run(context.WithoutCancel(ctx), job, doc, chunks)
+	return snapshot, nil
+}
+
+func (in *Ingester) run(ctx context.Context, job *Job, doc Document, chunks []string) {
+	in.slots <- struct{}{}
+	defer func() { <-in.slots }()
+
+	log := logging.For("ingest")
+	log.InfoContext(ctx, "Ingestion started", "job", job.ID, "document", doc.ID, "chunks", job.Total)
+	in.setState(job, StateRunning)
+
+	var unavailable error
+	for i := range job.Chunks {
+		index := job.Chunks[i].Index
+		res := job.Chunks[i]
+		res.State = StateDone
+		var err error
+		if unavailable != nil {
+			// QAST is down; the rest would fail the same way
+			res.State, res.Error = StateSkipped, unavailable.Error()
+		} else {
+			in.update(func() { job.Chunks[i].State = StateRunning })
+			_, err = in.qast.IngestGraph(ctx, chunks[index], doc.UserID)
+			if errors.Is(err, services.ErrUnavailable) {
+				unavailable = err
+			}
+		}
+		if err != nil {
+			res.State, res.Error = StateFailed, err.Error()
+			log.ErrorContext(ctx, "Chunk ingestion failed", "job", job.ID, "document", doc.ID, "chunk", index, "error", err)
+		}
+		in.update(func() {
+			job.Chunks[i] = res
+			if res.State == StateDone {
+				job.Done++
+			} else {
+				job.Failed++
+			}
+		})
+	}
+
+	var failed []int
+	in.update(func() {
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+		job.State = StateDone
+		for _, res := range job.Chunks {
+			if res.State != StateDone {
+				failed = append(failed, res.Index)
+			}
+		}
+		if len(failed) > 0 {
+			job.State = StateFailed
+		}
+		delete(in.running, doc.ID)
+	})
+
+	err := in.store.Update(doc.ID, func(d *Document) {
+		d.State, d.Failed, d.Error = job.State, failed, ""
+		if len(failed) > 0 {
+			d.Error = fmt.Sprintf("%d of %d chunks failed", len(failed), job.Total)
+		}
+		if job.Done > 0 {
+			d.IngestedAt = job.FinishedAt
+		}
+	})
+	if err != nil && !errors.Is(err, services.ErrNotFound) {
+		log.ErrorContext(ctx, "Failed to record the ingestion in the manifest", "job", job.ID, "document", doc.ID, "error", err)
+	}
+
+	entry := audit.Entry{
+		Action:   "qast.ingest",
+		Actor:    job.Actor,
+		ActorID:  job.Owner,
+		Resource: "ingest:" + doc.ID,
+		Details:  map[string]interface{}{"job": job.ID, "format": doc.Format, "sha256": doc.SHA256, "chunks": job.Total, "failed": len(failed)},
+	}
+	if len(failed) > 0 {
+		entry.Outcome = audit.Failure
+	}
+	audit.Record(ctx, entry)
+	log.InfoContext(ctx, "Ingestion finished", "job", job.ID, "document", doc.ID, "state", job.State, "done", job.Done, "failed", len(failed))
+}
+
+func (in *Ingester) setState(job *Job, state string) {
+	in.update(func() { job.State = state })
+	err := in.store.Update(job.Document, func(d *Document) { d.State = state })
+	if err != nil && !errors.Is(err, services.ErrNotFound) {
+		logging.For("ingest").Error("Failed to record the ingestion in the manifest", "job", job.ID, "error", err)
+	}
+}
+
+func (in *Ingester) update(fn func()) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	fn()
+}
+
+// trim drops the oldest finished jobs beyond maxJobs. Callers hold in.mu.
+func (in *Ingester) trim() {
+	for len(in.order) > maxJobs {
+		id := in.order[0]
+		if s := in.jobs[id].State; s == StatePending || s == StateRunning {
+			return
+		}
+		delete(in.jobs, id)
+		in.order = in.order[1:]
+	}
+}
+
+func (j *Job) copy() Job {
+	c := *j
+	c.Chunks = append([]ChunkResult(nil), j.Chunks...)
+	return c
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
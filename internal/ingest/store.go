@@ -0,0 +1,199 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"wodge/internal/security"
+	"wodge/internal/services"
+)
+
+// Document and chunk states
+const (
+	StatePending = "pending"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+	StateSkipped = "skipped"
+)
+
+// Document is a manifest entry: an uploaded file and how its latest
+// ingestion went.
+type Document struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Format     string     `json:"format" ts:"'pdf' | 'docx' | 'markdown' | 'html' | 'text'"`
+	Size       int64      `json:"size"` // Bytes uploaded
+	SHA256     string     `json:"sha256"`
+	Owner      string     `json:"owner,omitempty"`   // User ID, empty without auth
+	UserID     string     `json:"user_id,omitempty"` // QAST user the graph is built for
+	Chars      int        `json:"chars"`             // Characters extracted
+	Chunks     int        `json:"chunks"`
+	State      string     `json:"state" ts:"'pending' | 'running' | 'done' | 'failed'"`
+	Job        string     `json:"job,omitempty"`           // Latest ingestion
+	Failed     []int      `json:"failed_chunks,omitempty"` // Chunk indexes the latest ingestion missed
+	Error      string     `json:"error,omitempty"`
+	UploadedAt time.Time  `json:"uploaded_at"`
+	IngestedAt *time.Time `json:"ingested_at,omitempty"`
+}
+
+// Store keeps the manifest in <dir>/manifest.json and each document's
+// extracted text in <dir>/<id>.txt, so documents can be re-ingested without
+// uploading them again. Both are encrypted when a Sealer is configured
+// (INGEST_ENCRYPTION_KEY).
+type Store struct {
+	dir    string
+	sealer *security.Sealer
+	mu     sync.Mutex
+}
+
+func NewStore(dir string, sealer *security.Sealer) *Store {
+	return &Store{dir: dir, sealer: sealer}
+}
+
+// Documents lists the documents, newest first.
+func (s *Store) Documents() ([]Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Document, 0, len(all))
+	for _, d := range all {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UploadedAt.After(out[j].UploadedAt) })
+	return out, nil
+}
+
+func (s *Store) Document(id string) (Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.load()
+	if err != nil {
+		return Document{}, err
+	}
+	d, ok := all[id]
+	if !ok {
+		return Document{}, services.NotFound("Document not found")
+	}
+	return *d, nil
+}
+
+// Put adds or replaces a document.
+func (s *Store) Put(d Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	all[d.ID] = &d
+	return s.save(all)
+}
+
+// Update applies fn to a stored document.
+func (s *Store) Update(id string, fn func(d *Document)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	d, ok := all[id]
+	if !ok {
+		return services.NotFound("Document not found")
+	}
+	fn(d)
+	return s.save(all)
+}
+
+// Delete removes a document and its text.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	delete(all, id)
+	if err := s.save(all); err != nil {
+		return err
+	}
+	if err := os.Remove(s.textPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SaveText keeps a document's extracted text.
+func (s *Store) SaveText(id, text string) error {
+	return s.write(s.textPath(id), []byte(text), []byte("ingest:"+id))
+}
+
+func (s *Store) Text(id string) (string, error) {
+	data, err := s.read(s.textPath(id), []byte("ingest:"+id))
+	if os.IsNotExist(err) {
+		return "", services.NotFound("The document's text is no longer stored, upload it again")
+	}
+	return string(data), err
+}
+
+func (s *Store) textPath(id string) string {
+	return filepath.Join(s.dir, id+".txt")
+}
+
+func (s *Store) load() (map[string]*Document, error) {
+	all := make(map[string]*Document)
+	data, err := s.read(filepath.Join(s.dir, "manifest.json"), []byte("ingest"))
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (s *Store) save(all map[string]*Document) error {
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return s.write(filepath.Join(s.dir, "manifest.json"), data, []byte("ingest"))
+}
+
+func (s *Store) read(path string, ad []byte) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if s.sealer != nil {
+		if data, err = s.sealer.Open(data, ad); err != nil {
+			return nil, errors.New("failed to decrypt the ingestion store")
+		}
+	}
+	return data, nil
+}
+
+func (s *Store) write(path string, data, ad []byte) error {
+	if s.sealer != nil {
+		data = s.sealer.Seal(data, ad)
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
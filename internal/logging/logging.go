@@ -0,0 +1,202 @@
+// Package logging configures the process-wide slog logger from the app's
+// environment: level, text or JSON output and one or more sinks.
+//
+// Every record is tagged with the request ID carried by its context, and
+// credentials in DSNs and URLs are masked before anything is written.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"wodge/internal/requestid"
+)
+
+// Config selects the log level, format and sinks.
+type Config struct {
+	Level  slog.Level
+	Format string // "text" or "json"
+	// Sinks are "stdout", "stderr", "file[:path]" or "syslog[:network://addr]"
+	Sinks []string
+	// Rotation of file sinks
+	FileMaxBytes   int64
+	FileMaxBackups int
+}
+
+// DefaultFile is where a "file" sink without a path writes.
+var DefaultFile = filepath.Join(".wodge", "logs", "wodge.log")
+
+// FromEnv reads LOG_LEVEL (debug, info, warn, error), LOG_FORMAT (text,
+// json), LOG_SINKS (comma-separated, default stderr like the standard log
+// package), LOG_FILE_MAX_MB and LOG_FILE_MAX_BACKUPS.
+func FromEnv() (Config, error) {
+	cfg := Config{Format: "text", Sinks: []string{"stderr"}, FileMaxBytes: 10 << 20, FileMaxBackups: 5}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := cfg.Level.UnmarshalText([]byte(v)); err != nil {
+			return cfg, fmt.Errorf("LOG_LEVEL: %w", err)
+		}
+	}
+	if v := os.Getenv("LOG_FORMAT"); v != "" {
+		cfg.Format = strings.ToLower(v)
+	}
+	if v := os.Getenv("LOG_SINKS"); v != "" {
+		cfg.Sinks = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				cfg.Sinks = append(cfg.Sinks, s)
+			}
+		}
+	}
+	if v := os.Getenv("LOG_FILE_MAX_MB"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("LOG_FILE_MAX_MB: invalid size %q", v)
+		}
+		cfg.FileMaxBytes = n << 20
+	}
+	if v := os.Getenv("LOG_FILE_MAX_BACKUPS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("LOG_FILE_MAX_BACKUPS: invalid count %q", v)
+		}
+		cfg.FileMaxBackups = n
+	}
+	return cfg, nil
+}
+
+// Setup installs a logger for cfg as the slog default, which also receives
+// everything still written through the standard log package. The returned
+// closer flushes and closes the sinks.
+func Setup(cfg Config) (io.Closer, error) {
+	if cfg.Format != "text" && cfg.Format != "json" {
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	if len(cfg.Sinks) == 0 {
+		cfg.Sinks = []string{"stderr"}
+	}
+
+	var handlers []slog.Handler
+	var closers closers
+	for _, sink := range cfg.Sinks {
+		w, err := openSink(sink, cfg)
+		if err != nil {
+			closers.Close()
+			return nil, fmt.Errorf("log sink %s: %w", sink, err)
+		}
+		if c, ok := w.(io.Closer); ok {
+			closers = append(closers, c)
+		}
+		opts := &slog.HandlerOptions{Level: cfg.Level, ReplaceAttr: maskAttr}
+		if cfg.Format == "json" {
+			handlers = append(handlers, slog.NewJSONHandler(w, opts))
+		} else {
+			handlers = append(handlers, slog.NewTextHandler(w, opts))
+		}
+	}
+
+	var h slog.Handler = fanout(handlers)
+	if len(handlers) == 1 {
+		h = handlers[0]
+	}
+	slog.SetDefault(slog.New(&contextHandler{h}))
+	return closers, nil
+}
+
+// For returns the default logger tagged with a component name, e.g. a driver.
+func For(component string) *slog.Logger {
+	return slog.Default().With("component", component)
+}
+
+func openSink(sink string, cfg Config) (io.Writer, error) {
+	kind, arg, _ := strings.Cut(sink, ":")
+	switch kind {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	case "file":
+		if arg == "" {
+			arg = DefaultFile
+		}
+		return openRotatingFile(arg, cfg.FileMaxBytes, cfg.FileMaxBackups)
+	case "syslog":
+		return openSyslog(arg)
+	}
+	return nil, errors.New("unknown sink")
+}
+
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var errs []error
+	for _, c := range cs {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// contextHandler adds the request ID from the record's context and masks
+// secrets in the message.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.Message = MaskSecrets(r.Message)
+	if id := requestid.FromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
+
+// fanout writes every record to all sinks.
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanout, len(f))
+	for i, h := range f {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	out := make(fanout, len(f))
+	for i, h := range f {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
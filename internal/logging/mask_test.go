@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestMaskSecrets(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"postgres://wodge:hunter2@db:5432/app?sslmode=disable", "postgres://wodge:****@db:5432/app?sslmode=disable"},
+		{"redis://:hunter2@cache:6379/0", "redis://:****@cache:6379/0"},
+		{"host=db user=wodge password=hunter2 dbname=app", "host=db user=wodge password=**** dbname=app"},
+		{"host=db password='two words' dbname=app", "host=db password=**** dbname=app"},
+		{"https://idp/token?client_secret=abc&grant_type=x", "https://idp/token?client_secret=****&grant_type=x"},
+		{"PASSWORD=hunter2", "PASSWORD=****"},
+		{"postgres://wodge@db/app", "postgres://wodge@db/app"},
+		{"dial tcp 10.0.0.1:5432: connection refused", "dial tcp 10.0.0.1:5432: connection refused"},
+	}
+	for _, tt := range tests {
+		if got := MaskSecrets(tt.in); got != tt.want {
+			t.Errorf("MaskSecrets(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Strings, errors and Stringers logged as attributes are masked.
+func TestHandlerMasksAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{ReplaceAttr: maskAttr}))
+	log.Info("connecting",
+		"dsn", "postgres://wodge:hunter2@db/app",
+		"error", errors.New("dial amqp://guest:s3cret@mq: refused"),
+		"group", slog.GroupValue(slog.String("url", "redis://:pw1@cache")))
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "s3cret", "pw1"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log line leaks %q: %s", secret, out)
+		}
+	}
+}
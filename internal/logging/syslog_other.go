@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"errors"
+	"io"
+)
+
+func openSyslog(addr string) (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,19 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"io"
+	"log/syslog"
+	"strings"
+)
+
+// openSyslog connects to the local syslog daemon, or to addr given as
+// "udp://host:514" or "tcp://host:514".
+func openSyslog(addr string) (io.Writer, error) {
+	network, raddr := "", ""
+	if addr != "" {
+		network, raddr, _ = strings.Cut(addr, "://")
+	}
+	return syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, "wodge")
+}
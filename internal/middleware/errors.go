@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"wodge/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Errors renders the last error attached with c.Error as an RFC 7807
+// application/problem+json body. Handlers report failures with
+// `c.Error(err); return` instead of writing their own error bodies.
+// Middleware reading the final status must be registered before Errors;
+// registering it again further in is harmless, the innermost one renders.
+func Errors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		err := c.Errors.Last().Err
+		status, detail := classify(err)
+		requestID := GetRequestID(c)
+		if status >= 500 || errors.Is(err, services.ErrUpstream) {
+			slog.ErrorContext(c.Request.Context(), "Request failed", "method", c.Request.Method, "path", c.Request.URL.Path, "status", status, "error", err)
+		}
+
+		problem := gin.H{}
+		var typed *services.Error
+		if errors.As(err, &typed) {
+			for k, v := range typed.Details {
+				problem[k] = v
+			}
+			if len(typed.Fields) > 0 {
+				problem["errors"] = typed.Fields
+			}
+		}
+		problem["type"] = "about:blank"
+		problem["title"] = http.StatusText(status)
+		problem["status"] = status
+		problem["detail"] = detail
+		problem["instance"] = c.Request.URL.Path
+		if requestID != "" {
+			problem["request_id"] = requestID
+		}
+
+		c.Header("Content-Type", "application/problem+json")
+		c.JSON(status, problem)
+	}
+}
+
+// classify maps an error onto a status code and a client-safe detail.
+// Untyped errors are treated as internal and never echoed to the client.
+func classify(err error) (int, string) {
+	var typed *services.Error
+	detail := "Internal server error"
+	if errors.As(err, &typed) {
+		detail = typed.Message
+	}
+
+	switch {
+	case errors.Is(err, services.ErrValidation):
+		if typed != nil && len(typed.Fields) > 0 {
+			return http.StatusUnprocessableEntity, detail
+		}
+		return http.StatusBadRequest, detail
+	case errors.Is(err, services.ErrInvalidCredentials):
+		return http.StatusUnauthorized, "Invalid username or password"
+	case errors.Is(err, services.ErrUnauthorized):
+		return http.StatusUnauthorized, detail
+	case errors.Is(err, services.ErrForbidden):
+		return http.StatusForbidden, detail
+	case errors.Is(err, services.ErrNotFound):
+		return http.StatusNotFound, detail
+	case errors.Is(err, services.ErrConflict):
+		return http.StatusConflict, detail
+	case errors.Is(err, services.ErrRateLimited):
+		return http.StatusTooManyRequests, detail
+	case errors.Is(err, services.ErrNotSupported):
+		return http.StatusNotImplemented, detail
+	case errors.Is(err, services.ErrUnavailable):
+		return http.StatusServiceUnavailable, detail
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "Upstream request timed out"
+	case errors.Is(err, services.ErrUpstream):
+		return http.StatusBadGateway, detail
+	}
+	if typed != nil && typed.Kind != nil {
+		return http.StatusInternalServerError, detail
+	}
+	return http.StatusInternalServerError, "Internal server error"
+}
+
+var useJSONNames sync.Once
+
+// BindJSON decodes and validates the request body against its `binding` tags.
+// On failure the validation error is recorded on the context and false is returned.
+func BindJSON(c *gin.Context, obj interface{}) bool {
+	useJSONNames.Do(func() {
+		// Report fields by their JSON names, not the Go struct field names
+		if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+			v.RegisterTagNameFunc(func(f reflect.StructField) string {
+				name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+				if name == "-" {
+					return ""
+				}
+				if name == "" {
+					return f.Name
+				}
+				return name
+			})
+		}
+	})
+
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		c.Error(services.Validation("Malformed JSON body"))
+		return false
+	}
+	fields := make([]services.FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		fields = append(fields, services.FieldError{
+			Field:   fe.Field(),
+			Code:    fe.Tag(),
+			Message: fieldMessage(fe),
+		})
+	}
+	c.Error(services.Validation(fields[0].Message, fields...))
+	return false
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", fe.Field())
+	case "email":
+		return fmt.Sprintf("%s must be a valid email address", fe.Field())
+	case "min":
+		return fmt.Sprintf("%s must be at least %s characters", fe.Field(), fe.Param())
+	case "max":
+		return fmt.Sprintf("%s must be at most %s characters", fe.Field(), fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", fe.Field(), strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "gte":
+		return fmt.Sprintf("%s must be %s or greater", fe.Field(), fe.Param())
+	}
+	return fmt.Sprintf("%s is invalid", fe.Field())
+}
@@ -2,28 +2,36 @@ package middleware
 
 import (
 	"bytes"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"strings"
 	"time"
 	"wodge/internal/monitor"
+	"wodge/internal/redact"
 
 	"github.com/gin-gonic/gin"
 )
 
 type LogEntry struct {
 	Timestamp  string      `json:"timestamp"`
+	RequestID  string      `json:"request_id,omitempty"`
 	Method     string      `json:"method"`
 	Path       string      `json:"path"`
+	Route      string      `json:"route,omitempty"` // Matched route pattern, e.g. /api/context/:id
 	Status     int         `json:"status"`
 	DurationMs int64       `json:"duration_ms"`
 	IP         string      `json:"ip"`
+	UserID     string      `json:"user_id,omitempty"`
+	TokenID    string      `json:"token_id,omitempty"` // Fingerprint of the access token
 	Body       interface{} `json:"body,omitempty"`
 	Response   interface{} `json:"response,omitempty"`
 }
 
 // RequestLogger returns a middleware that logs detailed request/response info.
-// It also taps into the monitor bus.
-func RequestLogger() gin.HandlerFunc {
+// It also taps into the monitor bus. Bodies pass through r before they are
+// published; bodies larger than its cap are only recorded by size.
+func RequestLogger(r *redact.Redactor) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Skip logging for the monitor stream itself to prevent loops
 		if c.Request.URL.Path == "/wodge/monitor/events" {
@@ -32,24 +40,22 @@ func RequestLogger() gin.HandlerFunc {
 		}
 
 		start := time.Now()
+		route := c.Request.Method + " " + c.Request.URL.Path
 
 		// Capture Request Body
-		var requestBody interface{}
-		if c.Request.Method == "POST" || c.Request.Method == "PUT" || c.Request.Method == "PATCH" {
+		var requestBody []byte
+		// File uploads are neither buffered nor logged, only JSON bodies are
+		upload := strings.HasPrefix(c.ContentType(), "multipart/")
+		if !upload && (c.Request.Method == "POST" || c.Request.Method == "PUT" || c.Request.Method == "PATCH") {
 			// Read body
-			bodyBytes, _ := io.ReadAll(c.Request.Body)
+			requestBody, _ = io.ReadAll(c.Request.Body)
 			// Restore it for next handlers
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
-			// Try to parse JSON
-			if len(bodyBytes) > 0 {
-				_ = json.Unmarshal(bodyBytes, &requestBody)
-			}
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 		}
 
 		// Capture Response Body
 		// We need a custom writer to peek at the body
-		w := &responseBodyWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
+		w := &responseBodyWriter{body: &bytes.Buffer{}, limit: r.MaxBodyBytes(), ResponseWriter: c.Writer}
 		c.Writer = w
 
 		// Process request
@@ -57,38 +63,52 @@ func RequestLogger() gin.HandlerFunc {
 
 		duration := time.Since(start).Milliseconds()
 
-		// Try to parse response body if JSON
-		var responseBody interface{}
-		_ = json.Unmarshal(w.body.Bytes(), &responseBody)
-
 		entry := LogEntry{
 			Timestamp:  start.Format(time.RFC3339),
+			RequestID:  GetRequestID(c),
 			Method:     c.Request.Method,
 			Path:       c.Request.URL.Path,
+			Route:      c.FullPath(),
 			Status:     c.Writer.Status(),
 			DurationMs: duration,
 			IP:         c.ClientIP(),
-			Body:       requestBody,
-			Response:   responseBody,
+			TokenID:    tokenID(AccessToken(c)),
+			Body:       r.Body(route, requestBody, len(requestBody)),
+			Response:   r.Body(route, w.body.Bytes(), w.size),
+		}
+		if user, ok := CurrentUser(c); ok {
+			entry.UserID = user.ID
 		}
 
-		// 1. Emit to Monitor Bus (Visualization)
-		monitor.Bus.Publish(monitor.TypeRequest, entry)
+		// Emit to Monitor Bus (Visualization). The process log gets its
+		// line from AccessLog.
+		monitor.Bus.PublishContext(c.Request.Context(), monitor.TypeRequest, entry)
+	}
+}
 
-		// 2. Standard Stdout Log (for container/system logs)
-		// We could use slog or zap here, but fmt is fine for now
-		// logJSON, _ := json.Marshal(entry)
-		// fmt.Println(string(logJSON))
+// tokenID fingerprints a token so requests made with it can be correlated
+// without logging the token itself.
+func tokenID(token string) string {
+	if token == "" {
+		return ""
 	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
 }
 
 // responseBodyWriter is a wrapper to capture the response body
+// up to limit bytes (0 for no limit) while counting all of it
 type responseBodyWriter struct {
 	gin.ResponseWriter
-	body *bytes.Buffer
+	body  *bytes.Buffer
+	limit int
+	size  int
 }
 
-func (w responseBodyWriter) Write(b []byte) (int, error) {
-	w.body.Write(b)
+func (w *responseBodyWriter) Write(b []byte) (int, error) {
+	w.size += len(b)
+	if w.limit <= 0 || w.body.Len()+len(b) <= w.limit {
+		w.body.Write(b)
+	}
 	return w.ResponseWriter.Write(b)
 }
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+	"wodge/internal/logging"
+	"wodge/internal/requestid"
+
+	"github.com/gin-gonic/gin"
+)
+
+const requestIDKey = "wodge.request_id"
+
+// RequestID accepts the caller's X-Request-ID or generates one, echoes it on
+// the response and stores it in the request context, so drivers forward it
+// to upstream services.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		c.Set(requestIDKey, id)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+		c.Header(requestid.Header, id)
+		c.Next()
+	}
+}
+
+// GetRequestID returns the ID assigned by RequestID, or "" outside of it.
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// AccessLog logs one line per request through slog, tagged with the
+// request ID. Server errors are logged at error level.
+func AccessLog() gin.HandlerFunc {
+	logger := logging.For("http")
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("ip", c.ClientIP()),
+		}
+		if msg := c.Errors.ByType(gin.ErrorTypePrivate).String(); msg != "" {
+			attrs = append(attrs, slog.String("error", msg))
+		}
+		logger.LogAttrs(c.Request.Context(), level, "Request", attrs...)
+	}
+}
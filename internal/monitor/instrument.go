@@ -0,0 +1,266 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"wodge/internal/services"
+)
+
+// DriverEvent is the payload of POSTGRES, REDIS and RABBITMQ events.
+type DriverEvent struct {
+	Operation  string  `json:"operation"`           // QUERY, EXEC, GET, SET, DEL, PUBLISH, CONSUME
+	Statement  string  `json:"statement,omitempty"` // SQL with literals redacted
+	Target     string  `json:"target,omitempty"`    // Cache key namespace (e.g. "pii:*") or queue topic
+	Args       int     `json:"args,omitempty"`      // Number of bound parameters, values are never published
+	DurationMs float64 `json:"duration_ms"`
+	Rows       int64   `json:"rows,omitempty"`
+	Bytes      int     `json:"bytes,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func publishDriver(ctx context.Context, t EventType, ev DriverEvent, start time.Time, err error) {
+	ev.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		ev.Error = driverError(err)
+	}
+	Bus.PublishContext(ctx, t, ev)
+}
+
+// driverError describes err for an event. Postgres errors are reduced to
+// their SQLSTATE, since their message and detail quote the offending values.
+func driverError(err error) string {
+	var sqlErr interface{ SQLState() string }
+	if errors.As(err, &sqlErr) {
+		code := sqlErr.SQLState()
+		if len(code) == 5 {
+			return "SQLSTATE " + code + " (class " + code[:2] + ")"
+		}
+		return "SQLSTATE " + code
+	}
+	return err.Error()
+}
+
+// InstrumentDatabase wraps db so every query publishes a POSTGRES event.
+func InstrumentDatabase(db services.DatabaseService) services.DatabaseService {
+	return &instrumentedDatabase{next: db}
+}
+
+type instrumentedDatabase struct {
+	next services.DatabaseService
+}
+
+func (d *instrumentedDatabase) Query(ctx context.Context, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	start := time.Now()
+	rows, err := d.next.Query(ctx, query, args...)
+	publishDriver(ctx, TypePostgres, DriverEvent{
+		Operation: "QUERY",
+		Statement: RedactSQL(query),
+		Args:      len(args),
+		Rows:      int64(len(rows)),
+	}, start, err)
+	return rows, err
+}
+
+func (d *instrumentedDatabase) Execute(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	start := time.Now()
+	n, err := d.next.Execute(ctx, query, args...)
+	publishDriver(ctx, TypePostgres, DriverEvent{
+		Operation: "EXEC",
+		Statement: RedactSQL(query),
+		Args:      len(args),
+		Rows:      n,
+	}, start, err)
+	return n, err
+}
+
+// InstrumentCache wraps cache so every command publishes a REDIS event.
+// Values are never published, only their size, and keys only by their
+// namespace, since the rest names users and sessions (pii:<owner>:<session>).
+// Reads of the chat buffer are left out: followers poll it twice a second
+// and running chats once a second, which would flood the monitor.
+func InstrumentCache(cache services.CacheService) services.CacheService {
+	return &instrumentedCache{next: cache}
+}
+
+type instrumentedCache struct {
+	next services.CacheService
+}
+
+// Namespaces whose reads are polls, published only when they fail
+var polledKeyPrefixes = []string{"stream:"}
+
+// keyPattern reduces a key or pattern to its namespace, e.g. "pii:*".
+func keyPattern(key string) string {
+	if ns, _, ok := strings.Cut(key, ":"); ok {
+		return ns + ":*"
+	}
+	return "*"
+}
+
+func polled(key string, err error) bool {
+	if err != nil && !errors.Is(err, services.ErrNotFound) {
+		return false
+	}
+	for _, p := range polledKeyPrefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *instrumentedCache) Get(ctx context.Context, key string) (string, error) {
+	start := time.Now()
+	val, err := r.next.Get(ctx, key)
+	if !polled(key, err) {
+		publishDriver(ctx, TypeRedis, DriverEvent{Operation: "GET", Target: keyPattern(key), Bytes: len(val)}, start, err)
+	}
+	return val, err
+}
+
+func (r *instrumentedCache) Set(ctx context.Context, key string, value string, ttlSeconds int) error {
+	start := time.Now()
+	err := r.next.Set(ctx, key, value, ttlSeconds)
+	publishDriver(ctx, TypeRedis, DriverEvent{Operation: "SET", Target: keyPattern(key), Bytes: len(value)}, start, err)
+	return err
+}
+
+func (r *instrumentedCache) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := r.next.Delete(ctx, key)
+	publishDriver(ctx, TypeRedis, DriverEvent{Operation: "DEL", Target: keyPattern(key)}, start, err)
+	return err
+}
+
+func (r *instrumentedCache) Keys(ctx context.Context, pattern string) ([]string, error) {
+	start := time.Now()
+	keys, err := r.next.Keys(ctx, pattern)
+	publishDriver(ctx, TypeRedis, DriverEvent{Operation: "SCAN", Target: keyPattern(pattern)}, start, err)
+	return keys, err
+}
+
+// ContextSubscriber is implemented by queues that can recover the publishing
+// request's context (e.g. its request ID) from each delivered message.
+type ContextSubscriber interface {
+	SubscribeContext(ctx context.Context, topic string, handler func(ctx context.Context, message []byte) error) error
+}
+
+// InstrumentQueue wraps queue so every publish and consumed message
+// publishes a RABBITMQ event.
+func InstrumentQueue(queue services.QueueService) services.QueueService {
+	return &instrumentedQueue{next: queue}
+}
+
+type instrumentedQueue struct {
+	next services.QueueService
+}
+
+func (q *instrumentedQueue) Publish(ctx context.Context, topic string, message []byte) error {
+	start := time.Now()
+	err := q.next.Publish(ctx, topic, message)
+	publishDriver(ctx, TypeRabbitMQ, DriverEvent{Operation: "PUBLISH", Target: topic, Bytes: len(message)}, start, err)
+	return err
+}
+
+func (q *instrumentedQueue) Subscribe(ctx context.Context, topic string, handler func(message []byte) error) error {
+	consume := func(msgCtx context.Context, message []byte) error {
+		start := time.Now()
+		err := handler(message)
+		publishDriver(msgCtx, TypeRabbitMQ, DriverEvent{Operation: "CONSUME", Target: topic, Bytes: len(message)}, start, err)
+		return err
+	}
+	if cs, ok := q.next.(ContextSubscriber); ok {
+		return cs.SubscribeContext(ctx, topic, consume)
+	}
+	return q.next.Subscribe(ctx, topic, func(message []byte) error {
+		return consume(context.Background(), message)
+	})
+}
+
+// RedactSQL replaces string, dollar-quoted and numeric literals with "?" so
+// statements can be published without the data they carry. Bind parameters
+// ($1, $2, ...) are kept as they are.
+func RedactSQL(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'':
+			// String literal, '' is an escaped quote
+			i++
+			for i < len(query) {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+			b.WriteByte('?')
+		case c == '$' && dollarTag(query[i:]) != "":
+			// Dollar-quoted literal: $$...$$ or $tag$...$tag$
+			tag := dollarTag(query[i:])
+			closing := strings.Index(query[i+len(tag):], tag)
+			if closing < 0 {
+				i = len(query)
+			} else {
+				i += len(tag) + closing + len(tag)
+			}
+			b.WriteByte('?')
+		case c == '$' || isIdentStart(c):
+			// Identifiers and bind parameters may contain digits, copy them whole
+			j := i + 1
+			for j < len(query) && (isIdentStart(query[j]) || isDigit(query[j]) || query[j] == '$') {
+				j++
+			}
+			b.WriteString(query[i:j])
+			i = j
+		case isDigit(c) || c == '.' && i+1 < len(query) && isDigit(query[i+1]):
+			for i < len(query) && (isDigit(query[i]) || query[i] == '.' || query[i] == 'e' || query[i] == 'E') {
+				i++
+			}
+			b.WriteByte('?')
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			// Collapse whitespace so multi-line statements fit on one row
+			for i < len(query) && (query[i] == ' ' || query[i] == '\t' || query[i] == '\n' || query[i] == '\r') {
+				i++
+			}
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	out := strings.TrimSpace(b.String())
+	if len(out) > 500 {
+		out = out[:500] + "..."
+	}
+	return out
+}
+
+// dollarTag returns the opening "$tag$" at the start of s, or "".
+func dollarTag(s string) string {
+	for j := 1; j < len(s); j++ {
+		if s[j] == '$' {
+			return s[:j+1]
+		}
+		if !isIdentStart(s[j]) && !(j > 1 && isDigit(s[j])) {
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,109 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"wodge/internal/services"
+
+	"github.com/lib/pq"
+)
+
+type failingDB struct{ err error }
+
+func (d failingDB) Query(context.Context, string, ...interface{}) ([]map[string]interface{}, error) {
+	return nil, d.err
+}
+
+func (d failingDB) Execute(context.Context, string, ...interface{}) (int64, error) {
+	return 0, d.err
+}
+
+func TestDriverEventsDoNotQuoteValues(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"unique violation", &services.Error{Kind: services.ErrConflict, Message: "Record already exists", Err: &pq.Error{
+			Code: "23505", Message: `duplicate key value violates unique constraint "users_email_key"`, Detail: "Key (email)=(alice@example.com) already exists.",
+		}}, "SQLSTATE 23505 (class 23)"},
+		{"bad input", &pq.Error{Code: "22P02", Message: `invalid input syntax for type integer: "alice@example.com"`}, "SQLSTATE 22P02 (class 22)"},
+		{"connection", errors.New("dial tcp 127.0.0.1:5432: connect: connection refused"), "dial tcp 127.0.0.1:5432: connect: connection refused"},
+	}
+	events := Bus.Subscribe()
+	defer Bus.Unsubscribe(events)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := InstrumentDatabase(failingDB{tt.err})
+			_, _ = db.Execute(context.Background(), "INSERT INTO users (email) VALUES ($1)", "alice@example.com")
+			ev := (<-events).Payload.(DriverEvent)
+			if ev.Error != tt.want {
+				t.Errorf("event error = %q, want %q", ev.Error, tt.want)
+			}
+			if strings.Contains(ev.Error, "alice") {
+				t.Errorf("event error quotes a value: %q", ev.Error)
+			}
+		})
+	}
+}
+
+// memCache is a CacheService over a map; Get misses with ErrNotFound.
+type memCache map[string]string
+
+func (m memCache) Get(_ context.Context, key string) (string, error) {
+	v, ok := m[key]
+	if !ok {
+		return "", services.NotFound("Key not found")
+	}
+	return v, nil
+}
+
+func (m memCache) Set(_ context.Context, key, value string, _ int) error {
+	m[key] = value
+	return nil
+}
+
+func (m memCache) Delete(_ context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func (m memCache) Keys(context.Context, string) ([]string, error) { return nil, nil }
+
+func TestCacheEventsShowOnlyNamespaces(t *testing.T) {
+	cache := InstrumentCache(memCache{"pii:u1:s1": "sealed", "stream:m1": "{}"})
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		op   func()
+		want string // Target published, "" for no event
+	}{
+		{"PII map", func() { _, _ = cache.Get(ctx, "pii:u1:s1") }, "pii:*"},
+		{"PII scan", func() { _, _ = cache.Keys(ctx, "pii:u1:*") }, "pii:*"},
+		{"key without a namespace", func() { _ = cache.Set(ctx, "alice@example.com", "v", 0) }, "*"},
+		{"chat buffer write", func() { _ = cache.Set(ctx, "stream:m1:3", "[]", 60) }, "stream:*"},
+		{"chat buffer poll", func() { _, _ = cache.Get(ctx, "stream:m1") }, ""},
+		{"chat buffer poll miss", func() { _, _ = cache.Get(ctx, "stream:m1:9") }, ""},
+	}
+	events := Bus.Subscribe()
+	defer Bus.Unsubscribe(events)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.op()
+			var got string
+			select {
+			case ev := <-events:
+				got = ev.Payload.(DriverEvent).Target
+				if got == "" {
+					t.Fatal("event without a target")
+				}
+			default:
+			}
+			if got != tt.want {
+				t.Errorf("target = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
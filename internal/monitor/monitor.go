@@ -1,9 +1,19 @@
 package monitor
 
 import (
+	"context"
+	"encoding/json"
 	"io"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"wodge/internal/logging"
+	"wodge/internal/requestid"
+	"wodge/internal/services"
+	"wodge/internal/sse"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,32 +26,157 @@ const (
 	TypePostgres EventType = "POSTGRES"
 	TypeRedis    EventType = "REDIS"
 	TypeRabbitMQ EventType = "RABBITMQ"
+	TypeSecurity EventType = "SECURITY"
+	TypeIncident EventType = "INCIDENT"
+	TypeChat     EventType = "CHAT"
+	// Stored in place of events the store queue had no room for
+	TypeGap EventType = "GAP"
 )
 
 // Event represents a monitoring event
 type Event struct {
+	ID        uint64      `json:"id"` // Increasing, used as the SSE event ID for resume
 	Timestamp time.Time   `json:"timestamp"`
 	Type      EventType   `json:"type"`
+	RequestID string      `json:"request_id,omitempty"`
 	Payload   interface{} `json:"payload"`
 }
 
 type Broadcaster struct {
-	clients map[chan Event]bool
+	clients map[chan Event]*subscriber
 	mu      sync.Mutex
+	lastID  uint64
+	store   Store
+	writes  chan Event
+	dropped atomic.Uint64 // Events the store queue had no room for
+	gap     *Gap          // Dropped since the last event the queue took
+	filter  func(payload interface{}) interface{}
+}
+
+// Gap is the payload of a GAP event: the IDs of events that were published
+// but never stored. The event takes the ID of the last one.
+type Gap struct {
+	FirstID uint64 `json:"first_id"`
+	LastID  uint64 `json:"last_id"`
+	Dropped uint64 `json:"dropped"`
 }
 
 var Bus = &Broadcaster{
-	clients: make(map[chan Event]bool),
+	clients: make(map[chan Event]*subscriber),
+}
+
+// subscriber counts the events a subscription had no room for
+type subscriber struct {
+	dropped uint64
+}
+
+// SetFilter runs every payload through fn before it reaches any client or
+// the store, e.g. to redact secrets.
+func (b *Broadcaster) SetFilter(fn func(payload interface{}) interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.filter = fn
+}
+
+// UseStore persists every published event to store from now on and prunes
+// events older than retention every hour (0 keeps them forever). Event IDs
+// continue from the last one stored.
+func (b *Broadcaster) UseStore(store Store, retention time.Duration) error {
+	last, err := store.LastID()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	if last > b.lastID {
+		b.lastID = last
+	}
+	b.store = store
+	b.writes = make(chan Event, 1024)
+	b.mu.Unlock()
+
+	go b.persist(store, b.writes)
+	if retention > 0 {
+		logger := logging.For("monitor")
+		go func() {
+			for ; ; time.Sleep(time.Hour) {
+				if n, err := store.Prune(time.Now().Add(-retention)); err != nil {
+					logger.Error("Pruning event store failed", "error", err)
+				} else if n > 0 {
+					logger.Info("Pruned events", "count", n, "retention", retention)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// persist writes queued events to the store in batches, off the request path.
+func (b *Broadcaster) persist(store Store, writes chan Event) {
+	for e := range writes {
+		batch := []Event{e}
+	drain:
+		for len(batch) < 100 {
+			select {
+			case e := <-writes:
+				batch = append(batch, e)
+			default:
+				break drain
+			}
+		}
+		if err := store.Append(batch); err != nil {
+			logging.For("monitor").Error("Storing events failed", "count", len(batch), "error", err)
+		}
+		if n := b.dropped.Swap(0); n > 0 {
+			logging.For("monitor").Warn("Event store fell behind, events were not stored", "dropped", n)
+		}
+	}
+}
+
+// Store returns the store set by UseStore, nil if events aren't persisted.
+func (b *Broadcaster) Store() Store {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.store
+}
+
+// History returns stored events matching f.
+func (b *Broadcaster) History(f Filter) ([]Event, error) {
+	b.mu.Lock()
+	store := b.store
+	b.mu.Unlock()
+	if store == nil {
+		return nil, services.Unavailable("Monitor event store is disabled")
+	}
+	return store.Query(f)
 }
 
 func (b *Broadcaster) Subscribe() chan Event {
+	return b.SubscribeSize(100)
+}
+
+// SubscribeSize subscribes with room for size events. Publishers never wait
+// for a subscriber; events that don't fit are dropped and counted.
+func (b *Broadcaster) SubscribeSize(size int) chan Event {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	ch := make(chan Event, 100) // buffer to prevent blocking
-	b.clients[ch] = true
+	ch := make(chan Event, size)
+	b.clients[ch] = &subscriber{}
 	return ch
 }
 
+// Dropped returns how many events ch missed since the last call.
+func (b *Broadcaster) Dropped(ch chan Event) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub, ok := b.clients[ch]
+	if !ok {
+		return 0
+	}
+	n := sub.dropped
+	sub.dropped = 0
+	return n
+}
+
 func (b *Broadcaster) Unsubscribe(ch chan Event) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -52,43 +187,153 @@ func (b *Broadcaster) Unsubscribe(ch chan Event) {
 }
 
 func (b *Broadcaster) Publish(eventType EventType, payload interface{}) {
+	b.PublishContext(context.Background(), eventType, payload)
+}
+
+// PublishContext publishes an event tagged with the request ID carried by ctx.
+func (b *Broadcaster) PublishContext(ctx context.Context, eventType EventType, payload interface{}) {
+	// Filters may be slow (redaction), so they don't hold up other publishers
+	b.mu.Lock()
+	filter := b.filter
+	b.mu.Unlock()
+	if filter != nil {
+		payload = filter(payload)
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	b.lastID++
 	event := Event{
+		ID:        b.lastID,
 		Timestamp: time.Now(),
 		Type:      eventType,
+		RequestID: requestid.FromContext(ctx),
 		Payload:   payload,
 	}
-	for ch := range b.clients {
+	for ch, sub := range b.clients {
 		select {
 		case ch <- event:
 		default:
-			// Drop event if client is too slow
+			// Drop event if client is too slow, it can resume from the store
+			sub.dropped++
 		}
 	}
+	if b.writes != nil {
+		b.queue(event)
+	}
 }
 
-// Handler strictly for the Monitor CLI
+// queue hands event to the store writer. It never blocks publishers, which
+// hold b.mu and sit on the request path: events that don't fit are counted
+// into a gap, which is stored as a GAP event ahead of the next event that
+// fits, so the history shows where it is incomplete.
+func (b *Broadcaster) queue(event Event) {
+	if b.gap != nil {
+		gap := Event{ID: b.gap.LastID, Timestamp: event.Timestamp, Type: TypeGap, Payload: *b.gap}
+		select {
+		case b.writes <- gap:
+			b.gap = nil
+		default:
+		}
+	}
+	if b.gap == nil {
+		select {
+		case b.writes <- event:
+			return
+		default:
+		}
+	}
+	b.dropped.Add(1)
+	if b.gap == nil {
+		b.gap = &Gap{FirstID: event.ID}
+	}
+	b.gap.LastID = event.ID
+	b.gap.Dropped++
+}
+
+// Handler serves GET /wodge/monitor/events?since=&type=&path=&request_id=&limit=
+// for the Monitor CLI. It does no access control of its own; the server
+// mounts it behind the operator guard. With Accept: application/json it returns the stored
+// history; otherwise it streams events over SSE, first replaying what was
+// stored after Last-Event-ID (or since) and then following live events.
 func Handler(c *gin.Context) {
+	filter, err := FilterFromQuery(c.Query)
+	if err != nil {
+		c.Error(services.Validation(err.Error()))
+		return
+	}
+
+	if strings.Contains(c.GetHeader("Accept"), "application/json") {
+		events, err := Bus.History(filter)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"events": events})
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	if lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			c.Error(services.Validation("Last-Event-ID must be an event ID"))
+			return
+		}
+		filter.AfterID = id
+	}
+
+	// Subscribe before reading the backlog so nothing falls in between
+	clientChan := Bus.Subscribe()
+	defer Bus.Unsubscribe(clientChan)
+
+	var backlog []Event
+	if filter.AfterID > 0 || !filter.Since.IsZero() {
+		backlog, _ = Bus.History(filter)
+	}
+
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
 	c.Writer.Header().Set("Cache-Control", "no-cache")
 	c.Writer.Header().Set("Connection", "keep-alive")
 	c.Writer.Header().Set("Transfer-Encoding", "chunked")
-	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 
-	clientChan := Bus.Subscribe()
-	defer Bus.Unsubscribe(clientChan)
+	sent := filter.AfterID
+	for _, event := range backlog {
+		writeSSE(c.Writer, event)
+		sent = event.ID
+	}
+	c.Writer.Flush()
 
+	// Live events only need the type/path filters from here on
+	filter.Since = time.Time{}
 	c.Stream(func(w io.Writer) bool {
-		event, ok := <-clientChan
-		if !ok {
+		select {
+		case event, ok := <-clientChan:
+			if !ok {
+				return false
+			}
+			if event.ID > sent && filter.Match(event) {
+				writeSSE(w, event)
+			}
+			return true
+		case <-c.Request.Context().Done():
 			return false
 		}
-		c.SSEvent("message", event)
-		return true
 	})
 }
 
+// writeSSE writes an event with its ID so clients can resume with Last-Event-ID
+func writeSSE(w io.Writer, event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	sse.Event{ID: strconv.FormatUint(event.ID, 10), Event: "message", Data: string(data)}.WriteTo(w)
+}
+
 // Middleware to capture HTTP requests
 func Middleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -101,7 +346,7 @@ func Middleware() gin.HandlerFunc {
 		c.Next()
 		duration := time.Since(start)
 
-		Bus.Publish(TypeRequest, map[string]interface{}{
+		Bus.PublishContext(c.Request.Context(), TypeRequest, map[string]interface{}{
 			"method":   c.Request.Method,
 			"path":     c.Request.URL.Path,
 			"status":   c.Writer.Status(),
@@ -0,0 +1,61 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+// A full store queue drops events instead of blocking the publisher.
+func TestPublishDoesNotBlockOnAFullStoreQueue(t *testing.T) {
+	b := &Broadcaster{clients: make(map[chan Event]*subscriber), writes: make(chan Event, 1)}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			b.Publish(TypeRequest, i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on the store queue")
+	}
+	if n := b.dropped.Load(); n != 2 {
+		t.Errorf("dropped = %d, want 2", n)
+	}
+}
+
+// Events the store queue drops are stored as a gap ahead of the next event
+// that fits.
+func TestStoreQueueRecordsGaps(t *testing.T) {
+	b := &Broadcaster{clients: make(map[chan Event]*subscriber), writes: make(chan Event, 2)}
+	for i := 0; i < 4; i++ {
+		b.Publish(TypeRequest, i)
+	}
+	<-b.writes
+	<-b.writes
+	b.Publish(TypeRequest, 4)
+
+	gap, next := <-b.writes, <-b.writes
+	if want := (Gap{FirstID: 3, LastID: 4, Dropped: 2}); gap.Type != TypeGap || gap.ID != 4 || gap.Payload != want {
+		t.Errorf("first stored after the drops = %+v, want a gap %+v", gap, want)
+	}
+	if next.ID != 5 || next.Type != TypeRequest {
+		t.Errorf("stored after the gap = %+v, want event 5", next)
+	}
+}
+
+func TestSubscriberDropsAreCounted(t *testing.T) {
+	b := &Broadcaster{clients: make(map[chan Event]*subscriber)}
+	ch := b.SubscribeSize(2)
+	for i := 0; i < 5; i++ {
+		b.Publish(TypeRequest, i)
+	}
+	if n := b.Dropped(ch); n != 3 {
+		t.Errorf("Dropped() = %d, want 3", n)
+	}
+	if n := b.Dropped(ch); n != 0 {
+		t.Errorf("Dropped() after reading = %d, want 0", n)
+	}
+}
@@ -0,0 +1,493 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wodge/internal/drivers/postgres"
+	"wodge/internal/services"
+)
+
+// Store persists monitor events so they can be queried and replayed after
+// the fact. Events arrive with their ID already assigned by the Broadcaster.
+type Store interface {
+	Append(events []Event) error
+	// Query returns matching events in ID order. When more than f.Limit
+	// match, the most recent ones are returned.
+	Query(f Filter) ([]Event, error)
+	LastID() (uint64, error)
+	// Prune deletes events older than before and returns how many were removed.
+	Prune(before time.Time) (int, error)
+	// Delete removes every event matching f, ignoring its limit, e.g. to
+	// erase a user's events.
+	Delete(f Filter) (int, error)
+	Close() error
+}
+
+// StoreFromEnv opens the store selected by MONITOR_STORE: "file" (the
+// default, at MONITOR_STORE_PATH), "postgres" (MONITOR_STORE_DSN, falling
+// back to POSTGRES_DSN) or "off", for which it returns nil.
+func StoreFromEnv() (Store, error) {
+	switch kind := os.Getenv("MONITOR_STORE"); kind {
+	case "off":
+		return nil, nil
+	case "postgres":
+		dsn := os.Getenv("MONITOR_STORE_DSN")
+		if dsn == "" {
+			dsn = os.Getenv("POSTGRES_DSN")
+		}
+		// Own connection, the shared one is instrumented and would publish
+		// an event for every event it stores
+		pg, err := postgres.NewPostgresDriver(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("connecting monitor store: %w", err)
+		}
+		return NewSQLStore(pg)
+	case "", "file":
+		path := os.Getenv("MONITOR_STORE_PATH")
+		if path == "" {
+			path = filepath.Join(".wodge", "monitor", "events.jsonl")
+		}
+		return NewFileStore(path)
+	default:
+		return nil, fmt.Errorf("unknown MONITOR_STORE %q", kind)
+	}
+}
+
+const (
+	DefaultQueryLimit = 500
+	MaxQueryLimit     = 5000
+)
+
+// Filter selects stored events. Zero fields match everything.
+type Filter struct {
+	Since     time.Time
+	Until     time.Time // Exclusive
+	AfterID   uint64    // Resume point, e.g. the SSE Last-Event-ID
+	BeforeID  uint64    // Paging backwards, see QueryAll
+	Types     []EventType
+	Path      string // Prefix of the request path, for events that carry one
+	RequestID string
+	UserID    string // The payload's user_id, for events that carry one
+	Limit     int
+}
+
+// Match reports whether e passes the filter.
+func (f Filter) Match(e Event) bool {
+	if f.AfterID > 0 && e.ID <= f.AfterID {
+		return false
+	}
+	if f.BeforeID > 0 && e.ID >= f.BeforeID {
+		return false
+	}
+	if !f.Since.IsZero() && e.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Timestamp.Before(f.Until) {
+		return false
+	}
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			found = found || t == e.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.RequestID != "" && e.RequestID != f.RequestID {
+		return false
+	}
+	if f.Path != "" && !strings.HasPrefix(eventPath(e), f.Path) {
+		return false
+	}
+	if f.UserID != "" && payloadString(e, "user_id") != f.UserID {
+		return false
+	}
+	return true
+}
+
+func (f Filter) limit() int {
+	if f.Limit <= 0 {
+		return DefaultQueryLimit
+	}
+	if f.Limit > MaxQueryLimit {
+		return MaxQueryLimit
+	}
+	return f.Limit
+}
+
+// eventPath returns the "path" member of the payload, if any.
+func eventPath(e Event) string {
+	return payloadString(e, "path")
+}
+
+// payloadString returns a string member of the payload, if any.
+func payloadString(e Event, key string) string {
+	switch p := e.Payload.(type) {
+	case map[string]interface{}:
+		s, _ := p[key].(string)
+		return s
+	case nil:
+		return ""
+	}
+	raw, err := json.Marshal(e.Payload)
+	if err != nil {
+		return ""
+	}
+	var payload map[string]interface{}
+	_ = json.Unmarshal(raw, &payload)
+	s, _ := payload[key].(string)
+	return s
+}
+
+// FilterFromQuery parses the ?since=&type=&path=&request_id=&user_id=&after_id=&limit=
+// parameters of the events API. since takes an RFC 3339 time or a duration
+// back from now, e.g. "15m".
+func FilterFromQuery(get func(string) string) (Filter, error) {
+	var f Filter
+	if since := get("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			f.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			f.Since = t
+		} else {
+			return f, fmt.Errorf("since must be an RFC 3339 time or a duration like 15m")
+		}
+	}
+	for _, t := range strings.Split(get("type"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			f.Types = append(f.Types, EventType(strings.ToUpper(t)))
+		}
+	}
+	f.Path = get("path")
+	f.RequestID = get("request_id")
+	f.UserID = get("user_id")
+	if v := get("after_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("after_id must be an event ID")
+		}
+		f.AfterID = id
+	}
+	if v := get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return f, fmt.Errorf("limit must be a positive number")
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+// QueryAll returns every event matching f in ID order, paging backwards
+// through the store so f.Limit doesn't apply. Meant for reports over a
+// bounded time window.
+func QueryAll(store Store, f Filter) ([]Event, error) {
+	f.Limit = MaxQueryLimit
+	var pages [][]Event
+	total := 0
+	for {
+		page, err := store.Query(f)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) > 0 {
+			pages = append(pages, page)
+			total += len(page)
+			f.BeforeID = page[0].ID
+		}
+		if len(page) < MaxQueryLimit {
+			break
+		}
+	}
+	events := make([]Event, 0, total)
+	for i := len(pages) - 1; i >= 0; i-- {
+		events = append(events, pages[i]...)
+	}
+	return events, nil
+}
+
+// tail keeps the last n matching events of a scan in order.
+type tail struct {
+	n      int
+	events []Event
+}
+
+func (t *tail) add(e Event) {
+	t.events = append(t.events, e)
+	if len(t.events) > 2*t.n {
+		t.events = append(t.events[:0], t.events[len(t.events)-t.n:]...)
+	}
+}
+
+func (t *tail) result() []Event {
+	if len(t.events) > t.n {
+		return t.events[len(t.events)-t.n:]
+	}
+	return t.events
+}
+
+// FileStore keeps events as JSON lines in a local file. It is the default
+// store and needs no setup; queries scan the file, which is fine for the
+// volumes a single app produces within its retention window.
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{path: path, f: f}, nil
+}
+
+func (s *FileStore) Append(events []Event) error {
+	var buf []byte
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.f.Write(buf)
+	return err
+}
+
+// scan calls fn for every decodable event in the file.
+func (s *FileStore) scan(fn func(e Event, line []byte)) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			fn(e, scanner.Bytes())
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *FileStore) Query(f Filter) ([]Event, error) {
+	t := &tail{n: f.limit()}
+	err := s.scan(func(e Event, _ []byte) {
+		if f.Match(e) {
+			t.add(e)
+		}
+	})
+	return t.result(), err
+}
+
+func (s *FileStore) LastID() (uint64, error) {
+	var last uint64
+	err := s.scan(func(e Event, _ []byte) {
+		if e.ID > last {
+			last = e.ID
+		}
+	})
+	return last, err
+}
+
+// Prune rewrites the file without the expired events.
+func (s *FileStore) Prune(before time.Time) (int, error) {
+	return s.rewrite(func(e Event) bool { return e.Timestamp.Before(before) })
+}
+
+func (s *FileStore) Delete(f Filter) (int, error) {
+	return s.rewrite(f.Match)
+}
+
+// rewrite replaces the file with the events drop rejects.
+func (s *FileStore) rewrite(drop func(e Event) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp := s.path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(out)
+	removed := 0
+	err = s.scan(func(e Event, line []byte) {
+		if drop(e) {
+			removed++
+			return
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	out.Close()
+	if err != nil || removed == 0 {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	s.f.Close()
+	if err := os.Rename(tmp, s.path); err != nil {
+		return 0, err
+	}
+	s.f, err = os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	return removed, err
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// SQLStore keeps events in Postgres. It takes the uninstrumented driver,
+// otherwise every stored event would publish a POSTGRES event of its own.
+type SQLStore struct {
+	db services.DatabaseService
+}
+
+func NewSQLStore(db services.DatabaseService) (*SQLStore, error) {
+	_, err := db.Execute(context.Background(), `
+		CREATE TABLE IF NOT EXISTS wodge_monitor_events (
+			id         BIGINT PRIMARY KEY,
+			ts         TIMESTAMPTZ NOT NULL,
+			type       TEXT NOT NULL,
+			request_id TEXT NOT NULL DEFAULT '',
+			path       TEXT NOT NULL DEFAULT '',
+			event      JSONB NOT NULL
+		)`)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Execute(context.Background(), `CREATE INDEX IF NOT EXISTS wodge_monitor_events_ts ON wodge_monitor_events (ts)`); err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Append(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	var values []string
+	var args []interface{}
+	for _, e := range events {
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6))
+		args = append(args, e.ID, e.Timestamp, string(e.Type), e.RequestID, eventPath(e), string(raw))
+	}
+	_, err := s.db.Execute(context.Background(),
+		"INSERT INTO wodge_monitor_events (id, ts, type, request_id, path, event) VALUES "+
+			strings.Join(values, ", ")+" ON CONFLICT (id) DO NOTHING", args...)
+	return err
+}
+
+func (s *SQLStore) Query(f Filter) ([]Event, error) {
+	where, args := sqlWhere(f)
+	query := "SELECT event FROM wodge_monitor_events" + where
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", f.limit())
+
+	rows, err := s.db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(rows))
+	for i := len(rows) - 1; i >= 0; i-- {
+		var e Event
+		raw, _ := rows[i]["event"].(string)
+		if json.Unmarshal([]byte(raw), &e) == nil {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (s *SQLStore) Delete(f Filter) (int, error) {
+	where, args := sqlWhere(f)
+	n, err := s.db.Execute(context.Background(), "DELETE FROM wodge_monitor_events"+where, args...)
+	return int(n), err
+}
+
+// sqlWhere translates f into a WHERE clause, empty when f matches all.
+func sqlWhere(f Filter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if f.AfterID > 0 {
+		where = append(where, "id > "+arg(f.AfterID))
+	}
+	if f.BeforeID > 0 {
+		where = append(where, "id < "+arg(f.BeforeID))
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "ts >= "+arg(f.Since))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "ts < "+arg(f.Until))
+	}
+	if len(f.Types) > 0 {
+		var types []string
+		for _, t := range f.Types {
+			types = append(types, arg(string(t)))
+		}
+		where = append(where, "type IN ("+strings.Join(types, ", ")+")")
+	}
+	if f.RequestID != "" {
+		where = append(where, "request_id = "+arg(f.RequestID))
+	}
+	if f.Path != "" {
+		where = append(where, "starts_with(path, "+arg(f.Path)+")")
+	}
+	if f.UserID != "" {
+		where = append(where, "event->'payload'->>'user_id' = "+arg(f.UserID))
+	}
+	if len(where) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+func (s *SQLStore) LastID() (uint64, error) {
+	rows, err := s.db.Query(context.Background(), "SELECT COALESCE(MAX(id), 0) AS id FROM wodge_monitor_events")
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	id, _ := rows[0]["id"].(int64)
+	return uint64(id), nil
+}
+
+func (s *SQLStore) Prune(before time.Time) (int, error) {
+	n, err := s.db.Execute(context.Background(), "DELETE FROM wodge_monitor_events WHERE ts < $1", before)
+	return int(n), err
+}
+
+func (s *SQLStore) Close() error {
+	return nil
+}
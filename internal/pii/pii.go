@@ -0,0 +1,272 @@
+// Package pii replaces personal data in chat text with tokens such as
+// [EMAIL_1] before it leaves for QAST, and keeps the token map encrypted
+// server-side so answers can be rehydrated for the session's owner only.
+package pii
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"wodge/internal/redact"
+	"wodge/internal/security"
+	"wodge/internal/services"
+)
+
+// DefaultDetectors are applied in order; card and national_id go before
+// phone, which would otherwise claim their digits.
+var DefaultDetectors = []string{"national_id", "card", "email", "jwt", "phone", "ip"}
+
+// token matches anything that may be a placeholder: ours, QAST's own and
+// the caller's [<user id>_SELF]. Only mapped ones are replaced.
+var token = regexp.MustCompile(`\[[^\[\]\s]{1,128}\]`)
+
+// sessionMap is what is sealed and stored per session.
+type sessionMap struct {
+	Owner  string            `json:"owner"`  // User ID, empty without auth
+	Tokens map[string]string `json:"tokens"` // "[EMAIL_1]" -> value
+	Next   map[string]int    `json:"next"`   // Last number used per type
+}
+
+// Tokenizer tokenizes and rehydrates text per session. It is safe for
+// concurrent use.
+type Tokenizer struct {
+	detect *redact.Redactor
+	store  Store
+	sealer *security.Sealer
+	ttl    time.Duration
+	// AllowAnonymous lets callers without a user ID own maps, for local
+	// development without auth. Otherwise such maps are refused, since the
+	// session ID alone would unlock them.
+	AllowAnonymous bool
+	// Serializes load-modify-save; across instances the last write wins,
+	// which costs at most a token issued twice for one value
+	mu sync.Mutex
+}
+
+// New builds a Tokenizer. Maps expire ttl after their last change.
+func New(detectors []string, store Store, sealer *security.Sealer, ttl time.Duration) (*Tokenizer, error) {
+	if sealer == nil {
+		return nil, errors.New("pii: token maps need an encryption key")
+	}
+	if len(detectors) == 0 {
+		detectors = DefaultDetectors
+	}
+	detect, err := redact.New(redact.Config{Detectors: detectors}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokenizer{detect: detect, store: store, sealer: sealer, ttl: ttl}, nil
+}
+
+// NewSession returns a random ID for a session the client has not named.
+func NewSession() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Tokenize replaces the personal data in text and returns it with the
+// number of values replaced. A value seen before in the session keeps its
+// token.
+func (t *Tokenizer) Tokenize(ctx context.Context, owner, session, text string) (string, int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, err := t.load(ctx, owner, session)
+	if err != nil {
+		return "", 0, err
+	}
+	byValue := make(map[string]string, len(m.Tokens))
+	for tok, v := range m.Tokens {
+		byValue[v] = tok
+	}
+	n := 0
+	out := t.detect.Replace(text, func(kind, value string) string {
+		n++
+		if tok, ok := byValue[value]; ok {
+			return tok
+		}
+		m.Next[kind]++
+		tok := fmt.Sprintf("[%s_%d]", kind, m.Next[kind])
+		m.Tokens[tok], byValue[value] = value, tok
+		return tok
+	})
+	if n == 0 {
+		return text, 0, nil
+	}
+	return out, n, t.save(ctx, owner, session, m)
+}
+
+// Merge adds tokens issued elsewhere, e.g. by QAST, to the session's map.
+// Tokens already mapped keep their value.
+func (t *Tokenizer) Merge(ctx context.Context, owner, session string, tokens map[string]string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, err := t.load(ctx, owner, session)
+	if err != nil {
+		return err
+	}
+	added := 0
+	for tok, v := range tokens {
+		if _, ok := m.Tokens[tok]; !ok && v != "" {
+			m.Tokens[tok] = v
+			added++
+		}
+	}
+	if added == 0 {
+		return nil
+	}
+	return t.save(ctx, owner, session, m)
+}
+
+// Identity is the caller, whom QAST refers to as [<id>_SELF] and
+// [<id>_POSS_SELF] in its answers.
+type Identity struct {
+	ID   string
+	Name string // Display name
+}
+
+// Rehydrate puts the session's values back in place of its tokens, and the
+// caller's name in place of their self tokens. Unknown tokens are left as
+// they are.
+func (t *Tokenizer) Rehydrate(ctx context.Context, owner, session, text string, self Identity) (string, error) {
+	m, err := t.load(ctx, owner, session)
+	if err != nil {
+		return "", err
+	}
+	values := make(map[string]string, len(m.Tokens)+2)
+	for tok, v := range m.Tokens {
+		values[tok] = v
+	}
+	if self.ID != "" {
+		possessive, name := self.ID+"_POSS_SELF", self.ID+"_SELF"
+		values["["+name+"]"], values["["+possessive+"]"] = self.Name, "your"
+		// QAST maps some tokens to phrases about the caller
+		for tok, v := range values {
+			switch {
+			case strings.Contains(v, possessive):
+				values[tok] = "your"
+			case strings.Contains(v, name):
+				values[tok] = self.Name
+			}
+		}
+	}
+	return token.ReplaceAllStringFunc(text, func(tok string) string {
+		if v, ok := values[tok]; ok {
+			return v
+		}
+		return tok
+	}), nil
+}
+
+// Forget deletes the session's map.
+func (t *Tokenizer) Forget(ctx context.Context, owner, session string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.load(ctx, owner, session); err != nil {
+		return err
+	}
+	return t.store.Delete(ctx, owner, session)
+}
+
+// Maps returns the token maps owner has, by session, for a data-subject
+// export.
+func (t *Tokenizer) Maps(ctx context.Context, owner string) (map[string]map[string]string, error) {
+	if owner == "" {
+		return nil, errors.New("pii: maps are listed by user ID")
+	}
+	sessions, err := t.store.Sessions(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]map[string]string, len(sessions))
+	for _, session := range sessions {
+		m, err := t.load(ctx, owner, session)
+		if err != nil {
+			return nil, err
+		}
+		out[session] = m.Tokens
+	}
+	return out, nil
+}
+
+// ForgetAll deletes every map owner has and returns how many there were.
+func (t *Tokenizer) ForgetAll(ctx context.Context, owner string) (int, error) {
+	if owner == "" {
+		return 0, errors.New("pii: maps are erased by user ID")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessions, err := t.store.Sessions(ctx, owner)
+	if err != nil {
+		return 0, err
+	}
+	for i, session := range sessions {
+		if err := t.store.Delete(ctx, owner, session); err != nil {
+			return i, err
+		}
+	}
+	return len(sessions), nil
+}
+
+// Count is how many maps owner has.
+func (t *Tokenizer) Count(ctx context.Context, owner string) (int, error) {
+	sessions, err := t.store.Sessions(ctx, owner)
+	return len(sessions), err
+}
+
+// load returns the session's map, or a new one owned by owner. A map owned
+// by someone else is reported as not found, so session IDs cannot be probed.
+func (t *Tokenizer) load(ctx context.Context, owner, session string) (*sessionMap, error) {
+	if session == "" {
+		return nil, services.Validation("PII session is required", services.FieldError{Field: "pii_session", Code: "required"})
+	}
+	if owner == "" && !t.AllowAnonymous {
+		return nil, services.Unauthorized("PII sessions require a signed-in user")
+	}
+	sealed, err := t.store.Load(ctx, owner, session)
+	if err != nil {
+		return nil, err
+	}
+	if sealed == nil {
+		return &sessionMap{Owner: owner, Tokens: map[string]string{}, Next: map[string]int{}}, nil
+	}
+	raw, err := t.sealer.Open(sealed, additionalData(session))
+	if err != nil {
+		return nil, fmt.Errorf("pii: open token map: %w", err)
+	}
+	var m sessionMap
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("pii: decode token map: %w", err)
+	}
+	if m.Owner != owner {
+		return nil, services.NotFound("PII session not found")
+	}
+	if m.Tokens == nil {
+		m.Tokens = map[string]string{}
+	}
+	if m.Next == nil {
+		m.Next = map[string]int{}
+	}
+	return &m, nil
+}
+
+func (t *Tokenizer) save(ctx context.Context, owner, session string, m *sessionMap) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return t.store.Save(ctx, owner, session, t.sealer.Seal(raw, additionalData(session)), t.ttl)
+}
+
+// additionalData binds a sealed map to its session, so maps cannot be
+// swapped between sessions in the store.
+func additionalData(session string) []byte {
+	return []byte("pii:" + session)
+}
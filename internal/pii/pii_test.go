@@ -0,0 +1,219 @@
+package pii
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+	"time"
+	"wodge/internal/security"
+	"wodge/internal/services"
+)
+
+func newTokenizer(t *testing.T) *Tokenizer {
+	t.Helper()
+	sealer, err := security.NewSealer(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := New(nil, NewMemoryStore(), sealer, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+// Maps open for their owner only, and ownerless maps only when anonymous
+// use is allowed.
+func TestTokenizerOwnerBinding(t *testing.T) {
+	tests := []struct {
+		name      string
+		anonymous bool
+		owner     string
+		reader    string
+		wantErr   error
+	}{
+		{"owner", false, "u1", "u1", nil},
+		{"other user", false, "u1", "u2", services.ErrNotFound},
+		{"anonymous reader of a user's map", true, "u1", "", services.ErrNotFound},
+		{"anonymous map outside dev mode", false, "", "", services.ErrUnauthorized},
+		{"anonymous map in dev mode", true, "", "", nil},
+		{"user reading an anonymous map", true, "", "u1", services.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok := newTokenizer(t)
+			tok.AllowAnonymous = tt.anonymous
+			ctx := context.Background()
+			text, _, err := tok.Tokenize(ctx, tt.owner, "s1", "mail alice@example.com")
+			if tt.owner == "" && !tt.anonymous {
+				if !errors.Is(err, services.ErrUnauthorized) {
+					t.Fatalf("Tokenize() without an owner = %v, want ErrUnauthorized", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tok.Rehydrate(ctx, tt.reader, "s1", text, Identity{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Rehydrate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != "mail alice@example.com" {
+				t.Errorf("Rehydrate() = %q", got)
+			}
+		})
+	}
+}
+
+// Stored maps are sealed and bound to their session: the store never sees
+// values, and a map moved or altered in the store doesn't open.
+func TestTokenMapsAreSealed(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		tamper  func(t *testing.T, tok *Tokenizer)
+		wantErr bool
+	}{
+		{"untouched", func(*testing.T, *Tokenizer) {}, false},
+		{"swapped from another session", func(t *testing.T, tok *Tokenizer) {
+			if _, _, err := tok.Tokenize(ctx, "u1", "s2", "mail bob@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			other, _ := tok.store.Load(ctx, "u1", "s2")
+			tok.store.Save(ctx, "u1", "s1", other, time.Hour)
+		}, true},
+		{"flipped bit", func(t *testing.T, tok *Tokenizer) {
+			sealed, _ := tok.store.Load(ctx, "u1", "s1")
+			sealed[len(sealed)-1] ^= 1
+			tok.store.Save(ctx, "u1", "s1", sealed, time.Hour)
+		}, true},
+		{"sealed with another key", func(t *testing.T, tok *Tokenizer) {
+			tok.sealer, _ = security.NewSealer([]byte(strings.Repeat("k", 32)))
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok := newTokenizer(t)
+			text, n, err := tok.Tokenize(ctx, "u1", "s1", "mail alice@example.com")
+			if err != nil || n != 1 {
+				t.Fatalf("Tokenize() = %q, %d, %v", text, n, err)
+			}
+			sealed, _ := tok.store.Load(ctx, "u1", "s1")
+			if strings.Contains(string(sealed), "alice@example.com") {
+				t.Fatalf("stored map is readable: %q", sealed)
+			}
+
+			tt.tamper(t, tok)
+			got, err := tok.Rehydrate(ctx, "u1", "s1", text, Identity{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Rehydrate() = %q, %v, wantErr %v", got, err, tt.wantErr)
+			}
+			if err == nil && got != "mail alice@example.com" {
+				t.Errorf("Rehydrate() = %q", got)
+			}
+		})
+	}
+}
+
+func TestTokenizeAndRehydrate(t *testing.T) {
+	ctx := context.Background()
+	tok := newTokenizer(t)
+	first, _, err := tok.Tokenize(ctx, "u1", "s1", "alice@example.com and bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _, _ := tok.Tokenize(ctx, "u1", "s1", "ask bob@example.com")
+	if first != "[EMAIL_1] and [EMAIL_2]" || again != "ask [EMAIL_2]" {
+		t.Fatalf("tokens = %q, %q: a value must keep its token within a session", first, again)
+	}
+	if text, n, _ := tok.Tokenize(ctx, "u1", "s1", "nothing personal"); n != 0 || text != "nothing personal" {
+		t.Errorf("Tokenize() without personal data = %q, %d", text, n)
+	}
+	if err := tok.Merge(ctx, "u1", "s1", map[string]string{"[PERSON_1]": "Carol", "[EMAIL_1]": "mallory@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	self := Identity{ID: "u1", Name: "Alice"}
+	got, err := tok.Rehydrate(ctx, "u1", "s1", "[u1_SELF], [u1_POSS_SELF] mail [EMAIL_1] to [PERSON_1], not [EMAIL_9]", self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Alice, your mail alice@example.com to Carol, not [EMAIL_9]"; got != want {
+		t.Errorf("Rehydrate() = %q, want %q", got, want)
+	}
+}
+
+// fakeCache is a CacheService over a map, with Redis-style glob matching.
+type fakeCache struct{ data map[string]string }
+
+func (c *fakeCache) Get(_ context.Context, key string) (string, error) {
+	v, ok := c.data[key]
+	if !ok {
+		return "", services.NotFound("no such key")
+	}
+	return v, nil
+}
+
+func (c *fakeCache) Set(_ context.Context, key, value string, _ int) error {
+	c.data[key] = value
+	return nil
+}
+
+func (c *fakeCache) Delete(_ context.Context, key string) error {
+	delete(c.data, key)
+	return nil
+}
+
+func (c *fakeCache) Keys(_ context.Context, pattern string) ([]string, error) {
+	var keys []string
+	for k := range c.data {
+		if ok, _ := path.Match(pattern, k); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// An erase finds every map the user owns and nobody else's, in each store.
+func TestTokenizerForgetAll(t *testing.T) {
+	stores := map[string]func() Store{
+		"memory": func() Store { return NewMemoryStore() },
+		"cache":  func() Store { return NewCacheStore(&fakeCache{data: map[string]string{}}) },
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			tok := newTokenizer(t)
+			tok.store = newStore()
+			ctx := context.Background()
+			// "u1:x" and "u*" would match u1's keys if owners weren't escaped
+			for _, m := range []struct{ owner, session string }{
+				{"u1", "a"}, {"u1", "b"}, {"u1:x", "c"}, {"u*", "d"}, {"u2", "e"},
+			} {
+				if _, _, err := tok.Tokenize(ctx, m.owner, m.session, "mail alice@example.com"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			maps, err := tok.Maps(ctx, "u1")
+			if err != nil || len(maps) != 2 || maps["a"]["[EMAIL_1]"] != "alice@example.com" {
+				t.Fatalf("Maps(u1) = %v, %v", maps, err)
+			}
+			if n, err := tok.ForgetAll(ctx, "u1"); n != 2 || err != nil {
+				t.Fatalf("ForgetAll(u1) = %d, %v, want 2", n, err)
+			}
+			if n, _ := tok.Count(ctx, "u1"); n != 0 {
+				t.Errorf("Count(u1) after ForgetAll = %d", n)
+			}
+			for _, owner := range []string{"u1:x", "u*", "u2"} {
+				if n, _ := tok.Count(ctx, owner); n != 1 {
+					t.Errorf("Count(%s) = %d, want 1: another user's map was erased", owner, n)
+				}
+			}
+			if _, err := tok.ForgetAll(ctx, ""); err == nil {
+				t.Error("ForgetAll accepted an empty owner")
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package pii
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"wodge/internal/services"
+)
+
+// Store keeps sealed token maps by session, indexed by the owner's user ID
+// so a data-subject erase can find all of them.
+type Store interface {
+	// Load returns nil, nil when the session has no map or it expired
+	Load(ctx context.Context, owner, session string) ([]byte, error)
+	Save(ctx context.Context, owner, session string, sealed []byte, ttl time.Duration) error
+	Delete(ctx context.Context, owner, session string) error
+	// Sessions lists the sessions with a map owned by owner.
+	Sessions(ctx context.Context, owner string) ([]string, error)
+}
+
+// CacheStore keeps maps in Redis under pii:<owner>:<session>.
+type CacheStore struct {
+	cache services.CacheService
+}
+
+func NewCacheStore(cache services.CacheService) *CacheStore {
+	return &CacheStore{cache: cache}
+}
+
+func (s *CacheStore) Load(ctx context.Context, owner, session string) ([]byte, error) {
+	v, err := s.cache.Get(ctx, cacheKey(owner, session))
+	if errors.Is(err, services.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(v)
+}
+
+func (s *CacheStore) Save(ctx context.Context, owner, session string, sealed []byte, ttl time.Duration) error {
+	return s.cache.Set(ctx, cacheKey(owner, session), base64.StdEncoding.EncodeToString(sealed), int(ttl/time.Second))
+}
+
+func (s *CacheStore) Delete(ctx context.Context, owner, session string) error {
+	return s.cache.Delete(ctx, cacheKey(owner, session))
+}
+
+func (s *CacheStore) Sessions(ctx context.Context, owner string) ([]string, error) {
+	prefix := cacheKey(owner, "")
+	keys, err := s.cache.Keys(ctx, globEscaper.Replace(prefix)+"*")
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]string, 0, len(keys))
+	for _, k := range keys {
+		sessions = append(sessions, strings.TrimPrefix(k, prefix))
+	}
+	return sessions, nil
+}
+
+// cacheKey escapes ":" in the owner, so one owner's prefix never matches
+// another's keys.
+func cacheKey(owner, session string) string {
+	return "pii:" + ownerEscaper.Replace(owner) + ":" + session
+}
+
+var (
+	ownerEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+	globEscaper  = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+)
+
+// SQLStore keeps maps in Postgres. Expired rows are ignored on load and
+// removed on the next save. Sessions are unique across owners; the sealed
+// map's own owner keeps one user from opening another's.
+type SQLStore struct {
+	db services.DatabaseService
+}
+
+func NewSQLStore(db services.DatabaseService) (*SQLStore, error) {
+	_, err := db.Execute(context.Background(), `
+		CREATE TABLE IF NOT EXISTS wodge_pii_maps (
+			session_id TEXT PRIMARY KEY,
+			sealed     TEXT NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+		)`)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Execute(context.Background(), `ALTER TABLE wodge_pii_maps ADD COLUMN IF NOT EXISTS owner_id TEXT NOT NULL DEFAULT ''`); err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Load(ctx context.Context, _, session string) ([]byte, error) {
+	rows, err := s.db.Query(ctx, "SELECT sealed FROM wodge_pii_maps WHERE session_id = $1 AND expires_at > now()", session)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	v, ok := rows[0]["sealed"].(string)
+	if !ok {
+		return nil, errors.New("pii: unexpected sealed column type")
+	}
+	return base64.StdEncoding.DecodeString(v)
+}
+
+func (s *SQLStore) Save(ctx context.Context, owner, session string, sealed []byte, ttl time.Duration) error {
+	if _, err := s.db.Execute(ctx, "DELETE FROM wodge_pii_maps WHERE expires_at <= now()"); err != nil {
+		return err
+	}
+	_, err := s.db.Execute(ctx, `
+		INSERT INTO wodge_pii_maps (session_id, owner_id, sealed, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (session_id) DO UPDATE SET sealed = EXCLUDED.sealed, expires_at = EXCLUDED.expires_at`,
+		session, owner, base64.StdEncoding.EncodeToString(sealed), time.Now().Add(ttl))
+	return err
+}
+
+func (s *SQLStore) Delete(ctx context.Context, owner, session string) error {
+	_, err := s.db.Execute(ctx, "DELETE FROM wodge_pii_maps WHERE session_id = $1 AND owner_id = $2", session, owner)
+	return err
+}
+
+func (s *SQLStore) Sessions(ctx context.Context, owner string) ([]string, error) {
+	rows, err := s.db.Query(ctx, "SELECT session_id FROM wodge_pii_maps WHERE owner_id = $1 AND expires_at > now()", owner)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if id, ok := row["session_id"].(string); ok {
+			sessions = append(sessions, id)
+		}
+	}
+	return sessions, nil
+}
+
+// MemoryStore keeps maps in the process, for development without Redis or
+// Postgres. Maps are lost on restart. As in SQLStore, sessions are unique
+// across owners.
+type MemoryStore struct {
+	mu   sync.Mutex
+	maps map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	owner   string
+	sealed  []byte
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{maps: make(map[string]memoryEntry)}
+}
+
+func (s *MemoryStore) Load(_ context.Context, _, session string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.maps[session]
+	if !ok || time.Now().After(e.expires) {
+		return nil, nil
+	}
+	return e.sealed, nil
+}
+
+func (s *MemoryStore) Save(_ context.Context, owner, session string, sealed []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, e := range s.maps {
+		if now.After(e.expires) {
+			delete(s.maps, k)
+		}
+	}
+	s.maps[session] = memoryEntry{owner: owner, sealed: sealed, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, owner, session string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.maps[session]; ok && e.owner == owner {
+		delete(s.maps, session)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Sessions(_ context.Context, owner string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var sessions []string
+	for session, e := range s.maps {
+		if e.owner == owner && now.Before(e.expires) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
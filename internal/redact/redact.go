@@ -0,0 +1,379 @@
+// Package redact strips secrets and personal data from request logs and
+// monitor events before they are published or written to disk.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"wodge/internal/logging"
+)
+
+// Redacted replaces the values of sensitive fields
+const Redacted = "[REDACTED]"
+
+// Config holds the redaction rules. It can be loaded from a JSON file.
+type Config struct {
+	// Fields are key names redacted wherever they appear, case-insensitively
+	Fields []string `json:"fields"`
+	// Paths are dot-separated JSON paths from the body root, "*" matches any
+	// key or array element, e.g. "user.email" or "messages.*.content"
+	Paths []string `json:"paths"`
+	// Detectors names the built-in detectors applied to every string value:
+	// email, national_id, card, jwt, phone, ip. Custom ones go in Patterns.
+	Detectors []string          `json:"detectors"`
+	Patterns  map[string]string `json:"patterns"` // Name -> regexp
+	// MaxBodyBytes caps the request/response bodies that are logged at all
+	MaxBodyBytes int `json:"max_body_bytes"`
+	// Routes adds rules for requests whose "METHOD /path" starts with the key,
+	// e.g. "POST /api/qast/"
+	Routes map[string]RouteConfig `json:"routes"`
+}
+
+// RouteConfig overrides the rules for matching routes.
+type RouteConfig struct {
+	Fields   []string `json:"fields"`
+	Paths    []string `json:"paths"`
+	SkipBody bool     `json:"skip_body"` // Log no bodies at all
+}
+
+// DefaultConfig redacts credentials and tokens everywhere, personal data by
+// detector, and chat content on the QAST routes.
+func DefaultConfig() Config {
+	return Config{
+		Fields: []string{
+			"password", "confirm_password", "new_password", "old_password",
+			"access_token", "refresh_token", "id_token", "token", "mfa_token",
+			"authorization", "cookie", "api_key", "secret", "client_secret",
+			"recovery_codes", "totp_secret", "otpauth_url",
+			"signature", "client_data_json", "authenticator_data", "csrf_token",
+		},
+		Detectors:    []string{"email", "national_id", "card", "jwt"},
+		MaxBodyBytes: 16 << 10,
+		Routes: map[string]RouteConfig{
+			"POST /api/qast/":        {Paths: []string{"text", "query"}},
+			"POST /api/pii/":         {SkipBody: true},
+			"GET /api/history/":      {Paths: []string{"messages.*.content", "*.title", "title"}},
+			"POST /api/history/":     {Paths: []string{"title"}},
+			"GET /wodge/monitor/":    {SkipBody: true},
+			"POST /api/auth/mfa/":    {SkipBody: true},
+			"DELETE /api/auth/mfa":   {SkipBody: true},
+			"POST /api/auth/refresh": {SkipBody: true},
+		},
+	}
+}
+
+// FromEnv loads REDACT_CONFIG (a JSON file replacing the defaults) and
+// REDACT_MAX_BODY_BYTES. REDACT_HASH_KEY keeps detector pseudonyms stable
+// across restarts; without it they are only stable within one process.
+func FromEnv() (*Redactor, error) {
+	cfg := DefaultConfig()
+	if path := os.Getenv("REDACT_CONFIG"); path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg = Config{}
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if v := os.Getenv("REDACT_MAX_BODY_BYTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("REDACT_MAX_BODY_BYTES: %w", err)
+		}
+		cfg.MaxBodyBytes = n
+	}
+	return New(cfg, []byte(os.Getenv("REDACT_HASH_KEY")))
+}
+
+type detector struct {
+	name  string
+	re    *regexp.Regexp
+	valid func(match string) bool // Optional checksum to cut false positives
+}
+
+var builtinDetectors = map[string]detector{
+	"email": {name: "EMAIL", re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	"jwt":   {name: "JWT", re: regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	"card":  {name: "CARD", re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhn},
+	// Norwegian fødselsnummer/D-number (checksummed) and US SSN
+	"national_id": {name: "NATIONAL_ID", re: regexp.MustCompile(`\b\d{6} ?\d{5}\b|\b\d{3}-\d{2}-\d{4}\b`), valid: nationalID},
+	// Run after card and national_id, which would otherwise match as phones
+	"phone": {name: "PHONE", re: regexp.MustCompile(`(?:\+|\b)\d[\d ().-]{6,18}\d\b`), valid: phone},
+	"ip":    {name: "IP", re: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), valid: func(s string) bool { return net.ParseIP(s) != nil }},
+}
+
+// Redactor applies a Config. It is safe for concurrent use.
+type Redactor struct {
+	cfg       Config
+	fields    map[string]bool
+	paths     [][]string
+	detectors []detector
+	key       []byte
+}
+
+func New(cfg Config, hashKey []byte) (*Redactor, error) {
+	r := &Redactor{cfg: cfg, fields: lowerSet(cfg.Fields), paths: splitPaths(cfg.Paths), key: hashKey}
+	for _, name := range cfg.Detectors {
+		d, ok := builtinDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redaction detector %q", name)
+		}
+		r.detectors = append(r.detectors, d)
+	}
+	for name, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern %s: %w", name, err)
+		}
+		r.detectors = append(r.detectors, detector{name: strings.ToUpper(name), re: re})
+	}
+	if len(r.key) == 0 {
+		r.key = make([]byte, 32)
+		_, _ = rand.Read(r.key)
+	}
+	return r, nil
+}
+
+// MaxBodyBytes is the largest body worth capturing for the log.
+func (r *Redactor) MaxBodyBytes() int {
+	return r.cfg.MaxBodyBytes
+}
+
+// Body redacts a captured request or response body of the given route
+// ("METHOD /path"). size is the full body length, raw may be only its start.
+// It returns nil when the route's bodies are not logged.
+func (r *Redactor) Body(route string, raw []byte, size int) interface{} {
+	if size == 0 {
+		return nil
+	}
+	fields, paths := r.fields, r.paths
+	for prefix, rc := range r.cfg.Routes {
+		if !strings.HasPrefix(route, prefix) {
+			continue
+		}
+		if rc.SkipBody {
+			return nil
+		}
+		if len(rc.Fields) > 0 {
+			fields = mergeSet(fields, rc.Fields)
+		}
+		paths = append(paths[:len(paths):len(paths)], splitPaths(rc.Paths)...)
+	}
+	if size > len(raw) || (r.cfg.MaxBodyBytes > 0 && size > r.cfg.MaxBodyBytes) {
+		return map[string]interface{}{"_truncated": true, "_bytes": size}
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil // Only JSON bodies are logged, not e.g. SSE streams
+	}
+	for _, p := range paths {
+		body = redactPath(body, p)
+	}
+	return r.walk(body, fields)
+}
+
+// Value redacts an arbitrary payload (e.g. a monitor event) by field name
+// and detectors. Structs are converted through their JSON form.
+func (r *Redactor) Value(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, bool, float64, int, int64:
+		return v
+	case string, map[string]interface{}, []interface{}:
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return v
+		}
+		var generic interface{}
+		if json.Unmarshal(raw, &generic) != nil {
+			return v
+		}
+		v = generic
+	}
+	return r.walk(v, r.fields)
+}
+
+func (r *Redactor) walk(v interface{}, fields map[string]bool) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			if fields[strings.ToLower(k)] && val != nil {
+				out[k] = Redacted
+				continue
+			}
+			out[k] = r.walk(val, fields)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, val := range t {
+			out[i] = r.walk(val, fields)
+		}
+		return out
+	case string:
+		return r.String(t)
+	}
+	return v
+}
+
+// String masks credentials in DSNs and URLs, then replaces everything the
+// detectors find with a pseudonym such as [EMAIL:1a2b3c4d]. The same value
+// always maps to the same pseudonym, so events about one user can still be
+// correlated.
+func (r *Redactor) String(s string) string {
+	return r.Replace(logging.MaskSecrets(s), func(kind, value string) string {
+		return "[" + kind + ":" + r.pseudonym(value) + "]"
+	})
+}
+
+// Replace runs the detectors over s in order and replaces every value they
+// find with fn's result. kind is the detector name, e.g. EMAIL.
+func (r *Redactor) Replace(s string, fn func(kind, value string) string) string {
+	for _, d := range r.detectors {
+		s = d.re.ReplaceAllStringFunc(s, func(m string) string {
+			if d.valid != nil && !d.valid(m) {
+				return m
+			}
+			return fn(d.name, m)
+		})
+	}
+	return s
+}
+
+func (r *Redactor) pseudonym(value string) string {
+	m := hmac.New(sha256.New, r.key)
+	m.Write([]byte(value))
+	return hex.EncodeToString(m.Sum(nil))[:8]
+}
+
+// redactPath replaces the value at path, expanding "*" over keys and elements.
+func redactPath(v interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		if v == nil {
+			return nil
+		}
+		return Redacted
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if path[0] == "*" || path[0] == k {
+				t[k] = redactPath(val, path[1:])
+			}
+		}
+	case []interface{}:
+		for i, val := range t {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				t[i] = redactPath(val, path[1:])
+			}
+		}
+	}
+	return v
+}
+
+func splitPaths(paths []string) [][]string {
+	var out [][]string
+	for _, p := range paths {
+		p = strings.TrimPrefix(strings.TrimPrefix(p, "$"), ".")
+		p = strings.NewReplacer("[*]", ".*", "[", ".", "]", "").Replace(p)
+		if p != "" {
+			out = append(out, strings.Split(p, "."))
+		}
+	}
+	return out
+}
+
+func lowerSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[strings.ToLower(n)] = true
+	}
+	return set
+}
+
+func mergeSet(base map[string]bool, extra []string) map[string]bool {
+	out := lowerSet(extra)
+	for k := range base {
+		out[k] = true
+	}
+	return out
+}
+
+// luhn validates card numbers.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// phone accepts 8 to 15 digits, the E.164 range, but not dates.
+func phone(s string) bool {
+	if isoDate.MatchString(s) {
+		return false
+	}
+	n := 0
+	for i := range s {
+		if s[i] >= '0' && s[i] <= '9' {
+			n++
+		}
+	}
+	return n >= 8 && n <= 15
+}
+
+var isoDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// nationalID accepts US SSNs by shape and Norwegian 11-digit numbers by
+// their two mod-11 check digits.
+func nationalID(s string) bool {
+	if strings.Contains(s, "-") {
+		return true
+	}
+	s = strings.ReplaceAll(s, " ", "")
+	if len(s) != 11 {
+		return false
+	}
+	d := make([]int, 11)
+	for i := range s {
+		d[i] = int(s[i] - '0')
+	}
+	check := func(weights []int) int {
+		sum := 0
+		for i, w := range weights {
+			sum += w * d[i]
+		}
+		k := 11 - sum%11
+		if k == 11 {
+			k = 0
+		}
+		return k
+	}
+	k1 := check([]int{3, 7, 6, 1, 8, 9, 4, 5, 2})
+	k2 := check([]int{5, 4, 3, 2, 7, 6, 5, 4, 3, 2})
+	return k1 != 10 && k2 != 10 && k1 == d[9] && k2 == d[10]
+}
@@ -0,0 +1,163 @@
+package redact
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newDefault(t *testing.T) *Redactor {
+	t.Helper()
+	r, err := New(DefaultConfig(), []byte("test key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+var pseudonymRe = regexp.MustCompile(`\[([A-Z_]+):[0-9a-f]{8}\]`)
+
+func TestRedactorString(t *testing.T) {
+	r := newDefault(t)
+	tests := []struct {
+		name  string
+		in    string
+		want  string   // Exact output, if set
+		kinds []string // Otherwise the pseudonym kinds expected, in order
+		gone  string   // Must not appear in the output
+	}{
+		{name: "email", in: "login for alice@example.com", kinds: []string{"EMAIL"}, gone: "alice@example.com"},
+		{name: "card", in: "card 4111 1111 1111 1111 declined", kinds: []string{"CARD"}, gone: "4111"},
+		{name: "number failing luhn", in: "order 4111 1111 1111 1112", want: "order 4111 1111 1111 1112"},
+		{name: "US SSN", in: "ssn 123-45-6789", kinds: []string{"NATIONAL_ID"}, gone: "6789"},
+		{name: "Norwegian ID", in: "fnr 01019010046", kinds: []string{"NATIONAL_ID"}, gone: "01019010046"},
+		{name: "11 digits failing the check", in: "ref 01019010047", want: "ref 01019010047"},
+		{name: "JWT", in: "bearer eyJhbGciOiJFUzI1NiJ9.eyJzdWIiOiJ1MSJ9.c2ln", kinds: []string{"JWT"}, gone: "eyJzdWIiOiJ1MSJ9"},
+		{name: "URL DSN", in: "dial postgres://wodge:hunter2@db:5432/app failed", want: "dial postgres://wodge:****@db:5432/app failed"},
+		{name: "key/value DSN", in: "host=db user=wodge password=hunter2 dbname=app", want: "host=db user=wodge password=**** dbname=app"},
+		{name: "AMQP URL", in: "amqp://guest:s3cret@mq:5672/", want: "amqp://guest:****@mq:5672/"},
+		{name: "DSN with an escaped user", in: "postgres://alice%40example.com:pw@db/app", want: "postgres://alice%40example.com:****@db/app"},
+		{name: "plain text", in: "nothing to see", want: "nothing to see"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.String(tt.in)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if tt.kinds != nil {
+				var kinds []string
+				for _, m := range pseudonymRe.FindAllStringSubmatch(got, -1) {
+					kinds = append(kinds, m[1])
+				}
+				if strings.Join(kinds, ",") != strings.Join(tt.kinds, ",") {
+					t.Errorf("String(%q) = %q, want pseudonyms %v", tt.in, got, tt.kinds)
+				}
+			}
+			if tt.gone != "" && strings.Contains(got, tt.gone) {
+				t.Errorf("String(%q) = %q still contains %q", tt.in, got, tt.gone)
+			}
+		})
+	}
+}
+
+func TestRedactorPseudonymsAreStable(t *testing.T) {
+	r := newDefault(t)
+	a, b := r.String("alice@example.com"), r.String("alice@example.com")
+	if a != b {
+		t.Errorf("same value got pseudonyms %q and %q", a, b)
+	}
+	if c := r.String("bob@example.com"); c == a {
+		t.Errorf("different values share the pseudonym %q", a)
+	}
+	other, _ := New(DefaultConfig(), []byte("another key"))
+	if d := other.String("alice@example.com"); d == a {
+		t.Errorf("pseudonym %q doesn't depend on the key", a)
+	}
+}
+
+func TestRedactorBody(t *testing.T) {
+	r := newDefault(t)
+	tests := []struct {
+		name  string
+		route string
+		body  string
+		want  string // JSON of the redacted body, "null" when not logged
+	}{
+		{"login password", "POST /api/auth/login",
+			`{"username":"alice","password":"hunter2"}`,
+			`{"password":"[REDACTED]","username":"alice"}`},
+		{"tokens, any case and depth", "POST /api/auth/login",
+			`{"user":{"id":"u1"},"Access_Token":"a","tokens":{"refresh_token":"r"}}`,
+			`{"Access_Token":"[REDACTED]","tokens":{"refresh_token":"[REDACTED]"},"user":{"id":"u1"}}`},
+		{"chat text by path", "POST /api/qast/ask",
+			`{"query":"my salary","session_id":"s1"}`,
+			`{"query":"[REDACTED]","session_id":"s1"}`},
+		{"history messages by wildcard path", "GET /api/history/sessions/s1",
+			`{"title":"t","messages":[{"role":"user","content":"a"},{"role":"ai","content":"b"}]}`,
+			`{"messages":[{"content":"[REDACTED]","role":"user"},{"content":"[REDACTED]","role":"ai"}],"title":"[REDACTED]"}`},
+		{"route without bodies", "POST /api/auth/refresh", `{"refresh_token":"r"}`, `null`},
+		{"MFA disable", "DELETE /api/auth/mfa", `{"code":"123456"}`, `null`},
+		{"not JSON", "GET /api/qast/chat/1/events", `data: hello`, `null`},
+		{"null sensitive field left alone", "POST /api/auth/login", `{"password":null}`, `{"password":null}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(r.Body(tt.route, []byte(tt.body), len(tt.body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Body(%s) = %s, want %s", tt.route, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactorBodyCapsSize(t *testing.T) {
+	r, err := New(Config{Fields: []string{"password"}, MaxBodyBytes: 16}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"password":"hunter2","note":"long enough"}`)
+	got, _ := json.Marshal(r.Body("POST /api/x", body, len(body)))
+	if want := `{"_bytes":43,"_truncated":true}`; string(got) != want {
+		t.Errorf("Body() over the cap = %s, want %s", got, want)
+	}
+	// A capture cut short is never parsed, even under the cap
+	got, _ = json.Marshal(r.Body("POST /api/x", body[:10], 12))
+	if want := `{"_bytes":12,"_truncated":true}`; string(got) != want {
+		t.Errorf("Body() of a partial capture = %s, want %s", got, want)
+	}
+}
+
+// Monitor events go through Value, structs included.
+func TestRedactorValue(t *testing.T) {
+	r := newDefault(t)
+	event := struct {
+		Path  string `json:"path"`
+		Error string `json:"error"`
+		Token string `json:"token"`
+	}{"/api/auth/login", "connect postgres://wodge:hunter2@db/app as alice@example.com", "secret-token"}
+
+	raw, _ := json.Marshal(r.Value(event))
+	got := string(raw)
+	for _, leaked := range []string{"hunter2", "alice@example.com", "secret-token"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("Value() = %s leaks %q", got, leaked)
+		}
+	}
+	if !strings.Contains(got, `"path":"/api/auth/login"`) {
+		t.Errorf("Value() = %s, want the path kept", got)
+	}
+}
+
+func TestNewRejectsBadConfig(t *testing.T) {
+	if _, err := New(Config{Detectors: []string{"passport"}}, nil); err == nil {
+		t.Error("New accepted an unknown detector")
+	}
+	if _, err := New(Config{Patterns: map[string]string{"bad": "("}}, nil); err == nil {
+		t.Error("New accepted an invalid pattern")
+	}
+}
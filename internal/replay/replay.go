@@ -0,0 +1,282 @@
+// Package replay buffers the events of server-sent event streams, so a
+// client that drops can resume with Last-Event-ID, on any instance, and
+// while or after the stream runs.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wodge/internal/services"
+	"wodge/internal/sse"
+)
+
+// Running is the status of a stream that has not ended
+const Running = "running"
+
+// Stream is the state of a buffered stream.
+type Stream struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`            // User ID, empty without auth
+	Status    string    `json:"status"`           // Running, then the stream's outcome
+	Cancel    bool      `json:"cancel,omitempty"` // Cancellation was requested
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Done reports whether the stream has ended.
+func (s *Stream) Done() bool {
+	return s.Status != Running
+}
+
+// Buffer keeps streams and their events. Event IDs are sequence numbers
+// counting from 1, so Last-Event-ID says where to resume.
+type Buffer interface {
+	Save(ctx context.Context, s *Stream, ttl time.Duration) error
+	// Load returns a services.NotFound error for unknown or expired streams
+	Load(ctx context.Context, id string) (*Stream, error)
+	// Append adds events in sequence order, following those appended before
+	Append(ctx context.Context, id string, events []sse.Event, ttl time.Duration) error
+	// Since returns the events after sequence number after
+	Since(ctx context.Context, id string, after int) ([]sse.Event, error)
+	// Streams lists the buffered streams owned by owner, for data-subject
+	// requests
+	Streams(ctx context.Context, owner string) ([]Stream, error)
+	// Delete drops a stream and its events
+	Delete(ctx context.Context, id string) error
+}
+
+// Seq is an event's sequence number, zero when it has none.
+func Seq(ev sse.Event) int {
+	n, _ := strconv.Atoi(ev.ID)
+	return n
+}
+
+var errNotFound = services.NotFound("Stream not found")
+
+// MemoryBuffer keeps streams in the process.
+type MemoryBuffer struct {
+	mu      sync.Mutex
+	streams map[string]*memoryStream
+}
+
+type memoryStream struct {
+	state   Stream
+	events  []sse.Event
+	expires time.Time
+}
+
+func NewMemoryBuffer() *MemoryBuffer {
+	return &MemoryBuffer{streams: make(map[string]*memoryStream)}
+}
+
+func (b *MemoryBuffer) Save(_ context.Context, s *Stream, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for id, m := range b.streams {
+		if now.After(m.expires) {
+			delete(b.streams, id)
+		}
+	}
+	m, ok := b.streams[s.ID]
+	if !ok {
+		m = &memoryStream{}
+		b.streams[s.ID] = m
+	}
+	m.state, m.expires = *s, now.Add(ttl)
+	return nil
+}
+
+func (b *MemoryBuffer) Load(_ context.Context, id string) (*Stream, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.streams[id]
+	if !ok || time.Now().After(m.expires) {
+		return nil, errNotFound
+	}
+	s := m.state
+	return &s, nil
+}
+
+func (b *MemoryBuffer) Append(_ context.Context, id string, events []sse.Event, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.streams[id]
+	if !ok {
+		return errNotFound
+	}
+	m.events = append(m.events, events...)
+	m.expires = time.Now().Add(ttl)
+	return nil
+}
+
+func (b *MemoryBuffer) Since(_ context.Context, id string, after int) ([]sse.Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.streams[id]
+	if !ok || time.Now().After(m.expires) {
+		return nil, errNotFound
+	}
+	after = min(max(after, 0), len(m.events))
+	return append([]sse.Event(nil), m.events[after:]...), nil
+}
+
+func (b *MemoryBuffer) Streams(_ context.Context, owner string) ([]Stream, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	var out []Stream
+	for _, m := range b.streams {
+		if m.state.Owner == owner && now.Before(m.expires) {
+			out = append(out, m.state)
+		}
+	}
+	return out, nil
+}
+
+func (b *MemoryBuffer) Delete(_ context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.streams, id)
+	return nil
+}
+
+// CacheBuffer keeps streams in Redis: the state under stream:<id> and the
+// events in blocks under stream:<id>:<first sequence number>, so appending
+// takes one write and instances need no shared counter.
+type CacheBuffer struct {
+	cache services.CacheService
+}
+
+func NewCacheBuffer(cache services.CacheService) *CacheBuffer {
+	return &CacheBuffer{cache: cache}
+}
+
+func (b *CacheBuffer) Save(ctx context.Context, s *Stream, ttl time.Duration) error {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return b.cache.Set(ctx, "stream:"+s.ID, string(raw), seconds(ttl))
+}
+
+func (b *CacheBuffer) Load(ctx context.Context, id string) (*Stream, error) {
+	raw, err := b.cache.Get(ctx, "stream:"+id)
+	if errors.Is(err, services.ErrNotFound) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Stream
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return nil, fmt.Errorf("replay: decode stream %s: %w", id, err)
+	}
+	return &s, nil
+}
+
+// block is the stored form of events, which are not JSON-tagged.
+type block struct {
+	ID    string `json:"id"`
+	Event string `json:"event,omitempty"`
+	Data  string `json:"data"`
+}
+
+func (b *CacheBuffer) Append(ctx context.Context, id string, events []sse.Event, ttl time.Duration) error {
+	if len(events) == 0 {
+		return nil
+	}
+	blocks := make([]block, len(events))
+	for i, ev := range events {
+		blocks[i] = block{ID: ev.ID, Event: ev.Event, Data: ev.Data}
+	}
+	raw, err := json.Marshal(blocks)
+	if err != nil {
+		return err
+	}
+	return b.cache.Set(ctx, blockKey(id, Seq(events[0])), string(raw), seconds(ttl))
+}
+
+// Since walks the blocks from the first, each naming the next by its last
+// sequence number.
+func (b *CacheBuffer) Since(ctx context.Context, id string, after int) ([]sse.Event, error) {
+	var out []sse.Event
+	for next := 1; ; {
+		raw, err := b.cache.Get(ctx, blockKey(id, next))
+		if errors.Is(err, services.ErrNotFound) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var blocks []block
+		if err := json.Unmarshal([]byte(raw), &blocks); err != nil || len(blocks) == 0 {
+			return nil, fmt.Errorf("replay: decode stream %s block %d: %v", id, next, err)
+		}
+		for _, bl := range blocks {
+			ev := sse.Event{ID: bl.ID, Event: bl.Event, Data: bl.Data}
+			if Seq(ev) > after {
+				out = append(out, ev)
+			}
+		}
+		next = Seq(sse.Event{ID: blocks[len(blocks)-1].ID}) + 1
+	}
+}
+
+// Streams scans every stream's state for the owner's, which is fine for
+// the occasional data-subject request it serves.
+func (b *CacheBuffer) Streams(ctx context.Context, owner string) ([]Stream, error) {
+	keys, err := b.cache.Keys(ctx, "stream:*")
+	if err != nil {
+		return nil, err
+	}
+	var out []Stream
+	for _, key := range keys {
+		id := strings.TrimPrefix(key, "stream:")
+		if strings.Contains(id, ":") {
+			continue // An event block
+		}
+		s, err := b.Load(ctx, id)
+		if errors.Is(err, services.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if s.Owner == owner {
+			out = append(out, *s)
+		}
+	}
+	return out, nil
+}
+
+func (b *CacheBuffer) Delete(ctx context.Context, id string) error {
+	if err := b.cache.Delete(ctx, "stream:"+id); err != nil {
+		return err
+	}
+	blocks, err := b.cache.Keys(ctx, "stream:"+globEscaper.Replace(id)+":*")
+	if err != nil {
+		return err
+	}
+	for _, key := range blocks {
+		if err := b.cache.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func blockKey(id string, first int) string {
+	return "stream:" + id + ":" + strconv.Itoa(first)
+}
+
+func seconds(ttl time.Duration) int {
+	return max(int(ttl/time.Second), 1)
+}
@@ -0,0 +1,152 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wodge/internal/services"
+	"wodge/internal/sse"
+)
+
+// memCache is a CacheService over a map, with glob patterns for Keys.
+type memCache map[string]string
+
+func (m memCache) Get(_ context.Context, key string) (string, error) {
+	v, ok := m[key]
+	if !ok {
+		return "", services.NotFound("Key not found")
+	}
+	return v, nil
+}
+
+func (m memCache) Set(_ context.Context, key, value string, _ int) error {
+	m[key] = value
+	return nil
+}
+
+func (m memCache) Delete(_ context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func (m memCache) Keys(_ context.Context, pattern string) ([]string, error) {
+	var out []string
+	for key := range m {
+		if ok, _ := path.Match(pattern, key); ok {
+			out = append(out, key)
+		}
+	}
+	return out, nil
+}
+
+// events returns events with sequence numbers first to last.
+func events(first, last int) []sse.Event {
+	var out []sse.Event
+	for n := first; n <= last; n++ {
+		out = append(out, sse.Event{ID: strconv.Itoa(n), Event: "token", Data: "t" + strconv.Itoa(n)})
+	}
+	return out
+}
+
+func ids(events []sse.Event) string {
+	var out []string
+	for _, ev := range events {
+		out = append(out, ev.ID)
+	}
+	return strings.Join(out, ",")
+}
+
+func buffers() map[string]Buffer {
+	return map[string]Buffer{
+		"memory": NewMemoryBuffer(),
+		"cache":  NewCacheBuffer(memCache{}),
+	}
+}
+
+// Resuming after a Last-Event-ID returns the events that followed it,
+// across append batches.
+func TestSinceResumesAfterLastEventID(t *testing.T) {
+	tests := []struct {
+		name  string
+		after int
+		want  string
+	}{
+		{"from the start", 0, "1,2,3,4,5,6"},
+		{"within the first batch", 1, "2,3,4,5,6"},
+		{"at a batch boundary", 3, "4,5,6"},
+		{"within a later batch", 4, "5,6"},
+		{"after the last event", 6, ""},
+		{"past the end", 9, ""},
+		{"negative", -1, "1,2,3,4,5,6"},
+	}
+	ctx := context.Background()
+	for kind, b := range buffers() {
+		if err := b.Save(ctx, &Stream{ID: "m1", Status: Running}, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		for _, batch := range [][]sse.Event{events(1, 3), events(4, 4), events(5, 6)} {
+			if err := b.Append(ctx, "m1", batch, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, tt := range tests {
+			t.Run(kind+"/"+tt.name, func(t *testing.T) {
+				got, err := b.Since(ctx, "m1", tt.after)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ids(got) != tt.want {
+					t.Errorf("Since(%d) = %q, want %q", tt.after, ids(got), tt.want)
+				}
+				for _, ev := range got {
+					if ev.Event != "token" || ev.Data != "t"+ev.ID {
+						t.Errorf("event %s = %+v", ev.ID, ev)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Streams lists an owner's streams, and Delete drops a stream with its
+// events.
+func TestStreamsAndDelete(t *testing.T) {
+	ctx := context.Background()
+	for kind, b := range buffers() {
+		t.Run(kind, func(t *testing.T) {
+			for _, s := range []Stream{{ID: "a1", Owner: "alice"}, {ID: "a2", Owner: "alice"}, {ID: "b1", Owner: "bob"}} {
+				s.Status = Running
+				if err := b.Save(ctx, &s, time.Minute); err != nil {
+					t.Fatal(err)
+				}
+				if err := b.Append(ctx, s.ID, events(1, 2), time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+			owned, err := b.Streams(ctx, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(owned) != 2 {
+				t.Errorf("Streams(alice) = %+v, want a1 and a2", owned)
+			}
+
+			if err := b.Delete(ctx, "a1"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.Load(ctx, "a1"); !errors.Is(err, services.ErrNotFound) {
+				t.Errorf("Load() after Delete = %v, want not found", err)
+			}
+			if got, _ := b.Since(ctx, "a1", 0); len(got) != 0 {
+				t.Errorf("Since() after Delete = %q", ids(got))
+			}
+			if got, _ := b.Since(ctx, "a2", 0); ids(got) != "1,2" {
+				t.Errorf("Delete(a1) touched a2: %q", ids(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Sealer encrypts small secrets at rest with AES-256-GCM.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer builds a Sealer from a 32-byte key.
+func NewSealer(key []byte) (*Sealer, error) {
+	if len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// SealerFromEnv reads a base64-encoded 32-byte key from the named variable.
+// It returns nil, nil when the variable is unset.
+func SealerFromEnv(name string) (*Sealer, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid base64: %w", name, err)
+	}
+	return NewSealer(key)
+}
+
+// Seal encrypts plaintext; the random nonce is prepended to the result.
+// additionalData binds the ciphertext to a context such as a user or session ID.
+func (s *Sealer) Seal(plaintext, additionalData []byte) []byte {
+	nonce := make([]byte, s.aead.NonceSize())
+	_, _ = rand.Read(nonce)
+	return s.aead.Seal(nonce, nonce, plaintext, additionalData)
+}
+
+// Open reverses Seal.
+func (s *Sealer) Open(ciphertext, additionalData []byte) ([]byte, error) {
+	n := s.aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, errors.New("ciphertext too short")
+	}
+	return s.aead.Open(nil, ciphertext[:n], ciphertext[n:], additionalData)
+}
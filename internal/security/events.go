@@ -0,0 +1,112 @@
+package security
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"wodge/internal/audit"
+	"wodge/internal/logging"
+	"wodge/internal/monitor"
+	"wodge/internal/requestid"
+)
+
+// Severity levels for security events
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Security event actions
+const (
+	ActionLogin        = "auth.login"
+	ActionLoginFailed  = "auth.login_failed"
+	ActionLoginBlocked = "auth.login_blocked"
+	ActionLockout      = "auth.lockout"
+	ActionLogout       = "auth.logout"
+
+	ActionRegister       = "auth.register"
+	ActionRegisterFailed = "auth.register_failed"
+
+	ActionTokenRefreshed = "auth.token_refreshed"
+	ActionRefreshReuse   = "auth.refresh_token_reuse"
+	ActionSessionRevoked = "auth.session_revoked"
+
+	ActionMFAFailed   = "auth.mfa_failed"
+	ActionMFAEnrolled = "auth.mfa_enrolled"
+	ActionMFADisabled = "auth.mfa_disabled"
+)
+
+// Event is the structured payload published on the monitor bus for
+// security-relevant activity. It is shaped for NIS2 incident detection.
+type Event struct {
+	Action      string     `json:"action"`
+	Severity    string     `json:"severity"`
+	Username    string     `json:"username,omitempty"`
+	UserID      string     `json:"user_id,omitempty"`
+	SessionID   string     `json:"session_id,omitempty"`
+	IP          string     `json:"ip,omitempty"`
+	Attempts    int        `json:"attempts,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	RequestID   string     `json:"request_id,omitempty"`
+}
+
+// Emit publishes a security event to the monitor bus and the process log,
+// tagged with the request ID carried by ctx.
+func Emit(ctx context.Context, ev Event) {
+	if ev.RequestID == "" {
+		ev.RequestID = requestid.FromContext(ctx)
+	}
+	monitor.Bus.PublishContext(ctx, monitor.TypeSecurity, ev)
+	audit.Record(ctx, auditEntry(ev))
+
+	level := slog.LevelInfo
+	switch ev.Severity {
+	case SeverityWarning:
+		level = slog.LevelWarn
+	case SeverityCritical:
+		level = slog.LevelError
+	}
+	attrs := []slog.Attr{slog.String("action", ev.Action), slog.String("severity", ev.Severity)}
+	for _, a := range []slog.Attr{
+		slog.String("user_id", ev.UserID), slog.String("username", ev.Username),
+		slog.String("session_id", ev.SessionID), slog.String("ip", ev.IP), slog.String("reason", ev.Reason),
+	} {
+		if a.Value.String() != "" {
+			attrs = append(attrs, a)
+		}
+	}
+	if ev.Attempts > 0 {
+		attrs = append(attrs, slog.Int("attempts", ev.Attempts))
+	}
+	if ev.LockedUntil != nil {
+		attrs = append(attrs, slog.Time("locked_until", *ev.LockedUntil))
+	}
+	logging.For("security").LogAttrs(ctx, level, "Security event", attrs...)
+}
+
+func auditEntry(ev Event) audit.Entry {
+	details := map[string]interface{}{"severity": ev.Severity}
+	if ev.SessionID != "" {
+		details["session_id"] = ev.SessionID
+	}
+	if ev.Attempts > 0 {
+		details["attempts"] = ev.Attempts
+	}
+	if ev.LockedUntil != nil {
+		details["locked_until"] = ev.LockedUntil.UTC().Format(time.RFC3339)
+	}
+	if ev.Reason != "" {
+		details["reason"] = ev.Reason
+	}
+	return audit.Entry{
+		Action:    ev.Action,
+		Outcome:   audit.Outcome(ev.Action),
+		ActorID:   ev.UserID,
+		Actor:     ev.Username,
+		IP:        ev.IP,
+		RequestID: ev.RequestID,
+		Details:   details,
+	}
+}
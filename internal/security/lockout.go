@@ -0,0 +1,218 @@
+package security
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LockoutPolicy configures how failed logins are throttled and locked out.
+type LockoutPolicy struct {
+	MaxAttempts     int           // Failures per username before the account is locked
+	IPMaxAttempts   int           // Failures per client IP before the IP is locked
+	Window          time.Duration // Failures older than this are forgotten
+	LockoutDuration time.Duration // How long a lockout lasts
+	BaseDelay       time.Duration // Delay after the first failure, doubled per failure
+	MaxDelay        time.Duration // Upper bound for the progressive delay
+}
+
+// DefaultLockoutPolicy returns a conservative policy suitable for most apps.
+func DefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		MaxAttempts:     5,
+		IPMaxAttempts:   20,
+		Window:          15 * time.Minute,
+		LockoutDuration: 15 * time.Minute,
+		BaseDelay:       250 * time.Millisecond,
+		MaxDelay:        5 * time.Second,
+	}
+}
+
+// LockoutPolicyFromEnv reads the policy from AUTH_LOCKOUT_* variables,
+// falling back to DefaultLockoutPolicy for anything unset or invalid.
+func LockoutPolicyFromEnv() LockoutPolicy {
+	p := DefaultLockoutPolicy()
+	p.MaxAttempts = envInt("AUTH_LOCKOUT_MAX_ATTEMPTS", p.MaxAttempts)
+	p.IPMaxAttempts = envInt("AUTH_LOCKOUT_IP_MAX_ATTEMPTS", p.IPMaxAttempts)
+	p.Window = envDuration("AUTH_LOCKOUT_WINDOW", p.Window)
+	p.LockoutDuration = envDuration("AUTH_LOCKOUT_DURATION", p.LockoutDuration)
+	p.BaseDelay = envDuration("AUTH_LOCKOUT_BASE_DELAY", p.BaseDelay)
+	p.MaxDelay = envDuration("AUTH_LOCKOUT_MAX_DELAY", p.MaxDelay)
+	return p
+}
+
+type attemptRecord struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// LoginGuard tracks failed login attempts per username and per client IP.
+// It is enforced by wodge itself, independently of any limits in the auth backend.
+type LoginGuard struct {
+	policy  LockoutPolicy
+	mu      sync.Mutex
+	records map[string]*attemptRecord
+	calls   int
+	now     func() time.Time
+}
+
+// Failure describes the state after a failed attempt has been recorded.
+type Failure struct {
+	UserAttempts int
+	IPAttempts   int
+	Delay        time.Duration // Progressive delay to apply before responding
+	Locked       bool          // True if this failure triggered a lockout
+	LockedUntil  time.Time
+	LockedKey    string // "user" or "ip"
+}
+
+func NewLoginGuard(policy LockoutPolicy) *LoginGuard {
+	return &LoginGuard{
+		policy:  policy,
+		records: make(map[string]*attemptRecord),
+		now:     time.Now,
+	}
+}
+
+func userKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check reports whether the username or IP is currently locked out, and if so
+// how long the caller has to wait. Expired lockouts are cleared here.
+func (g *LoginGuard) Check(username, ip string) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	var wait time.Duration
+	for _, key := range []string{userKey(username), ipKey(ip)} {
+		rec, ok := g.records[key]
+		if !ok {
+			continue
+		}
+		if rec.lockedUntil.IsZero() {
+			continue
+		}
+		if now.Before(rec.lockedUntil) {
+			if d := rec.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+			continue
+		}
+		// Lockout timed out: start fresh
+		delete(g.records, key)
+	}
+	return wait, wait > 0
+}
+
+// Failure records a failed attempt for both the username and the IP.
+func (g *LoginGuard) Failure(username, ip string) Failure {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.calls++
+	if g.calls%256 == 0 {
+		g.prune(now)
+	}
+
+	user := g.bump(userKey(username), now)
+	addr := g.bump(ipKey(ip), now)
+
+	f := Failure{UserAttempts: user.failures, IPAttempts: addr.failures}
+
+	if g.policy.MaxAttempts > 0 && user.failures >= g.policy.MaxAttempts && user.lockedUntil.IsZero() {
+		user.lockedUntil = now.Add(g.policy.LockoutDuration)
+		f.Locked, f.LockedUntil, f.LockedKey = true, user.lockedUntil, "user"
+	}
+	if g.policy.IPMaxAttempts > 0 && addr.failures >= g.policy.IPMaxAttempts && addr.lockedUntil.IsZero() {
+		addr.lockedUntil = now.Add(g.policy.LockoutDuration)
+		if !f.Locked || addr.lockedUntil.After(f.LockedUntil) {
+			f.Locked, f.LockedUntil, f.LockedKey = true, addr.lockedUntil, "ip"
+		}
+	}
+
+	n := user.failures
+	if addr.failures > n {
+		n = addr.failures
+	}
+	f.Delay = g.delay(n)
+	return f
+}
+
+// Success clears the failure history for the username. The IP record is left
+// to decay on its own so a single valid account can't be used to reset spraying.
+func (g *LoginGuard) Success(username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.records, userKey(username))
+}
+
+// bump counts a failure for key, starting over once the window has passed
+// without a lockout or a lockout has ended. Check clears ended lockouts too,
+// but only for the keys it is asked about.
+func (g *LoginGuard) bump(key string, now time.Time) *attemptRecord {
+	rec, ok := g.records[key]
+	if ok && !rec.lockedUntil.IsZero() && !now.Before(rec.lockedUntil) {
+		ok = false
+	}
+	if !ok || (g.policy.Window > 0 && now.Sub(rec.last) > g.policy.Window && rec.lockedUntil.IsZero()) {
+		rec = &attemptRecord{}
+		g.records[key] = rec
+	}
+	rec.failures++
+	rec.last = now
+	return rec
+}
+
+func (g *LoginGuard) delay(failures int) time.Duration {
+	if failures <= 0 || g.policy.BaseDelay <= 0 {
+		return 0
+	}
+	d := g.policy.BaseDelay
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if g.policy.MaxDelay > 0 && d >= g.policy.MaxDelay {
+			return g.policy.MaxDelay
+		}
+	}
+	return d
+}
+
+// prune drops records whose window and lockout have both expired.
+func (g *LoginGuard) prune(now time.Time) {
+	for key, rec := range g.records {
+		if !rec.lockedUntil.IsZero() && now.Before(rec.lockedUntil) {
+			continue
+		}
+		if now.Sub(rec.last) > g.policy.Window {
+			delete(g.records, key)
+		}
+	}
+}
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
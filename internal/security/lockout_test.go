@@ -0,0 +1,112 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginGuard(t *testing.T) {
+	policy := LockoutPolicy{
+		MaxAttempts:     3,
+		IPMaxAttempts:   5,
+		Window:          time.Minute,
+		LockoutDuration: 10 * time.Minute,
+		BaseDelay:       100 * time.Millisecond,
+		MaxDelay:        300 * time.Millisecond,
+	}
+	type step struct {
+		after   time.Duration // Clock advance before the step
+		user    string
+		ip      string
+		success bool // Record a success instead of a failure
+		locked  bool // Whether Check reports a lockout afterwards
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"locks the user at MaxAttempts", []step{
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "b"},
+			{user: "alice", ip: "c", locked: true},
+		}},
+		{"username is case-insensitive", []step{
+			{user: "alice", ip: "a"},
+			{user: "Alice", ip: "a"},
+			{user: " ALICE ", ip: "a", locked: true},
+		}},
+		{"failures outside the window are forgotten", []step{
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "a"},
+			{after: 2 * time.Minute, user: "alice", ip: "a"},
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "a", locked: true},
+		}},
+		{"success resets the user count", []step{
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "a"},
+			{user: "alice", success: true},
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "a", locked: true},
+		}},
+		{"success doesn't reset the IP count", []step{
+			{user: "u1", ip: "a"},
+			{user: "u2", ip: "a"},
+			{user: "mine", success: true},
+			{user: "u3", ip: "a"},
+			{user: "u4", ip: "a"},
+			{user: "u5", ip: "a", locked: true},
+		}},
+		{"lockout ends after LockoutDuration", []step{
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "a", locked: true},
+			{after: 9 * time.Minute, user: "bob", ip: "b", locked: true},
+			{after: 2 * time.Minute, user: "alice", ip: "a"},
+		}},
+		{"failures during a lockout don't extend it", []step{
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "a", locked: true},
+			{after: 9 * time.Minute, user: "alice", ip: "a", locked: true},
+			{after: 2 * time.Minute, user: "bob", ip: "b"},
+		}},
+		{"count starts over after a lockout ends", []step{
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "a"},
+			{user: "alice", ip: "a", locked: true},
+			{after: 11 * time.Minute, user: "alice", ip: "b"},
+			{user: "alice", ip: "c"},
+			{user: "alice", ip: "d", locked: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1_700_000_000, 0)
+			g := NewLoginGuard(policy)
+			g.now = func() time.Time { return now }
+			first := tt.steps[0]
+			for i, s := range tt.steps {
+				now = now.Add(s.after)
+				if s.success {
+					g.Success(s.user)
+				} else {
+					g.Failure(s.user, s.ip)
+				}
+				if _, locked := g.Check(first.user, first.ip); locked != s.locked {
+					t.Fatalf("step %d: Check(%s, %s) locked = %v, want %v", i, first.user, first.ip, locked, s.locked)
+				}
+			}
+		})
+	}
+}
+
+func TestLoginGuardDelay(t *testing.T) {
+	g := NewLoginGuard(LockoutPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond})
+	for i, want := range []time.Duration{100, 200, 300, 300} {
+		if got := g.Failure("alice", "a").Delay; got != want*time.Millisecond {
+			t.Errorf("failure %d: delay = %v, want %v", i+1, got, want*time.Millisecond)
+		}
+	}
+}
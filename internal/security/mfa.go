@@ -0,0 +1,244 @@
+package security
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MFAEnrollment holds a user's second factors. Wodge keeps these itself so MFA
+// works even when the auth backend (AstAuth) has no MFA support.
+type MFAEnrollment struct {
+	UserID        string               `json:"user_id"`
+	TOTPSecret    string               `json:"totp_secret,omitempty"`
+	TOTPConfirmed bool                 `json:"totp_confirmed"`
+	LastTOTPStep  int64                `json:"last_totp_step"`
+	RecoveryCodes []string             `json:"recovery_codes,omitempty"` // SHA-256 hashes
+	WebAuthn      []WebAuthnCredential `json:"webauthn,omitempty"`
+	UpdatedAt     time.Time            `json:"updated_at"`
+
+	// A TOTP enrollment waiting for its first code. The secret and codes
+	// only replace the ones above once it is confirmed.
+	PendingTOTPSecret    string   `json:"pending_totp_secret,omitempty"`
+	PendingRecoveryCodes []string `json:"pending_recovery_codes,omitempty"`
+}
+
+// Active reports whether the user must pass a second factor at login.
+func (e *MFAEnrollment) Active() bool {
+	return e != nil && (e.TOTPConfirmed || len(e.WebAuthn) > 0)
+}
+
+// Methods lists the second factors available to the user.
+func (e *MFAEnrollment) Methods() []string {
+	var methods []string
+	if e.TOTPConfirmed {
+		methods = append(methods, "totp")
+	}
+	if len(e.WebAuthn) > 0 {
+		methods = append(methods, "webauthn")
+	}
+	if len(e.RecoveryCodes) > 0 {
+		methods = append(methods, "recovery")
+	}
+	return methods
+}
+
+// UseRecoveryCode consumes a matching recovery code.
+func (e *MFAEnrollment) UseRecoveryCode(code string) bool {
+	hash := HashRecoveryCode(code)
+	for i, h := range e.RecoveryCodes {
+		if h == hash {
+			e.RecoveryCodes = append(e.RecoveryCodes[:i], e.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// MFAStore persists enrollments.
+type MFAStore interface {
+	Get(userID string) (*MFAEnrollment, error)
+	Put(e *MFAEnrollment) error
+	Delete(userID string) error
+	// Update runs fn on the user's enrollment, nil if there is none, and
+	// stores what it returns in one step, deleting the enrollment for nil.
+	// Nothing is stored when fn fails. Checks that consume a factor run in
+	// fn, so two requests can't both pass with it.
+	Update(userID string, fn func(e *MFAEnrollment) (*MFAEnrollment, error)) error
+}
+
+// FileMFAStore keeps enrollments in a single JSON file, encrypted when a
+// Sealer is configured (MFA_ENCRYPTION_KEY).
+type FileMFAStore struct {
+	path   string
+	sealer *Sealer
+	mu     sync.Mutex
+}
+
+func NewFileMFAStore(path string, sealer *Sealer) *FileMFAStore {
+	return &FileMFAStore{path: path, sealer: sealer}
+}
+
+func (s *FileMFAStore) Get(userID string) (*MFAEnrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return all[userID], nil
+}
+
+func (s *FileMFAStore) Put(e *MFAEnrollment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	e.UpdatedAt = time.Now()
+	all[e.UserID] = e
+	return s.save(all)
+}
+
+func (s *FileMFAStore) Delete(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	delete(all, userID)
+	return s.save(all)
+}
+
+func (s *FileMFAStore) Update(userID string, fn func(e *MFAEnrollment) (*MFAEnrollment, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	e, err := fn(all[userID])
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		delete(all, userID)
+	} else {
+		e.UserID, e.UpdatedAt = userID, time.Now()
+		all[userID] = e
+	}
+	return s.save(all)
+}
+
+func (s *FileMFAStore) load() (map[string]*MFAEnrollment, error) {
+	all := make(map[string]*MFAEnrollment)
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.sealer != nil {
+		if data, err = s.sealer.Open(data, []byte("mfa")); err != nil {
+			return nil, errors.New("failed to decrypt MFA store")
+		}
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (s *FileMFAStore) save(all map[string]*MFAEnrollment) error {
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	if s.sealer != nil {
+		data = s.sealer.Seal(data, []byte("mfa"))
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// PendingLogin is a password-verified login waiting for its second factor.
+type PendingLogin struct {
+	UserID    string
+	Username  string
+	Challenge string // WebAuthn challenge for this login
+	Attempts  int
+	ExpiresAt time.Time
+	Session   interface{} // The auth response to release once MFA passes
+}
+
+// MFAChallenges holds pending logins between the password and MFA steps.
+type MFAChallenges struct {
+	mu          sync.Mutex
+	pending     map[string]*PendingLogin
+	ttl         time.Duration
+	maxAttempts int
+}
+
+func NewMFAChallenges(ttl time.Duration, maxAttempts int) *MFAChallenges {
+	return &MFAChallenges{pending: make(map[string]*PendingLogin), ttl: ttl, maxAttempts: maxAttempts}
+}
+
+// Create stores a pending login and returns its opaque MFA token.
+func (m *MFAChallenges) Create(p *PendingLogin) string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for k, v := range m.pending {
+		if now.After(v.ExpiresAt) {
+			delete(m.pending, k)
+		}
+	}
+	p.ExpiresAt = now.Add(m.ttl)
+	m.pending[token] = p
+	return token
+}
+
+// Take removes the pending login and returns it, or nil if it is unknown
+// or expired. Only one request can take a token, so a challenge is answered
+// once; Retry puts it back after a wrong answer.
+func (m *MFAChallenges) Take(token string) *PendingLogin {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.pending[token]
+	delete(m.pending, token)
+	if !ok || time.Now().After(p.ExpiresAt) {
+		return nil
+	}
+	return p
+}
+
+// Retry counts a wrong answer to a taken challenge and puts it back under
+// its token until the attempts run out.
+func (m *MFAChallenges) Retry(token string, p *PendingLogin) (remaining int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p.Attempts++
+	if p.Attempts >= m.maxAttempts {
+		return 0
+	}
+	m.pending[token] = p
+	return m.maxAttempts - p.Attempts
+}
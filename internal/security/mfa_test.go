@@ -0,0 +1,72 @@
+package security
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMFAChallengesTakeAndRetry(t *testing.T) {
+	m := NewMFAChallenges(time.Minute, 3)
+	token := m.Create(&PendingLogin{UserID: "u1"})
+
+	p := m.Take(token)
+	if p == nil {
+		t.Fatal("Take() = nil for a new challenge")
+	}
+	if m.Take(token) != nil {
+		t.Fatal("a challenge was taken twice")
+	}
+	for i, want := range []int{2, 1, 0} {
+		if got := m.Retry(token, p); got != want {
+			t.Errorf("Retry %d = %d remaining, want %d", i+1, got, want)
+		}
+		if again := m.Take(token); (again != nil) != (want > 0) {
+			t.Errorf("after Retry %d: Take() = %v", i+1, again)
+		}
+	}
+
+	expired := NewMFAChallenges(-time.Second, 3)
+	if expired.Take(expired.Create(&PendingLogin{})) != nil {
+		t.Error("Take() returned an expired challenge")
+	}
+}
+
+func TestFileMFAStoreUpdate(t *testing.T) {
+	s := NewFileMFAStore(filepath.Join(t.TempDir(), "mfa.json"), nil)
+	add := func(e *MFAEnrollment) (*MFAEnrollment, error) {
+		if e == nil {
+			e = &MFAEnrollment{}
+		}
+		e.RecoveryCodes = append(e.RecoveryCodes, "h")
+		return e, nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.Update("u1", add); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e, _ := s.Get("u1"); e == nil || e.UserID != "u1" || len(e.RecoveryCodes) != 2 {
+		t.Fatalf("after two updates: %+v", e)
+	}
+
+	refused := errors.New("refused")
+	err := s.Update("u1", func(e *MFAEnrollment) (*MFAEnrollment, error) {
+		e.RecoveryCodes = nil
+		return nil, refused
+	})
+	if !errors.Is(err, refused) {
+		t.Errorf("Update() = %v, want the callback's error", err)
+	}
+	if e, _ := s.Get("u1"); e == nil || len(e.RecoveryCodes) != 2 {
+		t.Errorf("a failed update was stored: %+v", e)
+	}
+
+	if err := s.Update("u1", func(*MFAEnrollment) (*MFAEnrollment, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := s.Get("u1"); e != nil {
+		t.Errorf("Update() returning nil kept %+v", e)
+	}
+}
@@ -0,0 +1,192 @@
+package security
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"wodge/internal/logging"
+	"wodge/internal/services"
+)
+
+// Character classes a password policy can require
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// PasswordPolicy configures the server-side checks applied on registration.
+type PasswordPolicy struct {
+	MinLength        int
+	MaxLength        int
+	RequiredClasses  []string // Any of ClassLower, ClassUpper, ClassDigit, ClassSymbol
+	DisallowIdentity bool     // Reject passwords containing the username or email parts
+
+	// BreachDir holds the offline breached-password list, split into files
+	// named by the first 5 hex chars of the SHA-1 (e.g. "5BAA6" or "5BAA6.txt")
+	// with one "SUFFIX:COUNT" line per hash, the same layout as the HIBP range API.
+	BreachDir string
+}
+
+// DefaultPasswordPolicy follows current NIST guidance: length over complexity.
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:        12,
+		MaxLength:        128,
+		DisallowIdentity: true,
+	}
+}
+
+// PasswordPolicyFromEnv reads the policy from PASSWORD_* variables, falling
+// back to DefaultPasswordPolicy for anything unset or invalid.
+func PasswordPolicyFromEnv() PasswordPolicy {
+	p := DefaultPasswordPolicy()
+	p.MinLength = envInt("PASSWORD_MIN_LENGTH", p.MinLength)
+	p.MaxLength = envInt("PASSWORD_MAX_LENGTH", p.MaxLength)
+	for _, class := range strings.Split(os.Getenv("PASSWORD_REQUIRE_CLASSES"), ",") {
+		if class = strings.ToLower(strings.TrimSpace(class)); class != "" {
+			p.RequiredClasses = append(p.RequiredClasses, class)
+		}
+	}
+	if os.Getenv("PASSWORD_ALLOW_IDENTITY") == "true" {
+		p.DisallowIdentity = false
+	}
+	p.BreachDir = os.Getenv("PASSWORD_BREACH_DIR")
+	return p
+}
+
+// Validate checks a new password and returns one FieldError per failed rule.
+func (p PasswordPolicy) Validate(password, confirm, username, email string) []services.FieldError {
+	var errs []services.FieldError
+	add := func(field, code, format string, args ...interface{}) {
+		errs = append(errs, services.FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if password != confirm {
+		add("confirm_password", "mismatch", "Passwords do not match")
+	}
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add("password", "too_short", "Password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add("password", "too_long", "Password must be at most %d characters", p.MaxLength)
+	}
+	for _, class := range p.RequiredClasses {
+		if !hasClass(password, class) {
+			add("password", "missing_"+class, "Password must contain at least one %s", classNames[class])
+		}
+	}
+	if p.DisallowIdentity {
+		if fragment := identityFragment(password, username, email); fragment != "" {
+			add("password", "contains_identity", "Password must not contain your %s", fragment)
+		}
+	}
+	if p.BreachDir != "" && len(errs) == 0 {
+		breached, err := p.Breached(password)
+		if err != nil {
+			// Fail open: a missing list must not block every registration
+			logging.For("security").Warn("Breached-password check skipped", "error", err)
+		} else if breached {
+			add("password", "breached", "This password has appeared in a data breach, choose a different one")
+		}
+	}
+	return errs
+}
+
+var classNames = map[string]string{
+	ClassLower:  "lowercase letter",
+	ClassUpper:  "uppercase letter",
+	ClassDigit:  "digit",
+	ClassSymbol: "symbol",
+}
+
+func hasClass(password, class string) bool {
+	for _, r := range password {
+		switch class {
+		case ClassLower:
+			if unicode.IsLower(r) {
+				return true
+			}
+		case ClassUpper:
+			if unicode.IsUpper(r) {
+				return true
+			}
+		case ClassDigit:
+			if unicode.IsDigit(r) {
+				return true
+			}
+		case ClassSymbol:
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) {
+				return true
+			}
+		default:
+			return true // Unknown classes are ignored
+		}
+	}
+	return false
+}
+
+// identityFragment names the part of the user's identity found in the password.
+// Fragments shorter than 3 characters are ignored to avoid false positives.
+func identityFragment(password, username, email string) string {
+	lower := strings.ToLower(password)
+	contains := func(s string) bool {
+		s = strings.ToLower(s)
+		return len(s) >= 3 && strings.Contains(lower, s)
+	}
+	if contains(username) {
+		return "username"
+	}
+	local, domain, _ := strings.Cut(email, "@")
+	for _, part := range strings.FieldsFunc(local, func(r rune) bool { return r == '.' || r == '_' || r == '-' || r == '+' }) {
+		if contains(part) {
+			return "email address"
+		}
+	}
+	if label, _, _ := strings.Cut(domain, "."); contains(label) {
+		return "email address"
+	}
+	return ""
+}
+
+// Breached looks the password's SHA-1 up in the offline prefix files. Only the
+// file for the 5-char prefix is read, so the full list never has to fit in memory.
+func (p PasswordPolicy) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(p.BreachDir, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(p.BreachDir, prefix+".txt"))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		// No file for the prefix means no known breached hash starts with it
+		if _, statErr := os.Stat(p.BreachDir); statErr != nil {
+			return false, statErr
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,59 @@
+package security
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	// SHA-1 prefix files: "password" is listed, "correct horse battery" only
+	// with a count of 0 (a padding entry in the HIBP format)
+	breaches := t.TempDir()
+	if err := os.WriteFile(filepath.Join(breaches, "5BAA6.txt"), []byte("1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(breaches, "98DEC"), []byte("c62ece399a22ed30d490ef333be7fde7385:0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		confirm  string
+		want     []string // Error codes, in order
+	}{
+		{"long enough", DefaultPasswordPolicy(), "correct horse battery", "", nil},
+		{"too short", DefaultPasswordPolicy(), "short", "", []string{"too_short"}},
+		{"length counts runes", PasswordPolicy{MinLength: 4}, "ææææ", "", nil},
+		{"too long", PasswordPolicy{MaxLength: 8}, "much too long", "", []string{"too_long"}},
+		{"mismatch", DefaultPasswordPolicy(), "correct horse battery", "correct horse", []string{"mismatch"}},
+		{"missing classes", PasswordPolicy{RequiredClasses: []string{ClassUpper, ClassDigit, ClassSymbol}}, "lowercase", "",
+			[]string{"missing_upper", "missing_digit", "missing_symbol"}},
+		{"has classes", PasswordPolicy{RequiredClasses: []string{ClassLower, ClassUpper, ClassDigit, ClassSymbol}}, "aB3!", "", nil},
+		{"contains username", DefaultPasswordPolicy(), "xx-Alice2024-yy", "", []string{"contains_identity"}},
+		{"contains email part", DefaultPasswordPolicy(), "smith is my name", "", []string{"contains_identity"}},
+		{"contains email domain", DefaultPasswordPolicy(), "i work at examplecorp", "", []string{"contains_identity"}},
+		{"identity allowed", PasswordPolicy{}, "alice alice alice", "", nil},
+		{"breached", PasswordPolicy{BreachDir: breaches}, "password", "", []string{"breached"}},
+		{"breached with count 0", PasswordPolicy{BreachDir: breaches}, "correct horse battery", "", nil},
+		{"breach list missing fails open", PasswordPolicy{BreachDir: filepath.Join(breaches, "missing")}, "password", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			confirm := tt.confirm
+			if confirm == "" {
+				confirm = tt.password
+			}
+			var got []string
+			for _, e := range tt.policy.Validate(tt.password, confirm, "alice", "a.smith@examplecorp.com") {
+				got = append(got, e.Code)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Validate(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
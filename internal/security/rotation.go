@@ -0,0 +1,157 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRefreshReuse means a refresh token that was already rotated was presented again.
+	ErrRefreshReuse = errors.New("refresh token reuse detected")
+	// ErrSessionRevoked means the token belongs to a session family that was revoked.
+	ErrSessionRevoked = errors.New("session revoked")
+)
+
+// Family is a chain of refresh tokens descending from one login.
+type Family struct {
+	ID        string
+	UserID    string
+	CreatedAt time.Time
+	LastUsed  time.Time
+	Revoked   bool
+
+	// Latest tokens, kept so a detected reuse can revoke them upstream
+	AccessToken  string
+	RefreshToken string
+}
+
+// SessionFamilies detects refresh-token reuse. Every refresh token is linked to
+// the family it was issued in; presenting any token other than the family's
+// newest one revokes the whole family.
+type SessionFamilies struct {
+	mu       sync.Mutex
+	families map[string]*Family
+	tokens   map[string]string // token hash -> family ID
+	ttl      time.Duration
+}
+
+func NewSessionFamilies(ttl time.Duration) *SessionFamilies {
+	return &SessionFamilies{
+		families: make(map[string]*Family),
+		tokens:   make(map[string]string),
+		ttl:      ttl,
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Start registers a new family for a fresh login.
+func (s *SessionFamilies) Start(userID, accessToken, refreshToken string) *Family {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	return s.start(userID, accessToken, refreshToken)
+}
+
+func (s *SessionFamilies) start(userID, accessToken, refreshToken string) *Family {
+	now := time.Now()
+	fam := &Family{
+		ID:           newFamilyID(),
+		UserID:       userID,
+		CreatedAt:    now,
+		LastUsed:     now,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}
+	s.families[fam.ID] = fam
+	if refreshToken != "" {
+		s.tokens[hashToken(refreshToken)] = fam.ID
+	}
+	return fam
+}
+
+// Check validates a refresh token before it is sent upstream. On reuse the
+// family is revoked and returned together with ErrRefreshReuse so the caller
+// can revoke its tokens. Unknown tokens (e.g. issued before a restart) pass.
+func (s *SessionFamilies) Check(refreshToken string) (*Family, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	famID, ok := s.tokens[hashToken(refreshToken)]
+	if !ok {
+		return nil, nil
+	}
+	fam, ok := s.families[famID]
+	if !ok {
+		return nil, nil
+	}
+	if fam.Revoked {
+		return fam, ErrSessionRevoked
+	}
+	if fam.RefreshToken != refreshToken {
+		fam.Revoked = true
+		snapshot := *fam
+		fam.AccessToken, fam.RefreshToken = "", ""
+		return &snapshot, ErrRefreshReuse
+	}
+	return fam, nil
+}
+
+// Rotate records the tokens issued in exchange for oldRefresh. Tokens the
+// server has never seen start a new family.
+func (s *SessionFamilies) Rotate(userID, oldRefresh, accessToken, refreshToken string) *Family {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	famID, ok := s.tokens[hashToken(oldRefresh)]
+	fam := s.families[famID]
+	if !ok || fam == nil || fam.Revoked {
+		return s.start(userID, accessToken, refreshToken)
+	}
+	fam.LastUsed = time.Now()
+	fam.AccessToken = accessToken
+	if refreshToken != "" {
+		fam.RefreshToken = refreshToken
+		s.tokens[hashToken(refreshToken)] = fam.ID
+	}
+	return fam
+}
+
+// End forgets the family owning refreshToken, e.g. on logout.
+func (s *SessionFamilies) End(refreshToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if famID, ok := s.tokens[hashToken(refreshToken)]; ok {
+		s.dropFamily(famID)
+	}
+}
+
+func (s *SessionFamilies) prune(now time.Time) {
+	for id, fam := range s.families {
+		if now.Sub(fam.LastUsed) > s.ttl {
+			s.dropFamily(id)
+		}
+	}
+}
+
+func (s *SessionFamilies) dropFamily(famID string) {
+	delete(s.families, famID)
+	for hash, id := range s.tokens {
+		if id == famID {
+			delete(s.tokens, hash)
+		}
+	}
+}
+
+func newFamilyID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
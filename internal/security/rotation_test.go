@@ -0,0 +1,85 @@
+package security
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionFamiliesDetectReuse(t *testing.T) {
+	// Each family starts with login tokens a1/r1; rotations name the refresh
+	// token they exchange and the one they issue.
+	type rotation struct{ old, next string }
+	tests := []struct {
+		name       string
+		rotations  []rotation
+		present    string
+		wantErr    error
+		wantFamily bool // Whether Check returns the family
+	}{
+		{"current token after login", nil, "r1", nil, true},
+		{"current token after rotations", []rotation{{"r1", "r2"}, {"r2", "r3"}}, "r3", nil, true},
+		{"rotated token", []rotation{{"r1", "r2"}}, "r1", ErrRefreshReuse, true},
+		{"older rotated token", []rotation{{"r1", "r2"}, {"r2", "r3"}}, "r2", ErrRefreshReuse, true},
+		{"upstream kept the refresh token", []rotation{{"r1", ""}}, "r1", nil, true},
+		{"unknown token", []rotation{{"r1", "r2"}}, "other", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSessionFamilies(time.Hour)
+			s.Start("u1", "a1", "r1")
+			for _, r := range tt.rotations {
+				s.Rotate("u1", r.old, "a-"+r.next, r.next)
+			}
+			fam, err := s.Check(tt.present)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check(%s) error = %v, want %v", tt.present, err, tt.wantErr)
+			}
+			if (fam != nil) != tt.wantFamily {
+				t.Errorf("Check(%s) family = %v, want one: %v", tt.present, fam, tt.wantFamily)
+			}
+		})
+	}
+}
+
+// A reuse revokes the whole family: the thief's and the owner's tokens stop
+// working, and the caller gets the latest tokens to revoke upstream.
+func TestSessionFamiliesReuseRevokesFamily(t *testing.T) {
+	s := NewSessionFamilies(time.Hour)
+	s.Start("u1", "a1", "r1")
+	s.Rotate("u1", "r1", "a2", "r2")
+	other := s.Start("u1", "b1", "q1")
+
+	fam, err := s.Check("r1")
+	if !errors.Is(err, ErrRefreshReuse) {
+		t.Fatalf("Check(r1) error = %v, want ErrRefreshReuse", err)
+	}
+	if fam.AccessToken != "a2" || fam.RefreshToken != "r2" {
+		t.Errorf("reuse returned tokens %q/%q, want the latest a2/r2", fam.AccessToken, fam.RefreshToken)
+	}
+	for _, token := range []string{"r1", "r2"} {
+		if _, err := s.Check(token); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("Check(%s) after reuse = %v, want ErrSessionRevoked", token, err)
+		}
+	}
+	if f, err := s.Check("q1"); err != nil || f.ID != other.ID {
+		t.Errorf("Check(q1) = %v, %v; another login's family must be unaffected", f, err)
+	}
+
+	// Rotating a token of a revoked family starts over rather than reviving it
+	if fresh := s.Rotate("u1", "r2", "a3", "r3"); fresh.ID == fam.ID {
+		t.Error("Rotate revived a revoked family")
+	}
+}
+
+func TestSessionFamiliesEndForgetsFamily(t *testing.T) {
+	s := NewSessionFamilies(time.Hour)
+	s.Start("u1", "a1", "r1")
+	s.Rotate("u1", "r1", "a2", "r2")
+	s.End("r2")
+	for _, token := range []string{"r1", "r2"} {
+		if fam, err := s.Check(token); fam != nil || err != nil {
+			t.Errorf("Check(%s) after End = %v, %v, want unknown", token, fam, err)
+		}
+	}
+}
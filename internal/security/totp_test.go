@@ -0,0 +1,55 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+// The RFC 6238 SHA-1 test key, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTP(t *testing.T) {
+	// RFC 6238 gives 94287082 at T=59, step 1; authenticators show the last 6 digits
+	at59 := time.Unix(59, 0)
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		now      time.Time
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"RFC 6238 vector", rfcSecret, "287082", at59, 0, 1, true},
+		{"lower case secret and spaced code", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "287 082", at59, 0, 1, true},
+		{"one step late", rfcSecret, "287082", time.Unix(60+29, 0), 0, 1, true},
+		{"two steps late", rfcSecret, "287082", time.Unix(90, 0), 0, 0, false},
+		{"one step early", rfcSecret, "287082", time.Unix(0, 0), -1, 1, true},
+		{"replayed step", rfcSecret, "287082", at59, 1, 0, false},
+		{"step before the last one", rfcSecret, "287082", time.Unix(60, 0), 1, 0, false},
+		{"wrong code", rfcSecret, "287083", at59, 0, 0, false},
+		{"short code", rfcSecret, "28708", at59, 0, 0, false},
+		{"empty code", rfcSecret, "", at59, 0, 0, false},
+		{"invalid secret", "not base32!", "287082", at59, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(tt.secret, tt.code, tt.now, tt.lastStep)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("ValidateTOTP() = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+// An empty or short secret must not validate, even with the code its key
+// produces.
+func TestValidateTOTPRejectsWeakSecrets(t *testing.T) {
+	now := time.Now()
+	for _, key := range [][]byte{nil, []byte("0123456789")} {
+		code := hotp(key, now.Unix()/totpPeriod)
+		if _, ok := ValidateTOTP(totpEncoding.EncodeToString(key), code, now, 0); ok {
+			t.Errorf("ValidateTOTP accepted a %d-byte secret", len(key))
+		}
+	}
+}
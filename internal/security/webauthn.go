@@ -0,0 +1,150 @@
+package security
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+// WebAuthnConfig identifies the relying party (the wodge app).
+type WebAuthnConfig struct {
+	RPID   string // e.g. "localhost" or "app.example.com"
+	RPName string
+	Origin string // e.g. "http://localhost:5173"
+}
+
+// WebAuthnCredential is a registered authenticator. The public key is the
+// SubjectPublicKeyInfo DER from AuthenticatorAttestationResponse.getPublicKey(),
+// which avoids parsing CBOR attestation objects server-side.
+type WebAuthnCredential struct {
+	ID        string `json:"id"`         // base64url credential ID
+	PublicKey string `json:"public_key"` // base64url SPKI DER
+	Algorithm int    `json:"algorithm"`  // COSE algorithm (-7 ES256, -8 EdDSA, -257 RS256)
+	SignCount uint32 `json:"sign_count"`
+	Name      string `json:"name,omitempty"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// NewWebAuthnChallenge returns a random base64url challenge.
+func NewWebAuthnChallenge() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// VerifyRegistration checks a navigator.credentials.create() response
+// and returns the credential to store.
+func (cfg WebAuthnConfig) VerifyRegistration(challenge, credentialID string, clientDataJSON, authenticatorData, publicKey []byte, alg int) (*WebAuthnCredential, error) {
+	if err := cfg.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.verifyAuthenticatorData(authenticatorData); err != nil {
+		return nil, err
+	}
+	if _, err := x509.ParsePKIXPublicKey(publicKey); err != nil {
+		return nil, errors.New("unsupported credential public key")
+	}
+	return &WebAuthnCredential{
+		ID:        credentialID,
+		PublicKey: base64.RawURLEncoding.EncodeToString(publicKey),
+		Algorithm: alg,
+	}, nil
+}
+
+// VerifyAssertion checks a navigator.credentials.get() response against a
+// stored credential and returns the new signature counter.
+func (cfg WebAuthnConfig) VerifyAssertion(cred WebAuthnCredential, challenge string, clientDataJSON, authenticatorData, signature []byte) (uint32, error) {
+	if err := cfg.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	count, err := cfg.verifyAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	// A counter that doesn't move forward suggests a cloned authenticator
+	if cred.SignCount > 0 && count <= cred.SignCount {
+		return 0, errors.New("authenticator signature counter did not increase")
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return 0, err
+	}
+
+	cdHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authenticatorData...), cdHash[:]...)
+	digest := sha256.Sum256(signed)
+
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return 0, errors.New("invalid assertion signature")
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return 0, errors.New("invalid assertion signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, signed, signature) {
+			return 0, errors.New("invalid assertion signature")
+		}
+	default:
+		return 0, errors.New("unsupported credential key type")
+	}
+	return count, nil
+}
+
+func (cfg WebAuthnConfig) verifyClientData(raw []byte, wantType, challenge string) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return errors.New("malformed client data")
+	}
+	if cd.Type != wantType {
+		return errors.New("unexpected client data type")
+	}
+	if cd.Challenge != challenge {
+		return errors.New("challenge mismatch")
+	}
+	// Without a configured origin any site could relay the ceremony
+	if cfg.Origin == "" {
+		return errors.New("WebAuthn origin is not configured")
+	}
+	if cd.Origin != cfg.Origin {
+		return errors.New("origin mismatch")
+	}
+	return nil
+}
+
+// verifyAuthenticatorData checks the RP ID hash and user-presence flag and
+// returns the signature counter.
+func (cfg WebAuthnConfig) verifyAuthenticatorData(data []byte) (uint32, error) {
+	if len(data) < 37 {
+		return 0, errors.New("authenticator data too short")
+	}
+	rpHash := sha256.Sum256([]byte(cfg.RPID))
+	if !bytes.Equal(data[:32], rpHash[:]) {
+		return 0, errors.New("relying party mismatch")
+	}
+	if data[32]&0x01 == 0 {
+		return 0, errors.New("user presence not asserted")
+	}
+	return binary.BigEndian.Uint32(data[33:37]), nil
+}
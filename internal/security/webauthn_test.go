@@ -0,0 +1,27 @@
+package security
+
+import "testing"
+
+func TestWebAuthnClientDataOrigin(t *testing.T) {
+	const challenge = "c2hhbGxub3RwYXNz"
+	raw := []byte(`{"type":"webauthn.get","challenge":"` + challenge + `","origin":"https://app.example.com"}`)
+
+	tests := []struct {
+		name    string
+		origin  string
+		wantErr bool
+	}{
+		{"matching origin", "https://app.example.com", false},
+		{"other origin", "https://evil.example", true},
+		{"origin not configured", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := WebAuthnConfig{RPID: "app.example.com", Origin: tt.origin}
+			err := cfg.verifyClientData(raw, "webauthn.get", challenge)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyClientData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
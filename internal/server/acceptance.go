@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+	"wodge/internal/acceptance"
+	"wodge/internal/audit"
+)
+
+var (
+	acceptList *acceptance.List
+	acceptMode = acceptance.ModeWarn
+)
+
+// versionProber is implemented by drivers that can ask the server for its
+// version once connected.
+type versionProber interface {
+	ServerVersion(ctx context.Context) (string, error)
+}
+
+// initAcceptance checks the configured services against the signed
+// acceptance list before anything connects to them. Services that report
+// their version are checked again by acceptVersion once connected.
+func initAcceptance() {
+	cfg, err := acceptance.FromEnv()
+	if err != nil {
+		slog.Error("Invalid acceptance config, warning only", "error", err)
+	}
+	acceptMode = cfg.Mode
+	if cfg.Mode == acceptance.ModeOff {
+		slog.Info("ACCEPTANCE_MODE is off, services are not checked")
+		return
+	}
+
+	list, err := acceptance.Load(cfg.Path, cfg.PublicKey)
+	if err == nil {
+		err = acceptance.CheckRollback(filepath.Join(".wodge", "acceptance.version"), list)
+	}
+	if err != nil {
+		msg := "Service acceptance list is unusable, services are not checked"
+		if errors.Is(err, fs.ErrNotExist) {
+			if len(acceptance.ServicesFromEnv()) == 0 {
+				return
+			}
+			msg = "No service acceptance list, services are not checked"
+		}
+		acceptanceFailed(msg, "path", cfg.Path, "error", err)
+		return
+	}
+	acceptList = list
+	slog.Info("Service acceptance list loaded", "version", list.Version, "issuer", list.Issuer, "mode", cfg.Mode)
+
+	for _, s := range acceptance.ServicesFromEnv() {
+		if v := list.Check(s); v != nil {
+			rejectService(s, *v)
+			continue
+		}
+		if !acceptance.ReportsVersion(s.Kind) {
+			if v := list.CheckVersion(s, s.Declared); v != nil {
+				rejectService(s, *v)
+			}
+		}
+	}
+}
+
+// acceptVersion checks the version a freshly connected service reports.
+func acceptVersion(kind string, driver interface{}) {
+	if acceptList == nil {
+		return
+	}
+	p, ok := driver.(versionProber)
+	if !ok {
+		return
+	}
+	for _, s := range acceptance.ServicesFromEnv() {
+		if s.Kind != kind {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		version, err := p.ServerVersion(ctx)
+		cancel()
+		if err != nil {
+			slog.Warn("Could not read service version", "kind", kind, "error", err)
+		}
+		if v := acceptList.CheckVersion(s, version); v != nil {
+			rejectService(s, *v)
+		} else if version != "" {
+			slog.Debug("Service version accepted", "kind", kind, "version", version)
+		}
+	}
+}
+
+// rejectService audits a service the list doesn't accept. In strict mode
+// the server stops, otherwise it carries on with a warning.
+func rejectService(s acceptance.Service, v acceptance.Violation) {
+	audit.Record(context.Background(), audit.Entry{
+		Action:   "service.rejected",
+		Outcome:  audit.Failure,
+		Resource: s.Setting,
+		Details:  map[string]interface{}{"kind": v.Kind, "host": v.Host, "reason": v.Reason, "mode": acceptMode},
+	})
+	acceptanceFailed("Service is not on the acceptance list", "kind", v.Kind, "host", v.Host, "setting", s.Setting, "reason", v.Reason)
+}
+
+func acceptanceFailed(msg string, args ...any) {
+	if acceptMode == acceptance.ModeStrict {
+		slog.Error(msg+" (ACCEPTANCE_MODE=strict)", args...)
+		os.Exit(1)
+	}
+	slog.Warn(msg, args...)
+}
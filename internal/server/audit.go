@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"wodge/internal/audit"
+	"wodge/internal/logging"
+	"wodge/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Settings that end up in the config.loaded audit entry
+var auditedEnvPrefixes = []string{
+	"WODGE_ENV", "AUTH_", "CORS_", "ASTAUTH_", "OIDC_", "MFA_", "WEBAUTHN_", "PASSWORD_",
+	"ACCEPTANCE_", "PII_", "INGEST_", "DATA_SUBJECT_", "DATA_RETENTION_", "LOG_", "MONITOR_", "INCIDENT_", "AUDIT_", "NIS2_", "REDACT_", "POSTGRES_", "REDIS_", "RABBITMQ_", "QAST_",
+}
+
+// auditDir is where the audit log is written, empty when auditing is off
+var auditDir string
+
+func initAudit() {
+	dir := os.Getenv("AUDIT_DIR")
+	if dir == "" {
+		dir = filepath.Join(".wodge", "audit")
+	}
+	key, err := audit.KeyFromEnv("AUDIT_HMAC_KEY")
+	if err != nil {
+		slog.Error("Invalid AUDIT_HMAC_KEY, audit entries will not be signed", "error", err)
+	}
+	maxSize := int64(10)
+	if v := os.Getenv("AUDIT_MAX_FILE_MB"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			maxSize = n
+		}
+	}
+
+	logger, err := audit.Open(dir, key, maxSize<<20)
+	if err != nil {
+		// The chain can't be continued, e.g. a truncated last entry.
+		// `wodge audit verify` shows what is wrong.
+		slog.Error("Failed to open audit log, auditing is disabled", "dir", dir, "error", err)
+		return
+	}
+	audit.SetDefault(logger)
+	auditDir = dir
+	recordConfig(logger)
+	slog.Info("Audit log enabled", "dir", dir, "signed", key != nil)
+}
+
+// recordConfig audits the effective configuration at startup, and which
+// settings changed since the previous start. Secrets are never written,
+// only whether they are set and a digest to notice when they change.
+func recordConfig(logger *audit.Logger) {
+	settings := map[string]interface{}{}
+	secrets := sha256.New()
+	env := os.Environ()
+	sort.Strings(env)
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		if !hasAnyPrefix(name, auditedEnvPrefixes) {
+			continue
+		}
+		if isSecretSetting(name) {
+			settings[name] = "[set]"
+			secrets.Write([]byte(kv + "\n"))
+			continue
+		}
+		settings[name] = value
+	}
+	digest := hex.EncodeToString(secrets.Sum(nil))
+
+	entry := audit.Entry{
+		Action:   audit.ActionConfigLoad,
+		Resource: "env",
+		Details:  map[string]interface{}{"settings": settings, "secrets_digest": digest},
+	}
+	prev, err := logger.LastOf(audit.ActionConfigLoad, audit.ActionConfigChange)
+	if err != nil {
+		logging.For("audit").Error("Failed to read previous config", "error", err)
+	}
+	if prev != nil {
+		if changed := changedSettings(prev.Details, settings, digest); len(changed) > 0 {
+			entry.Action = audit.ActionConfigChange
+			entry.Details["changed"] = changed
+		}
+	}
+	if err := logger.Record(context.Background(), entry); err != nil {
+		logging.For("audit").Error("Failed to record config", "error", err)
+	}
+}
+
+func changedSettings(prev map[string]interface{}, settings map[string]interface{}, digest string) []string {
+	var changed []string
+	old, _ := prev["settings"].(map[string]interface{})
+	for name, value := range settings {
+		if old[name] != value {
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := settings[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	if prev["secrets_digest"] != digest {
+		changed = append(changed, "[secrets]")
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSecretSetting(name string) bool {
+	for _, suffix := range []string{"_SECRET", "_KEY", "_PASSWORD", "_DSN", "_TOKEN"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	// AMQP URLs carry credentials
+	return name == "RABBITMQ_URL"
+}
+
+// Routes whose successful reads are audited too, since they return user data
+var auditedReadPrefixes = []string{
+	"/api/qast/", "/api/pii/", "/api/postgres/", "/api/admin/", "/api/history/", "/api/context/", "/api/users/",
+}
+
+// auditAccess records changes made through the service routes, as admin
+// actions when an admin made them, successful reads of the routes serving
+// user data, and any request refused for lack of authentication, permission
+// or rate. Other reads are left to the access log. Auth routes audit
+// themselves through security events. Bodies are never recorded.
+func auditAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" || strings.HasPrefix(route, "/api/auth/") || route == "/api/health" {
+			return
+		}
+		status := c.Writer.Status()
+		read := status < 400 && hasAnyPrefix(route, auditedReadPrefixes)
+		if !changesState(c.Request.Method) && !refused(status) && !read {
+			return
+		}
+		action := audit.ActionDataAccess
+		user, ok := middleware.CurrentUser(c)
+		if ok && user.Role == "admin" && changesState(c.Request.Method) {
+			action = audit.ActionAdmin
+		}
+		entry := audit.Entry{
+			Action:   action,
+			IP:       c.ClientIP(),
+			Resource: c.Request.Method + " " + route,
+			Details: map[string]interface{}{
+				"path":   c.Request.URL.Path,
+				"status": status,
+			},
+		}
+		if status >= 400 {
+			entry.Outcome = audit.Failure
+		}
+		if ok {
+			entry.ActorID, entry.Actor = user.ID, user.Username
+		}
+		audit.Record(c.Request.Context(), entry)
+	}
+}
+
+func changesState(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+func refused(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,688 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+	"wodge/internal/audit"
+	"wodge/internal/logging"
+	"wodge/internal/middleware"
+	"wodge/internal/monitor"
+	"wodge/internal/replay"
+	"wodge/internal/services"
+	"wodge/internal/sse"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Chat outcomes, reported in the done event and the chat summary
+const (
+	chatComplete  = "complete"
+	chatTruncated = "truncated" // Stopped by a limit
+	chatCancelled = "cancelled" // Stopped by the user or the server
+	chatFailed    = "error"
+)
+
+// maxLoggedAnswer caps the answer kept in the monitor event
+const maxLoggedAnswer = 4000
+
+// chatConfig limits SecureChat streams. Zero means no limit.
+type chatConfig struct {
+	MaxInput    int           // QAST_CHAT_MAX_INPUT, characters, default 16000
+	MaxOutput   int           // QAST_CHAT_MAX_OUTPUT, characters of the answer
+	MaxDuration time.Duration // QAST_CHAT_MAX_DURATION, default 5m
+	Heartbeat   time.Duration // QAST_CHAT_HEARTBEAT, default 15s
+	BufferTTL   time.Duration // QAST_CHAT_BUFFER_TTL, how long an ended chat can be resumed, default 10m
+}
+
+var chatLimits = chatConfig{MaxInput: 16000, MaxDuration: 5 * time.Minute, Heartbeat: 15 * time.Second, BufferTTL: 10 * time.Minute}
+
+func chatConfigFromEnv() chatConfig {
+	cfg := chatLimits
+	if n := qastInt("QAST_CHAT_MAX_INPUT"); n != 0 {
+		cfg.MaxInput = max(n, 0)
+	}
+	if n := qastInt("QAST_CHAT_MAX_OUTPUT"); n != 0 {
+		cfg.MaxOutput = max(n, 0)
+	}
+	if d := qastDuration("QAST_CHAT_MAX_DURATION"); d > 0 {
+		cfg.MaxDuration = d
+	}
+	if d := qastDuration("QAST_CHAT_HEARTBEAT"); d > 0 {
+		cfg.Heartbeat = d
+	}
+	if d := qastDuration("QAST_CHAT_BUFFER_TTL"); d > 0 {
+		cfg.BufferTTL = d
+	}
+	return cfg
+}
+
+// chatBuffer keeps chat events for clients that resume
+var chatBuffer replay.Buffer = replay.NewMemoryBuffer()
+
+// initChatBuffer keeps chat events in Redis when it is configured, so a
+// client can resume on any instance. QAST_CHAT_BUFFER=memory keeps them in
+// the process.
+func initChatBuffer() {
+	switch kind := os.Getenv("QAST_CHAT_BUFFER"); kind {
+	case "", "redis":
+		if cache != nil {
+			chatBuffer = replay.NewCacheBuffer(cache)
+			return
+		}
+		if kind == "redis" {
+			logging.For("qast").Error("QAST_CHAT_BUFFER is redis but Redis is not configured, buffering chats in memory")
+		}
+	case "memory":
+	default:
+		logging.For("qast").Error("Unknown QAST_CHAT_BUFFER, buffering chats in memory", "buffer", kind)
+	}
+	chatBuffer = replay.NewMemoryBuffer()
+}
+
+// chatTTL keeps a chat's events until BufferTTL after the longest it can run.
+func chatTTL() time.Duration {
+	if chatLimits.MaxDuration > 0 {
+		return chatLimits.MaxDuration + chatLimits.BufferTTL
+	}
+	return time.Hour + chatLimits.BufferTTL
+}
+
+// errChatCancelled is the cause of a chat stopped through the cancel endpoint
+var errChatCancelled = errors.New("chat cancelled by the user")
+
+// chatSummary is what a chat leaves in the monitor and audit streams.
+type chatSummary struct {
+	UserID          string `json:"user_id,omitempty"`
+	SessionID       string `json:"session_id,omitempty"`
+	MessageID       string `json:"message_id"`
+	Status          string `json:"status"`
+	Chunks          int    `json:"chunks"`
+	Characters      int    `json:"characters"`
+	EstimatedTokens int    `json:"estimated_tokens"`
+	DurationMS      int64  `json:"duration_ms"`
+	Error           string `json:"error,omitempty"`
+	// Answer is set for the monitor event only, never audited
+	Answer string `json:"answer,omitempty"`
+
+	answer      strings.Builder
+	started     time.Time
+	qastMessage string // The history message QAST stores the answer under
+}
+
+// chatCaller is who started a chat, kept since the chat outlives the request.
+type chatCaller struct {
+	ip, id, name string
+}
+
+func callerOf(c *gin.Context) chatCaller {
+	by := chatCaller{ip: c.ClientIP()}
+	if user, ok := middleware.CurrentUser(c); ok {
+		by.id, by.name = user.ID, user.Username
+	}
+	return by
+}
+
+// handleQastSecureChat starts a chat and streams it. Wodge adds a meta
+// event first, heartbeats while QAST is quiet, and usage and done events at
+// the end, or an error event when the stream fails or hits a limit.
+//
+// The chat runs on its own, under the message_id named in the meta event:
+// a client that drops resumes with GET /api/qast/chat/:id/events, and
+// POST /api/qast/chat/:id/cancel stops it.
+//
+// Personal data in the text is tokenized before QAST sees it; the meta
+// event names the pii_session to send back to /api/pii/rehydrate.
+func handleQastSecureChat(c *gin.Context) {
+	if qastSvc == nil {
+		c.Error(services.Unavailable("QAST not configured"))
+		return
+	}
+	var req struct {
+		Text            string `json:"text" binding:"required"`
+		UserID          string `json:"user_id"`
+		SessionID       string `json:"session_id"`
+		TargetMessageID string `json:"target_message_id"`
+		PIISession      string `json:"pii_session"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	if chatLimits.MaxInput > 0 && utf8.RuneCountInString(req.Text) > chatLimits.MaxInput {
+		msg := fmt.Sprintf("text must be at most %d characters", chatLimits.MaxInput)
+		c.Error(services.Validation("Message is too long", services.FieldError{Field: "text", Code: "max", Message: msg}))
+		return
+	}
+	text, vault, err := tokenizeChat(c, req.Text, req.PIISession)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// The chat is not bound to the request, so it survives the client
+	// dropping. It ends when the answer does, on a limit or on cancel.
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(c.Request.Context()))
+	release := func() { cancel(nil) }
+	if chatLimits.MaxDuration > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, chatLimits.MaxDuration)
+		release = func() { stop(); cancel(nil) }
+	}
+
+	run := &chatRun{id: randomToken(), owner: ownerID(c), started: time.Now(), cancel: cancel, changed: make(chan struct{})}
+	sum := &chatSummary{UserID: req.UserID, SessionID: req.SessionID, MessageID: run.id, qastMessage: req.TargetMessageID, started: run.started}
+	by := callerOf(c)
+	if err := chatBuffer.Save(ctx, run.state(replay.Running), chatTTL()); err != nil {
+		release()
+		slog.ErrorContext(ctx, "Failed to buffer chat", "error", err)
+		c.Error(services.Unavailable("Chat buffer is unavailable"))
+		return
+	}
+
+	// Forward the caller's token (bearer header or session cookie)
+	token := middleware.AccessToken(c)
+	slog.DebugContext(ctx, "SecureChat auth", "token_len", len(token))
+
+	stream, err := qastSvc.SecureChat(ctx, text, req.UserID, req.SessionID, req.TargetMessageID, token)
+	if err != nil {
+		release()
+		slog.ErrorContext(ctx, "SecureChat failed", "error", err)
+		sum.Status, sum.Error = chatFailed, "upstream"
+		_ = chatBuffer.Save(context.WithoutCancel(ctx), run.state(chatFailed), chatLimits.BufferTTL)
+		recordChat(ctx, sum, by)
+		c.Error(err)
+		return
+	}
+
+	meta := gin.H{
+		"request_id": middleware.GetRequestID(c),
+		"message_id": run.id,
+		"session_id": req.SessionID,
+		"started_at": sum.started.UTC(),
+	}
+	if vault != nil {
+		meta["pii_session"], meta["pii_tokens"] = vault.session, vault.tokens
+	}
+	run.JSON("meta", meta)
+	chatRuns.Store(run.id, run)
+	go run.relay(ctx, release, stream, sum, vault, by)
+
+	followChat(c, run.id, 0)
+}
+
+// GET /api/qast/chat/:id/events
+// Resumes a chat after the Last-Event-ID header (or ?last_event_id=): the
+// events the client missed are replayed, then followed while the chat runs.
+func handleQastChatResume(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := chatState(c, id); err != nil {
+		c.Error(err)
+		return
+	}
+	last := c.GetHeader("Last-Event-ID")
+	if last == "" {
+		last = c.Query("last_event_id")
+	}
+	after := 0
+	if last != "" {
+		n, err := strconv.Atoi(last)
+		if err != nil || n < 0 {
+			c.Error(services.Validation("Invalid Last-Event-ID", services.FieldError{Field: "last_event_id", Code: "invalid"}))
+			return
+		}
+		after = n
+	}
+	followChat(c, id, after)
+}
+
+// POST /api/qast/chat/:id/cancel
+// Stops a running chat and its upstream request. The answer so far is kept
+// in the history, marked truncated.
+func handleQastChatCancel(c *gin.Context) {
+	id := c.Param("id")
+	st, err := chatState(c, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if st.Done() {
+		c.JSON(http.StatusOK, gin.H{"message_id": id, "status": st.Status})
+		return
+	}
+	if v, ok := chatRuns.Load(id); ok {
+		v.(*chatRun).cancel(errChatCancelled)
+	} else {
+		// The chat runs on another instance, which picks the request up
+		st.Cancel = true
+		if err := chatBuffer.Save(c.Request.Context(), st, chatTTL()); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+	entry := audit.Entry{Action: "qast.chat.cancel", IP: c.ClientIP(), Resource: "chat:" + id}
+	if user, ok := middleware.CurrentUser(c); ok {
+		entry.ActorID, entry.Actor = user.ID, user.Username
+	}
+	audit.Record(c.Request.Context(), entry)
+	c.JSON(http.StatusAccepted, gin.H{"message_id": id, "status": "cancelling"})
+}
+
+// chatState returns the state of a chat the caller owns. Chats of others
+// are reported as not found.
+func chatState(c *gin.Context, id string) (*replay.Stream, error) {
+	var st *replay.Stream
+	if v, ok := chatRuns.Load(id); ok {
+		run := v.(*chatRun)
+		st = run.state(run.status())
+	} else {
+		var err error
+		if st, err = chatBuffer.Load(c.Request.Context(), id); err != nil {
+			if errors.Is(err, services.ErrNotFound) {
+				return nil, services.NotFound("Chat not found")
+			}
+			return nil, err
+		}
+	}
+	if st.Owner != ownerID(c) {
+		return nil, services.NotFound("Chat not found")
+	}
+	return st, nil
+}
+
+// followChat streams a chat's events after sequence number after, live
+// while it runs, until it ends or the client goes away. Chats running on
+// another instance are followed through the buffer.
+func followChat(c *gin.Context, id string, after int) {
+	ctx := c.Request.Context()
+	w := sse.NewWriter(c.Writer)
+	if chatLimits.Heartbeat > 0 {
+		// Stop the heartbeat before the response is handed back to gin
+		beat, stop := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			w.Heartbeat(beat, chatLimits.Heartbeat)
+		}()
+		defer func() { stop(); <-done }()
+	}
+
+	if v, ok := chatRuns.Load(id); ok {
+		run := v.(*chatRun)
+		for {
+			events, done, changed := run.since(after)
+			for _, ev := range events {
+				if w.Send(ev) != nil {
+					return
+				}
+				after = replay.Seq(ev)
+			}
+			if done {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+		}
+	}
+
+	for {
+		// State before events: once it says done, every event is buffered
+		st, err := chatBuffer.Load(ctx, id)
+		if err == nil {
+			var events []sse.Event
+			events, err = chatBuffer.Since(ctx, id, after)
+			for _, ev := range events {
+				if w.Send(ev) != nil {
+					return
+				}
+				after = replay.Seq(ev)
+			}
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.ErrorContext(ctx, "Failed to read buffered chat", "error", err)
+				w.JSON("error", gin.H{"code": "unavailable", "message": "The answer could not be resumed"})
+			}
+			return
+		}
+		if st.Done() {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// chatRuns are the chats running on this instance, by message ID
+var chatRuns sync.Map
+
+// chatRun is a chat running on this instance. Its events are kept in
+// memory for the clients following it here, and flushed to chatBuffer for
+// clients resuming elsewhere or after it ended.
+type chatRun struct {
+	id      string
+	owner   string
+	started time.Time
+	cancel  context.CancelCauseFunc
+
+	mu      sync.Mutex
+	events  []sse.Event
+	flushed int
+	outcome string        // Set once the chat ended
+	changed chan struct{} // Closed and replaced on every change
+}
+
+// Send numbers ev and adds it to the chat.
+func (r *chatRun) Send(ev sse.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ev.ID = strconv.Itoa(len(r.events) + 1)
+	r.events = append(r.events, ev)
+	r.notify()
+}
+
+// JSON sends an event with v encoded as its data.
+func (r *chatRun) JSON(event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Failed to encode chat event", "event", event, "error", err)
+		return
+	}
+	r.Send(sse.Event{Event: event, Data: string(data)})
+}
+
+func (r *chatRun) notify() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// since returns the events after sequence number after, whether the chat
+// has ended, and a channel closed on the next change.
+func (r *chatRun) since(after int) ([]sse.Event, bool, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	after = min(max(after, 0), len(r.events))
+	return append([]sse.Event(nil), r.events[after:]...), r.outcome != "", r.changed
+}
+
+func (r *chatRun) status() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.outcome == "" {
+		return replay.Running
+	}
+	return r.outcome
+}
+
+func (r *chatRun) state(status string) *replay.Stream {
+	return &replay.Stream{ID: r.id, Owner: r.owner, Status: status, StartedAt: r.started.UTC()}
+}
+
+// relay runs the chat to its end, then records it.
+func (r *chatRun) relay(ctx context.Context, release func(), stream io.ReadCloser, sum *chatSummary, vault *chatVault, by chatCaller) {
+	defer release()
+	flushing, stopFlush := context.WithCancel(context.WithoutCancel(ctx))
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		r.flushLoop(flushing)
+	}()
+
+	sum.Status = relayChat(ctx, r, sse.NewReader(stream), sum, vault)
+	stream.Close()
+	sum.DurationMS = time.Since(sum.started).Milliseconds()
+	r.JSON("usage", gin.H{
+		"chunks":           sum.Chunks,
+		"characters":       sum.Characters,
+		"estimated_tokens": sum.EstimatedTokens,
+		"duration_ms":      sum.DurationMS,
+	})
+	r.JSON("done", gin.H{"status": sum.Status})
+	stopFlush()
+	<-flushed
+
+	ctx = context.WithoutCancel(ctx)
+	r.finish(ctx, sum.Status)
+	savePartial(ctx, sum)
+	recordChat(ctx, sum, by)
+}
+
+// flushLoop writes new events to the buffer four times a second, and
+// picks up cancel requests made on other instances once a second.
+func (r *chatRun) flushLoop(ctx context.Context) {
+	t := time.NewTicker(250 * time.Millisecond)
+	defer t.Stop()
+	for tick := 1; ; tick++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := r.flush(ctx); err != nil {
+			slog.WarnContext(ctx, "Failed to buffer chat events", "error", err)
+		}
+		if tick%4 == 0 {
+			if st, err := chatBuffer.Load(ctx, r.id); err == nil && st.Cancel {
+				r.cancel(errChatCancelled)
+			}
+		}
+	}
+}
+
+func (r *chatRun) flush(ctx context.Context) error {
+	r.mu.Lock()
+	pending := append([]sse.Event(nil), r.events[r.flushed:]...)
+	r.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := chatBuffer.Append(ctx, r.id, pending, chatTTL()); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.flushed += len(pending)
+	r.mu.Unlock()
+	return nil
+}
+
+// finish buffers the last events and the outcome, then hands the chat over
+// to the buffer. The outcome is saved after the events, so a client
+// following through the buffer sees them all before it sees the end.
+func (r *chatRun) finish(ctx context.Context, outcome string) {
+	if err := r.flush(ctx); err != nil {
+		slog.ErrorContext(ctx, "Failed to buffer chat events", "error", err)
+	}
+	if err := chatBuffer.Save(ctx, r.state(outcome), chatLimits.BufferTTL); err != nil {
+		slog.ErrorContext(ctx, "Failed to buffer chat outcome", "error", err)
+	}
+	r.mu.Lock()
+	r.outcome = outcome
+	r.notify()
+	r.mu.Unlock()
+	chatRuns.Delete(r.id)
+}
+
+// relayChat forwards upstream events until the stream ends, the chat is
+// cancelled or a limit is hit, and returns the outcome. QAST's token maps
+// stay in the vault instead of reaching the browser.
+func relayChat(ctx context.Context, w *chatRun, rd *sse.Reader, sum *chatSummary, vault *chatVault) string {
+	status := chatComplete
+	for {
+		ev, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			return status
+		}
+		if err != nil {
+			switch {
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				sum.Error = "duration"
+				w.JSON("error", gin.H{"code": "limit_exceeded", "message": "The answer took too long and was stopped"})
+				return chatTruncated
+			case errors.Is(context.Cause(ctx), errChatCancelled):
+				sum.Error = "cancelled"
+				return chatCancelled
+			case ctx.Err() != nil:
+				return chatCancelled
+			}
+			slog.ErrorContext(ctx, "Streaming read error", "error", err)
+			sum.Error = "stream"
+			w.JSON("error", gin.H{"code": "upstream", "message": "The answer stream was interrupted"})
+			return chatFailed
+		}
+
+		if ev.Name() != "chunk" {
+			sum.upstreamIDs(ev)
+		}
+		switch ev.Name() {
+		case "done":
+			continue // Wodge sends its own once usage is known
+		case "token_map", "token_definitions":
+			if vault != nil {
+				vault.absorb(ctx, ev)
+				continue
+			}
+		case "chunk":
+			sum.add(chunkText(ev), false)
+		case "debug_log":
+			var log struct {
+				Stage   string `json:"stage"`
+				Content string `json:"content"`
+			}
+			// The composer output is the complete answer
+			if ev.JSON(&log) == nil && log.Stage == "composer_output" && log.Content != "" {
+				sum.add(log.Content, true)
+			}
+		case "error":
+			status, sum.Error = chatFailed, "upstream"
+		}
+
+		w.Send(ev) // Upstream IDs are replaced by the chat's own
+		if chatLimits.MaxOutput > 0 && sum.Characters > chatLimits.MaxOutput {
+			sum.Error = "output"
+			w.JSON("error", gin.H{"code": "limit_exceeded", "message": "The answer exceeded the length limit and was stopped"})
+			return chatTruncated
+		}
+	}
+}
+
+// add appends a chunk of the answer, or replaces the answer when complete.
+func (s *chatSummary) add(text string, complete bool) {
+	if complete {
+		s.answer.Reset()
+		s.Characters = 0
+	} else {
+		s.Chunks++
+	}
+	s.answer.WriteString(text)
+	s.Characters += utf8.RuneCountInString(text)
+	s.EstimatedTokens = (s.Characters + 3) / 4 // About four characters per token
+}
+
+// upstreamIDs picks up the session and history message QAST stores the
+// answer under, from any event that names them.
+func (s *chatSummary) upstreamIDs(ev sse.Event) {
+	if !strings.HasPrefix(strings.TrimSpace(ev.Data), "{") {
+		return
+	}
+	var ids struct {
+		SessionID          string `json:"session_id"`
+		MessageID          string `json:"message_id"`
+		AssistantMessageID string `json:"assistant_message_id"`
+	}
+	if ev.JSON(&ids) != nil {
+		return
+	}
+	if s.SessionID == "" {
+		s.SessionID = ids.SessionID
+	}
+	if ids.AssistantMessageID != "" {
+		s.qastMessage = ids.AssistantMessageID
+	} else if s.qastMessage == "" {
+		s.qastMessage = ids.MessageID
+	}
+}
+
+// chunkText is a chunk's text. QAST sends it JSON-encoded or raw.
+func chunkText(ev sse.Event) string {
+	var s string
+	if ev.JSON(&s) == nil {
+		return s
+	}
+	return ev.Data
+}
+
+// savePartial stores an answer that was cut off in the chat history,
+// marked truncated, so the QAST-side message is not left half-written.
+func savePartial(ctx context.Context, sum *chatSummary) {
+	if sum.Status == chatComplete || sum.answer.Len() == 0 || sum.SessionID == "" || sum.qastMessage == "" {
+		return
+	}
+	metadata := map[string]interface{}{
+		"truncated":        true,
+		"status":           sum.Status,
+		"wodge_message_id": sum.MessageID,
+	}
+	if sum.Error != "" {
+		metadata["reason"] = sum.Error
+	}
+	if err := qastSvc.UpdateMessage(ctx, sum.SessionID, sum.qastMessage, sum.answer.String(), metadata); err != nil {
+		slog.ErrorContext(ctx, "Failed to save the partial answer", "session_id", sum.SessionID, "error", err)
+	}
+}
+
+// recordChat publishes the chat summary to the monitor, with the answer as
+// QAST returned it (PII tokenized, then redacted by the monitor filter),
+// and audits it with counts only.
+func recordChat(ctx context.Context, sum *chatSummary, by chatCaller) {
+	ctx = context.WithoutCancel(ctx)
+	if sum.DurationMS == 0 {
+		sum.DurationMS = time.Since(sum.started).Milliseconds()
+	}
+	sum.Answer = sum.answer.String()
+	if len(sum.Answer) > maxLoggedAnswer {
+		sum.Answer = strings.ToValidUTF8(sum.Answer[:maxLoggedAnswer], "") + "…"
+	}
+	monitor.Bus.PublishContext(ctx, monitor.TypeChat, sum)
+
+	entry := audit.Entry{
+		Action:   "qast.chat",
+		Actor:    by.name,
+		ActorID:  by.id,
+		IP:       by.ip,
+		Resource: "session:" + sum.SessionID,
+		Details: map[string]interface{}{
+			"message_id":       sum.MessageID,
+			"status":           sum.Status,
+			"chunks":           sum.Chunks,
+			"characters":       sum.Characters,
+			"estimated_tokens": sum.EstimatedTokens,
+			"duration_ms":      sum.DurationMS,
+		},
+	}
+	if sum.SessionID == "" {
+		entry.Resource = "session:new"
+	}
+	if sum.Error != "" {
+		entry.Details["error"] = sum.Error
+	}
+	if sum.Status == chatFailed {
+		entry.Outcome = audit.Failure
+	}
+	audit.Record(ctx, entry)
+	slog.InfoContext(ctx, "Chat finished", "status", sum.Status, "chunks", sum.Chunks, "estimated_tokens", sum.EstimatedTokens, "duration_ms", sum.DurationMS)
+}
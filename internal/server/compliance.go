@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"time"
+	"wodge/internal/compliance"
+	"wodge/internal/middleware"
+	"wodge/internal/monitor"
+	"wodge/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func registerComplianceRoutes(g *gin.RouterGroup) {
+	g.Use(middleware.Authenticate(verifyToken), requireOperator())
+	g.GET("/incident-report", handleIncidentReport)
+}
+
+// GET /wodge/compliance/incident-report?incident=INC-0001&from=&to=&format=markdown|json|html
+// from and to take RFC 3339 times or durations back from now.
+func handleIncidentReport(c *gin.Context) {
+	now := time.Now()
+	from, err := compliance.ParseTime(c.Query("from"), now)
+	if err != nil {
+		c.Error(services.Validation("Invalid from: " + err.Error()))
+		return
+	}
+	to, err := compliance.ParseTime(c.Query("to"), now)
+	if err != nil {
+		c.Error(services.Validation("Invalid to: " + err.Error()))
+		return
+	}
+
+	src := compliance.Sources{Events: monitor.Bus.Store(), AuditDir: auditDir}
+	if incidents != nil {
+		src.Incidents = incidents.Store()
+	}
+	report, err := compliance.BuildIncidentReport(src, compliance.Options{
+		IncidentID: c.Query("incident"),
+		From:       from,
+		To:         to,
+		Entity:     compliance.EntityFromEnv(),
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	format := c.DefaultQuery("format", compliance.FormatMarkdown)
+	body, err := report.Render(format)
+	if err != nil {
+		c.Error(services.Validation(err.Error()))
+		return
+	}
+	c.Data(http.StatusOK, compliance.ContentType(format), body)
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"wodge/internal/datasubject"
+	"wodge/internal/middleware"
+	"wodge/internal/monitor"
+	"wodge/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+var dataSubjects *datasubject.Registry
+
+// initDataSubjects registers every store holding user-linked data, the
+// configured services plus the app's own tables and keys from
+// datasubjects.json, and starts the retention schedule.
+func initDataSubjects() {
+	cfg, err := datasubject.ConfigFromEnv()
+	if err != nil {
+		slog.Error("Invalid data-subject config, data-subject requests are disabled", "error", err)
+		return
+	}
+	reg := datasubject.NewRegistry()
+	register := func(s datasubject.Store) {
+		if err := reg.Register(s); err != nil {
+			slog.Error("Data-subject store not registered", "error", err)
+		}
+	}
+	for _, t := range cfg.Postgres {
+		if db == nil {
+			slog.Warn("datasubjects.json lists Postgres tables but Postgres is not configured")
+			break
+		}
+		register(t.SQLTable(db))
+	}
+	if len(cfg.Redis) > 0 {
+		if cache != nil {
+			register(&datasubject.RedisKeys{Cache: cache, Patterns: cfg.Redis})
+		} else {
+			slog.Warn("datasubjects.json lists Redis keys but Redis is not configured")
+		}
+	}
+	if qastSvc != nil {
+		register(&datasubject.QastSessions{Qast: qastSvc})
+		register(&datasubject.QastContext{Qast: qastSvc})
+		register(&datasubject.ChatStreams{Buffer: chatBuffer, TTL: chatTTL()})
+		reg.SetResolver(resolveSubject)
+	}
+	if store := monitor.Bus.Store(); store != nil {
+		register(&datasubject.MonitorEvents{Store: store})
+	}
+	if mfaStore != nil {
+		register(&datasubject.MFAEnrollments{Store: mfaStore})
+	}
+	if piiTokens != nil {
+		register(&datasubject.PIITokenMaps{Tokenizer: piiTokens})
+	}
+	if ingester != nil {
+		register(&datasubject.IngestedDocuments{Ingester: ingester})
+	}
+	if auditDir != "" {
+		reg.Retain("audit", "Tamper-evident security record, kept to meet NIS2 and GDPR Art. 17(3)(b) obligations")
+	}
+	dataSubjects = reg
+
+	interval := 24 * time.Hour
+	if v := os.Getenv("DATA_RETENTION_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			slog.Error("Invalid DATA_RETENTION_INTERVAL, using the default", "value", v, "interval", interval)
+		} else {
+			interval = d
+		}
+	}
+	go reg.RunRetention(context.Background(), interval)
+	slog.Info("Data-subject requests enabled", "stores", len(reg.Stores()), "retention_interval", interval)
+}
+
+// resolveSubject looks a subject given by username or email up among the
+// users synced to QAST at login. Only an unambiguous match fills in the
+// user ID; otherwise the stores keyed by it are skipped.
+func resolveSubject(ctx context.Context, s datasubject.Subject) (datasubject.Subject, error) {
+	if s.UserID != "" {
+		return s, nil
+	}
+	query := s.Username
+	if query == "" {
+		query = s.Email
+	}
+	users, err := qastSvc.SearchUsers(ctx, query)
+	if err != nil {
+		return s, err
+	}
+	var match *services.UserSummary
+	for i, u := range users {
+		if s.Username != "" && !strings.EqualFold(u.Username, s.Username) {
+			continue
+		}
+		if s.Email != "" && !strings.EqualFold(u.Email, s.Email) {
+			continue
+		}
+		if match != nil && match.ID != u.ID {
+			return s, nil
+		}
+		match = &users[i]
+	}
+	if match == nil {
+		return s, nil
+	}
+	s.UserID = match.ID
+	if s.Username == "" {
+		s.Username = match.Username
+	}
+	if s.Email == "" {
+		s.Email = match.Email
+	}
+	return s, nil
+}
+
+func registerDataSubjectRoutes(g *gin.RouterGroup) {
+	g.Use(middleware.CSRF(), middleware.Authenticate(verifyToken), requireOperator(), requireDataSubjects())
+	g.GET("/stores", handleDataSubjectStores)
+	g.POST("/export", handleDataSubjectStart(datasubject.OpExport))
+	g.POST("/erase", handleDataSubjectStart(datasubject.OpErase))
+	g.GET("/jobs", handleDataSubjectJobs)
+	g.GET("/jobs/:id", handleDataSubjectJob)
+	g.POST("/retention/run", handleDataSubjectRetention)
+}
+
+func requireDataSubjects() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if dataSubjects == nil {
+			c.Error(services.Unavailable("Data-subject requests are not enabled"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// GET /wodge/data-subjects/stores
+func handleDataSubjectStores(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"stores": dataSubjects.Stores()})
+}
+
+type dataSubjectRequest struct {
+	datasubject.Subject
+	By string `json:"by"` // Who, for CLI requests without a user
+}
+
+// POST /wodge/data-subjects/export and /wodge/data-subjects/erase
+// { "user_id": "...", "username": "...", "email": "..." }
+func handleDataSubjectStart(op string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dataSubjectRequest
+		if !middleware.BindJSON(c, &req) {
+			return
+		}
+		actor := req.By
+		if user, ok := middleware.CurrentUser(c); ok {
+			actor = user.Username
+		}
+		if actor == "" {
+			actor = "cli"
+		}
+		job, err := dataSubjects.Start(c.Request.Context(), op, req.Subject, actor)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.Header("Location", "/wodge/data-subjects/jobs/"+job.ID)
+		c.JSON(http.StatusAccepted, job)
+	}
+}
+
+// GET /wodge/data-subjects/jobs
+func handleDataSubjectJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"jobs": dataSubjects.Jobs()})
+}
+
+// GET /wodge/data-subjects/jobs/:id, with the exported data once done
+func handleDataSubjectJob(c *gin.Context) {
+	job, err := dataSubjects.Job(c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// POST /wodge/data-subjects/retention/run purges now instead of waiting
+// for the schedule.
+func handleDataSubjectRetention(c *gin.Context) {
+	results := dataSubjects.Purge(c.Request.Context(), time.Now())
+	if results == nil {
+		results = []datasubject.PurgeResult{}
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"wodge/internal/incident"
+	"wodge/internal/middleware"
+	"wodge/internal/monitor"
+	"wodge/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+var incidents *incident.Engine
+
+// initIncidents starts the incident rules on the monitor stream unless
+// INCIDENT_DETECTION=off.
+func initIncidents() {
+	if os.Getenv("INCIDENT_DETECTION") == "off" {
+		slog.Info("INCIDENT_DETECTION is off, skipping incident detection")
+		return
+	}
+	rules, err := incident.RulesFromEnv()
+	if err != nil {
+		slog.Error("Failed to load INCIDENT_RULES, incident detection is disabled", "error", err)
+		return
+	}
+	path := os.Getenv("INCIDENT_STORE_PATH")
+	if path == "" {
+		path = filepath.Join(".wodge", "incidents.json")
+	}
+	store, err := incident.OpenStore(path)
+	if err != nil {
+		slog.Error("Failed to open incident store, incident detection is disabled", "error", err)
+		return
+	}
+	engine, err := incident.NewEngine(rules, store, monitor.Bus)
+	if err != nil {
+		slog.Error("Invalid incident rule, incident detection is disabled", "error", err)
+		return
+	}
+	incidents = engine
+	go engine.Run(context.Background())
+	slog.Info("Incident detection enabled", "rules", len(rules), "store", path)
+}
+
+func registerIncidentRoutes(g *gin.RouterGroup) {
+	g.Use(middleware.CSRF(), middleware.Authenticate(verifyToken), requireOperator(), requireIncidents())
+	g.GET("", handleIncidentList)
+	g.GET("/rules", handleIncidentRules)
+	g.GET("/:id", handleIncidentGet)
+	g.POST("/:id/ack", handleIncidentTransition((*incident.Engine).Acknowledge))
+	g.POST("/:id/resolve", handleIncidentTransition((*incident.Engine).Resolve))
+}
+
+func requireIncidents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if incidents == nil {
+			c.Error(services.Unavailable("Incident detection is not enabled"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// operatorHeader carries the operator token the CLI authenticates with
+const operatorHeader = "X-Operator-Token"
+
+// operatorToken admits the CLI to the /wodge operations API
+var operatorToken string
+
+// initOperator sets the operator token: WODGE_OPERATOR_TOKEN, or a random
+// one written to .wodge/operator-token, where the CLI on the same machine
+// reads it.
+func initOperator() {
+	if operatorToken = os.Getenv("WODGE_OPERATOR_TOKEN"); operatorToken != "" {
+		return
+	}
+	operatorToken = randomToken()
+	path := filepath.Join(".wodge", "operator-token")
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = os.WriteFile(path, []byte(operatorToken), 0600)
+	}
+	if err != nil {
+		slog.Error("Failed to write the operator token, the CLI cannot use the operations API", "path", path, "error", err)
+	}
+}
+
+// requireOperator admits authenticated admins and callers presenting the
+// operator token to the /wodge operations API.
+func requireOperator() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.GetHeader(operatorHeader); token != "" && operatorToken != "" &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(operatorToken)) == 1 {
+			c.Next()
+			return
+		}
+		if user, ok := middleware.CurrentUser(c); ok && user.Role == "admin" {
+			c.Next()
+			return
+		}
+		c.Error(services.Forbidden("Only admins can use the operations API"))
+		c.Abort()
+	}
+}
+
+// GET /wodge/incidents?state=open,acknowledged
+func handleIncidentList(c *gin.Context) {
+	var states []string
+	if s := c.Query("state"); s != "" {
+		states = strings.Split(s, ",")
+	}
+	list := incidents.Store().List(states...)
+	if list == nil {
+		list = []incident.Incident{}
+	}
+	c.JSON(http.StatusOK, gin.H{"incidents": list})
+}
+
+// GET /wodge/incidents/rules
+func handleIncidentRules(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"rules": incidents.Rules()})
+}
+
+// GET /wodge/incidents/:id
+func handleIncidentGet(c *gin.Context) {
+	inc, err := incidents.Store().Get(c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, inc)
+}
+
+type incidentTransitionRequest struct {
+	By   string `json:"by"` // Who, for CLI requests without a user
+	Note string `json:"note" binding:"max=2000"`
+}
+
+// POST /wodge/incidents/:id/ack and /wodge/incidents/:id/resolve
+func handleIncidentTransition(fn func(e *incident.Engine, ctx context.Context, id, actor, note string) (incident.Incident, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req incidentTransitionRequest
+		if c.Request.ContentLength != 0 && !middleware.BindJSON(c, &req) {
+			return
+		}
+		actor := req.By
+		if user, ok := middleware.CurrentUser(c); ok {
+			actor = user.Username
+		}
+		if actor == "" {
+			actor = "cli"
+		}
+		inc, err := fn(incidents, c.Request.Context(), c.Param("id"), actor, req.Note)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, inc)
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"wodge/internal/audit"
+	"wodge/internal/ingest"
+	"wodge/internal/logging"
+	"wodge/internal/middleware"
+	"wodge/internal/security"
+	"wodge/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ingester runs file ingestion, nil when QAST or the store is not configured
+var ingester *ingest.Ingester
+
+// initIngest sets up document uploads for QAST. The manifest and extracted
+// text are kept in INGEST_DIR, encrypted with INGEST_ENCRYPTION_KEY when set.
+// INGEST_MAX_MB, INGEST_CHUNK_SIZE, INGEST_CHUNK_OVERLAP, INGEST_MAX_CHUNKS
+// and INGEST_WORKERS tune the limits.
+func initIngest() {
+	log := logging.For("ingest")
+	if qastSvc == nil {
+		return
+	}
+	sealer, err := security.SealerFromEnv("INGEST_ENCRYPTION_KEY")
+	if err != nil {
+		log.Error("Invalid INGEST_ENCRYPTION_KEY, file ingestion is disabled", "error", err)
+		return
+	}
+	dir := os.Getenv("INGEST_DIR")
+	if dir == "" {
+		dir = filepath.Join(".wodge", "ingest")
+	}
+	cfg := ingest.Config{
+		MaxBytes:     int64(qastInt("INGEST_MAX_MB")) << 20,
+		ChunkSize:    qastInt("INGEST_CHUNK_SIZE"),
+		ChunkOverlap: qastInt("INGEST_CHUNK_OVERLAP"),
+		MaxChunks:    qastInt("INGEST_MAX_CHUNKS"),
+		Workers:      qastInt("INGEST_WORKERS"),
+	}
+	if ingester, err = ingest.New(ingest.NewStore(dir, sealer), qastSvc, cfg); err != nil {
+		log.Error("Failed to read the ingestion manifest, file ingestion is disabled", "dir", dir, "error", err)
+		return
+	}
+	if sealer == nil && !devMode() {
+		log.Warn("INGEST_ENCRYPTION_KEY is not set, extracted document text is stored unencrypted", "dir", dir)
+	}
+	log.Info("File ingestion enabled", "dir", dir, "max_bytes", ingester.MaxBytes())
+}
+
+func registerIngestRoutes(g *gin.RouterGroup) {
+	g.GET("/ingest/jobs", handleIngestJobs)
+	g.GET("/ingest/jobs/:id", handleIngestJob)
+	g.GET("/documents", handleIngestDocuments)
+	g.GET("/documents/:id", handleIngestDocument)
+	g.POST("/documents/:id/reingest", handleIngestReingest)
+	g.DELETE("/documents/:id", handleIngestDelete)
+}
+
+func requireIngest(c *gin.Context) bool {
+	if ingester == nil {
+		c.Error(services.Unavailable("File ingestion is not configured"))
+		return false
+	}
+	return true
+}
+
+// actorOf names who started an ingestion in its job and audit entry.
+func actorOf(c *gin.Context) string {
+	if user, ok := middleware.CurrentUser(c); ok {
+		return user.Username
+	}
+	return ""
+}
+
+// POST /api/qast/ingest as multipart/form-data with a "file" and an
+// optional "user_id". The file is extracted and chunked before the reply;
+// the chunks are ingested in the background.
+func handleQastIngestUpload(c *gin.Context) {
+	if !requireIngest(c) {
+		return
+	}
+	limit := ingester.MaxBytes()
+	// Room for the form fields and part headers around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+1<<20)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.Error(services.Validation(fmt.Sprintf("Files can be at most %d MiB", limit>>20), services.FieldError{Field: "file", Code: "size"}))
+			return
+		}
+		c.Error(services.Validation("Attach the document as the \"file\" field", services.FieldError{Field: "file", Code: "required"}))
+		return
+	}
+	if fh.Size > limit {
+		c.Error(services.Validation(fmt.Sprintf("Files can be at most %d MiB", limit>>20), services.FieldError{Field: "file", Code: "size"}))
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	doc, job, err := ingester.Add(c.Request.Context(), ingest.Upload{
+		Name:   filepath.Base(fh.Filename),
+		Data:   data,
+		Owner:  ownerID(c),
+		UserID: c.PostForm("user_id"),
+		Actor:  actorOf(c),
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Header("Location", "/api/qast/ingest/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, gin.H{"document": doc, "job": job})
+}
+
+// GET /api/qast/ingest/jobs
+func handleIngestJobs(c *gin.Context) {
+	if !requireIngest(c) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": ingester.Jobs(ownerID(c))})
+}
+
+// GET /api/qast/ingest/jobs/:id, with per-chunk progress
+func handleIngestJob(c *gin.Context) {
+	if !requireIngest(c) {
+		return
+	}
+	job, err := ingester.Job(ownerID(c), c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// GET /api/qast/documents lists the caller's ingested documents.
+func handleIngestDocuments(c *gin.Context) {
+	if !requireIngest(c) {
+		return
+	}
+	docs, err := ingester.Documents(ownerID(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"documents": docs})
+}
+
+// GET /api/qast/documents/:id
+func handleIngestDocument(c *gin.Context) {
+	if !requireIngest(c) {
+		return
+	}
+	doc, err := ingester.Document(ownerID(c), c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, doc)
+}
+
+// POST /api/qast/documents/:id/reingest { "failed_only": true }
+// Ingests the stored text again, all of it or the chunks that failed.
+func handleIngestReingest(c *gin.Context) {
+	if !requireIngest(c) {
+		return
+	}
+	var req struct {
+		FailedOnly bool `json:"failed_only"`
+	}
+	if c.Request.ContentLength != 0 && !middleware.BindJSON(c, &req) {
+		return
+	}
+	if v := c.Query("failed_only"); v != "" {
+		req.FailedOnly, _ = strconv.ParseBool(v)
+	}
+	job, err := ingester.Reingest(c.Request.Context(), ownerID(c), c.Param("id"), actorOf(c), req.FailedOnly)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Header("Location", "/api/qast/ingest/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// DELETE /api/qast/documents/:id removes the document from the manifest
+// and drops its stored text. QAST has no API to remove ingested knowledge,
+// so the reply says what was kept there.
+func handleIngestDelete(c *gin.Context) {
+	if !requireIngest(c) {
+		return
+	}
+	doc, err := ingester.Delete(ownerID(c), c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	entry := audit.Entry{
+		Action:   "qast.ingest.delete",
+		IP:       c.ClientIP(),
+		Resource: "ingest:" + doc.ID,
+		Details:  map[string]interface{}{"sha256": doc.SHA256, "chunks": doc.Chunks},
+	}
+	if user, ok := middleware.CurrentUser(c); ok {
+		entry.ActorID, entry.Actor = user.ID, user.Username
+	}
+	audit.Record(c.Request.Context(), entry)
+	body := gin.H{"status": "deleted"}
+	if doc.IngestedAt != nil {
+		body["retained"] = "Knowledge QAST extracted from the document stays in its graph"
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// isUpload reports whether a request carries a file rather than JSON.
+func isUpload(c *gin.Context) bool {
+	return strings.HasPrefix(c.ContentType(), "multipart/form-data")
+}
@@ -0,0 +1,537 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"wodge/internal/logging"
+	"wodge/internal/middleware"
+	"wodge/internal/security"
+	"wodge/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MFA is implemented in wodge on top of the password login, so it works with
+// auth backends (like AstAuth) that have no second-factor support of their own.
+var (
+	mfaStore              security.MFAStore
+	mfaChallenges         *security.MFAChallenges
+	webauthnRegistrations *security.MFAChallenges
+	webauthnCfg           security.WebAuthnConfig
+	mfaIssuer             string
+	mfaRequiredRoles      map[string]bool
+)
+
+func initMFA() {
+	sealer, err := security.SealerFromEnv("MFA_ENCRYPTION_KEY")
+	if err != nil {
+		slog.Error("Invalid MFA_ENCRYPTION_KEY, MFA store will not be encrypted", "error", err)
+	}
+	path := os.Getenv("MFA_STORE_PATH")
+	if path == "" {
+		path = filepath.Join(".wodge", "mfa.json")
+	}
+	mfaStore = security.NewFileMFAStore(path, sealer)
+	mfaChallenges = security.NewMFAChallenges(5*time.Minute, 5)
+	webauthnRegistrations = security.NewMFAChallenges(5*time.Minute, 1)
+
+	mfaIssuer = os.Getenv("MFA_ISSUER")
+	if mfaIssuer == "" {
+		mfaIssuer = "Wodge"
+	}
+	webauthnCfg = security.WebAuthnConfig{
+		RPID:   os.Getenv("WEBAUTHN_RP_ID"),
+		RPName: mfaIssuer,
+		Origin: os.Getenv("WEBAUTHN_ORIGIN"),
+	}
+	if webauthnCfg.RPID == "" {
+		webauthnCfg.RPID = "localhost"
+	}
+	// The dev frontend is the only origin that can be assumed; elsewhere
+	// WebAuthn stays off until the app's public URL is set
+	if webauthnCfg.Origin == "" && webauthnCfg.RPID == "localhost" {
+		webauthnCfg.Origin = "http://localhost:5173"
+	}
+	if webauthnCfg.Origin == "" {
+		slog.Warn("WEBAUTHN_ORIGIN is not set, WebAuthn is disabled", "rp_id", webauthnCfg.RPID)
+	}
+
+	mfaRequiredRoles = make(map[string]bool)
+	for _, role := range strings.Split(os.Getenv("MFA_REQUIRED_ROLES"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			mfaRequiredRoles[role] = true
+		}
+	}
+}
+
+func registerMFARoutes(g *gin.RouterGroup) {
+	g.POST("/verify", handleMFAVerify)
+
+	authed := g.Group("", middleware.RequireAuth())
+	authed.GET("", handleMFAStatus)
+	authed.DELETE("", handleMFADisable)
+	authed.POST("/challenge", handleMFAChallenge)
+	authed.POST("/totp/enroll", handleMFATOTPEnroll)
+	authed.POST("/totp/confirm", handleMFATOTPConfirm)
+	authed.POST("/webauthn/register/begin", handleMFAWebAuthnBegin)
+	authed.POST("/webauthn/register/finish", handleMFAWebAuthnFinish)
+}
+
+// mfaRequiredFor reports whether privileged roles must enroll a second factor.
+func mfaRequiredFor(role string) bool {
+	return mfaRequiredRoles[role]
+}
+
+// startMFAChallenge parks the verified login and describes the second step.
+func startMFAChallenge(username string, resp *services.AuthResponse, e *security.MFAEnrollment) gin.H {
+	body := mfaChallenge(&security.PendingLogin{
+		UserID:   resp.User.ID,
+		Username: username,
+		Session:  resp,
+	}, e)
+	body["mfa_required"] = true
+	return body
+}
+
+// mfaChallenge stores a pending second-factor check and describes it: the
+// available methods, and the WebAuthn challenge when the user has keys.
+func mfaChallenge(pending *security.PendingLogin, e *security.MFAEnrollment) gin.H {
+	body := gin.H{"methods": e.Methods()}
+	if len(e.WebAuthn) > 0 {
+		pending.Challenge = security.NewWebAuthnChallenge()
+		ids := make([]string, 0, len(e.WebAuthn))
+		for _, cred := range e.WebAuthn {
+			ids = append(ids, cred.ID)
+		}
+		body["webauthn"] = gin.H{
+			"challenge":         pending.Challenge,
+			"rp_id":             webauthnCfg.RPID,
+			"allow_credentials": ids,
+		}
+	}
+	body["mfa_token"] = mfaChallenges.Create(pending)
+	return body
+}
+
+// mfaAnswer is a second factor as sent to the verify, disable and enroll
+// endpoints. WebAuthn answers the challenge of the request's mfa_token.
+type mfaAnswer struct {
+	Method            string `json:"method" binding:"omitempty,oneof=totp recovery webauthn"`
+	Code              string `json:"code"`
+	CredentialID      string `json:"credential_id"`
+	ClientDataJSON    string `json:"client_data_json"`
+	AuthenticatorData string `json:"authenticator_data"`
+	Signature         string `json:"signature"`
+}
+
+// check verifies the answer against the enrollment and records what it
+// consumed: the TOTP step, a recovery code or the WebAuthn counter. TOTP
+// only counts once it is confirmed. Without a method the code may be either
+// a TOTP or a recovery code.
+func (a mfaAnswer) check(e *security.MFAEnrollment, challenge string) bool {
+	switch a.Method {
+	case "totp", "":
+		if e.TOTPConfirmed {
+			if step, ok := security.ValidateTOTP(e.TOTPSecret, a.Code, time.Now(), e.LastTOTPStep); ok {
+				e.LastTOTPStep = step
+				return true
+			}
+		}
+		return a.Method == "" && e.UseRecoveryCode(a.Code)
+	case "recovery":
+		return e.UseRecoveryCode(a.Code)
+	case "webauthn":
+		return verifyWebAuthnAssertion(e, challenge, a.CredentialID, a.ClientDataJSON, a.AuthenticatorData, a.Signature)
+	}
+	return false
+}
+
+// POST /api/auth/mfa/verify
+func handleMFAVerify(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		mfaAnswer
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	if req.Method == "" {
+		c.Error(services.Validation("Choose an MFA method", services.FieldError{Field: "method", Code: "required"}))
+		return
+	}
+
+	pending := mfaChallenges.Take(req.MFAToken)
+	resp, isLogin := pendingSession(pending)
+	if !isLogin {
+		c.Error(services.Unauthorized("MFA challenge expired, please log in again"))
+		return
+	}
+	ip := c.ClientIP()
+	if wait, locked := loginGuard.Check(pending.Username, ip); locked {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.Error(services.RateLimited("Too many failed login attempts, try again later"))
+		return
+	}
+
+	err := mfaStore.Update(pending.UserID, func(e *security.MFAEnrollment) (*security.MFAEnrollment, error) {
+		if e == nil {
+			return nil, services.Unavailable("MFA store unavailable")
+		}
+		if !req.check(e, pending.Challenge) {
+			return nil, errWrongFactor
+		}
+		return e, nil
+	})
+	if errors.Is(err, errWrongFactor) {
+		remaining := mfaChallenges.Retry(req.MFAToken, pending)
+		recordLoginFailure(c, pending.Username, ip, security.ActionMFAFailed)
+		err := services.Unauthorized("Invalid verification code")
+		err.Details = map[string]interface{}{"attempts_remaining": remaining}
+		c.Error(err)
+		return
+	}
+	if err != nil {
+		c.Error(mfaStoreError(err))
+		return
+	}
+	loginGuard.Success(pending.Username)
+
+	startSession(c, resp, "mfa:"+req.Method)
+	writeSession(c, resp)
+}
+
+// errWrongFactor fails an MFA store update whose answer didn't check out.
+var errWrongFactor = errors.New("wrong second factor")
+
+// mfaStoreError keeps the typed errors of an MFA store update and reports
+// the store's own as a failure to save.
+func mfaStoreError(err error) error {
+	var typed *services.Error
+	if errors.As(err, &typed) {
+		return err
+	}
+	return fmt.Errorf("failed to update MFA state: %w", err)
+}
+
+// pendingSession returns the session a login challenge holds back. Step-up
+// challenges of signed-in users hold none and can't complete a login.
+func pendingSession(pending *security.PendingLogin) (*services.AuthResponse, bool) {
+	if pending == nil {
+		return nil, false
+	}
+	resp, ok := pending.Session.(*services.AuthResponse)
+	return resp, ok
+}
+
+// POST /api/auth/mfa/challenge starts a step-up check for a signed-in user,
+// for the endpoints that ask for the second factor again. Its mfa_token
+// carries the WebAuthn challenge.
+func handleMFAChallenge(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+	e, err := mfaStore.Get(user.ID)
+	if err != nil {
+		c.Error(services.Unavailable("MFA store unavailable"))
+		return
+	}
+	if !e.Active() {
+		c.Error(services.Validation("MFA is not enabled"))
+		return
+	}
+	c.JSON(http.StatusOK, mfaChallenge(&security.PendingLogin{UserID: user.ID, Username: user.Username}, e))
+}
+
+// stepUp checks a signed-in user's second factor like a login would: a
+// locked account is refused, and wrong answers count towards the lockout.
+// The check runs with change in one MFA store update, so a factor is used
+// once: change gets the enrollment and returns what to store, nil to delete
+// it. Users without an active factor go straight to change. stepUp reports
+// whether the update went through and has rendered the error if not.
+func stepUp(c *gin.Context, user *services.User, mfaToken string, answer mfaAnswer, change func(e *security.MFAEnrollment) (*security.MFAEnrollment, error)) bool {
+	ip := c.ClientIP()
+	if wait, locked := loginGuard.Check(user.Username, ip); locked {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.Error(services.RateLimited("Too many failed attempts, try again later"))
+		return false
+	}
+	var challenge string
+	if answer.Method == "webauthn" {
+		pending := mfaChallenges.Take(mfaToken)
+		if pending == nil || pending.UserID != user.ID {
+			c.Error(services.Validation("Start a WebAuthn challenge first", services.FieldError{Field: "mfa_token", Code: "required"}))
+			return false
+		}
+		challenge = pending.Challenge
+	}
+	err := mfaStore.Update(user.ID, func(e *security.MFAEnrollment) (*security.MFAEnrollment, error) {
+		if e.Active() && !answer.check(e, challenge) {
+			return nil, errWrongFactor
+		}
+		return change(e)
+	})
+	if errors.Is(err, errWrongFactor) {
+		recordLoginFailure(c, user.Username, ip, security.ActionMFAFailed)
+		c.Error(services.Unauthorized("Invalid verification code"))
+		return false
+	}
+	if err != nil {
+		c.Error(mfaStoreError(err))
+		return false
+	}
+	return true
+}
+
+func verifyWebAuthnAssertion(e *security.MFAEnrollment, challenge, credID, clientData, authData, sig string) bool {
+	if challenge == "" {
+		return false
+	}
+	cd, err1 := base64.RawURLEncoding.DecodeString(clientData)
+	ad, err2 := base64.RawURLEncoding.DecodeString(authData)
+	sg, err3 := base64.RawURLEncoding.DecodeString(sig)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false
+	}
+	for i, cred := range e.WebAuthn {
+		if cred.ID != credID {
+			continue
+		}
+		count, err := webauthnCfg.VerifyAssertion(cred, challenge, cd, ad, sg)
+		if err != nil {
+			logging.For("security").Warn("WebAuthn assertion rejected", "error", err)
+			return false
+		}
+		e.WebAuthn[i].SignCount = count
+		return true
+	}
+	return false
+}
+
+// GET /api/auth/mfa
+func handleMFAStatus(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+	e, err := mfaStore.Get(user.ID)
+	if err != nil {
+		c.Error(services.Unavailable("MFA store unavailable"))
+		return
+	}
+	if e == nil {
+		e = &security.MFAEnrollment{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":            e.Active(),
+		"required":           mfaRequiredFor(user.Role),
+		"totp":               e.TOTPConfirmed,
+		"webauthn":           len(e.WebAuthn),
+		"recovery_remaining": len(e.RecoveryCodes),
+	})
+}
+
+// POST /api/auth/mfa/totp/enroll
+// Users who already have a security key prove it first, like for
+// DELETE /api/auth/mfa, since confirming replaces their recovery codes.
+func handleMFATOTPEnroll(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		mfaAnswer
+	}
+	if c.Request.ContentLength != 0 && !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	secret := security.NewTOTPSecret()
+	codes, hashes := security.NewRecoveryCodes(10)
+	ok := stepUp(c, user, req.MFAToken, req.mfaAnswer, func(e *security.MFAEnrollment) (*security.MFAEnrollment, error) {
+		if e == nil {
+			e = &security.MFAEnrollment{UserID: user.ID}
+		}
+		if e.TOTPConfirmed {
+			return nil, services.Conflict("TOTP already enrolled")
+		}
+		e.PendingTOTPSecret, e.PendingRecoveryCodes = secret, hashes
+		return e, nil
+	})
+	if !ok {
+		return
+	}
+
+	account := user.Username
+	if account == "" {
+		account = user.Email
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": security.ProvisioningURI(mfaIssuer, account, secret),
+		"recovery_codes":   codes, // Shown once, only hashes are stored
+	})
+}
+
+// POST /api/auth/mfa/totp/confirm { "code": "123456" }
+func handleMFATOTPConfirm(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	err := mfaStore.Update(user.ID, func(e *security.MFAEnrollment) (*security.MFAEnrollment, error) {
+		if e == nil || e.PendingTOTPSecret == "" {
+			return nil, services.Validation("Start TOTP enrollment first")
+		}
+		step, ok := security.ValidateTOTP(e.PendingTOTPSecret, req.Code, time.Now(), 0)
+		if !ok {
+			return nil, services.Unauthorized("Invalid verification code")
+		}
+		e.TOTPSecret, e.TOTPConfirmed, e.LastTOTPStep = e.PendingTOTPSecret, true, step
+		e.RecoveryCodes = e.PendingRecoveryCodes
+		e.PendingTOTPSecret, e.PendingRecoveryCodes = "", nil
+		return e, nil
+	})
+	if err != nil {
+		c.Error(mfaStoreError(err))
+		return
+	}
+	security.Emit(c.Request.Context(), security.Event{
+		Action:   security.ActionMFAEnrolled,
+		Severity: security.SeverityInfo,
+		UserID:   user.ID,
+		IP:       c.ClientIP(),
+		Reason:   "totp",
+	})
+	c.JSON(http.StatusOK, gin.H{"status": "enabled"})
+}
+
+// DELETE /api/auth/mfa { "method": "totp", "code": "123456" }
+// Requires a current factor: a confirmed TOTP code, a recovery code, or a
+// WebAuthn assertion for the mfa_token of POST /api/auth/mfa/challenge.
+func handleMFADisable(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		mfaAnswer
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	if mfaRequiredFor(user.Role) {
+		c.Error(services.Forbidden("MFA is required for your role"))
+		return
+	}
+	ok := stepUp(c, user, req.MFAToken, req.mfaAnswer, func(*security.MFAEnrollment) (*security.MFAEnrollment, error) {
+		return nil, nil
+	})
+	if !ok {
+		return
+	}
+	security.Emit(c.Request.Context(), security.Event{
+		Action:   security.ActionMFADisabled,
+		Severity: security.SeverityWarning,
+		UserID:   user.ID,
+		IP:       c.ClientIP(),
+	})
+	c.JSON(http.StatusOK, gin.H{"status": "disabled"})
+}
+
+// requireWebAuthn fails requests to register credentials while no origin is configured.
+func requireWebAuthn(c *gin.Context) bool {
+	if webauthnCfg.Origin == "" {
+		c.Error(services.Unavailable("WebAuthn is not configured, set WEBAUTHN_ORIGIN to the app's public URL"))
+		return false
+	}
+	return true
+}
+
+// POST /api/auth/mfa/webauthn/register/begin
+func handleMFAWebAuthnBegin(c *gin.Context) {
+	if !requireWebAuthn(c) {
+		return
+	}
+	user, _ := middleware.CurrentUser(c)
+	challenge := security.NewWebAuthnChallenge()
+	token := webauthnRegistrations.Create(&security.PendingLogin{UserID: user.ID, Challenge: challenge})
+
+	name := user.Username
+	if name == "" {
+		name = user.Email
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"registration_token": token,
+		"challenge":          challenge,
+		"rp":                 gin.H{"id": webauthnCfg.RPID, "name": webauthnCfg.RPName},
+		"user": gin.H{
+			"id":          base64.RawURLEncoding.EncodeToString([]byte(user.ID)),
+			"name":        name,
+			"displayName": strings.TrimSpace(user.FirstName + " " + user.LastName),
+		},
+		// ES256, EdDSA, RS256
+		"pub_key_cred_params": []gin.H{{"type": "public-key", "alg": -7}, {"type": "public-key", "alg": -8}, {"type": "public-key", "alg": -257}},
+	})
+}
+
+// POST /api/auth/mfa/webauthn/register/finish
+func handleMFAWebAuthnFinish(c *gin.Context) {
+	if !requireWebAuthn(c) {
+		return
+	}
+	user, _ := middleware.CurrentUser(c)
+	var req struct {
+		RegistrationToken  string `json:"registration_token" binding:"required"`
+		ID                 string `json:"id" binding:"required"`
+		ClientDataJSON     string `json:"client_data_json" binding:"required"`
+		AuthenticatorData  string `json:"authenticator_data" binding:"required"`
+		PublicKey          string `json:"public_key" binding:"required"`
+		PublicKeyAlgorithm int    `json:"public_key_algorithm" binding:"required"`
+		Name               string `json:"name" binding:"max=64"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	pending := webauthnRegistrations.Take(req.RegistrationToken)
+	if pending == nil || pending.UserID != user.ID {
+		c.Error(services.Validation("Registration expired, start again"))
+		return
+	}
+
+	cd, err1 := base64.RawURLEncoding.DecodeString(req.ClientDataJSON)
+	ad, err2 := base64.RawURLEncoding.DecodeString(req.AuthenticatorData)
+	pk, err3 := base64.RawURLEncoding.DecodeString(req.PublicKey)
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.Error(services.Validation("Malformed credential"))
+		return
+	}
+	cred, err := webauthnCfg.VerifyRegistration(pending.Challenge, req.ID, cd, ad, pk, req.PublicKeyAlgorithm)
+	if err != nil {
+		c.Error(&services.Error{Kind: services.ErrValidation, Message: "Credential rejected", Err: err})
+		return
+	}
+	cred.Name = req.Name
+
+	err = mfaStore.Update(user.ID, func(e *security.MFAEnrollment) (*security.MFAEnrollment, error) {
+		if e == nil {
+			e = &security.MFAEnrollment{UserID: user.ID}
+		}
+		e.WebAuthn = append(e.WebAuthn, *cred)
+		return e, nil
+	})
+	if err != nil {
+		c.Error(fmt.Errorf("failed to store credential: %w", err))
+		return
+	}
+	security.Emit(c.Request.Context(), security.Event{
+		Action:   security.ActionMFAEnrolled,
+		Severity: security.SeverityInfo,
+		UserID:   user.ID,
+		IP:       c.ClientIP(),
+		Reason:   "webauthn",
+	})
+	c.JSON(http.StatusCreated, gin.H{"status": "registered", "id": cred.ID})
+}
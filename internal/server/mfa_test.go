@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"wodge/internal/middleware"
+	"wodge/internal/security"
+	"wodge/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// passwordAuth accepts any username with the password "secret".
+type passwordAuth struct{ services.AuthService }
+
+func (passwordAuth) Login(_ context.Context, username, password string) (*services.AuthResponse, error) {
+	if password != "secret" {
+		return nil, services.ErrInvalidCredentials
+	}
+	return &services.AuthResponse{AccessToken: "a", RefreshToken: "r", User: services.User{ID: "u-" + username, Username: username}}, nil
+}
+
+// setupMFA points the auth globals at a fake backend, a file MFA store in a
+// temp dir and a lockout policy without delays.
+func setupMFA(t *testing.T, maxAttempts int) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	authSvc = passwordAuth{}
+	loginGuard = security.NewLoginGuard(security.LockoutPolicy{MaxAttempts: maxAttempts, Window: time.Hour, LockoutDuration: time.Hour})
+	mfaStore = security.NewFileMFAStore(filepath.Join(t.TempDir(), "mfa.json"), nil)
+	mfaChallenges = security.NewMFAChallenges(time.Minute, 5)
+	webauthnRegistrations = security.NewMFAChallenges(time.Minute, 1)
+	sessions = security.NewSessionFamilies(time.Hour)
+	qastSvc = nil
+	t.Cleanup(func() { authSvc = nil })
+
+	r := gin.New()
+	r.Use(middleware.Errors())
+	// The bearer token is the username
+	r.Use(middleware.Authenticate(func(_ context.Context, token string) (*services.User, error) {
+		return &services.User{ID: "u-" + token, Username: token}, nil
+	}))
+	r.POST("/api/auth/login", handleAuthLogin)
+	registerMFARoutes(r.Group("/api/auth/mfa"))
+	return r
+}
+
+func postJSON(r http.Handler, path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return sendJSON(r, http.MethodPost, path, "", body)
+}
+
+// sendJSON makes a request as the user named by bearer, anonymous when empty.
+func sendJSON(r http.Handler, method, path, bearer, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	r.ServeHTTP(w, req)
+	var out map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &out)
+	return w, out
+}
+
+// A correct password doesn't clear the failures of the second factor, so
+// wrong codes lock the account however often the password is re-entered.
+func TestMFAFailuresLockOutAcrossLogins(t *testing.T) {
+	r := setupMFA(t, 3)
+	if err := mfaStore.Put(&security.MFAEnrollment{UserID: "u-alice", TOTPSecret: security.NewTOTPSecret(), TOTPConfirmed: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		w, body := postJSON(r, "/api/auth/login", `{"username":"alice","password":"secret"}`)
+		if w.Code != http.StatusOK || body["mfa_required"] != true {
+			t.Fatalf("login %d: status %d, body %v", i, w.Code, body)
+		}
+		w, _ = postJSON(r, "/api/auth/mfa/verify", `{"mfa_token":"`+body["mfa_token"].(string)+`","method":"totp","code":"abcdef"}`)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("verify %d: status %d, want 401", i, w.Code)
+		}
+	}
+
+	w, _ := postJSON(r, "/api/auth/login", `{"username":"alice","password":"secret"}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("login after failed codes: status %d, want 429", w.Code)
+	}
+}
+
+// A code passes once, however many verifications race with it: on one
+// MFA token, or on several tokens of the same user.
+func TestMFAVerifyUsesCodeOnce(t *testing.T) {
+	for _, shared := range []bool{true, false} {
+		t.Run(fmt.Sprintf("shared token %v", shared), func(t *testing.T) {
+			r := setupMFA(t, 100)
+			secret := security.NewTOTPSecret()
+			if err := mfaStore.Put(&security.MFAEnrollment{UserID: "u-alice", TOTPSecret: secret, TOTPConfirmed: true}); err != nil {
+				t.Fatal(err)
+			}
+			const n = 8
+			tokens := make([]string, n)
+			for i := range tokens {
+				if shared && i > 0 {
+					tokens[i] = tokens[0]
+					continue
+				}
+				_, body := postJSON(r, "/api/auth/login", `{"username":"alice","password":"secret"}`)
+				tokens[i], _ = body["mfa_token"].(string)
+			}
+
+			code := totpCode(secret, time.Now())
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			passed := 0
+			for _, token := range tokens {
+				wg.Add(1)
+				go func(token string) {
+					defer wg.Done()
+					w, _ := postJSON(r, "/api/auth/mfa/verify", `{"mfa_token":"`+token+`","method":"totp","code":"`+code+`"}`)
+					if w.Code == http.StatusOK {
+						mu.Lock()
+						passed++
+						mu.Unlock()
+					}
+				}(token)
+			}
+			wg.Wait()
+			if passed != 1 {
+				t.Errorf("%d verifications passed with one code, want 1", passed)
+			}
+		})
+	}
+}
+
+// totpCode computes the RFC 6238 code an authenticator app would show.
+func totpCode(secret string, now time.Time) string {
+	key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(now.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestMFADisable(t *testing.T) {
+	secret := security.NewTOTPSecret()
+	codes, hashes := security.NewRecoveryCodes(2)
+	key := security.WebAuthnCredential{ID: "key-1"}
+
+	tests := []struct {
+		name       string
+		enrollment security.MFAEnrollment
+		body       string
+		want       int
+	}{
+		{"confirmed TOTP", security.MFAEnrollment{TOTPSecret: secret, TOTPConfirmed: true}, `{"method":"totp","code":"` + totpCode(secret, time.Now()) + `"}`, http.StatusOK},
+		{"code without a method", security.MFAEnrollment{TOTPSecret: secret, TOTPConfirmed: true}, `{"code":"` + totpCode(secret, time.Now()) + `"}`, http.StatusOK},
+		{"unconfirmed TOTP next to a key", security.MFAEnrollment{TOTPSecret: secret, WebAuthn: []security.WebAuthnCredential{key}}, `{"method":"totp","code":"` + totpCode(secret, time.Now()) + `"}`, http.StatusUnauthorized},
+		{"recovery code next to a key", security.MFAEnrollment{RecoveryCodes: hashes, WebAuthn: []security.WebAuthnCredential{key}}, `{"method":"recovery","code":"` + codes[0] + `"}`, http.StatusOK},
+		{"WebAuthn without a challenge", security.MFAEnrollment{WebAuthn: []security.WebAuthnCredential{key}}, `{"method":"webauthn","credential_id":"key-1"}`, http.StatusUnprocessableEntity},
+		{"no code", security.MFAEnrollment{TOTPSecret: secret, TOTPConfirmed: true}, `{}`, http.StatusUnauthorized},
+		{"nothing enrolled yet", security.MFAEnrollment{TOTPSecret: secret}, `{}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := setupMFA(t, 5)
+			tt.enrollment.UserID = "u-alice"
+			if err := mfaStore.Put(&tt.enrollment); err != nil {
+				t.Fatal(err)
+			}
+			w, body := sendJSON(r, http.MethodDelete, "/api/auth/mfa", "alice", tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %v", w.Code, tt.want, body)
+			}
+			e, err := mfaStore.Get("u-alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if disabled := e == nil; disabled != (tt.want == http.StatusOK) {
+				t.Errorf("enrollment removed = %v after status %d", disabled, w.Code)
+			}
+		})
+	}
+}
+
+// Wrong codes on disable count towards the lockout like failed logins.
+func TestMFADisableFailuresLockOut(t *testing.T) {
+	r := setupMFA(t, 2)
+	if err := mfaStore.Put(&security.MFAEnrollment{UserID: "u-alice", TOTPSecret: security.NewTOTPSecret(), TOTPConfirmed: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i, status := range want {
+		if w, _ := sendJSON(r, http.MethodDelete, "/api/auth/mfa", "alice", `{"code":"abcdef"}`); w.Code != status {
+			t.Errorf("attempt %d: status = %d, want %d", i+1, w.Code, status)
+		}
+	}
+}
+
+// A new secret and recovery codes stay pending until the first code
+// confirms them, and a user with a security key has to prove it to enroll.
+func TestMFATOTPEnrollIsPendingUntilConfirmed(t *testing.T) {
+	r := setupMFA(t, 5)
+	codes, hashes := security.NewRecoveryCodes(2)
+	key := security.WebAuthnCredential{ID: "key-1"}
+	if err := mfaStore.Put(&security.MFAEnrollment{UserID: "u-alice", RecoveryCodes: hashes, WebAuthn: []security.WebAuthnCredential{key}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w, _ := sendJSON(r, http.MethodPost, "/api/auth/mfa/totp/enroll", "alice", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("enroll without a factor: status = %d, want 401", w.Code)
+	}
+	w, body := sendJSON(r, http.MethodPost, "/api/auth/mfa/totp/enroll", "alice", `{"method":"recovery","code":"`+codes[0]+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("enroll: status = %d: %v", w.Code, body)
+	}
+	secret := body["secret"].(string)
+
+	e, _ := mfaStore.Get("u-alice")
+	if e.TOTPSecret != "" || e.TOTPConfirmed || len(e.RecoveryCodes) != 1 {
+		t.Errorf("enroll changed the active factors: %+v", e)
+	}
+
+	if w, _ := sendJSON(r, http.MethodPost, "/api/auth/mfa/totp/confirm", "alice", `{"code":"`+totpCode(secret, time.Now())+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("confirm: status = %d", w.Code)
+	}
+	e, _ = mfaStore.Get("u-alice")
+	if e.TOTPSecret != secret || !e.TOTPConfirmed || len(e.RecoveryCodes) != 10 || e.PendingTOTPSecret != "" || e.PendingRecoveryCodes != nil {
+		t.Errorf("confirm did not move the pending enrollment into place: %+v", e)
+	}
+	if e.UseRecoveryCode(codes[1]) {
+		t.Error("a recovery code from before the enrollment still works")
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"time"
+	"wodge/internal/middleware"
+	"wodge/internal/monitor"
+	"wodge/internal/redact"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerMonitorRoutes serves the event stream and history to admins and
+// the CLI, like the rest of the operations API.
+func registerMonitorRoutes(g *gin.RouterGroup) {
+	g.Use(middleware.CSRF(), middleware.Authenticate(verifyToken), requireOperator())
+	g.GET("/events", monitor.Handler)
+}
+
+// initRedaction strips secrets and personal data from monitor events before
+// they reach any client or the store. The returned redactor is shared with
+// the request logger.
+func initRedaction() *redact.Redactor {
+	redactor, err := redact.FromEnv()
+	if err != nil {
+		slog.Error("Invalid redaction config, using the defaults", "error", err)
+		redactor, _ = redact.New(redact.DefaultConfig(), []byte(os.Getenv("REDACT_HASH_KEY")))
+	}
+	monitor.Bus.SetFilter(redactor.Value)
+	return redactor
+}
+
+// initMonitorStore persists monitor events so `wodge monitor` can backfill
+// and incidents can be investigated after the fact.
+func initMonitorStore() {
+	retention := 7 * 24 * time.Hour
+	if v := os.Getenv("MONITOR_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			slog.Error("Invalid MONITOR_RETENTION, using the default", "value", v, "retention", retention)
+		} else {
+			retention = d
+		}
+	}
+
+	store, err := monitor.StoreFromEnv()
+	if err != nil {
+		slog.Error("Failed to open monitor store, monitor events are not persisted", "error", err)
+		return
+	}
+	if store == nil {
+		slog.Info("MONITOR_STORE is off, monitor events are not persisted")
+		return
+	}
+
+	if err := monitor.Bus.UseStore(store, retention); err != nil {
+		slog.Error("Failed to read monitor store", "error", err)
+		return
+	}
+	slog.Info("Monitor event store enabled", "retention", retention)
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"wodge/internal/drivers/oidc"
+	"wodge/internal/logging"
+	"wodge/internal/requestid"
+	"wodge/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const oidcStateCookie = "wodge_oidc_state"
+
+// oidcLogin is an authorization request waiting for its callback
+type oidcLogin struct {
+	Verifier  string    `json:"verifier"`
+	Nonce     string    `json:"nonce"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// oidcLoginTTL is how long the user has to come back from the IdP
+const oidcLoginTTL = 10 * time.Minute
+
+var (
+	oidcMu            sync.Mutex
+	oidcPending       = make(map[string]oidcLogin) // state -> login, without Redis
+	oidcPostLoginURL  string
+	oidcPasswordLogin bool
+)
+
+// saveOIDCLogin parks an authorization request under its state. With Redis
+// it goes to the shared cache, so the callback may reach any instance;
+// otherwise it is kept in memory, which suits a single instance only.
+func saveOIDCLogin(ctx context.Context, state string, login oidcLogin) error {
+	if cache != nil {
+		raw, err := json.Marshal(login)
+		if err != nil {
+			return err
+		}
+		return cache.Set(ctx, "oidc:"+state, string(raw), int(oidcLoginTTL.Seconds()))
+	}
+	now := time.Now()
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	for s, p := range oidcPending {
+		if now.After(p.ExpiresAt) {
+			delete(oidcPending, s)
+		}
+	}
+	oidcPending[state] = login
+	return nil
+}
+
+// takeOIDCLogin removes and returns the request parked under state, if it
+// is still valid.
+func takeOIDCLogin(ctx context.Context, state string) (oidcLogin, bool) {
+	var login oidcLogin
+	if cache != nil {
+		raw, err := cache.Get(ctx, "oidc:"+state)
+		if err != nil {
+			if !errors.Is(err, services.ErrNotFound) {
+				logging.For("oidc").ErrorContext(ctx, "Failed to load the pending login", "error", err)
+			}
+			return login, false
+		}
+		if err := cache.Delete(ctx, "oidc:"+state); err != nil {
+			logging.For("oidc").WarnContext(ctx, "Failed to delete the pending login", "error", err)
+		}
+		if err := json.Unmarshal([]byte(raw), &login); err != nil {
+			return login, false
+		}
+	} else {
+		oidcMu.Lock()
+		var found bool
+		login, found = oidcPending[state]
+		delete(oidcPending, state)
+		oidcMu.Unlock()
+		if !found {
+			return login, false
+		}
+	}
+	return login, time.Now().Before(login.ExpiresAt)
+}
+
+func initOIDC() {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		slog.Info("OIDC_ISSUER is empty, skipping OIDC init")
+		return
+	}
+	cfg := oidc.Config{
+		Issuer:        issuer,
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		Audience:      os.Getenv("OIDC_AUDIENCE"),
+		RoleClaim:     os.Getenv("OIDC_ROLE_CLAIM"),
+		PasswordGrant: os.Getenv("OIDC_PASSWORD_GRANT") == "true",
+	}
+	if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+		cfg.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+	}
+	if cfg.RedirectURL == "" {
+		cfg.RedirectURL = "http://localhost:8080/api/auth/oidc/callback"
+	}
+	oidcPostLoginURL = os.Getenv("OIDC_POST_LOGIN_REDIRECT")
+	if oidcPostLoginURL == "" {
+		oidcPostLoginURL = "http://localhost:5173/"
+	}
+	oidcPasswordLogin = cfg.PasswordGrant
+
+	authSvc = oidc.NewOIDCDriver(cfg)
+	logging.For("oidc").Info("OIDC driver initialized", "issuer", issuer)
+}
+
+// GET /api/auth/config - tells the login UI which flows are available
+func handleAuthConfig(c *gin.Context) {
+	_, redirect := authSvc.(services.RedirectAuthService)
+	provider := os.Getenv("AUTH_PROVIDER")
+	if provider == "" {
+		provider = "astauth"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"provider":       provider,
+		"configured":     authSvc != nil,
+		"password_login": authSvc != nil && (!redirect || oidcPasswordLogin),
+		"register":       authSvc != nil && !redirect,
+		"redirect_login": redirect,
+		"session_mode":   session.Mode,
+	})
+}
+
+// GET /api/auth/oidc/login - starts the authorization code flow
+func handleOIDCLogin(c *gin.Context) {
+	provider, ok := authSvc.(services.RedirectAuthService)
+	if !ok {
+		c.Error(services.NotFound("OIDC provider not configured"))
+		return
+	}
+
+	state, verifier, nonce := randomToken(), randomToken(), randomToken()
+	challenge := sha256.Sum256([]byte(verifier))
+	target := provider.AuthCodeURL(state, base64.RawURLEncoding.EncodeToString(challenge[:]), nonce)
+	if target == "" {
+		c.Error(services.Upstream("identity provider", errors.New("discovery unavailable")))
+		return
+	}
+
+	login := oidcLogin{Verifier: verifier, Nonce: nonce, ExpiresAt: time.Now().Add(oidcLoginTTL)}
+	if err := saveOIDCLogin(c.Request.Context(), state, login); err != nil {
+		c.Error(services.Unavailable("Login is temporarily unavailable"))
+		return
+	}
+
+	// Binds the callback to this browser. Lax, since the callback is a
+	// cross-site top-level navigation coming back from the IdP.
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		Secure:   session.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	c.Redirect(http.StatusFound, target)
+}
+
+// GET /api/auth/oidc/callback?code=...&state=...
+func handleOIDCCallback(c *gin.Context) {
+	provider, ok := authSvc.(services.RedirectAuthService)
+	if !ok {
+		c.Error(services.NotFound("OIDC provider not configured"))
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		oidcRedirectError(c, e)
+		return
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	http.SetCookie(c.Writer, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc", MaxAge: -1})
+
+	if state == "" || state != cookie {
+		oidcRedirectError(c, "invalid_state")
+		return
+	}
+	pending, ok := takeOIDCLogin(c.Request.Context(), state)
+	if !ok {
+		oidcRedirectError(c, "invalid_state")
+		return
+	}
+
+	resp, err := provider.Exchange(c.Request.Context(), c.Query("code"), pending.Verifier, pending.Nonce)
+	if err != nil {
+		logging.For("oidc").ErrorContext(c.Request.Context(), "Code exchange failed", "error", err)
+		oidcRedirectError(c, "exchange_failed")
+		return
+	}
+	startSession(c, resp, "oidc")
+
+	// Sync User to QAST
+	if qastSvc != nil {
+		ctx := requestid.Detach(c.Request.Context())
+		go func() {
+			if err := qastSvc.SyncUser(ctx, resp.User.ID, resp.User.Email, resp.User.Username, resp.User.FirstName, resp.User.LastName); err != nil {
+				slog.ErrorContext(ctx, "Failed to sync user to Qast", "user_id", resp.User.ID, "error", err)
+			}
+		}()
+	}
+
+	// MFA is left to the identity provider for redirect logins
+	if session.cookieMode() {
+		setSessionCookies(c, resp)
+		c.Redirect(http.StatusFound, oidcPostLoginURL)
+		return
+	}
+	// Bearer mode: hand the tokens over in the fragment, which is never sent to a server
+	fragment := url.Values{"access_token": {resp.AccessToken}, "refresh_token": {resp.RefreshToken}}
+	c.Redirect(http.StatusFound, oidcPostLoginURL+"#"+fragment.Encode())
+}
+
+func oidcRedirectError(c *gin.Context, code string) {
+	c.Redirect(http.StatusFound, oidcPostLoginURL+"#"+url.Values{"auth_error": {code}}.Encode())
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"path"
+	"sync"
+	"testing"
+	"time"
+	"wodge/internal/services"
+)
+
+// sharedCache stands in for the Redis every instance talks to.
+type sharedCache struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (s *sharedCache) Get(_ context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	if !ok {
+		return "", services.NotFound("Key not found")
+	}
+	return v, nil
+}
+
+func (s *sharedCache) Set(_ context.Context, key, value string, _ int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+	return nil
+}
+
+func (s *sharedCache) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func (s *sharedCache) Keys(_ context.Context, pattern string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.data {
+		if ok, _ := path.Match(pattern, k); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// A pending login is used once, and with Redis it lives outside the process,
+// so the callback may land on another instance.
+func TestOIDCLoginsAreSingleUse(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		name := "in memory"
+		if shared {
+			name = "in the shared cache"
+		}
+		t.Run(name, func(t *testing.T) {
+			cache = nil
+			if shared {
+				cache = &sharedCache{data: map[string]string{}}
+			}
+			t.Cleanup(func() { cache = nil })
+			ctx := context.Background()
+
+			login := oidcLogin{Verifier: "v", Nonce: "n", ExpiresAt: time.Now().Add(time.Minute)}
+			if err := saveOIDCLogin(ctx, "s1", login); err != nil {
+				t.Fatal(err)
+			}
+			if _, local := oidcPending["s1"]; shared && local {
+				t.Error("the login was kept in this process, not in the shared cache")
+			}
+			got, ok := takeOIDCLogin(ctx, "s1")
+			if !ok || got.Verifier != "v" || got.Nonce != "n" {
+				t.Fatalf("takeOIDCLogin() = %+v, %v", got, ok)
+			}
+			if _, ok := takeOIDCLogin(ctx, "s1"); ok {
+				t.Error("a login was taken twice")
+			}
+
+			expired := oidcLogin{Verifier: "v", ExpiresAt: time.Now().Add(-time.Second)}
+			if err := saveOIDCLogin(ctx, "s2", expired); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := takeOIDCLogin(ctx, "s2"); ok {
+				t.Error("an expired login was taken")
+			}
+		})
+	}
+}
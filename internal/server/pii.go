@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"wodge/internal/audit"
+	"wodge/internal/logging"
+	"wodge/internal/middleware"
+	"wodge/internal/pii"
+	"wodge/internal/security"
+	"wodge/internal/services"
+	"wodge/internal/sse"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// piiTokens tokenizes chat text, nil when off or misconfigured
+	piiTokens *pii.Tokenizer
+	// piiRequired refuses chats that cannot be tokenized, unless
+	// PII_TOKENIZATION=off
+	piiRequired = true
+)
+
+// initPII sets up the tokenization gateway for SecureChat. The token maps
+// are sealed with PII_MAP_KEY and kept in PII_MAP_STORE (redis, postgres or
+// memory; by default whichever is configured) for PII_MAP_TTL.
+func initPII() {
+	log := logging.For("pii")
+	if strings.EqualFold(os.Getenv("PII_TOKENIZATION"), "off") {
+		piiRequired = false
+		log.Warn("PII_TOKENIZATION is off, chat text reaches QAST untokenized")
+		return
+	}
+
+	sealer, err := security.SealerFromEnv("PII_MAP_KEY")
+	if err != nil {
+		log.Error("Invalid PII_MAP_KEY, chats are refused", "error", err)
+		return
+	}
+	if sealer == nil {
+		if !devMode() {
+			log.Error("PII_MAP_KEY is not set, chats are refused")
+			return
+		}
+		key := make([]byte, 32)
+		_, _ = rand.Read(key)
+		sealer, _ = security.NewSealer(key)
+		log.Warn("PII_MAP_KEY is not set, token maps are lost on restart")
+	}
+
+	var store pii.Store
+	switch kind := os.Getenv("PII_MAP_STORE"); {
+	case kind == "redis" || (kind == "" && cache != nil):
+		if cache == nil {
+			log.Error("PII_MAP_STORE is redis but Redis is not configured, chats are refused")
+			return
+		}
+		store = pii.NewCacheStore(cache)
+	case kind == "postgres" || (kind == "" && db != nil):
+		if db == nil {
+			log.Error("PII_MAP_STORE is postgres but Postgres is not configured, chats are refused")
+			return
+		}
+		if store, err = pii.NewSQLStore(db); err != nil {
+			log.Error("Failed to create the PII map table, chats are refused", "error", err)
+			return
+		}
+	case kind == "memory" || kind == "":
+		store = pii.NewMemoryStore()
+		if !devMode() {
+			log.Warn("PII token maps are kept in memory, configure Redis or Postgres for more than one instance")
+		}
+	default:
+		log.Error("Unknown PII_MAP_STORE, chats are refused", "store", kind)
+		return
+	}
+
+	ttl := 24 * time.Hour
+	if d := qastDuration("PII_MAP_TTL"); d > 0 {
+		ttl = d
+	}
+	var detectors []string
+	for _, name := range strings.Split(os.Getenv("PII_DETECTORS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			detectors = append(detectors, name)
+		}
+	}
+	if piiTokens, err = pii.New(detectors, store, sealer, ttl); err != nil {
+		log.Error("Invalid PII_DETECTORS, chats are refused", "error", err)
+		return
+	}
+	piiTokens.AllowAnonymous = devMode()
+	log.Info("PII tokenization enabled", "ttl", ttl)
+}
+
+// registerPIIRoutes serves the token maps to their owners. Outside dev mode
+// that takes a signed-in user: an anonymous map would open for anyone
+// holding its session ID.
+func registerPIIRoutes(g *gin.RouterGroup) {
+	if !devMode() {
+		g.Use(middleware.RequireAuth())
+	}
+	g.POST("/rehydrate", handlePIIRehydrate)
+	g.DELETE("/sessions/:id", handlePIIForget)
+}
+
+// ownerID is who a token map or chat stream belongs to: the signed-in
+// user, or nobody when auth is off, in which case the random ID is the
+// only key. The tokenizer refuses ownerless maps outside dev mode.
+func ownerID(c *gin.Context) string {
+	if user, ok := middleware.CurrentUser(c); ok {
+		return user.ID
+	}
+	return ""
+}
+
+// piiIdentity is how the caller's self tokens read when rehydrated.
+func piiIdentity(c *gin.Context) pii.Identity {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return pii.Identity{}
+	}
+	name := user.Username
+	if name == "" {
+		name = user.Email
+	}
+	if name == "" {
+		name = "You"
+	}
+	return pii.Identity{ID: user.ID, Name: name}
+}
+
+func requirePII(c *gin.Context) bool {
+	if piiTokens == nil {
+		c.Error(services.Unavailable("PII tokenization is not configured"))
+		return false
+	}
+	return true
+}
+
+// POST /api/pii/rehydrate { "pii_session": "...", "text": "..." }
+// Puts the values behind the session's tokens back into text, for the
+// session's owner only.
+func handlePIIRehydrate(c *gin.Context) {
+	if !requirePII(c) {
+		return
+	}
+	var req struct {
+		Session string `json:"pii_session" binding:"required"`
+		Text    string `json:"text"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	text, err := piiTokens.Rehydrate(c.Request.Context(), ownerID(c), req.Session, req.Text, piiIdentity(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"text": text})
+}
+
+// DELETE /api/pii/sessions/:id drops a session's token map before it expires.
+func handlePIIForget(c *gin.Context) {
+	if !requirePII(c) {
+		return
+	}
+	id := c.Param("id")
+	if err := piiTokens.Forget(c.Request.Context(), ownerID(c), id); err != nil {
+		c.Error(err)
+		return
+	}
+	entry := audit.Entry{Action: "pii.forget", IP: c.ClientIP(), Resource: "pii:" + id}
+	if user, ok := middleware.CurrentUser(c); ok {
+		entry.ActorID, entry.Actor = user.ID, user.Username
+	}
+	audit.Record(c.Request.Context(), entry)
+	slog.InfoContext(c.Request.Context(), "PII token map deleted")
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// chatVault is where a chat's tokens are kept, nil when tokenization is off.
+type chatVault struct {
+	owner   string
+	session string
+	tokens  int // Values tokenized in the message
+}
+
+// tokenizeChat replaces the personal data in a chat message before it goes
+// to QAST, under the client's pii_session or a new one.
+func tokenizeChat(c *gin.Context, text, session string) (string, *chatVault, error) {
+	if piiTokens == nil {
+		if piiRequired {
+			return "", nil, services.Unavailable("PII tokenization is not configured")
+		}
+		return text, nil, nil
+	}
+	if session == "" {
+		session = pii.NewSession()
+	}
+	v := &chatVault{owner: ownerID(c), session: session}
+	text, n, err := piiTokens.Tokenize(c.Request.Context(), v.owner, session, text)
+	if err != nil {
+		return "", nil, err
+	}
+	v.tokens = n
+	return text, v, nil
+}
+
+// absorb keeps the token map QAST sends with its answer server-side, where
+// the rehydrate endpoint finds it.
+func (v *chatVault) absorb(ctx context.Context, ev sse.Event) {
+	var tokens map[string]string
+	if err := ev.JSON(&tokens); err != nil {
+		logging.For("pii").WarnContext(ctx, "Dropped an unreadable token map", "event", ev.Name(), "error", err)
+		return
+	}
+	if err := piiTokens.Merge(context.WithoutCancel(ctx), v.owner, v.session, tokens); err != nil {
+		logging.For("pii").ErrorContext(ctx, "Failed to store QAST's tokens", "event", ev.Name(), "error", err)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"wodge/internal/drivers/qast"
+	"wodge/internal/logging"
+	"wodge/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// devMode reports whether the server runs for local development.
+// `wodge dev` sets WODGE_ENV=development unless the app's .env says
+// otherwise; deployments set production.
+func devMode() bool {
+	switch strings.ToLower(os.Getenv("WODGE_ENV")) {
+	case "dev", "development":
+		return true
+	}
+	return false
+}
+
+// initQast connects the QAST client when QAST_URL is set.
+func initQast() {
+	qastURL := os.Getenv("QAST_URL")
+	if qastURL == "" {
+		slog.Info("QAST_URL is empty, skipping QAST init")
+		return
+	}
+	cfg := qast.Config{
+		APIKey:           os.Getenv("QAST_API_KEY"),
+		DevMode:          devMode(),
+		Timeout:          qastDuration("QAST_TIMEOUT"),
+		AskTimeout:       qastDuration("QAST_ASK_TIMEOUT"),
+		IngestTimeout:    qastDuration("QAST_INGEST_TIMEOUT"),
+		ChatTimeout:      qastDuration("QAST_CHAT_TIMEOUT"),
+		BreakerCooldown:  qastDuration("QAST_BREAKER_COOLDOWN"),
+		Retries:          qastInt("QAST_RETRIES"),
+		BreakerThreshold: qastInt("QAST_BREAKER_THRESHOLD"),
+	}
+	driver, err := qast.NewQastDriver(qastURL, cfg)
+	if err != nil {
+		logging.For("qast").Error("QAST is disabled", "error", err)
+		return
+	}
+	if cfg.APIKey == "" {
+		logging.For("qast").Warn("QAST_API_KEY is not set, using the development token")
+	}
+	qastSvc = driver
+	chatLimits = chatConfigFromEnv()
+	initChatBuffer()
+	logging.For("qast").Info("QAST driver initialized", "url", qastURL)
+}
+
+// qastDuration reads an optional duration, zero meaning the driver default.
+func qastDuration(key string) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		slog.Error("Invalid duration, using the default", "setting", key, "value", v)
+		return 0
+	}
+	return d
+}
+
+// qastInt reads an optional count, zero meaning the driver default.
+func qastInt(key string) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Error("Invalid number, using the default", "setting", key, "value", v)
+		return 0
+	}
+	return n
+}
+
+// GET /api/ready reports whether the configured services accept requests.
+// Unlike /api/health it fails while a dependency is known to be down, e.g.
+// while the QAST circuit breaker is open.
+func handleReady(c *gin.Context) {
+	checks := gin.H{}
+	ready := true
+	for name, svc := range map[string]interface{}{"postgres": db, "redis": cache, "rabbitmq": queue, "qast": qastSvc, "auth": authSvc} {
+		if svc == nil {
+			continue
+		}
+		status := gin.H{"status": "ok"}
+		if checker, ok := svc.(services.ReadinessChecker); ok {
+			if err := checker.Ready(); err != nil {
+				ready = false
+				status = gin.H{"status": "unavailable"}
+				var typed *services.Error
+				if errors.As(err, &typed) {
+					status["detail"] = typed.Message
+					for k, v := range typed.Details {
+						status[k] = v
+					}
+				}
+			}
+		}
+		checks[name] = status
+	}
+	code, status := http.StatusOK, "ready"
+	if !ready {
+		code, status = http.StatusServiceUnavailable, "unavailable"
+	}
+	c.JSON(code, gin.H{"status": status, "services": checks})
+}
@@ -2,18 +2,24 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 	"wodge/internal/drivers/astauth"
 	"wodge/internal/drivers/postgres"
-	"wodge/internal/drivers/qast"
 	"wodge/internal/drivers/rabbitmq"
 	"wodge/internal/drivers/redis"
+	"wodge/internal/logging"
 	"wodge/internal/middleware"
 	"wodge/internal/monitor"
+	"wodge/internal/redact"
+	"wodge/internal/requestid"
+	"wodge/internal/security"
 	"wodge/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -25,59 +31,88 @@ var (
 	cache      services.CacheService
 	queue      services.QueueService
 	qastSvc    services.QastService
-	astAuthSvc *astauth.AstAuthDriver
+	authSvc    services.AuthService
+	loginGuard *security.LoginGuard
+	passwords  security.PasswordPolicy
+	sessions   *security.SessionFamilies
 )
 
 // Start starts the Wodge API server
 func Start(port int) {
-	// Print debug info about env vars
-	log.Printf("DEBUG: POSTGRES_DSN=%s", os.Getenv("POSTGRES_DSN"))
-	log.Printf("DEBUG: REDIS_ADDR=%s", os.Getenv("REDIS_ADDR"))
+	initLogging()
+
+	// Redact, persist and audit before anything publishes events
+	redactor := initRedaction()
+	initMonitorStore()
+	initAudit()
+	initOperator()
 
 	// Initialize Services
+	initAcceptance()
 	initServices()
+	initPII()
+	initIngest()
+	initIncidents()
+	initDataSubjects()
 
-	r := gin.Default()
+	r := newRouter(redactor)
 
-	// Add Request Logging Middleware
-	r.Use(middleware.RequestLogger())
+	slog.Info("Starting Wodge API server", "port", port)
+	slog.Debug("Frontend will access APIs via http://localhost:5173/api")
 
-	// Enable CORS for dev server
-	r.Use(func(c *gin.Context) {
-		// Dynamic ORIGIN support for development
-		// In production, this should be stricter, but for dev tool we can trust localhost
-		origin := c.Request.Header.Get("Origin")
-		// Check if origin is localhost or 127.0.0.1
-		// Simplest for now: Allow all localhost ports
-		if origin != "" {
-			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
-		} else {
-			// Fallback
-			c.Writer.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
-		}
+	// Format address
+	addr := fmt.Sprintf(":%d", port)
 
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
+	if err := r.Run(addr); err != nil {
+		slog.Error("Server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// newRouter wires the middleware and routes around the initialized services.
+func newRouter(redactor *redact.Redactor) *gin.Engine {
+	r := gin.New()
+
+	r.Use(middleware.RequestID())
+	r.Use(middleware.AccessLog(), gin.Recovery())
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+	// Add Request Logging Middleware
+	r.Use(middleware.RequestLogger(redactor))
+
+	// Browsers may call the API from the origins in CORS_ORIGINS (the dev
+	// frontend on localhost when unset). The /wodge operations API is for
+	// the CLI and admins, not for other sites' pages, so it never gets CORS
+	// headers.
+	cors := middleware.CORS(corsOrigins())
+	r.Use(func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/wodge/") {
+			c.Next()
 			return
 		}
-
-		c.Next()
+		cors(c)
 	})
 
+	// Errors renders problem bodies as its handlers return, so it goes after
+	// everything that reads the final status: the loggers above and
+	// auditAccess, which renders its group's errors with a second Errors().
+	r.Use(middleware.Errors())
+
 	// Register API endpoints
 	r.GET("/api/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
+	r.GET("/api/ready", handleReady)
 
-	// Monitor Event Stream
-	r.GET("/wodge/monitor/events", monitor.Handler)
+	registerMonitorRoutes(r.Group("/wodge/monitor"))
+	registerIncidentRoutes(r.Group("/wodge/incidents"))
+	registerDataSubjectRoutes(r.Group("/wodge/data-subjects"))
+	registerComplianceRoutes(r.Group("/wodge/compliance"))
 
 	// Service Routes
 	api := r.Group("/api")
+	api.Use(middleware.CSRF())
+	api.Use(middleware.Authenticate(verifyToken))
+	api.Use(auditAccess(), middleware.Errors())
 	{
 		// Postgres Routes
 		api.POST("/postgres/query", handlePostgresQuery)
@@ -96,7 +131,11 @@ func Start(port int) {
 		api.POST("/qast/ask", handleQastAsk)
 		api.POST("/qast/ingest", handleQastIngest)
 		api.POST("/qast/ingest/async", handleQastIngestAsync)
+		registerIngestRoutes(api.Group("/qast"))
 		api.POST("/qast/chat", handleQastSecureChat)
+		api.GET("/qast/chat/:id/events", handleQastChatResume)
+		api.POST("/qast/chat/:id/cancel", handleQastChatCancel)
+		registerPIIRoutes(api.Group("/pii"))
 
 		// History Routes (Qast Proxy)
 		api.POST("/history/sessions", handleHistoryCreateSession)
@@ -111,6 +150,10 @@ func Start(port int) {
 		api.POST("/auth/verify", handleAuthVerify)
 		api.GET("/users/me", handleAuthVerify) // Alias for verify
 		api.POST("/auth/logout", handleAuthLogout)
+		api.GET("/auth/config", handleAuthConfig)
+		api.GET("/auth/oidc/login", handleOIDCLogin)
+		api.GET("/auth/oidc/callback", handleOIDCCallback)
+		registerMFARoutes(api.Group("/auth/mfa"))
 		api.GET("/users/search", handleUsersSearch)
 
 		// Share Route
@@ -120,16 +163,20 @@ func Start(port int) {
 		api.PUT("/context/:id", handleContextUpdate)
 		api.GET("/context/:id", handleContextGet)
 	}
+	return r
+}
 
-	log.Printf("Starting Wodge API server on :%d\n", port)
-	log.Println("--- WODGE SERVER VERSION: CONTEXT_UPDATE_PATCHED ---")
-	log.Println("Frontend will access APIs via: http://localhost:5173/api")
-
-	// Format address
-	addr := fmt.Sprintf(":%d", port)
-
-	if err := r.Run(addr); err != nil {
-		log.Fatal(err)
+// initLogging routes all server output through slog as configured by the
+// LOG_* settings. The sinks stay open for the life of the process.
+func initLogging() {
+	cfg, err := logging.FromEnv()
+	if err == nil {
+		_, err = logging.Setup(cfg)
+	}
+	if err != nil {
+		slog.Error("Invalid logging config, logging text to stderr", "error", err)
+		cfg.Format, cfg.Sinks = "text", []string{"stderr"}
+		_, _ = logging.Setup(cfg)
 	}
 }
 
@@ -140,13 +187,15 @@ func initServices() {
 		var err error
 		db, err = postgres.NewPostgresDriver(dsn)
 		if err != nil {
-			log.Printf("ERROR: Failed to init Postgres: %v", err)
+			logging.For("postgres").Error("Failed to init Postgres", "dsn", dsn, "error", err)
 			db = nil // Ensure strictly nil
 		} else {
-			log.Println("Postgres connected successfully")
+			acceptVersion("postgres", db)
+			db = monitor.InstrumentDatabase(db)
+			logging.For("postgres").Info("Postgres connected", "dsn", dsn)
 		}
 	} else {
-		log.Println("POSTGRES_DSN is empty, skipping Postgres init")
+		slog.Info("POSTGRES_DSN is empty, skipping Postgres init")
 	}
 
 	// Redis
@@ -156,13 +205,15 @@ func initServices() {
 		var err error
 		cache, err = redis.NewRedisDriver(redisAddr, pass, 0)
 		if err != nil {
-			log.Printf("ERROR: Failed to init Redis: %v", err)
+			logging.For("redis").Error("Failed to init Redis", "addr", redisAddr, "error", err)
 			cache = nil
 		} else {
-			log.Println("Redis connected successfully")
+			acceptVersion("redis", cache)
+			cache = monitor.InstrumentCache(cache)
+			logging.For("redis").Info("Redis connected", "addr", redisAddr)
 		}
 	} else {
-		log.Println("REDIS_ADDR is empty, skipping Redis init")
+		slog.Info("REDIS_ADDR is empty, skipping Redis init")
 	}
 
 	// RabbitMQ
@@ -171,31 +222,89 @@ func initServices() {
 		var err error
 		queue, err = rabbitmq.NewRabbitMQDriver(amqpUrl)
 		if err != nil {
-			log.Printf("ERROR: Failed to init RabbitMQ: %v", err)
+			logging.For("rabbitmq").Error("Failed to init RabbitMQ", "url", amqpUrl, "error", err)
 			queue = nil
 		} else {
-			log.Println("RabbitMQ connected successfully")
+			acceptVersion("rabbitmq", queue)
+			queue = monitor.InstrumentQueue(queue)
+			logging.For("rabbitmq").Info("RabbitMQ connected", "url", amqpUrl)
 		}
 	}
 
 	// QAST
-	qastURL := os.Getenv("QAST_URL")
-	if qastURL != "" {
-		apiKey := os.Getenv("QAST_API_KEY")
-		qastSvc = qast.NewQastDriver(qastURL, apiKey)
-		log.Println("QAST driver initialized")
-	} else {
-		log.Println("QAST_URL is empty, skipping QAST init")
+	initQast()
+
+	session = sessionConfigFromEnv()
+	slog.Info("Auth session mode", "mode", session.Mode)
+
+	// Login brute-force protection is enforced by wodge regardless of backend limits
+	loginGuard = security.NewLoginGuard(security.LockoutPolicyFromEnv())
+	sessions = security.NewSessionFamilies(session.RefreshTTL)
+	passwords = security.PasswordPolicyFromEnv()
+	if passwords.BreachDir != "" {
+		if _, err := os.Stat(passwords.BreachDir); err != nil {
+			slog.Warn("PASSWORD_BREACH_DIR is not readable, breached-password checks are skipped", "error", err)
+		}
 	}
+	initMFA()
+
+	// Auth provider
+	switch provider := os.Getenv("AUTH_PROVIDER"); provider {
+	case "oidc":
+		initOIDC()
+	case "", "astauth":
+		initAstAuth()
+	default:
+		slog.Error("Unknown AUTH_PROVIDER, skipping auth init", "provider", provider)
+	}
+}
 
-	// AstAuth
+func initAstAuth() {
 	astAuthURL := os.Getenv("ASTAUTH_URL")
-	if astAuthURL != "" {
-		astAuthSvc = astauth.NewAstAuthDriver(astAuthURL)
-		log.Println("AstAuth driver initialized")
-	} else {
-		log.Println("ASTAUTH_URL is empty, skipping AstAuth init")
+	if astAuthURL == "" {
+		slog.Info("ASTAUTH_URL is empty, skipping AstAuth init")
+		return
+	}
+	driver := astauth.NewAstAuthDriver(astAuthURL)
+	// Local verification would accept any token signed by the AstAuth keys,
+	// including ones issued for other services, so it needs both claims pinned
+	issuer, audience := os.Getenv("ASTAUTH_ISSUER"), os.Getenv("ASTAUTH_AUDIENCE")
+	switch {
+	case os.Getenv("ASTAUTH_LOCAL_VERIFY") == "false":
+	case issuer == "" || audience == "":
+		logging.For("astauth").Warn("ASTAUTH_ISSUER or ASTAUTH_AUDIENCE is not set, local JWT verification is disabled")
+	default:
+		leeway, _ := time.ParseDuration(os.Getenv("ASTAUTH_JWT_LEEWAY"))
+		driver.EnableLocalVerification(astauth.JWTConfig{
+			JWKSURL:  os.Getenv("ASTAUTH_JWKS_URL"),
+			Issuer:   issuer,
+			Audience: audience,
+			Leeway:   leeway,
+		})
+		logging.For("astauth").Info("Local JWT verification enabled")
+	}
+	authSvc = driver
+	logging.For("astauth").Info("AstAuth driver initialized", "url", astAuthURL)
+}
+
+// corsOrigins reads CORS_ORIGINS, a comma-separated list of origins such as
+// https://app.example.com.
+func corsOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("CORS_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
 	}
+	return origins
+}
+
+// verifyToken backs the auth middleware with whichever auth driver is configured.
+func verifyToken(ctx context.Context, token string) (*services.User, error) {
+	if authSvc == nil {
+		return nil, errors.New("auth not configured")
+	}
+	return authSvc.VerifyToken(ctx, token)
 }
 
 // -- Handlers --
@@ -203,20 +312,19 @@ func initServices() {
 // POST /api/postgres/query { "query": "SELECT...", "args": [...] }
 func handlePostgresQuery(c *gin.Context) {
 	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Postgres not configured"})
+		c.Error(services.Unavailable("Postgres not configured"))
 		return
 	}
 	var req struct {
-		Query string        `json:"query"`
+		Query string        `json:"query" binding:"required"`
 		Args  []interface{} `json:"args"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 	results, err := db.Query(c.Request.Context(), req.Query, req.Args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, results)
@@ -225,20 +333,19 @@ func handlePostgresQuery(c *gin.Context) {
 // POST /api/postgres/execute { "query": "INSERT...", "args": [...] }
 func handlePostgresExecute(c *gin.Context) {
 	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Postgres not configured"})
+		c.Error(services.Unavailable("Postgres not configured"))
 		return
 	}
 	var req struct {
-		Query string        `json:"query"`
+		Query string        `json:"query" binding:"required"`
 		Args  []interface{} `json:"args"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 	rows, err := db.Execute(c.Request.Context(), req.Query, req.Args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"rows_affected": rows})
@@ -247,13 +354,13 @@ func handlePostgresExecute(c *gin.Context) {
 // GET /api/redis/:key
 func handleRedisGet(c *gin.Context) {
 	if cache == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Redis not configured"})
+		c.Error(services.Unavailable("Redis not configured"))
 		return
 	}
 	key := c.Param("key")
 	val, err := cache.Get(c.Request.Context(), key)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"}) // approximate
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"value": val})
@@ -262,20 +369,19 @@ func handleRedisGet(c *gin.Context) {
 // POST /api/redis { "key": "...", "value": "...", "ttl": 60 }
 func handleRedisSet(c *gin.Context) {
 	if cache == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Redis not configured"})
+		c.Error(services.Unavailable("Redis not configured"))
 		return
 	}
 	var req struct {
-		Key   string `json:"key"`
+		Key   string `json:"key" binding:"required"`
 		Value string `json:"value"`
-		TTL   int    `json:"ttl"`
+		TTL   int    `json:"ttl" binding:"gte=0"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 	if err := cache.Set(c.Request.Context(), req.Key, req.Value, req.TTL); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -284,12 +390,12 @@ func handleRedisSet(c *gin.Context) {
 // DELETE /api/redis/:key
 func handleRedisDelete(c *gin.Context) {
 	if cache == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Redis not configured"})
+		c.Error(services.Unavailable("Redis not configured"))
 		return
 	}
 	key := c.Param("key")
 	if err := cache.Delete(c.Request.Context(), key); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -298,19 +404,18 @@ func handleRedisDelete(c *gin.Context) {
 // POST /api/queue/publish { "topic": "...", "message": "..." }
 func handleQueuePublish(c *gin.Context) {
 	if queue == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "RabbitMQ not configured"})
+		c.Error(services.Unavailable("RabbitMQ not configured"))
 		return
 	}
 	var req struct {
-		Topic   string `json:"topic"`
+		Topic   string `json:"topic" binding:"required"`
 		Message string `json:"message"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 	if err := queue.Publish(c.Request.Context(), req.Topic, []byte(req.Message)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -319,43 +424,45 @@ func handleQueuePublish(c *gin.Context) {
 // POST /api/qast/ask { "query": "..." }
 func handleQastAsk(c *gin.Context) {
 	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
+		c.Error(services.Unavailable("QAST not configured"))
 		return
 	}
 	var req struct {
-		Query          string `json:"query"`
+		Query          string `json:"query" binding:"required"`
 		UserID         string `json:"user_id"`
 		ExpertiseLevel string `json:"expertise_level"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 	answer, context, err := qastSvc.Ask(c.Request.Context(), req.Query, req.UserID, req.ExpertiseLevel)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"answer": answer, "context": context})
 }
 
-// POST /api/qast/ingest { "text": "..." }
+// POST /api/qast/ingest { "text": "..." }, or a file as multipart/form-data
 func handleQastIngest(c *gin.Context) {
 	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
+		c.Error(services.Unavailable("QAST not configured"))
+		return
+	}
+	if isUpload(c) {
+		handleQastIngestUpload(c)
 		return
 	}
 	var req struct {
-		Text   string `json:"text"`
+		Text   string `json:"text" binding:"required"`
 		UserID string `json:"user_id"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 	result, err := qastSvc.IngestGraph(c.Request.Context(), req.Text, req.UserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok", "result": result})
@@ -364,113 +471,50 @@ func handleQastIngest(c *gin.Context) {
 // POST /api/qast/ingest/async { "text": "..." }
 func handleQastIngestAsync(c *gin.Context) {
 	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
+		c.Error(services.Unavailable("QAST not configured"))
 		return
 	}
 	var req struct {
-		Text   string `json:"text"`
+		Text   string `json:"text" binding:"required"`
 		UserID string `json:"user_id"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 
-	// Run in background
+	// Run in background. The request context is cancelled once we respond,
+	// so detach from it but keep the request ID for correlation.
+	ctx := requestid.Detach(c.Request.Context())
 	go func() {
-		// Create a background context since request context will be cancelled
-		ctx := context.Background()
-		log.Printf("Starting async ingest for user %s...", req.UserID)
+		slog.InfoContext(ctx, "Starting async ingest", "user_id", req.UserID)
 		_, err := qastSvc.IngestGraph(ctx, req.Text, req.UserID)
 		if err != nil {
-			log.Printf("Async ingest failed: %v", err)
+			slog.ErrorContext(ctx, "Async ingest failed", "user_id", req.UserID, "error", err)
 		} else {
-			log.Printf("Async ingest completed for user %s", req.UserID)
+			slog.InfoContext(ctx, "Async ingest completed", "user_id", req.UserID)
 		}
 	}()
 
 	c.JSON(http.StatusAccepted, gin.H{"status": "accepted", "message": "Ingestion started in background"})
 }
 
-func handleQastSecureChat(c *gin.Context) {
-	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
-		return
-	}
-	var req struct {
-		Text            string `json:"text"`
-		UserID          string `json:"user_id"`
-		SessionID       string `json:"session_id"`
-		TargetMessageID string `json:"target_message_id"`
-	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Extract Bearer token from header
-	token := ""
-	authHeader := c.GetHeader("Authorization")
-	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-		token = authHeader[7:]
-	}
-	log.Printf("[Wodge Server] SecureChat Auth: HeaderLen=%d, TokenLen=%d", len(authHeader), len(token))
-
-	stream, err := qastSvc.SecureChat(c.Request.Context(), req.Text, req.UserID, req.SessionID, req.TargetMessageID, token)
-	if err != nil {
-		log.Printf("[Wodge] SecureChat failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer stream.Close()
-
-	// Set SSE headers
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.Writer.Header().Set("Connection", "keep-alive")
-	c.Writer.Header().Set("Transfer-Encoding", "chunked")
-
-	// Flush immediately to establish stream
-	c.Writer.Flush()
-
-	// Manual copy loop to ensure flushing after every chunk
-	buf := make([]byte, 1024)
-	for {
-		n, err := stream.Read(buf)
-		if n > 0 {
-			if _, wErr := c.Writer.Write(buf[:n]); wErr != nil {
-				log.Printf("[Wodge] Streaming write error: %v", wErr)
-				return // Client disconnected
-			}
-			c.Writer.Flush()
-		}
-		if err != nil {
-			if err != io.EOF {
-				log.Printf("[Wodge] Streaming read error: %v", err)
-			}
-			break
-		}
-	}
-}
-
 // -- History Handlers --
 
 func handleHistoryCreateSession(c *gin.Context) {
 	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
+		c.Error(services.Unavailable("QAST not configured"))
 		return
 	}
 	var req struct {
-		UserID string `json:"user_id"`
-		Title  string `json:"title"`
+		UserID string `json:"user_id" binding:"required"`
+		Title  string `json:"title" binding:"max=200"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 	sess, err := qastSvc.CreateSession(c.Request.Context(), req.UserID, req.Title)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusCreated, sess)
@@ -478,17 +522,17 @@ func handleHistoryCreateSession(c *gin.Context) {
 
 func handleHistoryGetSessions(c *gin.Context) {
 	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
+		c.Error(services.Unavailable("QAST not configured"))
 		return
 	}
 	userID := c.Query("user_id")
 	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id required"})
+		c.Error(services.Validation("user_id is required", services.FieldError{Field: "user_id", Code: "required", Message: "user_id is required"}))
 		return
 	}
 	sessions, err := qastSvc.GetSessions(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, sessions)
@@ -496,18 +540,13 @@ func handleHistoryGetSessions(c *gin.Context) {
 
 func handleHistoryGetSession(c *gin.Context) {
 	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
+		c.Error(services.Unavailable("QAST not configured"))
 		return
 	}
 	sessionID := c.Param("id")
 	sess, err := qastSvc.GetSession(c.Request.Context(), sessionID)
 	if err != nil {
-		status := http.StatusInternalServerError
-		// Naive check for 404
-		if err.Error() == "failed to get session: 404" {
-			status = http.StatusNotFound
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, sess)
@@ -515,134 +554,296 @@ func handleHistoryGetSession(c *gin.Context) {
 
 func handleHistoryDeleteSession(c *gin.Context) {
 	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
+		c.Error(services.Unavailable("QAST not configured"))
 		return
 	}
 	sessionID := c.Param("id")
 	if err := qastSvc.DeleteSession(c.Request.Context(), sessionID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
 }
 
-// -- AstAuth Handlers --
+// -- Auth Handlers --
 
 // POST /api/auth/login
 func handleAuthLogin(c *gin.Context) {
-	if astAuthSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AstAuth not configured"})
+	if authSvc == nil {
+		c.Error(services.Unavailable("Auth provider not configured"))
 		return
 	}
 	var req struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
-	resp, err := astAuthSvc.Login(c.Request.Context(), req.Username, req.Password)
+
+	ip := c.ClientIP()
+	if wait, locked := loginGuard.Check(req.Username, ip); locked {
+		security.Emit(c.Request.Context(), security.Event{
+			Action:   security.ActionLoginBlocked,
+			Severity: security.SeverityWarning,
+			Username: req.Username,
+			IP:       ip,
+			Reason:   "attempt during lockout",
+		})
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.Error(services.RateLimited("Too many failed login attempts, try again later"))
+		return
+	}
+
+	resp, err := authSvc.Login(c.Request.Context(), req.Username, req.Password)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		if errors.Is(err, services.ErrNotSupported) {
+			c.Error(&services.Error{Kind: services.ErrNotSupported, Message: "Password login is not available, sign in through the identity provider"})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			recordLoginFailure(c, req.Username, ip, security.ActionLoginFailed)
+		}
+		c.Error(err)
 		return
 	}
 
 	// Sync User to QAST
 	if qastSvc != nil {
+		ctx := requestid.Detach(c.Request.Context())
 		go func() {
-			ctx := context.Background() // detach context
 			if err := qastSvc.SyncUser(ctx, resp.User.ID, resp.User.Email, resp.User.Username, resp.User.FirstName, resp.User.LastName); err != nil {
-				log.Printf("[Wodge] Failed to sync user %s to Qast: %v", resp.User.ID, err)
+				slog.ErrorContext(ctx, "Failed to sync user to Qast", "user_id", resp.User.ID, "error", err)
 			}
 		}()
 	}
 
-	c.JSON(http.StatusOK, resp)
+	// Second factor: hold the tokens back until the MFA step passes. The
+	// failure count is only cleared once it has, so a known password doesn't
+	// reset the count of wrong codes.
+	enrollment, err := mfaStore.Get(resp.User.ID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "MFA store unavailable", "error", err)
+		c.Error(services.Unavailable("MFA store unavailable"))
+		return
+	}
+	if enrollment.Active() {
+		c.JSON(http.StatusOK, startMFAChallenge(req.Username, resp, enrollment))
+		return
+	}
+	loginGuard.Success(req.Username)
+
+	startSession(c, resp, "password")
+	writeSession(c, resp, gin.H{"mfa_enrollment_required": mfaRequiredFor(resp.User.Role)})
+}
+
+// startSession begins the refresh token family of a completed login and
+// records the login. method says how the user authenticated.
+func startSession(c *gin.Context, resp *services.AuthResponse, method string) {
+	fam := sessions.Start(resp.User.ID, resp.AccessToken, resp.RefreshToken)
+	security.Emit(c.Request.Context(), security.Event{
+		Action:    security.ActionLogin,
+		Severity:  security.SeverityInfo,
+		Username:  resp.User.Username,
+		UserID:    resp.User.ID,
+		SessionID: fam.ID,
+		IP:        c.ClientIP(),
+		Reason:    method,
+	})
+}
+
+// recordLoginFailure counts a failed login, emits the matching security
+// events and holds the response back by the progressive delay.
+func recordLoginFailure(c *gin.Context, username, ip, action string) {
+	f := loginGuard.Failure(username, ip)
+	attempts := f.UserAttempts
+	if f.IPAttempts > attempts {
+		attempts = f.IPAttempts
+	}
+	security.Emit(c.Request.Context(), security.Event{
+		Action:   action,
+		Severity: security.SeverityInfo,
+		Username: username,
+		IP:       ip,
+		Attempts: attempts,
+	})
+	if f.Locked {
+		until := f.LockedUntil
+		security.Emit(c.Request.Context(), security.Event{
+			Action:      security.ActionLockout,
+			Severity:    security.SeverityWarning,
+			Username:    username,
+			IP:          ip,
+			Attempts:    attempts,
+			LockedUntil: &until,
+			Reason:      f.LockedKey + " exceeded failed attempt limit",
+		})
+	}
+
+	select {
+	case <-time.After(f.Delay):
+	case <-c.Request.Context().Done():
+	}
 }
 
 // POST /api/auth/register
 func handleAuthRegister(c *gin.Context) {
-	if astAuthSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AstAuth not configured"})
+	if authSvc == nil {
+		c.Error(services.Unavailable("Auth provider not configured"))
 		return
 	}
 	var req struct {
-		Email           string `json:"email"`
-		Username        string `json:"username"`
-		Password        string `json:"password"`
-		ConfirmPassword string `json:"confirm_password"`
-		FirstName       string `json:"first_name"`
-		LastName        string `json:"last_name"`
+		Email           string `json:"email" binding:"required,email"`
+		Username        string `json:"username" binding:"required,max=64"`
+		Password        string `json:"password" binding:"required"`
+		ConfirmPassword string `json:"confirm_password" binding:"required"`
+		FirstName       string `json:"first_name" binding:"max=100"`
+		LastName        string `json:"last_name" binding:"max=100"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
-	err := astAuthSvc.Register(c.Request.Context(), req.Email, req.Username, req.Password, req.ConfirmPassword, req.FirstName, req.LastName)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+	// Enforced here so weak passwords never reach the provider
+	if fields := passwords.Validate(req.Password, req.ConfirmPassword, req.Username, req.Email); len(fields) > 0 {
+		c.Error(services.Validation(fields[0].Message, fields...))
 		return
 	}
+
+	err := authSvc.Register(c.Request.Context(), req.Email, req.Username, req.Password, req.ConfirmPassword, req.FirstName, req.LastName)
+	if errors.Is(err, services.ErrNotSupported) {
+		c.Error(&services.Error{Kind: services.ErrNotSupported, Message: "Registration is handled by the identity provider"})
+		return
+	}
+	if err != nil {
+		security.Emit(c.Request.Context(), security.Event{
+			Action:   security.ActionRegisterFailed,
+			Severity: security.SeverityInfo,
+			Username: req.Username,
+			IP:       c.ClientIP(),
+		})
+		c.Error(err)
+		return
+	}
+	security.Emit(c.Request.Context(), security.Event{
+		Action:   security.ActionRegister,
+		Severity: security.SeverityInfo,
+		Username: req.Username,
+		IP:       c.ClientIP(),
+	})
 	c.JSON(http.StatusCreated, gin.H{"status": "created"})
 }
 
 // POST /api/auth/refresh
 func handleAuthRefresh(c *gin.Context) {
-	if astAuthSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AstAuth not configured"})
+	if authSvc == nil {
+		c.Error(services.Unavailable("Auth provider not configured"))
 		return
 	}
 	var req struct {
 		RefreshToken string `json:"refresh_token"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Body is optional: in cookie mode the refresh token arrives as a cookie
+	if c.Request.ContentLength > 0 {
+		if !middleware.BindJSON(c, &req) {
+			return
+		}
+	}
+	if req.RefreshToken == "" {
+		req.RefreshToken = middleware.RefreshToken(c)
+	}
+	if req.RefreshToken == "" {
+		c.Error(services.Validation("refresh_token is required", services.FieldError{Field: "refresh_token", Code: "required", Message: "refresh_token is required"}))
 		return
 	}
-	resp, err := astAuthSvc.RefreshToken(c.Request.Context(), req.RefreshToken)
+
+	// Reject rotated-out tokens before the provider sees them
+	if fam, err := sessions.Check(req.RefreshToken); err != nil {
+		handleRefreshRejected(c, fam, err)
+		return
+	}
+
+	resp, err := authSvc.RefreshToken(c.Request.Context(), req.RefreshToken)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		if session.cookieMode() {
+			clearSessionCookies(c)
+		}
+		c.Error(err)
 		return
 	}
-	c.JSON(http.StatusOK, resp)
+
+	fam := sessions.Rotate(resp.User.ID, req.RefreshToken, resp.AccessToken, resp.RefreshToken)
+	security.Emit(c.Request.Context(), security.Event{
+		Action:    security.ActionTokenRefreshed,
+		Severity:  security.SeverityInfo,
+		UserID:    resp.User.ID,
+		SessionID: fam.ID,
+		IP:        c.ClientIP(),
+	})
+	writeSession(c, resp)
+}
+
+// handleRefreshRejected answers a refresh with a reused or revoked token. On
+// reuse the whole session family is revoked, upstream included, since either
+// the legitimate client or an attacker holds a stolen token.
+func handleRefreshRejected(c *gin.Context, fam *security.Family, err error) {
+	if errors.Is(err, security.ErrRefreshReuse) {
+		security.Emit(c.Request.Context(), security.Event{
+			Action:    security.ActionRefreshReuse,
+			Severity:  security.SeverityCritical,
+			UserID:    fam.UserID,
+			SessionID: fam.ID,
+			IP:        c.ClientIP(),
+			Reason:    "rotated refresh token presented again",
+		})
+		go func(ctx context.Context, access, refresh string) {
+			if err := authSvc.Logout(ctx, access, refresh); err != nil {
+				slog.ErrorContext(ctx, "Failed to revoke session family upstream", "family_id", fam.ID, "error", err)
+			}
+		}(requestid.Detach(c.Request.Context()), fam.AccessToken, fam.RefreshToken)
+		security.Emit(c.Request.Context(), security.Event{
+			Action:    security.ActionSessionRevoked,
+			Severity:  security.SeverityWarning,
+			UserID:    fam.UserID,
+			SessionID: fam.ID,
+			IP:        c.ClientIP(),
+			Reason:    "refresh token reuse",
+		})
+	}
+	if session.cookieMode() {
+		clearSessionCookies(c)
+	}
+	c.Error(services.Unauthorized("Session revoked, please log in again"))
 }
 
 // POST /api/auth/verify
 func handleAuthVerify(c *gin.Context) {
-	if astAuthSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AstAuth not configured"})
+	if authSvc == nil {
+		c.Error(services.Unavailable("Auth provider not configured"))
 		return
 	}
 
-	// Extract Bearer token from header
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing Authorization header"})
+	if middleware.AccessToken(c) == "" {
+		c.Error(services.Unauthorized("Missing Authorization header or session cookie"))
 		return
 	}
 
-	// Strip "Bearer " prefix if present
-	token := authHeader
-	if len(token) > 7 && token[:7] == "Bearer " {
-		token = token[7:]
-	}
-
-	user, err := astAuthSvc.VerifyToken(c.Request.Context(), token)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	// Already verified by the auth middleware
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		c.Error(services.Unauthorized("Invalid token"))
 		return
 	}
 
 	// Sync User to QAST (Async to not block response)
 	if qastSvc != nil {
+		ctx := requestid.Detach(c.Request.Context())
 		go func() {
-			ctx := context.Background()
-			log.Printf("[Wodge] Syncing user %s (%s) to Qast...", user.ID, user.Username)
+			slog.InfoContext(ctx, "Syncing user to Qast", "user_id", user.ID)
 			if err := qastSvc.SyncUser(ctx, user.ID, user.Email, user.Username, user.FirstName, user.LastName); err != nil {
-				log.Printf("[Wodge] Failed to sync user %s to Qast: %v", user.ID, err)
+				slog.ErrorContext(ctx, "Failed to sync user to Qast", "user_id", user.ID, "error", err)
 			} else {
-				log.Printf("[Wodge] Successfully synced user %s to Qast", user.ID)
+				slog.InfoContext(ctx, "Synced user to Qast", "user_id", user.ID)
 			}
 		}()
 	}
@@ -652,21 +853,37 @@ func handleAuthVerify(c *gin.Context) {
 
 // POST /api/auth/logout
 func handleAuthLogout(c *gin.Context) {
-	if astAuthSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AstAuth not configured"})
+	if authSvc == nil {
+		c.Error(services.Unavailable("Auth provider not configured"))
 		return
 	}
 	var req struct {
 		AccessToken  string `json:"access_token"`
 		RefreshToken string `json:"refresh_token"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	if c.Request.ContentLength > 0 {
+		if !middleware.BindJSON(c, &req) {
+			return
+		}
+	}
+	if req.AccessToken == "" {
+		req.AccessToken = middleware.AccessToken(c)
+	}
+	if req.RefreshToken == "" {
+		req.RefreshToken = middleware.RefreshToken(c)
+	}
+	if session.cookieMode() {
+		clearSessionCookies(c)
+	}
+	sessions.End(req.RefreshToken)
+	ev := security.Event{Action: security.ActionLogout, Severity: security.SeverityInfo, IP: c.ClientIP()}
+	if user, ok := middleware.CurrentUser(c); ok {
+		ev.UserID, ev.Username = user.ID, user.Username
 	}
-	err := astAuthSvc.Logout(c.Request.Context(), req.AccessToken, req.RefreshToken)
+	security.Emit(c.Request.Context(), ev)
+	err := authSvc.Logout(c.Request.Context(), req.AccessToken, req.RefreshToken)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
@@ -674,20 +891,19 @@ func handleAuthLogout(c *gin.Context) {
 
 func handleHistoryShareSession(c *gin.Context) {
 	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
+		c.Error(services.Unavailable("QAST not configured"))
 		return
 	}
 	sessionID := c.Param("id")
 	var req struct {
-		TargetUsername string `json:"target_username"`
+		TargetUsername string `json:"target_username" binding:"required"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 	resp, err := qastSvc.ShareSession(c.Request.Context(), sessionID, req.TargetUsername)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, resp)
@@ -695,17 +911,17 @@ func handleHistoryShareSession(c *gin.Context) {
 
 func handleUsersSearch(c *gin.Context) {
 	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
+		c.Error(services.Unavailable("QAST not configured"))
 		return
 	}
 	query := c.Query("q")
 	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'q' is required"})
+		c.Error(services.Validation("q is required", services.FieldError{Field: "q", Code: "required", Message: "q is required"}))
 		return
 	}
 	resp, err := qastSvc.SearchUsers(c.Request.Context(), query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, resp)
@@ -713,20 +929,19 @@ func handleUsersSearch(c *gin.Context) {
 
 func handleContextUpdate(c *gin.Context) {
 	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
+		c.Error(services.Unavailable("QAST not configured"))
 		return
 	}
 	id := c.Param("id")
 	var req struct {
 		Content string `json:"content"`
 	}
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 
 	if err := qastSvc.UpdateContext(c.Request.Context(), id, req.Content); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "updated"})
@@ -734,13 +949,13 @@ func handleContextUpdate(c *gin.Context) {
 
 func handleContextGet(c *gin.Context) {
 	if qastSvc == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "QAST not configured"})
+		c.Error(services.Unavailable("QAST not configured"))
 		return
 	}
 	id := c.Param("id")
 	ctxData, err := qastSvc.GetContext(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, ctxData)
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wodge/internal/audit"
+	"wodge/internal/middleware"
+	"wodge/internal/monitor"
+	"wodge/internal/redact"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A handler's c.Error is rendered before the access log, the monitor event
+// and the audit entry read the status, so all three see the error status.
+func TestErrorStatusIsLoggedMonitoredAndAudited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	logger, err := audit.Open(dir, nil, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.SetDefault(logger)
+	t.Cleanup(func() {
+		audit.SetDefault(nil)
+		logger.Close()
+	})
+
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	events := monitor.Bus.Subscribe()
+	defer monitor.Bus.Unsubscribe(events)
+
+	redactor, err := redact.New(redact.DefaultConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qastSvc = nil // POST /api/qast/ingest fails with c.Error(services.Unavailable(...))
+	r := newRouter(redactor)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/qast/ingest", strings.NewReader(`{"text":"x"}`)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("response status = %d, want 503", w.Code)
+	}
+
+	if !strings.Contains(logs.String(), "status=503") {
+		t.Errorf("access log does not show status 503:\n%s", logs.String())
+	}
+
+	monitored := 0
+	timeout := time.After(time.Second)
+	for monitored == 0 {
+		select {
+		case ev := <-events:
+			if entry, ok := ev.Payload.(middleware.LogEntry); ok && entry.Path == "/api/qast/ingest" {
+				monitored = entry.Status
+			}
+		case <-timeout:
+			t.Fatal("no monitor event for the request")
+		}
+	}
+	if monitored != http.StatusServiceUnavailable {
+		t.Errorf("monitored status = %d, want 503", monitored)
+	}
+
+	entries, err := audit.Read(dir, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found *audit.Entry
+	for i := range entries {
+		if entries[i].Resource == "POST /api/qast/ingest" {
+			found = &entries[i]
+		}
+	}
+	if found == nil {
+		t.Fatal("no audit entry for the request")
+	}
+	if status, _ := found.Details["status"].(float64); status != http.StatusServiceUnavailable {
+		t.Errorf("audited status = %v, want 503", found.Details["status"])
+	}
+	if found.Outcome != audit.Failure {
+		t.Errorf("audited outcome = %q, want %q", found.Outcome, audit.Failure)
+	}
+}
+
+// The operations API answers other sites' pages without CORS headers.
+func TestOperationsAPIHasNoCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	redactor, err := redact.New(redact.DefaultConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRouter(redactor)
+
+	for _, path := range []string{"/wodge/incidents", "/wodge/monitor/events"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Origin", "https://evil.example")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want none", path, got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q, want none", path, got)
+		}
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want 403 without the operator token", path, w.Code)
+		}
+	}
+}
+
+// Only listed origins, or loopback ones when none are listed, get CORS
+// headers and may send credentials.
+func TestCORSAllowsOnlyListedOrigins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	redactor, err := redact.New(redact.DefaultConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		origins string
+		origin  string
+		allowed bool
+	}{
+		{"dev frontend by default", "", "http://localhost:5173", true},
+		{"other site by default", "", "https://evil.example", false},
+		{"listed origin", "https://app.example.com, https://admin.example.com", "https://admin.example.com", true},
+		{"unlisted origin", "https://app.example.com", "https://evil.example", false},
+		{"localhost when a list is set", "https://app.example.com", "http://localhost:5173", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CORS_ORIGINS", tt.origins)
+			r := newRouter(redactor)
+			for _, method := range []string{http.MethodOptions, http.MethodGet} {
+				req := httptest.NewRequest(method, "/api/health", nil)
+				req.Header.Set("Origin", tt.origin)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				want, wantCreds := "", ""
+				if tt.allowed {
+					want, wantCreds = tt.origin, "true"
+				}
+				if got := w.Header().Get("Access-Control-Allow-Origin"); got != want {
+					t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", method, got, want)
+				}
+				if got := w.Header().Get("Access-Control-Allow-Credentials"); got != wantCreds {
+					t.Errorf("%s: Access-Control-Allow-Credentials = %q, want %q", method, got, wantCreds)
+				}
+			}
+		})
+	}
+}
+
+// Changes, refused requests and reads of user data are audited; other reads
+// and failed reads are left to the access log.
+func TestAuditAccessRecordsChangesReadsAndRefusals(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		method  string
+		route   string
+		status  int
+		audited bool
+	}{
+		{http.MethodGet, "/api/items/:id", http.StatusOK, false},
+		{http.MethodGet, "/api/items/:id", http.StatusNotFound, false},
+		{http.MethodGet, "/api/items/:id", http.StatusUnauthorized, true},
+		{http.MethodGet, "/api/items/:id", http.StatusForbidden, true},
+		{http.MethodGet, "/api/items/:id", http.StatusTooManyRequests, true},
+		{http.MethodPost, "/api/items/:id", http.StatusOK, true},
+		{http.MethodDelete, "/api/items/:id", http.StatusNoContent, true},
+		{http.MethodPut, "/api/items/:id", http.StatusUnprocessableEntity, true},
+		{http.MethodGet, "/api/qast/chat/:id/events", http.StatusOK, true},
+		{http.MethodGet, "/api/history/sessions/:id", http.StatusOK, true},
+		{http.MethodGet, "/api/admin/users/:id", http.StatusOK, true},
+		{http.MethodGet, "/api/history/sessions/:id", http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s %d", tt.method, tt.route, tt.status), func(t *testing.T) {
+			dir := t.TempDir()
+			logger, err := audit.Open(dir, nil, 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			audit.SetDefault(logger)
+			t.Cleanup(func() {
+				audit.SetDefault(nil)
+				logger.Close()
+			})
+
+			r := gin.New()
+			r.Use(auditAccess())
+			r.Handle(tt.method, tt.route, func(c *gin.Context) { c.Status(tt.status) })
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, strings.ReplaceAll(tt.route, ":id", "1"), nil))
+
+			entries, err := audit.Read(dir, time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if audited := len(entries) == 1; audited != tt.audited {
+				t.Errorf("audited = %v (%d entries), want %v", audited, len(entries), tt.audited)
+			}
+		})
+	}
+}
+
+// Outside dev mode the token maps are served to signed-in users only.
+func TestPIIRoutesRequireAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("WODGE_ENV", "production")
+	redactor, err := redact.New(redact.DefaultConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRouter(redactor)
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/api/pii/rehydrate", strings.NewReader(`{"pii_session":"s1","text":"[EMAIL_1]"}`)),
+		httptest.NewRequest(http.MethodDelete, "/api/pii/sessions/s1", nil),
+	} {
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: status = %d, want 401", req.Method, req.URL.Path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"wodge/internal/middleware"
+	"wodge/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Session modes
+const (
+	SessionModeBearer = "bearer" // Tokens returned in the JSON body (default)
+	SessionModeCookie = "cookie" // Tokens set as HttpOnly cookies, CSRF enforced
+)
+
+type sessionConfig struct {
+	Mode       string
+	Secure     bool
+	SameSite   http.SameSite
+	Domain     string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+var session sessionConfig
+
+// sessionConfigFromEnv reads AUTH_SESSION_MODE and the AUTH_COOKIE_* settings.
+func sessionConfigFromEnv() sessionConfig {
+	cfg := sessionConfig{
+		Mode:       SessionModeBearer,
+		Secure:     true,
+		SameSite:   http.SameSiteStrictMode,
+		Domain:     os.Getenv("AUTH_COOKIE_DOMAIN"),
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: 7 * 24 * time.Hour,
+	}
+	if strings.EqualFold(os.Getenv("AUTH_SESSION_MODE"), SessionModeCookie) {
+		cfg.Mode = SessionModeCookie
+	}
+	if os.Getenv("AUTH_COOKIE_SECURE") == "false" {
+		cfg.Secure = false
+	}
+	switch strings.ToLower(os.Getenv("AUTH_COOKIE_SAMESITE")) {
+	case "lax":
+		cfg.SameSite = http.SameSiteLaxMode
+	case "none":
+		cfg.SameSite = http.SameSiteNoneMode
+		cfg.Secure = true // Browsers reject SameSite=None without Secure
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUTH_COOKIE_ACCESS_TTL")); err == nil {
+		cfg.AccessTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUTH_COOKIE_REFRESH_TTL")); err == nil {
+		cfg.RefreshTTL = d
+	}
+	return cfg
+}
+
+func (s sessionConfig) cookieMode() bool {
+	return s.Mode == SessionModeCookie
+}
+
+// writeSession sends the auth response to the client according to the session
+// mode. In cookie mode the tokens never appear in the body. Extra fields are
+// merged into the response.
+func writeSession(c *gin.Context, resp *services.AuthResponse, extra ...gin.H) {
+	body := gin.H{"user": resp.User}
+	if session.cookieMode() {
+		body["csrf_token"] = setSessionCookies(c, resp)
+	} else {
+		body["access_token"] = resp.AccessToken
+		body["refresh_token"] = resp.RefreshToken
+	}
+	for _, e := range extra {
+		for k, v := range e {
+			body[k] = v
+		}
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// setSessionCookies sets the access, refresh and CSRF cookies and returns the CSRF token.
+func setSessionCookies(c *gin.Context, resp *services.AuthResponse) string {
+	csrf := newCSRFToken()
+	setCookie(c, middleware.AccessCookie, resp.AccessToken, "/", session.AccessTTL, true)
+	if resp.RefreshToken != "" {
+		// Refresh token is only ever needed by the auth routes
+		setCookie(c, middleware.RefreshCookie, resp.RefreshToken, "/api/auth", session.RefreshTTL, true)
+	}
+	// CSRF cookie must be readable by the client for the double-submit header
+	setCookie(c, middleware.CSRFCookie, csrf, "/", session.RefreshTTL, false)
+	return csrf
+}
+
+func clearSessionCookies(c *gin.Context) {
+	setCookie(c, middleware.AccessCookie, "", "/", -1, true)
+	setCookie(c, middleware.RefreshCookie, "", "/api/auth", -1, true)
+	setCookie(c, middleware.CSRFCookie, "", "/", -1, false)
+}
+
+func setCookie(c *gin.Context, name, value, path string, ttl time.Duration, httpOnly bool) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   session.Domain,
+		Secure:   session.Secure,
+		HttpOnly: httpOnly,
+		SameSite: session.SameSite,
+	}
+	if ttl < 0 {
+		cookie.MaxAge = -1
+		cookie.Expires = time.Unix(0, 0)
+	} else {
+		cookie.MaxAge = int(ttl.Seconds())
+		cookie.Expires = time.Now().Add(ttl)
+	}
+	http.SetCookie(c.Writer, cookie)
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error kinds. Drivers wrap failures in an *Error of one of these kinds so
+// handlers and the error middleware never have to inspect error strings.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrValidation   = errors.New("validation failed")
+	ErrUpstream     = errors.New("upstream service error")
+	ErrUnavailable  = errors.New("service unavailable")
+	ErrRateLimited  = errors.New("too many requests")
+)
+
+var (
+	// ErrInvalidCredentials is returned by AuthService.Login when the provider
+	// rejects the username/password, as opposed to being unreachable.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrNotSupported is returned for operations a provider doesn't offer,
+	// e.g. password login or registration against an external IdP.
+	ErrNotSupported = errors.New("operation not supported by auth provider")
+)
+
+// FieldError is a validation failure for one request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is a typed error. Message is safe to return to clients; Err holds
+// the underlying cause (driver output, upstream body) and is only logged.
+type Error struct {
+	Kind    error                  // One of the Err* kinds above
+	Message string                 // Client-safe description
+	Fields  []FieldError           // Per-field validation errors
+	Details map[string]interface{} // Extra members for the client, e.g. attempts_remaining
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// Unwrap lets errors.Is match both the kind and the cause.
+func (e *Error) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+func NotFound(msg string) *Error     { return &Error{Kind: ErrNotFound, Message: msg} }
+func Conflict(msg string) *Error     { return &Error{Kind: ErrConflict, Message: msg} }
+func Unauthorized(msg string) *Error { return &Error{Kind: ErrUnauthorized, Message: msg} }
+func Forbidden(msg string) *Error    { return &Error{Kind: ErrForbidden, Message: msg} }
+func Unavailable(msg string) *Error  { return &Error{Kind: ErrUnavailable, Message: msg} }
+func RateLimited(msg string) *Error  { return &Error{Kind: ErrRateLimited, Message: msg} }
+
+// Validation returns a validation error carrying per-field details.
+func Validation(msg string, fields ...FieldError) *Error {
+	return &Error{Kind: ErrValidation, Message: msg, Fields: fields}
+}
+
+// Upstream wraps a failure talking to another service (QAST, AstAuth, ...).
+func Upstream(service string, cause error) *Error {
+	return &Error{Kind: ErrUpstream, Message: service + " request failed", Err: cause}
+}
+
+// UpstreamResponse is the cause of an UpstreamStatus error: what the
+// upstream service answered. Find it with errors.As.
+type UpstreamResponse struct {
+	Service string
+	Op      string
+	Status  int
+	Body    string
+}
+
+func (r *UpstreamResponse) Error() string {
+	return fmt.Sprintf("status %d: %s", r.Status, r.Body)
+}
+
+// UpstreamStatus maps a non-success upstream HTTP status onto an error kind.
+// The upstream body only ends up in the cause, never in the client message.
+func UpstreamStatus(service, op string, status int, body string) *Error {
+	e := &Error{Kind: ErrUpstream, Message: fmt.Sprintf("%s: %s failed", service, op)}
+	switch status {
+	case http.StatusNotFound:
+		e.Kind, e.Message = ErrNotFound, fmt.Sprintf("%s: %s: not found", service, op)
+	case http.StatusConflict:
+		e.Kind, e.Message = ErrConflict, fmt.Sprintf("%s: %s: conflict", service, op)
+	case http.StatusUnauthorized:
+		e.Kind, e.Message = ErrUnauthorized, fmt.Sprintf("%s: %s: unauthorized", service, op)
+	case http.StatusForbidden:
+		e.Kind, e.Message = ErrForbidden, fmt.Sprintf("%s: %s: forbidden", service, op)
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		e.Kind, e.Message = ErrValidation, fmt.Sprintf("%s: %s: rejected", service, op)
+	case http.StatusTooManyRequests:
+		e.Kind, e.Message = ErrRateLimited, fmt.Sprintf("%s: %s: rate limited", service, op)
+	case http.StatusServiceUnavailable:
+		e.Kind = ErrUnavailable
+	}
+	e.Err = &UpstreamResponse{Service: service, Op: op, Status: status, Body: body}
+	return e
+}
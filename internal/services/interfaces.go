@@ -17,6 +17,8 @@ type CacheService interface {
 	Get(ctx context.Context, key string) (string, error)
 	Set(ctx context.Context, key string, value string, ttlSeconds int) error
 	Delete(ctx context.Context, key string) error
+	// Keys lists the keys matching a glob pattern, e.g. "cart:42:*".
+	Keys(ctx context.Context, pattern string) ([]string, error)
 }
 
 // QueueService defines the interface for message queue operations (e.g. RabbitMQ)
@@ -25,20 +27,59 @@ type QueueService interface {
 	Subscribe(ctx context.Context, topic string, handler func(message []byte) error) error
 }
 
-// QastService defines the interface for interacting with the QAST API
 // QastService defines the interface for interacting with the QAST API
 type QastService interface {
 	Ask(ctx context.Context, query, userId, expertise string) (string, []string, error)
 	IngestGraph(ctx context.Context, text, userId string) (interface{}, error)
 	SecureChat(ctx context.Context, text, userId, sessionId, targetMessageID, token string) (io.ReadCloser, error)
-	CreateSession(ctx context.Context, userID, title string) (interface{}, error)
-	GetSessions(ctx context.Context, userID string) (interface{}, error)
-	GetSession(ctx context.Context, sessionID string) (interface{}, error)
+	CreateSession(ctx context.Context, userID, title string) (*Session, error)
+	GetSessions(ctx context.Context, userID string) ([]Session, error)
+	GetSession(ctx context.Context, sessionID string) (*SessionDetail, error)
 	DeleteSession(ctx context.Context, sessionID string) error
-	ShareSession(ctx context.Context, sessionID, targetUsername string) (interface{}, error)
-	SearchUsers(ctx context.Context, query string) (interface{}, error)
+	ShareSession(ctx context.Context, sessionID, targetUsername string) (*SharedSession, error)
+	SearchUsers(ctx context.Context, query string) ([]UserSummary, error)
 	SyncUser(ctx context.Context, id, email, username, firstName, lastName string) error
 	UpdateContext(ctx context.Context, id, content string) error
-	GetContext(ctx context.Context, id string) (interface{}, error)
+	GetContext(ctx context.Context, id string) (*ContextDocument, error)
 	UpdateMessage(ctx context.Context, sessionID, messageID, content string, metadata map[string]interface{}) error
 }
+
+// ReadinessChecker is implemented by services that can tell whether they
+// accept requests right now, e.g. a client whose circuit breaker is open.
+type ReadinessChecker interface {
+	Ready() error
+}
+
+// AuthService defines the interface for authentication providers (e.g. AstAuth, OIDC)
+type AuthService interface {
+	Login(ctx context.Context, username, password string) (*AuthResponse, error)
+	Register(ctx context.Context, email, username, password, confirmPassword, firstName, lastName string) error
+	VerifyToken(ctx context.Context, accessToken string) (*User, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*AuthResponse, error)
+	Logout(ctx context.Context, accessToken, refreshToken string) error
+}
+
+// RedirectAuthService is implemented by providers that log users in through a
+// browser redirect (OAuth2 authorization code flow with PKCE).
+type RedirectAuthService interface {
+	AuthService
+	AuthCodeURL(state, codeChallenge, nonce string) string
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*AuthResponse, error)
+}
+
+// AuthResponse is the session issued by an auth provider
+type AuthResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	User         User   `json:"user"`
+}
+
+// User is the authenticated user as seen by wodge
+type User struct {
+	ID        string `json:"id"`
+	Username  string `json:"username,omitempty"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Role      string `json:"role"`
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QAST domain models. Drivers decode upstream responses into these and
+// reject payloads that fail Validate, so handlers never pass unchecked data
+// through. The same structs generate the TypeScript types of the history
+// and users API templates.
+
+// Session is a chat session.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Title     string    `json:"title"`
+	CreatedAt Timestamp `json:"created_at"`
+	UpdatedAt Timestamp `json:"updated_at"`
+}
+
+// Message is one message in a session.
+type Message struct {
+	ID        string                 `json:"id"`
+	SessionID string                 `json:"session_id"`
+	Role      string                 `json:"role" ts:"'user' | 'assistant' | 'system'"`
+	Content   string                 `json:"content"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	CreatedAt Timestamp              `json:"created_at"`
+}
+
+// SessionDetail is a session with its messages.
+type SessionDetail struct {
+	Session  Session   `json:"session"`
+	Messages []Message `json:"messages"`
+}
+
+// SharedSession confirms a session was shared with another user.
+type SharedSession struct {
+	Status     string `json:"status"`
+	SessionID  string `json:"session_id,omitempty"`
+	SharedWith string `json:"shared_with"`
+}
+
+// UserSummary is a user as listed by user search.
+type UserSummary struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+}
+
+// ContextDocument is the personal context QAST keeps for a user.
+type ContextDocument struct {
+	UserID    string     `json:"user_id,omitempty"`
+	Content   string     `json:"content"`
+	UpdatedAt *Timestamp `json:"updated_at,omitempty"`
+}
+
+// Message roles
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleSystem    = "system"
+)
+
+func (s Session) Validate() error {
+	if s.ID == "" {
+		return errors.New("session without id")
+	}
+	return nil
+}
+
+func (m Message) Validate() error {
+	if m.ID == "" {
+		return errors.New("message without id")
+	}
+	switch m.Role {
+	case RoleUser, RoleAssistant, RoleSystem:
+		return nil
+	}
+	return fmt.Errorf("message %s: unknown role %q", m.ID, m.Role)
+}
+
+func (d SessionDetail) Validate() error {
+	if err := d.Session.Validate(); err != nil {
+		return err
+	}
+	for _, m := range d.Messages {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+		if m.SessionID != "" && m.SessionID != d.Session.ID {
+			return fmt.Errorf("message %s belongs to session %s, not %s", m.ID, m.SessionID, d.Session.ID)
+		}
+	}
+	return nil
+}
+
+func (s SharedSession) Validate() error {
+	if s.Status == "" {
+		return errors.New("share response without status")
+	}
+	return nil
+}
+
+func (u UserSummary) Validate() error {
+	if u.ID == "" || u.Username == "" {
+		return errors.New("user without id or username")
+	}
+	return nil
+}
+
+func (d ContextDocument) Validate() error { return nil }
+
+// Timestamp is a time QAST sends either as RFC 3339 or as a naive ISO 8601
+// time in UTC, the way Python's isoformat writes it.
+type Timestamp struct {
+	time.Time
+}
+
+var naiveLayouts = []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999"}
+
+func (t *Timestamp) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return fmt.Errorf("timestamp %s is not a string", s)
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if s == "" {
+		return nil
+	}
+	if v, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		t.Time = v
+		return nil
+	}
+	for _, layout := range naiveLayouts {
+		if v, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			t.Time = v
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid timestamp %q", s)
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(`"` + t.UTC().Format(time.RFC3339Nano) + `"`), nil
+}
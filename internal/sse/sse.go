@@ -0,0 +1,223 @@
+// Package sse reads and writes server-sent events (text/event-stream), the
+// format QAST streams chat answers in and wodge re-emits them to browsers.
+package sse
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxEventSize bounds the data of a single event
+const MaxEventSize = 1 << 20
+
+var ErrEventTooLarge = errors.New("sse: event too large")
+
+// Event is one server-sent event. An empty Event name means "message".
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// Name is the event type as seen by clients.
+func (e Event) Name() string {
+	if e.Event == "" {
+		return "message"
+	}
+	return e.Event
+}
+
+// JSON decodes the event data into v.
+func (e Event) JSON(v interface{}) error {
+	return json.Unmarshal([]byte(e.Data), v)
+}
+
+// WriteTo writes the event in wire format, ending with the blank line that
+// dispatches it. Data spanning lines becomes one data field per line.
+func (e Event) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + oneLine(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + oneLine(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(e.Data, "\r\n", "\n"), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// oneLine keeps a field value from breaking the framing.
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// Reader parses an event stream.
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next event, or io.EOF once the stream ends. Comments
+// (heartbeats) are skipped. Unlike a browser it also returns an event cut
+// off by the end of the stream, since upstreams often omit the final blank
+// line.
+func (r *Reader) Next() (Event, error) {
+	var e Event
+	var data strings.Builder
+	pending := false
+	for {
+		line, err := r.readLine()
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF && pending {
+				e.Data = strings.TrimSuffix(data.String(), "\n")
+				return e, nil
+			}
+			return Event{}, err
+		}
+		if line == "" {
+			if pending {
+				e.Data = strings.TrimSuffix(data.String(), "\n")
+				return e, nil
+			}
+			continue
+		}
+		if line[0] == ':' {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			e.Event, pending = value, true
+		case "data":
+			if data.Len()+len(value) > MaxEventSize {
+				return Event{}, ErrEventTooLarge
+			}
+			data.WriteString(value + "\n")
+			pending = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				e.ID = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				e.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// readLine reads a line without its \n or \r\n terminator.
+func (r *Reader) readLine() (string, error) {
+	var b []byte
+	for {
+		chunk, isPrefix, err := r.r.ReadLine()
+		if err != nil {
+			return string(b), err
+		}
+		if len(b)+len(chunk) > MaxEventSize {
+			return "", ErrEventTooLarge
+		}
+		b = append(b, chunk...)
+		if !isPrefix {
+			return string(b), nil
+		}
+	}
+}
+
+// Writer sends events to a client, flushing each one. It is safe for
+// concurrent use, so heartbeats can run next to the main stream.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+	f  http.Flusher
+}
+
+// NewWriter sets the event-stream headers and flushes them, so the client
+// sees the stream open before the first event.
+func NewWriter(w http.ResponseWriter) *Writer {
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no") // Keep proxies like nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	sw := &Writer{w: w}
+	sw.f, _ = w.(http.Flusher)
+	sw.flush()
+	return sw
+}
+
+// Send writes e and flushes it.
+func (w *Writer) Send(e Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := e.WriteTo(w.w); err != nil {
+		return err
+	}
+	w.flush()
+	return nil
+}
+
+// JSON sends an event with v encoded as its data.
+func (w *Writer) JSON(event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("sse: %w", err)
+	}
+	return w.Send(Event{Event: event, Data: string(data)})
+}
+
+// Comment writes a comment line, which clients ignore.
+func (w *Writer) Comment(text string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := io.WriteString(w.w, ": "+oneLine(text)+"\n\n"); err != nil {
+		return err
+	}
+	w.flush()
+	return nil
+}
+
+// Heartbeat writes a comment every interval until ctx is done or a write
+// fails, keeping proxies from closing an idle stream.
+func (w *Writer) Heartbeat(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := w.Comment("ping"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (w *Writer) flush() {
+	if w.f != nil {
+		w.f.Flush()
+	}
+}
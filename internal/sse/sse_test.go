@@ -0,0 +1,116 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAll parses stream and returns its events and the error that ended it.
+func readAll(stream string) ([]Event, error) {
+	r := NewReader(strings.NewReader(stream))
+	var events []Event
+	for {
+		e, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return events, err
+		}
+		events = append(events, e)
+	}
+}
+
+func TestReaderNext(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []Event
+	}{
+		{"single event", "id: 1\nevent: token\ndata: hi\n\n", []Event{{ID: "1", Event: "token", Data: "hi"}}},
+		{"multi-line data", "data: a\ndata: b\n\n", []Event{{Data: "a\nb"}}},
+		{"CRLF line ends", "event: token\r\ndata: hi\r\n\r\n", []Event{{Event: "token", Data: "hi"}}},
+		{"no space after the colon", "data:hi\n\n", []Event{{Data: "hi"}}},
+		{"only the first space is dropped", "data:  hi\n\n", []Event{{Data: " hi"}}},
+		{"field without a colon", "data\n\n", []Event{{Data: ""}}},
+		{"comments are skipped", ": ping\n\n: ping\ndata: hi\n\n", []Event{{Data: "hi"}}},
+		{"blank lines between events", "\n\ndata: a\n\n\n\ndata: b\n\n", []Event{{Data: "a"}, {Data: "b"}}},
+		{"event without data", "event: done\n\n", []Event{{Event: "done"}}},
+		{"id alone dispatches nothing", "id: 7\n\n", nil},
+		{"id with NUL is ignored", "id: a\x00b\ndata: x\n\n", []Event{{Data: "x"}}},
+		{"retry in milliseconds", "retry: 1500\ndata: x\n\n", []Event{{Data: "x", Retry: 1500 * time.Millisecond}}},
+		{"invalid retry is ignored", "retry: soon\ndata: x\n\n", []Event{{Data: "x"}}},
+		{"unknown fields are ignored", "foo: bar\ndata: x\n\n", []Event{{Data: "x"}}},
+		{"last event without a blank line", "data: a\n\ndata: b", []Event{{Data: "a"}, {Data: "b"}}},
+		{"empty stream", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readAll(tt.stream)
+			if err != nil {
+				t.Fatalf("Next() = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events %+v, want %+v", len(got), got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("event %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	half := strings.Repeat("x", MaxEventSize/2)
+	tests := []struct {
+		name    string
+		stream  string
+		wantErr error
+	}{
+		{"line at the limit", "data: " + strings.Repeat("x", MaxEventSize-len("data: ")) + "\n\n", nil},
+		{"line over the limit", "data: " + strings.Repeat("x", MaxEventSize) + "\n\n", ErrEventTooLarge},
+		{"comment over the limit", ": " + strings.Repeat("x", MaxEventSize) + "\n\ndata: x\n\n", ErrEventTooLarge},
+		{"data lines adding up over the limit", "data: " + half + "\ndata: " + half + "\ndata: x\n\n", ErrEventTooLarge},
+		{"the limit is per event", "data: " + half + "\n\ndata: " + half + "\n\ndata: " + half + "\n\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readAll(tt.stream); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Next() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// What WriteTo writes reads back as the same event, whatever its fields
+// contain.
+func TestWriteToRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Event
+		want Event
+	}{
+		{"plain", Event{ID: "3", Event: "token", Data: "hi"}, Event{ID: "3", Event: "token", Data: "hi"}},
+		{"multi-line data", Event{Data: "a\nb\r\nc"}, Event{Data: "a\nb\nc"}},
+		{"data looking like fields", Event{Data: "x\n\nevent: evil\ndata: y"}, Event{Data: "x\n\nevent: evil\ndata: y"}},
+		{"newlines in id and name", Event{ID: "1\ndata: evil", Event: "a\nb"}, Event{ID: "1 data: evil", Event: "a b"}},
+		{"retry", Event{Data: "x", Retry: 2 * time.Second}, Event{Data: "x", Retry: 2 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if _, err := tt.in.WriteTo(&b); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readAll(b.String())
+			if err != nil || len(got) != 1 || got[0] != tt.want {
+				t.Errorf("read back %+v, %v from %q, want %+v", got, err, b.String(), tt.want)
+			}
+		})
+	}
+}
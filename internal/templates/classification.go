@@ -0,0 +1,174 @@
+package templates
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sensitivity of the data a component or service handles, lowest first.
+type Sensitivity int
+
+const (
+	Public       Sensitivity = iota // Nothing about users or the system
+	Internal                        // Operational data, not about individuals
+	Confidential                    // Personal data or user content
+	Restricted                      // Credentials, raw data access, PII mappings
+)
+
+var sensitivityNames = []string{"public", "internal", "confidential", "restricted"}
+
+func (s Sensitivity) String() string {
+	if s < 0 || int(s) >= len(sensitivityNames) {
+		return fmt.Sprintf("Sensitivity(%d)", int(s))
+	}
+	return sensitivityNames[s]
+}
+
+func (s Sensitivity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Sensitivity) UnmarshalText(b []byte) error {
+	for i, name := range sensitivityNames {
+		if name == string(b) {
+			*s = Sensitivity(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown sensitivity %q", b)
+}
+
+// Controls a classified component or service requires
+const (
+	ControlAuth       = "auth"       // Users are signed in and pages are behind ProtectedRoute
+	ControlMFA        = "mfa"        // Privileged roles need a second factor
+	ControlRedaction  = "redaction"  // Logged bodies and monitor events are redacted
+	ControlAudit      = "audit"      // Access is recorded in the signed audit log
+	ControlMonitoring = "monitoring" // Events are persisted and incident detection runs
+)
+
+// Logging requirements
+const (
+	LogAccess   = "access"   // The access log is enough
+	LogRedacted = "redacted" // Bodies may only be logged after redaction
+	LogAudited  = "audited"  // Every access is also recorded in the audit log
+)
+
+// Classification is the compliance metadata of a template added with
+// `wodge add ui` or `wodge add api`. `wodge compliance scan` uses it to
+// find what an app uses and which controls it is missing.
+type Classification struct {
+	Name        string      `json:"name"`
+	Kind        string      `json:"kind"` // "component" or "service"
+	Sensitivity Sensitivity `json:"sensitivity"`
+	PII         bool        `json:"pii"`
+	Logging     string      `json:"logging"`
+	Controls    []string    `json:"controls,omitempty"`
+	// Files the template writes, relative to the app root
+	Files []string `json:"files"`
+	// Backend routes it calls, as prefixes under /api
+	APIs []string `json:"apis,omitempty"`
+	Note string   `json:"note,omitempty"`
+}
+
+// Requires reports whether the classification lists control.
+func (c Classification) Requires(control string) bool {
+	for _, ctl := range c.Controls {
+		if ctl == control {
+			return true
+		}
+	}
+	return false
+}
+
+// Classifications covers every component and service template.
+var Classifications = []Classification{
+	// UI components
+	{Name: "button", Kind: "component", Sensitivity: Public, Logging: LogAccess,
+		Files: []string{"src/components/ui/Button.tsx"}},
+	{Name: "card", Kind: "component", Sensitivity: Public, Logging: LogAccess,
+		Files: []string{"src/components/ui/Card.tsx"}},
+	{Name: "input", Kind: "component", Sensitivity: Public, Logging: LogAccess,
+		Files: []string{"src/components/ui/Input.tsx"}},
+	{Name: "navbar", Kind: "component", Sensitivity: Public, Logging: LogAccess,
+		Files: []string{"src/components/ui/Navbar.tsx"}},
+	{Name: "theme-provider", Kind: "component", Sensitivity: Public, Logging: LogAccess,
+		Files: []string{"src/context/ThemeProvider.tsx"}},
+	{Name: "qast-test", Kind: "component", Sensitivity: Internal, Logging: LogRedacted,
+		Controls: []string{ControlAuth, ControlRedaction},
+		Files:    []string{"src/components/ui/QastTest.tsx"},
+		APIs:     []string{"/qast/"},
+		Note:     "Development aid, remove before production."},
+	{Name: "token-manager", Kind: "component", Sensitivity: Restricted, PII: true, Logging: LogRedacted,
+		Controls: []string{ControlAuth, ControlRedaction},
+		Files:    []string{"src/utils/TokenManager.ts"},
+		Note:     "Deprecated, keeps the PII token map in the browser. Use the server-side /pii/ API instead."},
+	{Name: "secure-chat", Kind: "component", Sensitivity: Confidential, PII: true, Logging: LogAudited,
+		Controls: []string{ControlAuth, ControlRedaction, ControlAudit, ControlMonitoring},
+		Files:    []string{"src/components/ui/SecureChat.tsx"},
+		APIs:     []string{"/qast/chat", "/pii/"}},
+	{Name: "sidebar", Kind: "component", Sensitivity: Confidential, PII: true, Logging: LogRedacted,
+		Controls: []string{ControlAuth, ControlRedaction},
+		Files:    []string{"src/components/ui/Sidebar.tsx"}},
+	{Name: "users-api", Kind: "component", Sensitivity: Confidential, PII: true, Logging: LogAudited,
+		Controls: []string{ControlAuth, ControlRedaction, ControlAudit},
+		Files:    []string{"src/api/users.ts"},
+		APIs:     []string{"/users/"}},
+	{Name: "history-api", Kind: "component", Sensitivity: Confidential, PII: true, Logging: LogAudited,
+		Controls: []string{ControlAuth, ControlRedaction, ControlAudit},
+		Files:    []string{"src/api/history.ts"},
+		APIs:     []string{"/history/"}},
+	{Name: "llmwrapper", Kind: "component", Sensitivity: Confidential, PII: true, Logging: LogAudited,
+		Controls: []string{ControlAuth, ControlRedaction, ControlAudit, ControlMonitoring},
+		Files:    []string{"src/components/layout/LLMLayout.tsx"}},
+	{Name: "login", Kind: "component", Sensitivity: Restricted, PII: true, Logging: LogAudited,
+		Controls: []string{ControlAuth, ControlMFA, ControlRedaction, ControlAudit, ControlMonitoring},
+		Files:    []string{"src/routes/login.route.tsx", "src/context/AuthProvider.tsx", "src/components/ProtectedRoute.tsx"}},
+
+	// Service clients
+	{Name: "health", Kind: "service", Sensitivity: Public, Logging: LogAccess,
+		Files: []string{"src/api/health.ts"},
+		APIs:  []string{"/health"}},
+	{Name: "postgres", Kind: "service", Sensitivity: Restricted, PII: true, Logging: LogAudited,
+		Controls: []string{ControlAuth, ControlRedaction, ControlAudit, ControlMonitoring},
+		Files:    []string{"src/api/postgres.ts"},
+		APIs:     []string{"/postgres/"},
+		Note:     "Runs SQL sent by the browser. Prefer a dedicated API route per query."},
+	{Name: "redis", Kind: "service", Sensitivity: Confidential, PII: true, Logging: LogRedacted,
+		Controls: []string{ControlAuth, ControlRedaction, ControlAudit},
+		Files:    []string{"src/api/redis.ts"},
+		APIs:     []string{"/redis"}},
+	{Name: "rabbitmq", Kind: "service", Sensitivity: Confidential, Logging: LogRedacted,
+		Controls: []string{ControlAuth, ControlRedaction, ControlMonitoring},
+		Files:    []string{"src/api/rabbitmq.ts"},
+		APIs:     []string{"/queue/"}},
+	{Name: "qast", Kind: "service", Sensitivity: Confidential, PII: true, Logging: LogAudited,
+		Controls: []string{ControlAuth, ControlRedaction, ControlAudit, ControlMonitoring},
+		Files:    []string{"src/api/qast.ts"},
+		APIs:     []string{"/qast/"}},
+	{Name: "auth", Kind: "service", Sensitivity: Restricted, PII: true, Logging: LogAudited,
+		Controls: []string{ControlAuth, ControlMFA, ControlRedaction, ControlAudit, ControlMonitoring},
+		Files:    []string{"src/api/auth.ts"},
+		APIs:     []string{"/auth/"}},
+}
+
+var classificationAliases = map[string]string{
+	"llm-chat": "secure-chat",
+	"astauth":  "auth",
+	"oidc":     "auth",
+}
+
+// Classify looks up a component or service by the name given to
+// `wodge add ui` or `wodge add api`.
+func Classify(name string) (Classification, bool) {
+	name = strings.ToLower(name)
+	if alias, ok := classificationAliases[name]; ok {
+		name = alias
+	}
+	for _, c := range Classifications {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Classification{}, false
+}
@@ -283,7 +283,9 @@ export function QastTest() {
 `
 
 const ComponentTokenManager = `// A utility to manage Client-Side PII Tokens
-// This mocks a secure storage. In production, this would be Encrypted LocalStorage.
+// Deprecated: it keeps the token map in localStorage, readable by any script
+// on the page. SecureChat now rehydrates through wodge (qast.rehydrate), which
+// keeps the map encrypted server-side. Kept for apps that still import it.
 
 const STORAGE_KEY = 'wodge_token_map';
 
@@ -335,7 +337,6 @@ import { Button } from '@/components/ui/Button';
 import { Input } from '@/components/ui/Input';
 import { Card, CardContent } from '@/components/ui/Card';
 import { qast } from '@/api/qast';
-import { TokenManager } from '@/utils/TokenManager';
 import { motion, AnimatePresence } from 'framer-motion';
 import { useAuth } from '@/context/AuthProvider';
 import ReactMarkdown from 'react-markdown';
@@ -346,6 +347,7 @@ interface Message {
   content: string;
   isSanitized?: boolean;
   isKnowledgeUpdate?: boolean;
+  isTruncated?: boolean;
 }
 
 export function SecureChat() {
@@ -354,6 +356,8 @@ export function SecureChat() {
   const [input, setInput] = useState('');
   const [isLoading, setIsLoading] = useState(false);
   const [loadingStatus, setLoadingStatus] = useState("");
+  // The answer being streamed, which Stop cancels on the server
+  const [activeChat, setActiveChat] = useState<string | null>(null);
   const scrollRef = useRef<HTMLDivElement>(null);
 
   useEffect(() => {
@@ -362,34 +366,25 @@ export function SecureChat() {
     }
   }, [messages, loadingStatus]);
 
-  // Helper to inject user identity into token maps
-  const injectIdentity = (map: Record<string, string>) => {
-    if (!user) return map;
-    // Fallback display name if username is missing
-    const displayName = user.username || user.email || "You";
-
-    // Patterns to match user-related tokens
-    const selfPattern = user.id + "_SELF";
-    const possSelfPattern = user.id + "_POSS_SELF";
-
-    const newMap = { ...map };
-
-    // Add direct mappings
-    newMap['[' + selfPattern + ']'] = displayName;
-    newMap['[' + possSelfPattern + ']'] = "your"; // Possessive form
-
-    // Check if any value contains user patterns and resolve them
-    for (const [key, val] of Object.entries(newMap)) {
-      if (typeof val === 'string') {
-        if (val.includes(possSelfPattern)) {
-          newMap[key] = "your";
-        } else if (val.includes(selfPattern)) {
-          newMap[key] = displayName;
-        }
-      }
+  // Wodge keeps this conversation's PII token map server-side under this
+  // session; it comes with the first answer's meta event
+  const piiSession = useRef<string | undefined>(undefined);
+
+  // Puts the real values back through wodge; the browser never holds the map
+  const rehydrate = async (text: string): Promise<string> => {
+    if (!piiSession.current || !text) return text;
+    try {
+      return await qast.rehydrate(text, piiSession.current);
+    } catch (e) {
+      console.error("SecureChat: Rehydration failed", e);
+      return text;
     }
+  };
 
-    return newMap;
+  const handleStop = () => {
+    if (!activeChat) return;
+    setLoadingStatus("Stopping...");
+    qast.cancelChat(activeChat).catch(e => console.error("SecureChat: Cancel failed", e));
   };
 
   const handleSend = async () => {
@@ -418,13 +413,38 @@ export function SecureChat() {
     try {
       let currentContent = "";
 
+      // Rehydrates the answer so far, at most every 300ms while streaming.
+      // Only the latest result is shown, responses may arrive out of order.
+      let latest = 0;
+      let scheduled: ReturnType<typeof setTimeout> | null = null;
+      const showAnswer = async () => {
+        const seq = ++latest;
+        const content = await rehydrate(currentContent);
+        if (seq === latest) {
+          setMessages(prev => prev.map(m =>
+            m.id === botMsgId ? { ...m, content } : m
+          ));
+        }
+      };
+      const scheduleAnswer = () => {
+        if (scheduled) return;
+        scheduled = setTimeout(() => {
+          scheduled = null;
+          showAnswer();
+        }, 300);
+      };
+
+      let truncated = false;
       await qast.chatStream(userMsg.content, (event) => {
-        if (event.type === 'status') {
+        if (event.type === 'meta') {
+          if (event.data?.pii_session) {
+            piiSession.current = event.data.pii_session;
+          }
+          setActiveChat(event.data?.message_id || null);
+        } else if (event.type === 'done') {
+          truncated = event.data?.status !== 'complete';
+        } else if (event.type === 'status') {
           setLoadingStatus(event.data);
-        } else if (event.type === 'token_map') {
-          const mapWithIdentity = injectIdentity(event.data);
-          TokenManager.saveMap(mapWithIdentity);
-          console.log("SecureChat: Updated Token Map", mapWithIdentity);
         } else if (event.type === 'updated_context') {
           // Knowledge extraction completed
           const graph = event.data;
@@ -440,19 +460,6 @@ export function SecureChat() {
             };
             setMessages(prev => [...prev, knowledgeMsg]);
           }
-        } else if (event.type === 'token_definitions') {
-          // Server sent additional token definitions (from RAG context)
-          const mapWithIdentity = injectIdentity(event.data);
-          TokenManager.saveMap(mapWithIdentity);
-          console.log("SecureChat: Merged Token Definitions", mapWithIdentity);
-
-          // Re-hydrate the current bot message with new definitions
-          setMessages(prev => prev.map(m => {
-            if (m.id === botMsgId) {
-              return { ...m, content: TokenManager.rehydrate(currentContent) };
-            }
-            return m;
-          }));
         } else if (event.type === 'context_sources') {
           // Optionally log RAG sources
           console.log("SecureChat: Context Sources", event.data);
@@ -461,10 +468,7 @@ export function SecureChat() {
           const completeContent = event.data.content;
           if (completeContent) {
             currentContent = completeContent;
-            const rehydrated = TokenManager.rehydrate(currentContent);
-            setMessages(prev => prev.map(m =>
-              m.id === botMsgId ? { ...m, content: rehydrated } : m
-            ));
+            scheduleAnswer();
           }
         } else if (event.type === 'chunk') {
           const chunk = String(event.data);
@@ -480,15 +484,20 @@ export function SecureChat() {
           }
 
           currentContent += chunk;
-          const rehydrated = TokenManager.rehydrate(currentContent);
-
-          setMessages(prev => prev.map(m =>
-            m.id === botMsgId ? { ...m, content: rehydrated } : m
-          ));
+          scheduleAnswer();
         } else if (event.type === 'error') {
           console.error("Stream Error:", event.data);
         }
-      }, user?.id || "anonymous", user?.username || user?.email || "User");
+      }, user?.id || "anonymous", piiSession.current);
+
+      // The final answer, with every token QAST defined along the way
+      if (scheduled) clearTimeout(scheduled);
+      await showAnswer();
+      if (truncated) {
+        setMessages(prev => prev.map(m =>
+          m.id === botMsgId ? { ...m, isTruncated: true } : m
+        ));
+      }
 
     } catch (e) {
       console.error("SecureChat Error:", e);
@@ -501,6 +510,7 @@ export function SecureChat() {
     } finally {
       setIsLoading(false);
       setLoadingStatus("");
+      setActiveChat(null);
     }
   };
 
@@ -539,10 +549,13 @@ export function SecureChat() {
                           {msg.content}
                         </ReactMarkdown>
                       </div>
+                      {msg.role === 'assistant' && msg.isTruncated && (
+                        <div className="mt-2 opacity-70">The answer was stopped early</div>
+                      )}
                       {msg.role === 'assistant' && msg.isSanitized && (
                         <div className="mt-2 opacity-70 flex items-center gap-1">
                           <div className="w-1.5 h-1.5 bg-green-500 rounded-full" />
-                          <span>PII Rehydrated locally</span>
+                          <span>PII kept server-side</span>
                         </div>
                       )}
                     </div>
@@ -577,13 +590,23 @@ export function SecureChat() {
               disabled={isLoading}
             />
             <div className="absolute right-2 top-1/2 -translate-y-1/2">
-              <Button
-                onClick={handleSend}
-                disabled={isLoading || !input.trim()}
-                className="bg-[var(--secondary)] p-2 hover:bg-[var(--secondary-hover)]"
-              >
-                <img src="/assets/SendButton.svg" alt="Send" width={24} height={24} className="w-6 h-6 filter invert light:invert-0" />
-              </Button>
+              {isLoading ? (
+                <Button
+                  onClick={handleStop}
+                  disabled={!activeChat}
+                  className="bg-[var(--secondary)] px-3 py-2 hover:bg-[var(--secondary-hover)]"
+                >
+                  Stop
+                </Button>
+              ) : (
+                <Button
+                  onClick={handleSend}
+                  disabled={!input.trim()}
+                  className="bg-[var(--secondary)] p-2 hover:bg-[var(--secondary-hover)]"
+                >
+                  <img src="/assets/SendButton.svg" alt="Send" width={24} height={24} className="w-6 h-6 filter invert light:invert-0" />
+                </Button>
+              )}
             </div>
           </div>
         </div>
@@ -603,10 +626,19 @@ interface User {
   [key: string]: any;
 }
 
+// Returned by login() when the account has a second factor enrolled
+export interface MfaChallenge {
+  mfa_token: string;
+  methods: string[];
+  webauthn?: { challenge: string; rp_id: string; allow_credentials: string[] };
+}
+
 interface AuthContextType {
   user: User | null;
   accessToken: string | null;
-  login: (username: string, pass: string) => Promise<void>;
+  login: (username: string, pass: string) => Promise<MfaChallenge | null>;
+  verifyMfa: (challenge: MfaChallenge, method: string, code?: string) => Promise<void>;
+  mfaEnrollmentRequired: boolean;
   register: (email: string, username: string, pass: string, confirmPass: string, first: string, last: string) => Promise<void>;
   logout: () => Promise<void>;
   isAuthenticated: boolean;
@@ -617,38 +649,74 @@ const AuthContext = createContext<AuthContextType | undefined>(undefined);
 
 export function AuthProvider({ children }: { children: React.ReactNode }) {
   const [user, setUser] = useState<User | null>(null);
+  // Only set in bearer mode; in cookie mode tokens live in HttpOnly cookies
   const [accessToken, setAccessToken] = useState<string | null>(localStorage.getItem('access_token'));
   const [isLoading, setIsLoading] = useState(true);
+  const [mfaEnrollmentRequired, setMfaEnrollmentRequired] = useState(false);
 
   useEffect(() => {
     const initAuth = async () => {
-      const storedToken = localStorage.getItem('access_token');
-      if (storedToken) {
-        try {
-           const res = await auth.verify(storedToken);
-           setUser(res.user);
-           setAccessToken(storedToken); // redundant but safe
-        } catch (e) {
-           console.warn("Auth check failed, logging out:", e);
-           logout();
+      // Bearer-mode redirect logins (OIDC) return the tokens in the URL fragment
+      const fragment = new URLSearchParams(window.location.hash.slice(1));
+      if (fragment.get('access_token')) {
+        localStorage.setItem('access_token', fragment.get('access_token')!);
+        localStorage.setItem('refresh_token', fragment.get('refresh_token') || '');
+        setAccessToken(fragment.get('access_token'));
+        window.history.replaceState(null, '', window.location.pathname + window.location.search);
+      }
+      try {
+        // Cookies are sent automatically (credentials: 'include'); a stored
+        // bearer token is added by the client when present.
+        const res = await auth.verify();
+        setUser(res.user);
+      } catch (e) {
+        if (localStorage.getItem('access_token')) {
+          console.warn("Auth check failed, logging out:", e);
+          await logout();
         }
       }
       setIsLoading(false);
     };
 
     initAuth();
+
+    // Fired by the API client when a refresh fails and the session is gone
+    const onExpired = () => {
+      setAccessToken(null);
+      setUser(null);
+      localStorage.removeItem('access_token');
+      localStorage.removeItem('refresh_token');
+    };
+    window.addEventListener('wodge:session-expired', onExpired);
+    return () => window.removeEventListener('wodge:session-expired', onExpired);
   }, []);
 
-  const login = async (username: string, pass: string) => {
-    const res = await auth.login(username, pass);
+  const startSession = (res: any) => {
     if (res.access_token) {
+        // Bearer mode: the server returned tokens in the body
         setAccessToken(res.access_token);
-        setUser(res.user);
-        
         localStorage.setItem('access_token', res.access_token);
         localStorage.setItem('refresh_token', res.refresh_token);
-        localStorage.setItem('user_profile', JSON.stringify(res.user));
     }
+    setMfaEnrollmentRequired(!!res.mfa_enrollment_required);
+    setUser(res.user);
+  };
+
+  const login = async (username: string, pass: string) => {
+    const res = await auth.login(username, pass);
+    if (res.mfa_required) {
+        // Password was correct, the session starts after verifyMfa()
+        return res as MfaChallenge;
+    }
+    startSession(res);
+    return null;
+  };
+
+  const verifyMfa = async (challenge: MfaChallenge, method: string, code: string = '') => {
+    const res = method === 'webauthn' && challenge.webauthn
+        ? await auth.mfaVerifyWebAuthn(challenge.mfa_token, challenge.webauthn)
+        : await auth.mfaVerify(challenge.mfa_token, method as 'totp' | 'recovery', code);
+    startSession(res);
   };
 
   const register = async (email: string, username: string, pass: string, confirmPass: string, first: string, last: string) => {
@@ -658,10 +726,8 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
 
   const logout = async () => {
     try {
-      if (accessToken) {
-        const refreshToken = localStorage.getItem('refresh_token');
-        await auth.logout(accessToken, refreshToken || ""); 
-      }
+      // In cookie mode the server reads the tokens from the session cookies
+      await auth.logout(accessToken || undefined, localStorage.getItem('refresh_token') || undefined);
     } catch (e) {
       console.warn("Logout error:", e);
     } finally {
@@ -678,9 +744,11 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
         user, 
         accessToken, 
         login, 
+        verifyMfa,
+        mfaEnrollmentRequired,
         register, 
         logout, 
-        isAuthenticated: !!accessToken,
+        isAuthenticated: !!user,
         isLoading 
     }}>
       {children}
@@ -718,16 +786,26 @@ export function ProtectedRoute({ children }: { children: React.ReactNode }) {
 }
 `
 
-const ComponentLoginPage = `import React, { useState } from 'react';
-import { useAuth } from '@/context/AuthProvider';
+const ComponentLoginPage = `import React, { useState, useEffect } from 'react';
+import { useAuth, MfaChallenge } from '@/context/AuthProvider';
+import { auth } from '@/api/auth';
 import { useNavigate, useLocation, Link } from 'react-router-dom';
 import { Button } from '@/components/ui/Button';
 import { Input } from '@/components/ui/Input';
 import { Card, CardContent, CardHeader, CardTitle } from '@/components/ui/Card';
 import { motion, AnimatePresence } from 'framer-motion';
 
+function FieldErrors({ messages }: { messages?: string[] }) {
+  if (!messages?.length) return null;
+  return (
+    <ul className="text-xs text-destructive space-y-0.5">
+      {messages.map(m => <li key={m}>{m}</li>)}
+    </ul>
+  );
+}
+
 export default function LoginPage() {
-  const { login, register } = useAuth();
+  const { login, verifyMfa, register } = useAuth();
   const navigate = useNavigate();
   const location = useLocation();
   const from = (location.state as any)?.from?.pathname || '/';
@@ -735,6 +813,21 @@ export default function LoginPage() {
   const [isLogin, setIsLogin] = useState(true);
   const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
+  const [mfa, setMfa] = useState<MfaChallenge | null>(null);
+  const [mfaMethod, setMfaMethod] = useState('totp');
+  const [mfaCode, setMfaCode] = useState('');
+  const [fieldErrors, setFieldErrors] = useState<Record<string, string[]>>({});
+  const [authConfig, setAuthConfig] = useState({ password_login: true, register: true, redirect_login: false });
+
+  useEffect(() => {
+    auth.config().then(setAuthConfig).catch(() => {});
+    // Errors from a redirect login come back in the fragment
+    const authError = new URLSearchParams(window.location.hash.slice(1)).get('auth_error');
+    if (authError) {
+      setError('Sign-in with your identity provider failed (' + authError + ')');
+      window.history.replaceState(null, '', window.location.pathname);
+    }
+  }, []);
 
   const [form, setForm] = useState({
     email: '',
@@ -750,7 +843,12 @@ export default function LoginPage() {
     setError('');
     setLoading(true);
     try {
-      await login(form.username, form.password);
+      const challenge = await login(form.username, form.password);
+      if (challenge) {
+        setMfa(challenge);
+        setMfaMethod(challenge.methods.includes('totp') ? 'totp' : challenge.methods[0]);
+        return;
+      }
       navigate(from, { replace: true });
     } catch (err: any) {
       setError(err.message || err.toString() || 'Login failed');
@@ -759,6 +857,85 @@ export default function LoginPage() {
     }
   };
 
+  const handleMfa = async (e?: React.FormEvent, method: string = mfaMethod) => {
+    e?.preventDefault();
+    if (!mfa) return;
+    setError('');
+    setLoading(true);
+    try {
+      await verifyMfa(mfa, method, mfaCode.trim());
+      navigate(from, { replace: true });
+    } catch (err: any) {
+      setMfaCode('');
+      setError(err.message || err.toString() || 'Verification failed');
+      if (/expired|too many/i.test(err.message || '')) {
+        setMfa(null);
+      }
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  if (mfa) {
+    return (
+      <div className="flex min-h-screen items-center justify-center bg-background p-4">
+        <Card className="w-full max-w-md border-border/50 shadow-2xl">
+          <CardHeader className="text-center pb-2">
+            <CardTitle className="text-3xl font-bold tracking-tight mb-2">Two-Step Verification</CardTitle>
+            <p className="text-sm text-muted-foreground">
+              {mfaMethod === 'recovery' ? 'Enter one of your recovery codes' : 'Enter the 6-digit code from your authenticator app'}
+            </p>
+          </CardHeader>
+          <CardContent>
+            <form onSubmit={handleMfa} className="space-y-4">
+              {mfaMethod !== 'webauthn' && (
+                <div className="space-y-2">
+                  <label className="text-xs font-medium">{mfaMethod === 'recovery' ? 'Recovery Code' : 'Verification Code'}</label>
+                  <Input
+                    autoFocus
+                    required
+                    autoComplete="one-time-code"
+                    inputMode={mfaMethod === 'totp' ? 'numeric' : 'text'}
+                    placeholder={mfaMethod === 'totp' ? '123456' : 'xxxxx-xxxxx'}
+                    value={mfaCode}
+                    onChange={e => setMfaCode(e.target.value)}
+                  />
+                </div>
+              )}
+
+              {error && (
+                <div className="p-3 rounded bg-destructive/10 text-destructive text-sm font-medium border border-destructive/20">
+                  {error}
+                </div>
+              )}
+
+              {mfaMethod !== 'webauthn' && (
+                <Button type="submit" className="w-full font-bold" disabled={loading} size="lg">
+                  {loading ? 'Verifying...' : 'Verify'}
+                </Button>
+              )}
+              {mfa.methods.includes('webauthn') && (
+                <Button type="button" variant="outline" className="w-full" disabled={loading} onClick={() => { setMfaMethod('webauthn'); handleMfa(undefined, 'webauthn'); }}>
+                  Use security key
+                </Button>
+              )}
+            </form>
+
+            <div className="mt-6 flex justify-between text-sm">
+              {mfa.methods.includes('totp') && mfaMethod !== 'totp' && (
+                <button type="button" onClick={() => { setMfaMethod('totp'); setError(''); }} className="font-semibold text-primary hover:underline">Use authenticator app</button>
+              )}
+              {mfa.methods.includes('recovery') && mfaMethod !== 'recovery' && (
+                <button type="button" onClick={() => { setMfaMethod('recovery'); setError(''); }} className="font-semibold text-primary hover:underline">Use a recovery code</button>
+              )}
+              <button type="button" onClick={() => { setMfa(null); setMfaCode(''); setError(''); }} className="text-muted-foreground hover:underline">Back</button>
+            </div>
+          </CardContent>
+        </Card>
+      </div>
+    );
+  }
+
   const handleRegister = async (e: React.FormEvent) => {
     e.preventDefault();
     if (form.password !== form.confirmPassword) {
@@ -766,12 +943,22 @@ export default function LoginPage() {
       return;
     }
     setError('');
+    setFieldErrors({});
     setLoading(true);
     try {
       await register(form.email, form.username, form.password, form.confirmPassword, form.firstName, form.lastName);
       navigate(from, { replace: true });
     } catch (err: any) {
-      setError(err.message || err.toString() || 'Registration failed');
+      if (err.fields?.length) {
+        // Server-side policy errors are shown next to their fields
+        const byField: Record<string, string[]> = {};
+        for (const f of err.fields) {
+          (byField[f.field] = byField[f.field] || []).push(f.message);
+        }
+        setFieldErrors(byField);
+      } else {
+        setError(err.message || err.toString() || 'Registration failed');
+      }
     } finally {
       setLoading(false);
     }
@@ -796,6 +983,18 @@ export default function LoginPage() {
             </motion.div>
         </CardHeader>
         <CardContent>
+          {authConfig.redirect_login && isLogin && (
+            <div className="space-y-4 mb-4">
+              <Button type="button" className="w-full font-bold" size="lg" onClick={() => auth.loginWithProvider()}>
+                Sign in with SSO
+              </Button>
+              {authConfig.password_login && (
+                <div className="text-center text-xs text-muted-foreground">or use your password</div>
+              )}
+            </div>
+          )}
+
+          {(authConfig.password_login || !isLogin) && (
           <form onSubmit={isLogin ? handleLogin : handleRegister} className="space-y-4">
             <AnimatePresence mode="popLayout">
                 {!isLogin && (
@@ -824,6 +1023,7 @@ export default function LoginPage() {
                                 value={form.email}
                                 onChange={e => setForm({ ...form, email: e.target.value })}
                             />
+                            <FieldErrors messages={fieldErrors.email} />
                         </div>
                     </motion.div>
                 )}
@@ -837,6 +1037,7 @@ export default function LoginPage() {
                 value={form.username}
                 onChange={e => setForm({ ...form, username: e.target.value })}
               />
+              {!isLogin && <FieldErrors messages={fieldErrors.username} />}
             </div>
             
             <div className="space-y-2">
@@ -848,6 +1049,7 @@ export default function LoginPage() {
                 value={form.password}
                 onChange={e => setForm({ ...form, password: e.target.value })}
               />
+              {!isLogin && <FieldErrors messages={fieldErrors.password} />}
             </div>
 
             {!isLogin && (
@@ -860,6 +1062,7 @@ export default function LoginPage() {
                         value={form.confirmPassword}
                         onChange={e => setForm({ ...form, confirmPassword: e.target.value })}
                     />
+                    <FieldErrors messages={fieldErrors.confirm_password} />
                 </div>
             )}
 
@@ -878,19 +1081,22 @@ export default function LoginPage() {
               ) : (isLogin ? 'Sign In' : 'Sign Up')}
             </Button>
           </form>
+          )}
 
+          {authConfig.register && (
           <div className="mt-6 text-center text-sm">
             <span className="text-muted-foreground">
                 {isLogin ? "Don't have an account? " : "Already have an account? "}
             </span>
             <button 
                 type="button"
-                onClick={() => { setIsLogin(!isLogin); setError(''); }}
+                onClick={() => { setIsLogin(!isLogin); setError(''); setFieldErrors({}); }}
                 className="font-semibold text-primary hover:underline focus:outline-none"
             >
                 {isLogin ? 'Sign up' : 'Sign in'}
             </button>
           </div>
+          )}
         </CardContent>
       </Card>
       
@@ -904,57 +1110,45 @@ export default function LoginPage() {
 }
 `
 
+// TypesMarker is replaced with TypeScript generated from the Go models when
+// an API client template is written.
+const TypesMarker = "// @wodge:types\n"
+
 const ComponentUsersAPI = `import { apiGet } from '@/lib/wodge';
 
-export interface User {
-    id: string;
-    username: string;
-    email?: string;
-    first_name?: string;
-    last_name?: string;
-}
+// @wodge:types
+
+export type User = UserSummary;
 
 export const usersApi = {
     // Search users by username query
-    async searchUsers(query: string): Promise<User[]> {
+    async searchUsers(query: string): Promise<UserSummary[]> {
         if (!query) return [];
-        const res = await apiGet<any>('/users/search?q=' + encodeURIComponent(query));
-        return res.data || res || [];
+        return apiGet<UserSummary[]>('/users/search?q=' + encodeURIComponent(query));
     }
 };
 `
 
 const ComponentHistoryAPI = `import { apiPost, apiGet, apiDelete } from '@/lib/wodge';
 
-export interface ChatSession {
-    id: string;
-    user_id: string;
-    title: string;
-    created_at: string;
-    updated_at: string;
-}
+// @wodge:types
 
-export interface ChatMessage {
-    id: string;
-    session_id: string;
-    role: 'user' | 'assistant';
-    content: string;
-    created_at: string;
-}
+export type ChatSession = Session;
+export type ChatMessage = Message;
 
 export const history = {
     // Create a new session
-    async createSession(userId: string, title: string = "New Chat"): Promise<ChatSession> {
+    async createSession(userId: string, title: string = "New Chat"): Promise<Session> {
         return apiPost('/history/sessions', { user_id: userId, title });
     },
 
     // Get all sessions for a user
-    async getSessions(userId: string): Promise<ChatSession[]> {
-        return apiGet('/history/sessions?user_id=' + userId);
+    async getSessions(userId: string): Promise<Session[]> {
+        return apiGet('/history/sessions?user_id=' + encodeURIComponent(userId));
     },
 
     // Get a specific session (returns session details + messages)
-    async getSession(sessionId: string): Promise<{ session: ChatSession; messages: ChatMessage[] }> {
+    async getSession(sessionId: string): Promise<SessionDetail> {
         return apiGet('/history/sessions/' + sessionId);
     },
 
@@ -964,7 +1158,7 @@ export const history = {
     },
 
     // Share a session
-    async shareSession(sessionId: string, targetUsername: string): Promise<{ status: string; shared_with: string }> {
+    async shareSession(sessionId: string, targetUsername: string): Promise<SharedSession> {
         return apiPost('/history/sessions/' + sessionId + '/share', { target_username: targetUsername });
     }
 };
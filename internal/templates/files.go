@@ -242,43 +242,118 @@ export default function Home() {
 
 const WodgeClientTS = `export const API_BASE = 'http://localhost:8080/api';
 
-export async function apiGet<T = any>(path: string): Promise<T> {
-  const res = await fetch(API_BASE + path, {
-    method: 'GET',
-    headers: { 'Content-Type': 'application/json' },
-  });
-  if (!res.ok) {
-    const err = await res.json().catch(() => ({ error: res.statusText }));
-    throw new Error(err.error || res.statusText);
+const CSRF_COOKIE = 'wodge_csrf';
+
+/**
+ * Reads the double-submit CSRF token the server sets in cookie session mode.
+ */
+export function csrfToken(): string | null {
+  if (typeof document === 'undefined') return null;
+  const match = document.cookie.match(new RegExp('(?:^|; )' + CSRF_COOKIE + '=([^;]*)'));
+  return match ? decodeURIComponent(match[1]) : null;
+}
+
+/**
+ * Headers sent with every API call. In cookie mode the session travels as
+ * HttpOnly cookies and only the CSRF token is added; a bearer token is only
+ * present when the server runs in bearer mode.
+ */
+export function apiHeaders(): Record<string, string> {
+  const headers: Record<string, string> = { 'Content-Type': 'application/json' };
+  const token = typeof localStorage !== 'undefined' ? localStorage.getItem('access_token') : null;
+  if (token) {
+    headers['Authorization'] = 'Bearer ' + token;
   }
-  return res.json();
+  const csrf = csrfToken();
+  if (csrf) {
+    headers['X-CSRF-Token'] = csrf;
+  }
+  return headers;
 }
 
-export async function apiPost<T = any>(path: string, body: any): Promise<T> {
-  const res = await fetch(API_BASE + path, {
-    method: 'POST',
-    headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify(body),
-  });
-  if (!res.ok) {
-    const err = await res.json().catch(() => ({ error: res.statusText }));
-    throw new Error(err.error || res.statusText);
+let refreshInFlight: Promise<boolean> | null = null;
+
+/**
+ * Refreshes the session once, no matter how many requests ask for it.
+ * Concurrent callers share the same in-flight promise.
+ */
+export function refreshSession(): Promise<boolean> {
+  if (!refreshInFlight) {
+    refreshInFlight = (async () => {
+      const stored = typeof localStorage !== 'undefined' ? localStorage.getItem('refresh_token') : null;
+      const res = await fetch(API_BASE + '/auth/refresh', {
+        method: 'POST',
+        headers: apiHeaders(),
+        credentials: 'include',
+        // In cookie mode the refresh token travels as an HttpOnly cookie
+        body: JSON.stringify(stored ? { refresh_token: stored } : {}),
+      });
+      if (!res.ok) {
+        if (typeof window !== 'undefined') {
+          window.dispatchEvent(new CustomEvent('wodge:session-expired'));
+        }
+        return false;
+      }
+      const data = await res.json().catch(() => ({}));
+      if (data.access_token) {
+        localStorage.setItem('access_token', data.access_token);
+        localStorage.setItem('refresh_token', data.refresh_token);
+      }
+      return true;
+    })().catch(() => false).finally(() => {
+      refreshInFlight = null;
+    });
   }
-  return res.json();
+  return refreshInFlight;
 }
 
-export async function apiDelete<T = any>(path: string): Promise<T> {
-  const res = await fetch(API_BASE + path, {
-    method: 'DELETE',
-    headers: { 'Content-Type': 'application/json' },
+/**
+ * Sends an API request. On a 401 the session is refreshed once and the
+ * request retried; requests issued while a refresh is running wait for it.
+ */
+async function request<T>(method: string, path: string, body?: any): Promise<T> {
+  const send = () => fetch(API_BASE + path, {
+    method,
+    headers: apiHeaders(),
+    credentials: 'include',
+    body: body === undefined ? undefined : JSON.stringify(body),
   });
+
+  if (refreshInFlight) {
+    await refreshInFlight;
+  }
+
+  let res = await send();
+  if (res.status === 401 && !path.startsWith('/auth/')) {
+    if (await refreshSession()) {
+      res = await send();
+    }
+  }
   if (!res.ok) {
-    const err = await res.json().catch(() => ({ error: res.statusText }));
-    throw new Error(err.error || res.statusText);
+    // Errors are RFC 7807 problem documents; validation failures carry
+    // per-field errors in "errors": [{ field, code, message }]
+    const err = await res.json().catch(() => ({ title: res.statusText }));
+    throw Object.assign(new Error(err.detail || err.title || res.statusText), {
+      status: res.status,
+      fields: err.errors || [],
+      requestId: err.request_id || res.headers.get('X-Request-ID'),
+    });
   }
   return res.json();
 }
 
+export async function apiGet<T = any>(path: string): Promise<T> {
+  return request<T>('GET', path);
+}
+
+export async function apiPost<T = any>(path: string, body: any): Promise<T> {
+  return request<T>('POST', path, body);
+}
+
+export async function apiDelete<T = any>(path: string, body?: any): Promise<T> {
+  return request<T>('DELETE', path, body);
+}
+
 /**
  * Parses a Server-Sent Events (SSE) stream line-by-line.
  * Handles "event:", "data:", and handles specific chunk formatting quirks.
@@ -290,12 +365,14 @@ export async function sseStream(
 ): Promise<void> {  
   const response = await fetch(API_BASE + url, {
     method: 'POST',
-    headers: { 'Content-Type': 'application/json' },
+    headers: apiHeaders(),
+    credentials: 'include',
     body: JSON.stringify(body),
   });
 
   if (!response.ok) {
-    throw new Error("Start stream failed: " + response.statusText);
+    const err = await response.json().catch(() => ({}));
+    throw new Error("Start stream failed: " + (err.detail || response.statusText));
   }
   
   if (!response.body) return;
@@ -473,6 +550,12 @@ typings/
 .env
 .env.test
 
+# Wodge runtime data (MFA store, monitor events, audit log)
+.wodge
+
+# Acceptance list signing key (the signed acceptance.json is committed)
+acceptance.key
+
 # parcel-bundler cache (https://parceljs.org/)
 .cache
 .parcel-cache